@@ -8,16 +8,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 	"github.com/tsuru/kubernetes-router/backend"
+	"github.com/tsuru/kubernetes-router/observability"
 	"github.com/tsuru/kubernetes-router/router"
 	"golang.org/x/sync/errgroup"
 )
@@ -40,17 +40,19 @@ func (a *RouterAPI) Routes() *mux.Router {
 }
 
 func (a *RouterAPI) registerRoutes(r *mux.Router) {
-	r.Handle("/backend/{name}", handler(a.getBackend)).Methods(http.MethodGet)
-	r.Handle("/backend/{name}", handler(a.ensureBackend)).Methods(http.MethodPut)
-	r.Handle("/backend/{name}", handler(a.removeBackend)).Methods(http.MethodDelete)
-	r.Handle("/backend/{name}/status", handler(a.status)).Methods(http.MethodGet)
-	r.Handle("/backend/{name}/routes", handler(a.getRoutes)).Methods(http.MethodGet)
+	r.Handle("/backend/{name}", a.instrumented("get_addresses", a.getBackend)).Methods(http.MethodGet)
+	r.Handle("/backend/{name}", a.putBackend()).Methods(http.MethodPut)
+	r.Handle("/backend/{name}", a.instrumented("remove", a.removeBackend)).Methods(http.MethodDelete)
+	r.Handle("/backend/{name}/status", a.instrumented("get_status", a.status)).Methods(http.MethodGet)
+	r.Handle("/backend/{name}/routes", a.instrumented("get_routes", a.getRoutes)).Methods(http.MethodGet)
+	r.Handle("/backend/{name}/swap", a.instrumented("swap", a.swap)).Methods(http.MethodPost)
 	r.Handle("/info", handler(a.info)).Methods(http.MethodGet)
 
 	// TLS
-	r.Handle("/backend/{name}/certificate/{certname}", handler(a.addCertificate)).Methods(http.MethodPut)
-	r.Handle("/backend/{name}/certificate/{certname}", handler(a.getCertificate)).Methods(http.MethodGet)
-	r.Handle("/backend/{name}/certificate/{certname}", handler(a.removeCertificate)).Methods(http.MethodDelete)
+	r.Handle("/backend/{name}/certificate/{certname}", a.instrumented("add_certificate", a.addCertificate)).Methods(http.MethodPut)
+	r.Handle("/backend/{name}/certificate/{certname}", a.instrumented("get_certificate", a.getCertificate)).Methods(http.MethodGet)
+	r.Handle("/backend/{name}/certificate/{certname}", a.instrumented("remove_certificate", a.removeCertificate)).Methods(http.MethodDelete)
+	r.Handle("/backend/{name}/certificates", a.instrumented("list_certificates", a.listCertificates)).Methods(http.MethodGet)
 
 	// Supports
 	r.Handle("/support/tls", handler(a.supportTLS)).Methods(http.MethodGet)
@@ -73,16 +75,7 @@ func (a *RouterAPI) registerRoutes(r *mux.Router) {
 }
 
 func (a *RouterAPI) router(ctx context.Context, mode string, header http.Header) (router.Router, error) {
-	router, err := a.Backend.Router(ctx, mode, header)
-	if err != nil {
-		if err == backend.ErrBackendNotFound {
-			return nil, httpError{Status: http.StatusNotFound}
-		}
-
-		return nil, err
-	}
-
-	return router, nil
+	return a.Backend.Router(ctx, mode, header)
 }
 
 func instanceID(r *http.Request) router.InstanceID {
@@ -193,6 +186,87 @@ func (a *RouterAPI) ensureBackend(w http.ResponseWriter, r *http.Request) error
 	vars := mux.Vars(r)
 	ctx := r.Context()
 
+	opts, err := ensureBackendOpts(r)
+	if err != nil {
+		return err
+	}
+
+	svc, err := a.router(ctx, vars["mode"], r.Header)
+	if err != nil {
+		return err
+	}
+
+	return svc.Ensure(ctx, instanceID(r), *opts)
+}
+
+// putBackend ensures the backend, or plans it when the dryRun query
+// parameter asks for a dry run. Unknown dryRun values are rejected instead
+// of ensuring the backend.
+func (a *RouterAPI) putBackend() http.Handler {
+	ensure := a.instrumented("ensure", a.ensureBackend)
+	plan := a.instrumented("plan_ensure", a.planBackend)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isDryRun, err := dryRun(r)
+		switch {
+		case err != nil:
+			a.instrumented("ensure", func(http.ResponseWriter, *http.Request) error {
+				return err
+			}).ServeHTTP(w, r)
+		case isDryRun:
+			plan.ServeHTTP(w, r)
+		default:
+			ensure.ServeHTTP(w, r)
+		}
+	})
+}
+
+// dryRun reports whether the dryRun query parameter is true or All, as
+// spelled by Kubernetes, it may be false or empty otherwise.
+func dryRun(r *http.Request) (bool, error) {
+	isDryRun := false
+	for _, value := range r.URL.Query()["dryRun"] {
+		switch strings.ToLower(value) {
+		case "true", "all":
+			isDryRun = true
+		case "false", "":
+		default:
+			return false, router.NewError(router.ErrorCodeInvalidRequest, "invalid dryRun %q, use true, All or false", value)
+		}
+	}
+	return isDryRun, nil
+}
+
+// planBackend returns the changes ensuring the backend would make to the
+// resources of the app, without writing them
+func (a *RouterAPI) planBackend(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	ctx := r.Context()
+
+	opts, err := ensureBackendOpts(r)
+	if err != nil {
+		return err
+	}
+
+	svc, err := a.router(ctx, vars["mode"], r.Header)
+	if err != nil {
+		return err
+	}
+	planRouter, ok := svc.(router.RouterPlan)
+	if !ok {
+		return httpError{Status: http.StatusNotImplemented, Body: "router does not support dry runs"}
+	}
+
+	plan, err := planRouter.PlanEnsure(ctx, instanceID(r), *opts)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(plan)
+}
+
+// ensureBackendOpts reads the options of the backend from the body and
+// headers of the request
+func ensureBackendOpts(r *http.Request) (*router.EnsureBackendOpts, error) {
 	opts := &router.EnsureBackendOpts{
 		Opts: router.Opts{
 			GatewayName:      r.Header.Get("X-Gateway-Name"),
@@ -202,15 +276,40 @@ func (a *RouterAPI) ensureBackend(w http.ResponseWriter, r *http.Request) error
 	}
 	err := json.NewDecoder(r.Body).Decode(opts)
 	if err != nil {
-		return err
+		return nil, router.NewError(router.ErrorCodeInvalidOptions, "%w", err)
 	}
+	return opts, nil
+}
 
+type swapReq struct {
+	Target    string `json:"target"`
+	CNameOnly bool   `json:"cnameOnly"`
+}
+
+// swap swaps the backends of two apps
+func (a *RouterAPI) swap(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	var req swapReq
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return httpError{Status: http.StatusBadRequest, Body: err.Error()}
+	}
+	if req.Target == "" {
+		return httpError{Status: http.StatusBadRequest, Body: "target is required"}
+	}
 	svc, err := a.router(ctx, vars["mode"], r.Header)
 	if err != nil {
 		return err
 	}
-
-	return svc.Ensure(ctx, instanceID(r), *opts)
+	swapRouter, ok := svc.(router.RouterSwap)
+	if !ok {
+		return httpError{Status: http.StatusNotImplemented, Body: "router does not support swap"}
+	}
+	src := instanceID(r)
+	dst := router.InstanceID{AppName: req.Target, InstanceName: src.InstanceName}
+	observability.Logger(ctx).Info("swapping apps", "target", dst.AppName, "cnameOnly", req.CNameOnly)
+	return swapRouter.Swap(ctx, src, dst, req.CNameOnly)
 }
 
 // getRoutes always returns an empty address list to force tsuru to call
@@ -246,26 +345,43 @@ func (a *RouterAPI) info(w http.ResponseWriter, r *http.Request) error {
 func (a *RouterAPI) Healthcheck(w http.ResponseWriter, r *http.Request) {
 	err := a.Backend.Healthcheck(r.Context())
 	if err != nil {
-		glog.Errorf("failed to write healthcheck: %v", err)
+		observability.Logger(r.Context()).Error("healthcheck failed", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, err.Error())
+		a.writeHealthcheckInfo(w)
 		return
 	}
 
 	fmt.Fprint(w, "WORKING")
+	a.writeHealthcheckInfo(w)
+}
+
+func (a *RouterAPI) writeHealthcheckInfo(w http.ResponseWriter) {
+	infoBackend, ok := a.Backend.(backend.HealthcheckInfoBackend)
+	if !ok {
+		return
+	}
+	info := infoBackend.HealthcheckInfo()
+	keys := make([]string, 0, len(info))
+	for k := range info {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "\n%s: %s", k, info[k])
+	}
 }
 
 // addCertificate Add certificate to app
 func (a *RouterAPI) addCertificate(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
-	name := vars["name"]
 	certName := vars["certname"]
-	log.Printf("Adding on %s certificate %s", name, certName)
+	observability.Logger(ctx).Info("adding certificate", "certName", certName)
 	cert := router.CertData{}
 	err := json.NewDecoder(r.Body).Decode(&cert)
 	if err != nil {
-		return err
+		return httpError{Status: http.StatusBadRequest, Body: err.Error()}
 	}
 	svc, err := a.router(ctx, vars["mode"], r.Header)
 	if err != nil {
@@ -278,9 +394,8 @@ func (a *RouterAPI) addCertificate(w http.ResponseWriter, r *http.Request) error
 func (a *RouterAPI) getCertificate(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
-	name := vars["name"]
 	certName := vars["certname"]
-	log.Printf("Getting certificate %s from %s", certName, name)
+	observability.Logger(ctx).Info("getting certificate", "certName", certName)
 	svc, err := a.router(ctx, vars["mode"], r.Header)
 	if err != nil {
 		return err
@@ -308,9 +423,8 @@ func (a *RouterAPI) getCertificate(w http.ResponseWriter, r *http.Request) error
 func (a *RouterAPI) removeCertificate(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
-	name := vars["name"]
 	certName := vars["certname"]
-	log.Printf("Removing certificate %s from %s", certName, name)
+	observability.Logger(ctx).Info("removing certificate", "certName", certName)
 	svc, err := a.router(ctx, vars["mode"], r.Header)
 	if err != nil {
 		return err
@@ -323,6 +437,26 @@ func (a *RouterAPI) removeCertificate(w http.ResponseWriter, r *http.Request) er
 	return err
 }
 
+// listCertificates describes the certificates of the TLS hosts of the app
+func (a *RouterAPI) listCertificates(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	svc, err := a.router(ctx, vars["mode"], r.Header)
+	if err != nil {
+		return err
+	}
+	certRouter, ok := svc.(router.RouterCertificates)
+	if !ok {
+		return httpError{Status: http.StatusNotImplemented, Body: "router does not support listing certificates"}
+	}
+	certificates, err := certRouter.ListCertificates(ctx, instanceID(r))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(certificates)
+}
+
 // Check for TLS Support
 func (a *RouterAPI) supportTLS(w http.ResponseWriter, r *http.Request) error {
 	var err error
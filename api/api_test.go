@@ -7,14 +7,17 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 	"github.com/tsuru/kubernetes-router/backend"
+	"github.com/tsuru/kubernetes-router/observability"
 	"github.com/tsuru/kubernetes-router/router"
 	"github.com/tsuru/kubernetes-router/router/mock"
 )
@@ -57,6 +60,52 @@ func (s *RouterAPISuite) TestHealthcheckOK() {
 	s.Equal("WORKING", string(body))
 }
 
+func (s *RouterAPISuite) TestHealthcheckInfo() {
+	multiCluster := &backend.MultiCluster{Fallback: s.api.Backend}
+	multiCluster.UpdateClusters([]backend.ClusterConfig{{Name: "c1"}})
+	multiCluster.SetReloadError(errors.New("invalid clusters file"))
+	s.api.Backend = multiCluster
+	req := httptest.NewRequest("GET", "http://localhost", nil)
+	w := httptest.NewRecorder()
+
+	s.api.Healthcheck(w, req)
+
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("WORKING\nclusters-generation: 1\nclusters-last-reload-error: invalid clusters file", string(body))
+}
+
+func (s *RouterAPISuite) TestRequestErrorLog() {
+	s.mockRouter.RemoveFn = func(id router.InstanceID) error {
+		return errors.New("failed to remove")
+	}
+	var buf bytes.Buffer
+	logger, err := observability.NewLogger(&buf, "json", "info")
+	s.Require().NoError(err)
+	req := httptest.NewRequest(http.MethodDelete, "http://localhost/api/mymode/backend/myapp", nil)
+	req.Header.Set("X-Router-Instance", "myinstance")
+	req.Header.Set("X-Tsuru-Cluster-Name", "mycluster")
+	req = req.WithContext(observability.ContextWithLogger(req.Context(), logger.With("request_id", "req-1")))
+	w := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(w, req)
+
+	s.Equal(http.StatusInternalServerError, w.Code)
+	var line map[string]interface{}
+	s.Require().NoError(json.Unmarshal(buf.Bytes(), &line))
+	s.Equal("request failed", line["msg"])
+	s.Equal("ERROR", line["level"])
+	s.Equal("req-1", line["request_id"])
+	s.Equal("myapp", line["app"])
+	s.Equal("myinstance", line["instance"])
+	s.Equal("mymode", line["mode"])
+	s.Equal("mycluster", line["cluster"])
+	s.Equal("failed to remove", line["error"])
+	s.Equal(float64(http.StatusInternalServerError), line["status"])
+}
+
 func (s *RouterAPISuite) TestGetBackend() {
 	s.mockRouter.GetAddressesFn = func(id router.InstanceID) ([]string, error) {
 		s.Assert().Equal("myapp", id.AppName)
@@ -196,6 +245,90 @@ func (s *RouterAPISuite) TestEnsureBackendWithHeaderOpts() {
 	s.True(s.mockRouter.EnsureInvoked)
 }
 
+func (s *RouterAPISuite) TestPlanBackend() {
+	s.mockRouter.PlanEnsureFn = func(id router.InstanceID, o router.EnsureBackendOpts) (*router.Plan, error) {
+		s.Equal("myapp", id.AppName)
+		s.Equal([]string{"myapp.io"}, o.CNames)
+		s.Equal("a.b", o.Opts.Domain)
+		return &router.Plan{Changes: []router.ResourceChange{
+			{
+				Action:     router.PlanActionUpdate,
+				APIVersion: "networking.k8s.io/v1",
+				Kind:       "Ingress",
+				Namespace:  "tsuru",
+				Name:       "kubernetes-router-myapp-ingress",
+				Diff: []router.FieldChange{
+					{Path: "spec.rules", Old: []interface{}{}, New: []interface{}{map[string]interface{}{"host": "myapp.io"}}},
+				},
+			},
+		}}, nil
+	}
+
+	reqData, _ := json.Marshal(map[string]interface{}{"opts": map[string]interface{}{}, "cnames": []string{"myapp.io"}})
+	req := httptest.NewRequest(http.MethodPut, "http://localhost/api/backend/myapp?dryRun=true", bytes.NewReader(reqData))
+	req.Header.Add("X-Router-Opt", "domain=a.b")
+	w := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(w, req)
+	resp := w.Result()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.True(s.mockRouter.PlanEnsureInvoked)
+	s.False(s.mockRouter.EnsureInvoked)
+	s.JSONEq(`{"changes": [{"action": "update", "apiVersion": "networking.k8s.io/v1", "kind": "Ingress",
+		"namespace": "tsuru", "name": "kubernetes-router-myapp-ingress",
+		"diff": [{"path": "spec.rules", "old": [], "new": [{"host": "myapp.io"}]}]}]}`, w.Body.String())
+}
+
+func (s *RouterAPISuite) TestPlanBackendDryRunValues() {
+	s.mockRouter.PlanEnsureFn = func(id router.InstanceID, o router.EnsureBackendOpts) (*router.Plan, error) {
+		return &router.Plan{Changes: []router.ResourceChange{}}, nil
+	}
+	s.mockRouter.EnsureFn = func(id router.InstanceID, o router.EnsureBackendOpts) error {
+		return nil
+	}
+	for _, tt := range []struct {
+		query  string
+		status int
+		plan   bool
+		ensure bool
+	}{
+		{query: "dryRun=true", status: http.StatusOK, plan: true},
+		{query: "dryRun=True", status: http.StatusOK, plan: true},
+		{query: "dryRun=All", status: http.StatusOK, plan: true},
+		{query: "dryRun=false&dryRun=All", status: http.StatusOK, plan: true},
+		{query: "dryRun=false", status: http.StatusOK, ensure: true},
+		{query: "dryRun=", status: http.StatusOK, ensure: true},
+		{query: "dryRun=1", status: http.StatusBadRequest},
+		{query: "dryRun=yes&dryRun=false", status: http.StatusBadRequest},
+	} {
+		s.Run(tt.query, func() {
+			s.mockRouter.PlanEnsureInvoked = false
+			s.mockRouter.EnsureInvoked = false
+			req := httptest.NewRequest(http.MethodPut, "http://localhost/api/backend/myapp?"+tt.query, bytes.NewReader([]byte(`{"opts": {}}`)))
+			w := httptest.NewRecorder()
+
+			s.handler.ServeHTTP(w, req)
+			s.Equal(tt.status, w.Code, w.Body.String())
+			s.Equal(tt.plan, s.mockRouter.PlanEnsureInvoked)
+			s.Equal(tt.ensure, s.mockRouter.EnsureInvoked)
+		})
+	}
+}
+
+func (s *RouterAPISuite) TestPlanBackendError() {
+	s.mockRouter.PlanEnsureFn = func(id router.InstanceID, o router.EnsureBackendOpts) (*router.Plan, error) {
+		return nil, router.NewError(router.ErrorCodeInvalidOptions, "invalid domain")
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "http://localhost/api/backend/myapp?dryRun=true", bytes.NewReader([]byte("{}")))
+	w := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(w, req)
+	resp := w.Result()
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+	s.False(s.mockRouter.EnsureInvoked)
+}
+
 func (s *RouterAPISuite) TestRemoveBackend() {
 	s.mockRouter.RemoveFn = func(id router.InstanceID) error {
 		s.Equal("myapp", id.AppName)
@@ -214,6 +347,36 @@ func (s *RouterAPISuite) TestRemoveBackend() {
 	}
 }
 
+func (s *RouterAPISuite) TestSwap() {
+	s.mockRouter.SwapFn = func(src, dst router.InstanceID, cnameOnly bool) error {
+		s.Equal(router.InstanceID{AppName: "myapp", InstanceName: "myinstance"}, src)
+		s.Equal(router.InstanceID{AppName: "otherapp", InstanceName: "myinstance"}, dst)
+		s.True(cnameOnly)
+		return nil
+	}
+
+	body := bytes.NewReader([]byte(`{"target": "otherapp", "cnameOnly": true}`))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/api/backend/myapp/swap", body)
+	req.Header.Set("X-Router-Instance", "myinstance")
+	w := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(w, req)
+	resp := w.Result()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.True(s.mockRouter.SwapInvoked)
+}
+
+func (s *RouterAPISuite) TestSwapWithoutTarget() {
+	body := bytes.NewReader([]byte(`{"cnameOnly": true}`))
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/api/backend/myapp/swap", body)
+	w := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(w, req)
+	resp := w.Result()
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+	s.False(s.mockRouter.SwapInvoked)
+}
+
 func (s *RouterAPISuite) TestInfo() {
 	s.mockRouter.SupportedOptionsFn = func() map[string]string {
 		return map[string]string{router.ExposedPort: "", router.Domain: "Custom help."}
@@ -273,6 +436,21 @@ func (s *RouterAPISuite) TestAddCertificate() {
 	}
 }
 
+func (s *RouterAPISuite) TestAddCertificateInvalid() {
+	s.mockRouter.AddCertificateFn = func(id router.InstanceID, certName string, cert router.CertData) error {
+		return &router.InvalidCertificateError{Reason: "private key does not match the certificate"}
+	}
+
+	reqData, _ := json.Marshal(router.CertData{Certificate: "Certz", Key: "keyz"})
+	req := httptest.NewRequest(http.MethodPut, "http://localhost/api/backend/myapp/certificate/certname", bytes.NewReader(reqData))
+	w := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(w, req)
+	resp := w.Result()
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+	s.JSONEq(`{"code": "invalid_certificate", "message": "invalid certificate: private key does not match the certificate"}`, w.Body.String())
+}
+
 func (s *RouterAPISuite) TestGetCertificate() {
 	s.mockRouter.GetCertificateFn = func(id router.InstanceID, certName string) (*router.CertData, error) {
 		cert := router.CertData{Certificate: "Certz", Key: "keyz"}
@@ -314,6 +492,35 @@ func (s *RouterAPISuite) TestRemoveCertificate() {
 	}
 }
 
+func (s *RouterAPISuite) TestListCertificates() {
+	notAfter := time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC)
+	s.mockRouter.ListCertificatesFn = func(id router.InstanceID) ([]router.CertificateInfo, error) {
+		s.Equal(router.InstanceID{AppName: "myapp"}, id)
+		return []router.CertificateInfo{
+			{
+				Host:       "myapp.example.com",
+				Source:     router.CertificateSourceCertManager,
+				Issuer:     "letsencrypt",
+				SecretName: "myapp-tls",
+				NotAfter:   &notAfter,
+				ValidChain: true,
+				Ready:      &router.CertificateCondition{Status: "False", Reason: "Failed", Message: "failed to issue"},
+			},
+		}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/api/backend/myapp/certificates", nil)
+	w := httptest.NewRecorder()
+
+	s.handler.ServeHTTP(w, req)
+	resp := w.Result()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.True(s.mockRouter.ListCertificatesInvoked)
+	s.JSONEq(`[{"host": "myapp.example.com", "source": "cert-manager", "issuer": "letsencrypt", "secretName": "myapp-tls",
+		"notAfter": "2026-10-21T00:00:00Z", "validChain": true,
+		"ready": {"status": "False", "reason": "Failed", "message": "failed to issue"}}]`, w.Body.String())
+}
+
 func (s *RouterAPISuite) TestGetBackendStatus() {
 	s.mockRouter.GetStatusFn = func(id router.InstanceID) (router.BackendStatus, string, error) {
 		s.Assert().Equal("myapp", id.AppName)
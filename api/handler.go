@@ -5,10 +5,17 @@
 package api
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 
+	"github.com/gorilla/mux"
+	"github.com/tsuru/kubernetes-router/observability"
 	"github.com/tsuru/kubernetes-router/router"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type httpError struct {
@@ -24,22 +31,169 @@ type handler func(http.ResponseWriter, *http.Request) error
 
 // ServeHTTP serves an HTTP request
 func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(observability.ContextWithLogger(r.Context(), requestLogger(r)))
 	handleError(h(w, r), w, r)
 }
 
-func handleError(err error, w http.ResponseWriter, r *http.Request) {
-	if err != nil {
-		log.Printf("error during request %v %v: %v", r.Method, r.URL.Path, err)
-		if httpErr, ok := err.(httpError); ok {
-			http.Error(w, httpErr.Error(), httpErr.Status)
-			return
+// requestLogger returns the logger of the request tagged with the app,
+// instance, mode and cluster it targets.
+func requestLogger(r *http.Request) *slog.Logger {
+	vars := mux.Vars(r)
+	var attrs []any
+	for _, attr := range []struct{ key, value string }{
+		{"app", vars["name"]},
+		{"instance", r.Header.Get("X-Router-Instance")},
+		{"mode", vars["mode"]},
+		{"cluster", r.Header.Get("X-Tsuru-Cluster-Name")},
+	} {
+		if attr.value != "" {
+			attrs = append(attrs, attr.key, attr.value)
 		}
-		if err == router.ErrIngressAlreadyExists {
-			http.Error(w, err.Error(), http.StatusConflict)
-			return
+	}
+	return observability.Logger(r.Context()).With(attrs...)
+}
+
+// errorResponse is the body of failed requests.
+type errorResponse struct {
+	Code    router.ErrorCode  `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+var errorCodeStatus = map[router.ErrorCode]int{
+	router.ErrorCodeInvalidRequest:     http.StatusBadRequest,
+	router.ErrorCodeInvalidOptions:     http.StatusBadRequest,
+	router.ErrorCodeInvalidCertificate: http.StatusBadRequest,
+	router.ErrorCodeInvalidIssuer:      http.StatusUnprocessableEntity,
+	router.ErrorCodeInvalidResource:    http.StatusUnprocessableEntity,
+	router.ErrorCodeUnsupported:        http.StatusUnprocessableEntity,
+	router.ErrorCodeNotFound:           http.StatusNotFound,
+	router.ErrorCodeAlreadyExists:      http.StatusConflict,
+	router.ErrorCodeConflict:           http.StatusConflict,
+	router.ErrorCodeFrozen:             http.StatusConflict,
+	router.ErrorCodeForbidden:          http.StatusForbidden,
+	router.ErrorCodeUnavailable:        http.StatusServiceUnavailable,
+	router.ErrorCodeNotImplemented:     http.StatusNotImplemented,
+	router.ErrorCodeInternal:           http.StatusInternalServerError,
+}
+
+// httpErrorCode are the codes of the httpError statuses returned by the
+// handlers.
+var httpErrorCode = map[int]router.ErrorCode{
+	http.StatusBadRequest:     router.ErrorCodeInvalidRequest,
+	http.StatusNotFound:       router.ErrorCodeNotFound,
+	http.StatusNotImplemented: router.ErrorCodeNotImplemented,
+}
+
+// newErrorResponse returns the status and body of the response to a request
+// failed with err.
+func newErrorResponse(err error) (int, errorResponse) {
+	rsp := errorResponse{Message: err.Error()}
+	var httpErr httpError
+	if errors.As(err, &httpErr) {
+		rsp.Code = httpErrorCode[httpErr.Status]
+		if rsp.Code == "" {
+			rsp.Code = router.ErrorCodeInternal
+		}
+		return httpErr.Status, rsp
+	}
+	rsp.Code = router.CodeOf(err)
+	rsp.Details = router.DetailsOf(err)
+	if rsp.Code == router.ErrorCodeInternal {
+		rsp.Code, rsp.Details = kubernetesErrorCode(err)
+	}
+	return errorCodeStatus[rsp.Code], rsp
+}
+
+// appResources are the resources created for the apps, the other resources
+// are the ones the router depends on, as gateways or custom resource
+// definitions, and are not expected to be missing.
+var appResources = map[schema.GroupResource]bool{
+	{Resource: "services"}:                                       true,
+	{Resource: "secrets"}:                                        true,
+	{Group: "networking.k8s.io", Resource: "ingresses"}:          true,
+	{Group: "gateway.networking.k8s.io", Resource: "httproutes"}: true,
+	{Group: "networking.istio.io", Resource: "virtualservices"}:  true,
+	{Group: "cert-manager.io", Resource: "certificates"}:         true,
+	{Group: "tsuru.io", Resource: "apps"}:                        true,
+}
+
+// kubernetesErrorCode returns the code of the errors returned by the
+// Kubernetes API, detailed by the kind and name of the resource. Only the
+// resources of the apps are reported as not found, the missing resources the
+// router depends on, as the gateways or the custom resource definitions of
+// the mode, make it unavailable.
+func kubernetesErrorCode(err error) (router.ErrorCode, map[string]string) {
+	code := router.ErrorCodeInternal
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return router.ErrorCodeUnavailable, nil
+	case k8sErrors.IsConflict(err):
+		code = router.ErrorCodeConflict
+	case k8sErrors.IsAlreadyExists(err):
+		code = router.ErrorCodeAlreadyExists
+	case k8sErrors.IsNotFound(err):
+		code = router.ErrorCodeUnavailable
+		if isAppResource(err) {
+			code = router.ErrorCodeNotFound
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case k8sErrors.IsForbidden(err), k8sErrors.IsUnauthorized(err):
+		code = router.ErrorCodeForbidden
+	case k8sErrors.IsInvalid(err), k8sErrors.IsBadRequest(err):
+		code = router.ErrorCodeInvalidResource
+	case k8sErrors.IsServerTimeout(err), k8sErrors.IsTimeout(err),
+		k8sErrors.IsTooManyRequests(err), k8sErrors.IsServiceUnavailable(err):
+		code = router.ErrorCodeUnavailable
+	default:
+		return code, nil
+	}
+	var status k8sErrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Details == nil {
+		return code, nil
+	}
+	details := map[string]string{}
+	if kind := status.Status().Details.Kind; kind != "" {
+		details["kind"] = kind
+	}
+	if name := status.Status().Details.Name; name != "" {
+		details["name"] = name
+	}
+	if len(details) == 0 {
+		return code, nil
+	}
+	return code, details
+}
+
+// isAppResource returns whether err is about a resource of the apps.
+func isAppResource(err error) bool {
+	var status k8sErrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Details == nil {
+		return false
+	}
+	details := status.Status().Details
+	return details.Name != "" && appResources[schema.GroupResource{Group: details.Group, Resource: details.Kind}]
+}
+
+func handleError(err error, w http.ResponseWriter, r *http.Request) {
+	if err == nil {
+		return
+	}
+	status, rsp := newErrorResponse(err)
+	level := slog.LevelError
+	if status < http.StatusInternalServerError {
+		level = slog.LevelWarn
 	}
+	observability.Logger(r.Context()).Log(r.Context(), level, "request failed",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"code", rsp.Code,
+		"error", err,
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rsp)
 }
 
 // AuthMiddleware is an http.Handler with Basic Auth
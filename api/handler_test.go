@@ -5,10 +5,17 @@
 package api
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tsuru/kubernetes-router/router"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 func TestHandler(t *testing.T) {
@@ -19,7 +26,51 @@ func TestHandler(t *testing.T) {
 		expectedStatus int
 	}{
 		{"withoutError", nil, "", http.StatusOK},
-		{"withGenericError", errors.New("internal error"), "internal error\n", http.StatusInternalServerError},
+		{"withGenericError", errors.New("internal error"), `{"code":"internal","message":"internal error"}`, http.StatusInternalServerError},
+		{"withHTTPError", httpError{Status: http.StatusBadRequest, Body: "target is required"}, `{"code":"invalid_request","message":"target is required"}`, http.StatusBadRequest},
+		{
+			"withRouterError",
+			fmt.Errorf("failed to add certificate: %w", router.NewError(router.ErrorCodeUnsupported, "cannot add certificate to ingress kr-myapp, it is managed by ACME").WithDetail("cname", "myapp.io")),
+			`{"code":"unsupported","message":"failed to add certificate: cannot add certificate to ingress kr-myapp, it is managed by ACME","details":{"cname":"myapp.io"}}`,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"withWrappedSentinel",
+			fmt.Errorf("%w: weight of default/myapp must be between 0 and 100", router.ErrInvalidWeights),
+			`{"code":"invalid_options","message":"invalid target weights: weight of default/myapp must be between 0 and 100"}`,
+			http.StatusBadRequest,
+		},
+		{
+			"withKubernetesConflict",
+			k8sErrors.NewConflict(schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"}, "kr-myapp", errors.New("the object has been modified")),
+			`{"code":"conflict","message":"Operation cannot be fulfilled on ingresses.networking.k8s.io \"kr-myapp\": the object has been modified","details":{"kind":"ingresses","name":"kr-myapp"}}`,
+			http.StatusConflict,
+		},
+		{
+			"withKubernetesForbidden",
+			k8sErrors.NewForbidden(schema.GroupResource{Resource: "services"}, "myapp", errors.New("no permission")),
+			`{"code":"forbidden","message":"services \"myapp\" is forbidden: no permission","details":{"kind":"services","name":"myapp"}}`,
+			http.StatusForbidden,
+		},
+		{
+			"withKubernetesAppNotFound",
+			k8sErrors.NewNotFound(schema.GroupResource{Group: "gateway.networking.k8s.io", Resource: "httproutes"}, "myapp"),
+			`{"code":"not_found","message":"httproutes.gateway.networking.k8s.io \"myapp\" not found","details":{"kind":"httproutes","name":"myapp"}}`,
+			http.StatusNotFound,
+		},
+		{
+			"withKubernetesGatewayNotFound",
+			fmt.Errorf("failed to get gateway: %w", k8sErrors.NewNotFound(schema.GroupResource{Group: "gateway.networking.k8s.io", Resource: "gateways"}, "my-gateway")),
+			`{"code":"unavailable","message":"failed to get gateway: gateways.gateway.networking.k8s.io \"my-gateway\" not found","details":{"kind":"gateways","name":"my-gateway"}}`,
+			http.StatusServiceUnavailable,
+		},
+		{
+			"withKubernetesResourceTypeNotFound",
+			k8sErrors.NewGenericServerResponse(http.StatusNotFound, "get", schema.GroupResource{}, "", "", 0, false),
+			`{"code":"unavailable","message":"the server could not find the requested resource"}`,
+			http.StatusServiceUnavailable,
+		},
+		{"withTimeout", fmt.Errorf("failed to get service: %w", context.DeadlineExceeded), `{"code":"unavailable","message":"failed to get service: context deadline exceeded"}`, http.StatusServiceUnavailable},
 	}
 	for _, tc := range tt {
 		tc := tc
@@ -34,8 +85,11 @@ func TestHandler(t *testing.T) {
 
 			response := w.Result()
 
-			if w.Body.String() != tc.expectedBody {
-				t.Errorf("Expected body to be %q. Got %q", tc.expectedBody, w.Body.String())
+			if tc.expectedBody == "" {
+				assert.Empty(t, w.Body.String())
+			} else {
+				assert.JSONEq(t, tc.expectedBody, w.Body.String())
+				assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
 			}
 			if response.StatusCode != tc.expectedStatus {
 				t.Errorf("Expected status %d. Got %d", tc.expectedStatus, response.StatusCode)
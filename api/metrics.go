@@ -0,0 +1,69 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tsuru/kubernetes-router/backend"
+)
+
+var (
+	routerRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubernetes_router_requests_total",
+		Help: "Total number of router operations by mode, cluster, operation and status code.",
+	}, []string{"mode", "cluster", "operation", "code"})
+	routerRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubernetes_router_request_duration_seconds",
+		Help:    "Duration of router operations by mode and operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"mode", "operation"})
+)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// instrumented counts the requests and observes the latency of the router
+// operation served by h. The modes are labeled as in backend.ModeLabel and the
+// requests are counted by the cluster serving them, "local" unless the backend
+// serves several clusters.
+func (a *RouterAPI) instrumented(operation string, h handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mode := backend.ModeLabel(mux.Vars(r)["mode"])
+		cluster := "local"
+		if clusterBackend, ok := a.Backend.(backend.ClusterLabelBackend); ok {
+			cluster = clusterBackend.ClusterLabel(r.Header)
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		routerRequestDuration.WithLabelValues(mode, operation).Observe(time.Since(start).Seconds())
+		routerRequests.WithLabelValues(mode, cluster, operation, strconv.Itoa(recorder.status)).Inc()
+	})
+}
@@ -0,0 +1,57 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tsuru/kubernetes-router/router"
+)
+
+func (s *RouterAPISuite) metricValue(metric prometheus.Metric) *dto.Metric {
+	var m dto.Metric
+	s.Require().NoError(metric.Write(&m))
+	return &m
+}
+
+func (s *RouterAPISuite) TestRequestMetrics() {
+	s.mockRouter.GetAddressesFn = func(id router.InstanceID) ([]string, error) {
+		return []string{"myapp"}, nil
+	}
+	s.mockRouter.RemoveFn = func(id router.InstanceID) error {
+		return errors.New("failed to remove")
+	}
+	succeeded := routerRequests.WithLabelValues("unknown", "local", "get_addresses", "200")
+	failed := routerRequests.WithLabelValues("default", "local", "remove", "500")
+	aliased := routerRequests.WithLabelValues("ingress-nginx", "local", "get_addresses", "404")
+	succeededBefore := s.metricValue(succeeded).GetCounter().GetValue()
+	failedBefore := s.metricValue(failed).GetCounter().GetValue()
+	aliasedBefore := s.metricValue(aliased).GetCounter().GetValue()
+	durationBefore := s.metricValue(routerRequestDuration.WithLabelValues("unknown", "get_addresses").(prometheus.Histogram)).GetHistogram().GetSampleCount()
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/api/mymode/backend/myapp", nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "http://localhost/api/backend/myapp", nil)
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, req)
+	s.Equal(http.StatusInternalServerError, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "http://localhost/api/nginx-ingress/backend/myapp", nil)
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, req)
+	s.Equal(http.StatusNotFound, w.Code)
+
+	s.Equal(succeededBefore+1, s.metricValue(succeeded).GetCounter().GetValue())
+	s.Equal(failedBefore+1, s.metricValue(failed).GetCounter().GetValue())
+	s.Equal(aliasedBefore+1, s.metricValue(aliased).GetCounter().GetValue())
+	s.Equal(durationBefore+1, s.metricValue(routerRequestDuration.WithLabelValues("unknown", "get_addresses").(prometheus.Histogram)).GetHistogram().GetSampleCount())
+}
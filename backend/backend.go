@@ -6,17 +6,31 @@ package backend
 
 import (
 	"context"
-	"errors"
 	"net/http"
 
 	"github.com/tsuru/kubernetes-router/router"
 )
 
 var (
-	ErrBackendNotFound = errors.New("Backend not found")
+	ErrBackendNotFound = &router.Error{Code: router.ErrorCodeNotFound, Message: "Backend not found"}
 )
 
 type Backend interface {
 	Router(ctx context.Context, mode string, header http.Header) (router.Router, error)
 	Healthcheck(ctx context.Context) error
 }
+
+// HealthcheckInfoBackend is a Backend that reports additional details
+// on the healthcheck endpoint
+type HealthcheckInfoBackend interface {
+	HealthcheckInfo() map[string]string
+}
+
+// ClusterLabelBackend is a Backend serving the routers of several clusters,
+// the metrics of the requests are labeled by cluster.
+type ClusterLabelBackend interface {
+	// ClusterLabel returns the cluster serving the request with the given
+	// headers: its name, "local" for the cluster of the router or "unknown"
+	// when the cluster is not configured.
+	ClusterLabel(headers http.Header) string
+}
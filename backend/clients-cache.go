@@ -0,0 +1,190 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	certmanagerv1clientset "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
+	"github.com/tsuru/kubernetes-router/kubernetes"
+	tsuruv1clientset "github.com/tsuru/tsuru/provision/kubernetes/pkg/client/clientset/versioned"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	kubernetesGO "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	sigsk8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	gatewayclient "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
+)
+
+const (
+	defaultClientsCacheTTL  = 10 * time.Minute
+	defaultClientsCacheSize = 100
+)
+
+// clusterClients holds every kubernetes client needed by the routers of a
+// single remote cluster. All clients are built eagerly so that they can be
+// safely shared between concurrent requests.
+type clusterClients struct {
+	base          *kubernetes.BaseService
+	gatewayClient gatewayclient.Interface
+}
+
+type clientsCacheEntry struct {
+	key       string
+	name      string
+	clients   *clusterClients
+	expiresAt time.Time
+}
+
+// clientsCache is a TTL and LRU bounded cache of cluster clients keyed by
+// cluster name and a hash of the credentials used to build them. Clusters
+// sharing a name with different credentials, as a cluster of the clusters file
+// and a kubeconfig sent in the request headers, get their own entries, the
+// stale ones are dropped by the TTL and the LRU.
+type clientsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	now     func() time.Time
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func newClientsCache(ttl time.Duration, size int) *clientsCache {
+	if ttl <= 0 {
+		ttl = defaultClientsCacheTTL
+	}
+	if size <= 0 {
+		size = defaultClientsCacheSize
+	}
+	return &clientsCache{
+		ttl:     ttl,
+		size:    size,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// get returns the cached clients for the cluster name and credentials hash
+// when the entry is not expired, otherwise build is called and its result is
+// stored.
+func (c *clientsCache) get(name, hash string, build func() (*clusterClients, error)) (*clusterClients, error) {
+	key := name + "\x00" + hash
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*clientsCacheEntry)
+		if c.now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.clients, nil
+		}
+		c.removeElement(elem)
+	}
+	c.mu.Unlock()
+
+	clients, err := build()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&clientsCacheEntry{
+		key:       key,
+		name:      name,
+		clients:   clients,
+		expiresAt: c.now().Add(c.ttl),
+	})
+	for c.lru.Len() > c.size {
+		c.removeElement(c.lru.Back())
+	}
+	return clients, nil
+}
+
+// invalidate removes the cached clients of a cluster, built from any
+// credentials.
+func (c *clientsCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*clientsCacheEntry).name == name {
+			c.removeElement(elem)
+		}
+		elem = next
+	}
+}
+
+// clear removes the cached clients of every cluster.
+func (c *clientsCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = map[string]*list.Element{}
+}
+
+func (c *clientsCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *clientsCache) removeElement(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*clientsCacheEntry)
+	delete(c.entries, entry.key)
+}
+
+func credentialsHash(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%d:%s;", len(part), part)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func newClusterClients(restConfig *rest.Config) (*clusterClients, error) {
+	k8sClient, err := kubernetesGO.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	tsuruClient, err := tsuruv1clientset.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	certManagerClient, err := certmanagerv1clientset.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	extensionsClient, err := apiextensionsclientset.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	sigsClient, err := sigsk8sclient.New(restConfig, sigsk8sclient.Options{})
+	if err != nil {
+		return nil, err
+	}
+	gatewayClient, err := gatewayclient.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterClients{
+		base: &kubernetes.BaseService{
+			Client:            k8sClient,
+			TsuruClient:       tsuruClient,
+			CertManagerClient: certManagerClient,
+			SigsClient:        sigsClient,
+			ExtensionsClient:  extensionsClient,
+			RestConfig:        restConfig,
+		},
+		gatewayClient: gatewayClient,
+	}, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientsCacheTTL(t *testing.T) {
+	now := time.Now()
+	cache := newClientsCache(time.Minute, 10)
+	cache.now = func() time.Time { return now }
+
+	builds := 0
+	build := func() (*clusterClients, error) {
+		builds++
+		return &clusterClients{}, nil
+	}
+
+	first, err := cache.get("c1", "hash", build)
+	require.NoError(t, err)
+	second, err := cache.get("c1", "hash", build)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.Equal(t, 1, builds)
+
+	now = now.Add(2 * time.Minute)
+	third, err := cache.get("c1", "hash", build)
+	require.NoError(t, err)
+	assert.NotSame(t, first, third)
+	assert.Equal(t, 2, builds)
+}
+
+func TestClientsCacheLRU(t *testing.T) {
+	cache := newClientsCache(time.Minute, 2)
+	build := func() (*clusterClients, error) {
+		return &clusterClients{}, nil
+	}
+
+	c1, err := cache.get("c1", "hash", build)
+	require.NoError(t, err)
+	_, err = cache.get("c2", "hash", build)
+	require.NoError(t, err)
+	// c1 becomes the most recently used entry, c2 is evicted
+	_, err = cache.get("c1", "hash", build)
+	require.NoError(t, err)
+	_, err = cache.get("c3", "hash", build)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, cache.len())
+	assert.Contains(t, cache.entries, "c1\x00hash")
+	assert.Contains(t, cache.entries, "c3\x00hash")
+	assert.NotContains(t, cache.entries, "c2\x00hash")
+
+	again, err := cache.get("c1", "hash", build)
+	require.NoError(t, err)
+	assert.Same(t, c1, again)
+}
+
+func TestClientsCacheHashChange(t *testing.T) {
+	cache := newClientsCache(0, 0)
+	build := func() (*clusterClients, error) {
+		return &clusterClients{}, nil
+	}
+
+	first, err := cache.get("c1", "hash-a", build)
+	require.NoError(t, err)
+	second, err := cache.get("c1", "hash-b", build)
+	require.NoError(t, err)
+	assert.NotSame(t, first, second)
+	assert.Equal(t, 2, cache.len())
+
+	// clusters sharing a name do not evict each other
+	again, err := cache.get("c1", "hash-a", build)
+	require.NoError(t, err)
+	assert.Same(t, first, again)
+
+	cache.invalidate("c1")
+	assert.Equal(t, 0, cache.len())
+}
+
+func TestClientsCacheBuildError(t *testing.T) {
+	cache := newClientsCache(0, 0)
+	_, err := cache.get("c1", "hash", func() (*clusterClients, error) {
+		return nil, errors.New("my error")
+	})
+	assert.EqualError(t, err, "my error")
+	assert.Equal(t, 0, cache.len())
+}
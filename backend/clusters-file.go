@@ -0,0 +1,137 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tsuru/kubernetes-router/observability"
+)
+
+var (
+	clustersFileGeneration = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kubernetes_router_clusters_file_generation",
+		Help: "Generation of the loaded clusters file, incremented on every successful reload.",
+	})
+	clustersFileReloadSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kubernetes_router_clusters_file_last_reload_success",
+		Help: "Whether the last reload of the clusters file succeeded.",
+	})
+	clustersFileReloadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kubernetes_router_clusters_file_reload_errors_total",
+		Help: "Total number of failed reloads of the clusters file.",
+	})
+)
+
+// LoadClustersFile reads and validates the clusters file at path.
+func LoadClustersFile(path string) (*ClustersFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseClustersFile(data)
+}
+
+func parseClustersFile(data []byte) (*ClustersFile, error) {
+	clustersFile := &ClustersFile{}
+	err := yaml.Unmarshal(data, clustersFile)
+	if err != nil {
+		return nil, err
+	}
+	err = clustersFile.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return clustersFile, nil
+}
+
+// Validate checks that the clusters are uniquely named and that their
+// credentials are well formed.
+func (f *ClustersFile) Validate() error {
+	names := map[string]bool{}
+	hasDefault := false
+	for i, cluster := range f.Clusters {
+		if cluster.Name == "" {
+			return fmt.Errorf("cluster at position %d has no name", i)
+		}
+		if names[cluster.Name] {
+			return fmt.Errorf("cluster %q is declared more than once", cluster.Name)
+		}
+		names[cluster.Name] = true
+		if cluster.Default {
+			if hasDefault {
+				return fmt.Errorf("cluster %q is marked as default but another default cluster is already declared", cluster.Name)
+			}
+			hasDefault = true
+		}
+		if cluster.Exec != nil && cluster.AuthProvider != nil {
+			return fmt.Errorf("cluster %q: both exec and authProvider mutually exclusive are set in the cluster config", cluster.Name)
+		}
+		if cluster.CA != "" {
+			if _, err := base64.StdEncoding.DecodeString(cluster.CA); err != nil {
+				return fmt.Errorf("cluster %q: invalid ca: %v", cluster.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ClustersFileWatcher periodically reads the clusters file and updates the
+// clusters of a MultiCluster backend whenever its content changes.
+type ClustersFileWatcher struct {
+	Path     string
+	Interval time.Duration
+	Backend  *MultiCluster
+
+	lastContent []byte
+}
+
+// Load reads the clusters file and updates the backend clusters when the
+// content has changed since the last load.
+func (w *ClustersFileWatcher) Load() error {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		return err
+	}
+	if w.lastContent != nil && bytes.Equal(data, w.lastContent) {
+		return nil
+	}
+	// invalid contents are also remembered so the same error is only
+	// reported once
+	w.lastContent = data
+	clustersFile, err := parseClustersFile(data)
+	if err != nil {
+		return err
+	}
+	w.Backend.UpdateClusters(clustersFile.Clusters)
+	return nil
+}
+
+// Watch reloads the clusters file every Interval until ctx is done. Invalid
+// files are reported through the backend and the previous clusters are kept.
+func (w *ClustersFileWatcher) Watch(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := w.Load()
+			if err != nil {
+				observability.Logger(ctx).Error("failed to reload clusters file", "path", w.Path, "error", err)
+				w.Backend.SetReloadError(err)
+			}
+		}
+	}
+}
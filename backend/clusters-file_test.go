@@ -0,0 +1,181 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/kubernetes-router/kubernetes"
+)
+
+func TestClustersFileValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			data: `
+clusters:
+  - name: c1
+    default: true
+    token: abc
+  - name: c2
+    ca: Y2EtZGF0YQ==
+`,
+		},
+		{
+			name: "missing name",
+			data: `
+clusters:
+  - token: abc
+`,
+			wantErr: "cluster at position 0 has no name",
+		},
+		{
+			name: "duplicated name",
+			data: `
+clusters:
+  - name: c1
+  - name: c1
+`,
+			wantErr: `cluster "c1" is declared more than once`,
+		},
+		{
+			name: "multiple defaults",
+			data: `
+clusters:
+  - name: c1
+    default: true
+  - name: c2
+    default: true
+`,
+			wantErr: `cluster "c2" is marked as default but another default cluster is already declared`,
+		},
+		{
+			name: "exec and auth provider",
+			data: `
+clusters:
+  - name: c1
+    exec:
+      command: my-cmd
+    authProvider:
+      name: gcp
+`,
+			wantErr: `cluster "c1": both exec and authProvider mutually exclusive are set in the cluster config`,
+		},
+		{
+			name: "invalid ca",
+			data: `
+clusters:
+  - name: c1
+    ca: not-base64!
+`,
+			wantErr: `cluster "c1": invalid ca`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseClustersFile([]byte(tt.data))
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClustersFileWatcherLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+clusters:
+  - name: c1
+    default: true
+    address: https://c1.example.com
+    token: token-1
+`), 0600))
+
+	multiCluster := &MultiCluster{
+		Namespace: "tsuru-test",
+		Fallback:  &fakeBackend{},
+	}
+	watcher := &ClustersFileWatcher{Path: path, Backend: multiCluster}
+	require.NoError(t, watcher.Load())
+	assert.Len(t, multiCluster.getClusters(), 1)
+	assert.Equal(t, map[string]string{"clusters-generation": "1"}, multiCluster.HealthcheckInfo())
+
+	// unchanged content does not bump the generation
+	require.NoError(t, watcher.Load())
+	assert.Equal(t, map[string]string{"clusters-generation": "1"}, multiCluster.HealthcheckInfo())
+
+	headers := http.Header{
+		"X-Tsuru-Cluster-Name":      []string{"c1"},
+		"X-Tsuru-Cluster-Addresses": []string{"https://c1.example.com"},
+	}
+	first, err := multiCluster.Router(ctx, "service", headers)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+clusters:
+  - name: c1
+    default: true
+    address: https://c1.example.com
+    token: token-2
+  - name: c2
+    address: https://c2.example.com
+`), 0600))
+	require.NoError(t, watcher.Load())
+	assert.Len(t, multiCluster.getClusters(), 2)
+	assert.Equal(t, map[string]string{"clusters-generation": "2"}, multiCluster.HealthcheckInfo())
+
+	second, err := multiCluster.Router(ctx, "service", headers)
+	require.NoError(t, err)
+	assert.NotSame(t, first.(*kubernetes.LBService).BaseService.Client, second.(*kubernetes.LBService).BaseService.Client)
+	assert.Equal(t, "token-2", second.(*kubernetes.LBService).BaseService.RestConfig.BearerToken)
+
+	// invalid content keeps the previous clusters
+	require.NoError(t, os.WriteFile(path, []byte(`
+clusters:
+  - name: c1
+  - name: c1
+`), 0600))
+	err = watcher.Load()
+	require.Error(t, err)
+	multiCluster.SetReloadError(err)
+	assert.Len(t, multiCluster.getClusters(), 2)
+	assert.Equal(t, map[string]string{
+		"clusters-generation":        "2",
+		"clusters-last-reload-error": `cluster "c1" is declared more than once`,
+	}, multiCluster.HealthcheckInfo())
+}
+
+func TestClustersFileWatcherWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clusters.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("clusters:\n  - name: c1\n"), 0600))
+
+	multiCluster := &MultiCluster{Fallback: &fakeBackend{}}
+	watcher := &ClustersFileWatcher{Path: path, Interval: 10 * time.Millisecond, Backend: multiCluster}
+	require.NoError(t, watcher.Load())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Watch(ctx)
+
+	require.NoError(t, os.WriteFile(path, []byte("clusters:\n  - name: c1\n  - name: c2\n"), 0600))
+	assert.Eventually(t, func() bool {
+		return len(multiCluster.getClusters()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -9,13 +9,18 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/tsuru/kubernetes-router/kubernetes"
 	"github.com/tsuru/kubernetes-router/observability"
 	"github.com/tsuru/kubernetes-router/router"
-	kubernetesGO "k8s.io/client-go/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
@@ -26,6 +31,17 @@ import (
 
 var _ Backend = &MultiCluster{}
 
+var (
+	clusterRouterRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubernetes_router_cluster_router_requests_total",
+		Help: "Total number of routers requested by cluster.",
+	}, []string{"cluster"})
+	clusterRouterErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubernetes_router_cluster_router_errors_total",
+		Help: "Total number of failures to build the router of a cluster, such as unknown clusters, disabled modes and invalid credentials.",
+	}, []string{"cluster"})
+)
+
 type ClusterConfig struct {
 	Name    string `json:"name"`
 	Default bool   `json:"default"`
@@ -35,6 +51,21 @@ type ClusterConfig struct {
 
 	AuthProvider *clientcmdapi.AuthProviderConfig `json:"authProvider"`
 	Exec         *clientcmdapi.ExecConfig         `json:"exec"`
+
+	// Router settings overriding the ones defined by command line flags,
+	// empty values fallback to the flags.
+	Namespace             string            `json:"namespace,omitempty"`
+	Modes                 []string          `json:"modes,omitempty"`
+	K8sTimeout            *metav1.Duration  `json:"k8sTimeout,omitempty"`
+	IngressClass          string            `json:"ingressClass,omitempty"`
+	DomainSuffix          string            `json:"domainSuffix,omitempty"`
+	GatewayName           string            `json:"gatewayName,omitempty"`
+	GatewayNamespace      string            `json:"gatewayNamespace,omitempty"`
+	AcmeIssuer            string            `json:"acmeIssuer,omitempty"`
+	IstioGatewaySelector  map[string]string `json:"istioGatewaySelector,omitempty"`
+	IstioGatewayNamespace string            `json:"istioGatewayNamespace,omitempty"`
+	Labels                map[string]string `json:"labels,omitempty"`
+	Annotations           map[string]string `json:"annotations,omitempty"`
 }
 
 type ClustersFile struct {
@@ -45,8 +76,27 @@ type MultiCluster struct {
 	Namespace  string
 	Fallback   Backend
 	K8sTimeout *time.Duration
-	Modes      []string
-	Clusters   []ClusterConfig
+	// Modes is the list of modes enabled on remote clusters, the first one
+	// is the default mode. It may be overridden by each cluster config.
+	Modes    []string
+	Clusters []ClusterConfig
+
+	// Settings configures the routers built for remote clusters
+	Settings RouterSettings
+
+	// ClientsCacheTTL is how long the kubernetes clients of a cluster are
+	// reused before being rebuilt, defaults to 10 minutes.
+	ClientsCacheTTL time.Duration
+	// ClientsCacheSize is the maximum number of clusters with cached
+	// clients, defaults to 100.
+	ClientsCacheSize int
+
+	cacheOnce sync.Once
+	cache     *clientsCache
+
+	clustersMu      sync.RWMutex
+	generation      int64
+	lastReloadError error
 }
 
 type TsuruKubeConfig struct {
@@ -55,14 +105,37 @@ type TsuruKubeConfig struct {
 }
 
 func (m *MultiCluster) Router(ctx context.Context, mode string, headers http.Header) (router.Router, error) {
-	timeout := time.Second * 10
-	if m.K8sTimeout != nil {
-		timeout = *m.K8sTimeout
+	cluster := m.ClusterLabel(headers)
+	r, err := m.router(ctx, mode, headers)
+	clusterRouterRequests.WithLabelValues(cluster).Inc()
+	if err != nil {
+		clusterRouterErrors.WithLabelValues(cluster).Inc()
 	}
+	return r, err
+}
 
-	var kubernetesRestConfig *rest.Config
-	var err error
+// ClusterLabel returns the cluster serving the request as labeled in the
+// metrics, the clusters missing from the clusters file are labeled as
+// "unknown" as the cluster name is read from the request.
+func (m *MultiCluster) ClusterLabel(headers http.Header) string {
+	name := headers.Get("X-Tsuru-Cluster-Name")
+	if headers.Get("X-Tsuru-Cluster-Kube-Config") != "" {
+		if name == "" || m.clusterByName(name).Name == "" {
+			return "unknown"
+		}
+		return name
+	}
+	if headers.Get("X-Tsuru-Cluster-Addresses") == "" {
+		return "local"
+	}
+	cluster, err := m.selectCluster(name)
+	if err != nil {
+		return "unknown"
+	}
+	return cluster.Name
+}
 
+func (m *MultiCluster) router(ctx context.Context, mode string, headers http.Header) (router.Router, error) {
 	name := headers.Get("X-Tsuru-Cluster-Name")
 	base64KubeConfig := headers.Get("X-Tsuru-Cluster-Kube-Config")
 
@@ -71,8 +144,12 @@ func (m *MultiCluster) Router(ctx context.Context, mode string, headers http.Hea
 		span.SetTag("cluster.name", name)
 	}
 
+	var selectedCluster ClusterConfig
+	var address string
+	var err error
+
 	if base64KubeConfig == "" {
-		address := headers.Get("X-Tsuru-Cluster-Addresses")
+		address = headers.Get("X-Tsuru-Cluster-Addresses")
 
 		if address == "" {
 			return m.Fallback.Router(ctx, mode, headers)
@@ -82,69 +159,151 @@ func (m *MultiCluster) Router(ctx context.Context, mode string, headers http.Hea
 			span.SetTag("cluster.address", address)
 		}
 
-		kubernetesRestConfig, err = m.getKubeConfigFromSettings(name, address, timeout)
+		selectedCluster, err = m.selectCluster(name)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		kubernetesRestConfig, err = m.getKubeConfigFromHeader(name, base64KubeConfig, timeout)
-		if err != nil {
-			return nil, err
-		}
+		// clusters authenticated through the header may still have router
+		// settings declared in the clusters file
+		selectedCluster = m.clusterByName(name)
 	}
 
-	k8sClient, err := kubernetesGO.NewForConfig(kubernetesRestConfig)
+	mode, err = m.resolveMode(mode, selectedCluster)
 	if err != nil {
 		return nil, err
 	}
 
-	baseService := &kubernetes.BaseService{
-		Namespace:  m.Namespace,
-		Timeout:    timeout,
-		Client:     k8sClient,
-		RestConfig: kubernetesRestConfig,
+	timeout := time.Second * 10
+	if selectedCluster.K8sTimeout != nil {
+		timeout = selectedCluster.K8sTimeout.Duration
+	} else if m.K8sTimeout != nil {
+		timeout = *m.K8sTimeout
 	}
 
-	if mode == "service" || mode == "loadbalancer" || mode == "" {
-		return &kubernetes.LBService{
-			BaseService: baseService,
-		}, nil
+	namespace := m.Namespace
+	if selectedCluster.Namespace != "" {
+		namespace = selectedCluster.Namespace
 	}
 
-	if mode == "ingress" {
-		return &kubernetes.IngressService{
-			BaseService: baseService,
-		}, nil
-	}
+	var hash string
+	var restConfigFn func() (*rest.Config, error)
 
-	if mode == "nginx-ingress" {
-		return &kubernetes.IngressService{
-			BaseService:       baseService,
-			IngressClass:      "nginx",
-			AnnotationsPrefix: "nginx.ingress.kubernetes.io",
-			// TODO(wpjunior): may router opts plugged in here ?
-		}, nil
+	if base64KubeConfig == "" {
+		clusterData, err := json.Marshal(selectedCluster)
+		if err != nil {
+			return nil, err
+		}
+		hash = credentialsHash(string(clusterData), address, timeout.String())
+		restConfigFn = func() (*rest.Config, error) {
+			return m.getKubeConfigFromSettings(selectedCluster, address, timeout)
+		}
+	} else {
+		hash = credentialsHash(base64KubeConfig, timeout.String())
+		restConfigFn = func() (*rest.Config, error) {
+			return m.getKubeConfigFromHeader(name, base64KubeConfig, timeout)
+		}
 	}
 
-	if mode == "istio-gateway" {
-		return &kubernetes.IstioGateway{
-			BaseService: baseService,
-		}, nil
+	clients, err := m.clientsCache().get(name, hash, func() (*clusterClients, error) {
+		restConfig, err := restConfigFn()
+		if err != nil {
+			return nil, err
+		}
+		return newClusterClients(restConfig)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if mode == "gateway-api" {
-		return &kubernetes.GatewayAPIService{
-			BaseService: baseService,
-		}, nil
-	}
+	baseService := *clients.base
+	baseService.Namespace = namespace
+	baseService.Timeout = timeout
 
-	return nil, errors.New("Mode not found")
+	settings := m.Settings.withClusterOverrides(selectedCluster)
+	return settings.router(mode, &baseService, clients.gatewayClient)
 }
 
 func (m *MultiCluster) Healthcheck(ctx context.Context) error {
 	return m.Fallback.Healthcheck(ctx)
 }
 
+// InvalidateCluster drops the cached kubernetes clients of the given cluster,
+// forcing them to be rebuilt on the next request.
+func (m *MultiCluster) InvalidateCluster(name string) {
+	m.clientsCache().invalidate(name)
+}
+
+// UpdateClusters atomically replaces the clusters configuration, the cached
+// clients of every changed or removed cluster are invalidated.
+func (m *MultiCluster) UpdateClusters(clusters []ClusterConfig) {
+	m.clustersMu.Lock()
+	previous := m.Clusters
+	m.Clusters = clusters
+	m.generation++
+	m.lastReloadError = nil
+	generation := m.generation
+	m.clustersMu.Unlock()
+
+	clustersFileGeneration.Set(float64(generation))
+	clustersFileReloadSuccess.Set(1)
+
+	current := map[string]ClusterConfig{}
+	for _, cluster := range clusters {
+		current[cluster.Name] = cluster
+	}
+	for _, cluster := range previous {
+		if reflect.DeepEqual(current[cluster.Name], cluster) {
+			continue
+		}
+		if cluster.Default || current[cluster.Name].Default {
+			// requests for unknown clusters may have cached clients
+			// built from the default cluster under their own names
+			m.clientsCache().clear()
+			return
+		}
+		m.InvalidateCluster(cluster.Name)
+	}
+}
+
+// SetReloadError records a failure to reload the clusters configuration, the
+// previously loaded clusters are kept.
+func (m *MultiCluster) SetReloadError(err error) {
+	m.clustersMu.Lock()
+	m.lastReloadError = err
+	m.clustersMu.Unlock()
+
+	clustersFileReloadErrors.Inc()
+	clustersFileReloadSuccess.Set(0)
+}
+
+// HealthcheckInfo reports the generation of the loaded clusters configuration
+// and the last reload error, if any.
+func (m *MultiCluster) HealthcheckInfo() map[string]string {
+	m.clustersMu.RLock()
+	defer m.clustersMu.RUnlock()
+	info := map[string]string{
+		"clusters-generation": strconv.FormatInt(m.generation, 10),
+	}
+	if m.lastReloadError != nil {
+		info["clusters-last-reload-error"] = m.lastReloadError.Error()
+	}
+	return info
+}
+
+func (m *MultiCluster) getClusters() []ClusterConfig {
+	m.clustersMu.RLock()
+	defer m.clustersMu.RUnlock()
+	return m.Clusters
+}
+
+func (m *MultiCluster) clientsCache() *clientsCache {
+	m.cacheOnce.Do(func() {
+		m.cache = newClientsCache(m.ClientsCacheTTL, m.ClientsCacheSize)
+	})
+	return m.cache
+}
+
 func (m *MultiCluster) getKubeConfigFromHeader(name, base64KubeConfig string, timeout time.Duration) (*rest.Config, error) {
 	kubeConfigData, err := base64.StdEncoding.DecodeString(base64KubeConfig)
 	if err != nil {
@@ -181,15 +340,45 @@ func (m *MultiCluster) getKubeConfigFromHeader(name, base64KubeConfig string, ti
 	}
 	restConfig.Timeout = timeout
 	restConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
-		return transport.DebugWrappers(observability.WrapTransport(rt))
+		return transport.DebugWrappers(observability.WrapClusterTransport(name, kubernetes.WrapDryRunTransport(rt)))
 	}
 	return restConfig, nil
 }
 
-func (m *MultiCluster) getKubeConfigFromSettings(name, address string, timeout time.Duration) (*rest.Config, error) {
+// resolveMode returns the requested mode when it is enabled for the cluster,
+// an empty mode resolves to the first enabled mode.
+func (m *MultiCluster) resolveMode(mode string, cluster ClusterConfig) (string, error) {
+	modes := m.Modes
+	if len(cluster.Modes) > 0 {
+		modes = cluster.Modes
+	}
+	if len(modes) == 0 {
+		return mode, nil
+	}
+	if mode == "" {
+		return modes[0], nil
+	}
+	for _, enabledMode := range modes {
+		if canonicalMode(enabledMode) == canonicalMode(mode) {
+			return mode, nil
+		}
+	}
+	return "", ErrBackendNotFound
+}
+
+func (m *MultiCluster) clusterByName(name string) ClusterConfig {
+	for _, cluster := range m.getClusters() {
+		if cluster.Name == name {
+			return cluster
+		}
+	}
+	return ClusterConfig{}
+}
+
+func (m *MultiCluster) selectCluster(name string) (ClusterConfig, error) {
 	selectedCluster := ClusterConfig{}
 
-	for _, cluster := range m.Clusters {
+	for _, cluster := range m.getClusters() {
 		if cluster.Default {
 			selectedCluster = cluster
 		}
@@ -200,9 +389,13 @@ func (m *MultiCluster) getKubeConfigFromSettings(name, address string, timeout t
 	}
 
 	if selectedCluster.Name == "" {
-		return nil, errors.New("cluster not found")
+		return ClusterConfig{}, router.NewError(router.ErrorCodeNotFound, "cluster not found").WithDetail("cluster", name)
 	}
 
+	return selectedCluster, nil
+}
+
+func (m *MultiCluster) getKubeConfigFromSettings(selectedCluster ClusterConfig, address string, timeout time.Duration) (*rest.Config, error) {
 	if selectedCluster.Address != "" {
 		address = selectedCluster.Address
 	}
@@ -212,7 +405,7 @@ func (m *MultiCluster) getKubeConfigFromSettings(name, address string, timeout t
 		BearerToken: selectedCluster.Token,
 		Timeout:     timeout,
 		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
-			return transport.DebugWrappers(observability.WrapTransport(rt))
+			return transport.DebugWrappers(observability.WrapClusterTransport(selectedCluster.Name, kubernetes.WrapDryRunTransport(rt)))
 		},
 	}
 
@@ -225,8 +418,9 @@ func (m *MultiCluster) getKubeConfigFromSettings(name, address string, timeout t
 	}
 
 	if selectedCluster.Exec != nil {
-		restConfig.ExecProvider = selectedCluster.Exec
-		restConfig.ExecProvider.InteractiveMode = "Never"
+		execConfig := *selectedCluster.Exec
+		execConfig.InteractiveMode = "Never"
+		restConfig.ExecProvider = &execConfig
 	}
 
 	if selectedCluster.CA != "" {
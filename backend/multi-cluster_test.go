@@ -13,8 +13,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ghodss/yaml"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tsuru/kubernetes-router/kubernetes"
@@ -434,3 +437,467 @@ func TestMultiClusterIstioGateway(t *testing.T) {
 	assert.Equal(t, "https://mycluster.com", istioGateway.BaseService.RestConfig.Host)
 	assert.Equal(t, "my-token", istioGateway.BaseService.RestConfig.BearerToken)
 }
+
+func TestMultiClusterCachesClients(t *testing.T) {
+	backend := &MultiCluster{
+		Namespace: "tsuru-test",
+		Fallback:  &fakeBackend{},
+		Clusters: []ClusterConfig{
+			{
+				Name:  "my-cluster",
+				Token: "my-token",
+			},
+		},
+	}
+	headers := http.Header{
+		"X-Tsuru-Cluster-Name": []string{
+			"my-cluster",
+		},
+		"X-Tsuru-Cluster-Addresses": []string{
+			"https://mycluster.com",
+		},
+	}
+	first, err := backend.Router(ctx, "service", headers)
+	require.NoError(t, err)
+	second, err := backend.Router(ctx, "ingress", headers)
+	require.NoError(t, err)
+	assert.Same(t, first.(*kubernetes.LBService).BaseService.Client, second.(*kubernetes.IngressService).BaseService.Client)
+	assert.NotNil(t, first.(*kubernetes.LBService).BaseService.Client)
+	assert.NotNil(t, first.(*kubernetes.LBService).BaseService.TsuruClient)
+	assert.NotNil(t, first.(*kubernetes.LBService).BaseService.CertManagerClient)
+	assert.NotNil(t, first.(*kubernetes.LBService).BaseService.ExtensionsClient)
+	assert.NotNil(t, first.(*kubernetes.LBService).BaseService.SigsClient)
+
+	gateway, err := backend.Router(ctx, "gateway-api", headers)
+	require.NoError(t, err)
+	assert.Same(t, first.(*kubernetes.LBService).BaseService.Client, gateway.(*kubernetes.GatewayAPIService).BaseService.Client)
+	assert.NotNil(t, gateway.(*kubernetes.GatewayAPIService).GatewayClient)
+
+	backend.InvalidateCluster("my-cluster")
+	third, err := backend.Router(ctx, "service", headers)
+	require.NoError(t, err)
+	assert.NotSame(t, first.(*kubernetes.LBService).BaseService.Client, third.(*kubernetes.LBService).BaseService.Client)
+}
+
+func TestMultiClusterCacheInvalidatedOnCredentialsChange(t *testing.T) {
+	backend := &MultiCluster{
+		Namespace: "tsuru-test",
+		Fallback:  &fakeBackend{},
+		Clusters: []ClusterConfig{
+			{
+				Name:  "my-cluster",
+				Token: "my-token",
+			},
+		},
+	}
+	headers := http.Header{
+		"X-Tsuru-Cluster-Name": []string{
+			"my-cluster",
+		},
+		"X-Tsuru-Cluster-Addresses": []string{
+			"https://mycluster.com",
+		},
+	}
+	first, err := backend.Router(ctx, "service", headers)
+	require.NoError(t, err)
+
+	backend.Clusters[0].Token = "my-new-token"
+	second, err := backend.Router(ctx, "service", headers)
+	require.NoError(t, err)
+	assert.NotSame(t, first.(*kubernetes.LBService).BaseService.Client, second.(*kubernetes.LBService).BaseService.Client)
+	assert.Equal(t, "my-new-token", second.(*kubernetes.LBService).BaseService.RestConfig.BearerToken)
+	// the clients built from the previous token are dropped by the TTL
+	assert.Equal(t, 2, backend.clientsCache().len())
+}
+
+func TestMultiClusterCachesClientsWithKubeConfig(t *testing.T) {
+	newHeaders := func(token string) http.Header {
+		kubeConfigData, err := json.Marshal(&TsuruKubeConfig{
+			Cluster: api.Cluster{
+				Server: "https://mycluster-from-kubeconfig.com",
+			},
+			AuthInfo: api.AuthInfo{
+				Token: token,
+			},
+		})
+		require.NoError(t, err)
+		return http.Header{
+			"X-Tsuru-Cluster-Name": []string{
+				"my-cluster-from-kubeconfig",
+			},
+			"X-Tsuru-Cluster-Kube-Config": []string{
+				base64.StdEncoding.EncodeToString(kubeConfigData),
+			},
+		}
+	}
+
+	backend := &MultiCluster{
+		Namespace: "tsuru-test",
+		Fallback:  &fakeBackend{},
+	}
+	first, err := backend.Router(ctx, "service", newHeaders("token-a"))
+	require.NoError(t, err)
+	second, err := backend.Router(ctx, "service", newHeaders("token-a"))
+	require.NoError(t, err)
+	assert.Same(t, first.(*kubernetes.LBService).BaseService.Client, second.(*kubernetes.LBService).BaseService.Client)
+
+	third, err := backend.Router(ctx, "service", newHeaders("token-b"))
+	require.NoError(t, err)
+	assert.NotSame(t, first.(*kubernetes.LBService).BaseService.Client, third.(*kubernetes.LBService).BaseService.Client)
+	assert.Equal(t, "token-b", third.(*kubernetes.LBService).BaseService.RestConfig.BearerToken)
+}
+
+func TestMultiClusterPropagatesRouterSettings(t *testing.T) {
+	backend := &MultiCluster{
+		Namespace: "tsuru-test",
+		Fallback:  &fakeBackend{},
+		Clusters: []ClusterConfig{
+			{
+				Name:    "default-token",
+				Token:   "my-token",
+				Default: true,
+			},
+		},
+		Settings: RouterSettings{
+			Labels:                   map[string]string{"label": "value"},
+			Annotations:              map[string]string{"annotation": "value"},
+			DomainSuffix:             "apps.example.com",
+			IngressClass:             "my-class",
+			IngressAnnotationsPrefix: "my-prefix",
+			UseIngressClassName:      true,
+			HTTPPort:                 8080,
+			OptsAsAnnotations:        map[string]string{"opt": "my/annotation"},
+			OptsAsAnnotationsDocs:    map[string]string{"opt": "my doc"},
+			GatewayName:              "my-gateway",
+			GatewayNamespace:         "gateway-ns",
+			AcmeIssuer:               "my-issuer",
+			GatewaySelector:          map[string]string{"istio": "ingress"},
+			IstioGatewayNamespace:    "istio-ingress",
+			OptsAsLabels:             map[string]string{"opt": "my-label"},
+			OptsAsLabelsDocs:         map[string]string{"opt": "my label doc"},
+			PoolLabels:               map[string]map[string]string{"pool": {"pool-label": "value"}},
+		},
+	}
+	headers := http.Header{
+		"X-Tsuru-Cluster-Name": []string{
+			"my-cluster",
+		},
+		"X-Tsuru-Cluster-Addresses": []string{
+			"https://mycluster.com",
+		},
+	}
+
+	r, err := backend.Router(ctx, "service", headers)
+	require.NoError(t, err)
+	lbService, ok := r.(*kubernetes.LBService)
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"label": "value"}, lbService.BaseService.Labels)
+	assert.Equal(t, map[string]string{"annotation": "value"}, lbService.BaseService.Annotations)
+	assert.Equal(t, map[string]string{"opt": "my-label"}, lbService.OptsAsLabels)
+	assert.Equal(t, map[string]string{"opt": "my label doc"}, lbService.OptsAsLabelsDocs)
+	assert.Equal(t, map[string]map[string]string{"pool": {"pool-label": "value"}}, lbService.PoolLabels)
+
+	r, err = backend.Router(ctx, "ingress", headers)
+	require.NoError(t, err)
+	ingressService, ok := r.(*kubernetes.IngressService)
+	require.True(t, ok)
+	assert.Equal(t, "apps.example.com", ingressService.DomainSuffix)
+	assert.Equal(t, "my-class", ingressService.IngressClass)
+	assert.Equal(t, "my-prefix", ingressService.AnnotationsPrefix)
+	assert.True(t, ingressService.UseIngressClassName)
+	assert.Equal(t, 8080, ingressService.HTTPPort)
+	assert.Equal(t, map[string]string{"opt": "my/annotation"}, ingressService.OptsAsAnnotations)
+	assert.Equal(t, map[string]string{"opt": "my doc"}, ingressService.OptsAsAnnotationsDocs)
+
+	r, err = backend.Router(ctx, "nginx-ingress", headers)
+	require.NoError(t, err)
+	ingressService, ok = r.(*kubernetes.IngressService)
+	require.True(t, ok)
+	assert.Equal(t, "apps.example.com", ingressService.DomainSuffix)
+	assert.Equal(t, "nginx", ingressService.IngressClass)
+	assert.Equal(t, "nginx.ingress.kubernetes.io", ingressService.AnnotationsPrefix)
+
+	r, err = backend.Router(ctx, "istio-gateway", headers)
+	require.NoError(t, err)
+	istioGateway, ok := r.(*kubernetes.IstioGateway)
+	require.True(t, ok)
+	assert.Equal(t, "apps.example.com", istioGateway.DomainSuffix)
+	assert.Equal(t, map[string]string{"istio": "ingress"}, istioGateway.GatewaySelector)
+	assert.Equal(t, "istio-ingress", istioGateway.GatewayNamespace)
+	assert.Equal(t, "my-issuer", istioGateway.AcmeIssuer)
+
+	r, err = backend.Router(ctx, "gateway-api", headers)
+	require.NoError(t, err)
+	gatewayAPI, ok := r.(*kubernetes.GatewayAPIService)
+	require.True(t, ok)
+	assert.Equal(t, "apps.example.com", gatewayAPI.DomainSuffix)
+	assert.Equal(t, "my-gateway", gatewayAPI.GatewayName)
+	assert.Equal(t, "gateway-ns", gatewayAPI.GatewayNamespace)
+	assert.Equal(t, "my-issuer", gatewayAPI.AcmeIssuer)
+
+	_, err = backend.Router(ctx, "unknown", headers)
+	assert.EqualError(t, err, "Mode not found")
+}
+
+func TestMultiClusterPerClusterSettings(t *testing.T) {
+	clustersFile := &ClustersFile{}
+	err := yaml.Unmarshal([]byte(`
+clusters:
+- name: my-cluster
+  token: my-token
+  namespace: custom-namespace
+  modes: [ingress, gateway-api]
+  k8sTimeout: 30s
+  ingressClass: my-class
+  domainSuffix: cluster.example.com
+  gatewayName: cluster-gateway
+  gatewayNamespace: cluster-gateway-ns
+  acmeIssuer: cluster-issuer
+  istioGatewaySelector:
+    istio: cluster-ingress
+  labels:
+    cluster-label: cluster-value
+  annotations:
+    cluster-annotation: cluster-value
+`), clustersFile)
+	require.NoError(t, err)
+
+	backend := &MultiCluster{
+		Namespace: "tsuru-test",
+		Fallback:  &fakeBackend{},
+		Modes:     []string{"service"},
+		Clusters:  clustersFile.Clusters,
+		Settings: RouterSettings{
+			Labels:       map[string]string{"label": "value", "cluster-label": "global-value"},
+			DomainSuffix: "apps.example.com",
+			IngressClass: "my-global-class",
+			GatewayName:  "my-gateway",
+		},
+	}
+	headers := http.Header{
+		"X-Tsuru-Cluster-Name": []string{
+			"my-cluster",
+		},
+		"X-Tsuru-Cluster-Addresses": []string{
+			"https://mycluster.com",
+		},
+	}
+
+	r, err := backend.Router(ctx, "", headers)
+	require.NoError(t, err)
+	ingressService, ok := r.(*kubernetes.IngressService)
+	require.True(t, ok)
+	assert.Equal(t, "custom-namespace", ingressService.BaseService.Namespace)
+	assert.Equal(t, 30*time.Second, ingressService.BaseService.Timeout)
+	assert.Equal(t, 30*time.Second, ingressService.BaseService.RestConfig.Timeout)
+	assert.Equal(t, "my-class", ingressService.IngressClass)
+	assert.Equal(t, "cluster.example.com", ingressService.DomainSuffix)
+	assert.Equal(t, map[string]string{"label": "value", "cluster-label": "cluster-value"}, ingressService.BaseService.Labels)
+	assert.Equal(t, map[string]string{"cluster-annotation": "cluster-value"}, ingressService.BaseService.Annotations)
+
+	r, err = backend.Router(ctx, "gateway-api", headers)
+	require.NoError(t, err)
+	gatewayAPI, ok := r.(*kubernetes.GatewayAPIService)
+	require.True(t, ok)
+	assert.Equal(t, "cluster-gateway", gatewayAPI.GatewayName)
+	assert.Equal(t, "cluster-gateway-ns", gatewayAPI.GatewayNamespace)
+	assert.Equal(t, "cluster-issuer", gatewayAPI.AcmeIssuer)
+
+	_, err = backend.Router(ctx, "service", headers)
+	assert.Equal(t, ErrBackendNotFound, err)
+}
+
+func TestMultiClusterModesAllowlist(t *testing.T) {
+	backend := &MultiCluster{
+		Namespace: "tsuru-test",
+		Fallback:  &fakeBackend{},
+		Modes:     []string{"ingress-nginx", "service"},
+		Clusters: []ClusterConfig{
+			{
+				Name:    "default-token",
+				Token:   "my-token",
+				Default: true,
+			},
+			{
+				Name:                  "istio-cluster",
+				Token:                 "my-token",
+				Modes:                 []string{"istio-gateway"},
+				IstioGatewaySelector:  map[string]string{"istio": "cluster-ingress"},
+				IstioGatewayNamespace: "cluster-istio",
+			},
+		},
+	}
+	headers := http.Header{
+		"X-Tsuru-Cluster-Name": []string{
+			"my-cluster",
+		},
+		"X-Tsuru-Cluster-Addresses": []string{
+			"https://mycluster.com",
+		},
+	}
+
+	r, err := backend.Router(ctx, "", headers)
+	require.NoError(t, err)
+	ingressService, ok := r.(*kubernetes.IngressService)
+	require.True(t, ok)
+	assert.Equal(t, "nginx", ingressService.IngressClass)
+
+	r, err = backend.Router(ctx, "nginx-ingress", headers)
+	require.NoError(t, err)
+	_, ok = r.(*kubernetes.IngressService)
+	assert.True(t, ok)
+
+	r, err = backend.Router(ctx, "loadbalancer", headers)
+	require.NoError(t, err)
+	_, ok = r.(*kubernetes.LBService)
+	assert.True(t, ok)
+
+	_, err = backend.Router(ctx, "istio-gateway", headers)
+	assert.Equal(t, ErrBackendNotFound, err)
+
+	headers.Set("X-Tsuru-Cluster-Name", "istio-cluster")
+	r, err = backend.Router(ctx, "istio-gateway", headers)
+	require.NoError(t, err)
+	istioGateway, ok := r.(*kubernetes.IstioGateway)
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"istio": "cluster-ingress"}, istioGateway.GatewaySelector)
+	assert.Equal(t, "cluster-istio", istioGateway.GatewayNamespace)
+
+	_, err = backend.Router(ctx, "ingress-nginx", headers)
+	assert.Equal(t, ErrBackendNotFound, err)
+}
+
+func TestMultiClusterKubeConfigWithClusterSettings(t *testing.T) {
+	kubeConfigData, err := json.Marshal(&TsuruKubeConfig{
+		Cluster: api.Cluster{
+			Server: "https://mycluster-from-kubeconfig.com",
+		},
+		AuthInfo: api.AuthInfo{
+			Token: "my-token-from-kubeconfig",
+		},
+	})
+	require.NoError(t, err)
+
+	backend := &MultiCluster{
+		Namespace: "tsuru-test",
+		Fallback:  &fakeBackend{},
+		Clusters: []ClusterConfig{
+			{
+				Name:    "default-cluster",
+				Default: true,
+				Modes:   []string{"service"},
+			},
+			{
+				Name:         "my-cluster-from-kubeconfig",
+				Namespace:    "custom-namespace",
+				DomainSuffix: "cluster.example.com",
+			},
+		},
+	}
+	r, err := backend.Router(ctx, "ingress", http.Header{
+		"X-Tsuru-Cluster-Name": []string{
+			"my-cluster-from-kubeconfig",
+		},
+		"X-Tsuru-Cluster-Kube-Config": []string{
+			base64.StdEncoding.EncodeToString(kubeConfigData),
+		},
+	})
+	require.NoError(t, err)
+	ingressService, ok := r.(*kubernetes.IngressService)
+	require.True(t, ok)
+	assert.Equal(t, "custom-namespace", ingressService.BaseService.Namespace)
+	assert.Equal(t, "cluster.example.com", ingressService.DomainSuffix)
+	assert.Equal(t, "https://mycluster-from-kubeconfig.com", ingressService.BaseService.RestConfig.Host)
+}
+
+func TestMultiClusterRouterMetrics(t *testing.T) {
+	backend := &MultiCluster{
+		Namespace: "tsuru-test",
+		Fallback:  &fakeBackend{},
+		Clusters: []ClusterConfig{
+			{
+				Name:  "metrics-cluster",
+				Token: "my-token",
+			},
+		},
+	}
+	counterValue := func(counter *prometheus.CounterVec, cluster string) float64 {
+		var m dto.Metric
+		require.NoError(t, counter.WithLabelValues(cluster).Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	headers := func(cluster string) http.Header {
+		return http.Header{
+			"X-Tsuru-Cluster-Name":      []string{cluster},
+			"X-Tsuru-Cluster-Addresses": []string{"https://mycluster.com"},
+		}
+	}
+
+	unknownRequests := counterValue(clusterRouterRequests, "unknown")
+	unknownErrors := counterValue(clusterRouterErrors, "unknown")
+
+	_, err := backend.Router(ctx, "service", headers("metrics-cluster"))
+	require.NoError(t, err)
+	_, err = backend.Router(ctx, "service", headers("metrics-unknown-cluster"))
+	assert.EqualError(t, err, "cluster not found")
+
+	assert.Equal(t, float64(1), counterValue(clusterRouterRequests, "metrics-cluster"))
+	assert.Equal(t, float64(0), counterValue(clusterRouterErrors, "metrics-cluster"))
+	assert.Equal(t, float64(0), counterValue(clusterRouterRequests, "metrics-unknown-cluster"))
+	assert.Equal(t, unknownRequests+1, counterValue(clusterRouterRequests, "unknown"))
+	assert.Equal(t, unknownErrors+1, counterValue(clusterRouterErrors, "unknown"))
+}
+
+func TestMultiClusterClusterLabel(t *testing.T) {
+	backend := &MultiCluster{
+		Namespace: "tsuru-test",
+		Fallback:  &fakeBackend{},
+		Clusters: []ClusterConfig{
+			{
+				Name:    "default-cluster",
+				Default: true,
+			},
+			{
+				Name: "my-cluster",
+			},
+		},
+	}
+	tests := []struct {
+		headers  http.Header
+		expected string
+	}{
+		{headers: http.Header{}, expected: "local"},
+		{headers: http.Header{"X-Tsuru-Cluster-Name": []string{"my-cluster"}}, expected: "local"},
+		{
+			headers: http.Header{
+				"X-Tsuru-Cluster-Name":      []string{"my-cluster"},
+				"X-Tsuru-Cluster-Addresses": []string{"https://mycluster.com"},
+			},
+			expected: "my-cluster",
+		},
+		{
+			headers: http.Header{
+				"X-Tsuru-Cluster-Name":      []string{"other-cluster"},
+				"X-Tsuru-Cluster-Addresses": []string{"https://mycluster.com"},
+			},
+			expected: "default-cluster",
+		},
+		{
+			headers: http.Header{
+				"X-Tsuru-Cluster-Name":        []string{"my-cluster"},
+				"X-Tsuru-Cluster-Kube-Config": []string{"config"},
+			},
+			expected: "my-cluster",
+		},
+		{
+			headers: http.Header{
+				"X-Tsuru-Cluster-Name":        []string{"other-cluster"},
+				"X-Tsuru-Cluster-Kube-Config": []string{"config"},
+			},
+			expected: "unknown",
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, backend.ClusterLabel(tt.headers), tt.headers)
+	}
+}
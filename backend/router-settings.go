@@ -0,0 +1,181 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"slices"
+
+	"github.com/tsuru/kubernetes-router/kubernetes"
+	"github.com/tsuru/kubernetes-router/router"
+	gatewayclient "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
+)
+
+// modeAliases maps alternative mode names to the canonical ones
+var modeAliases = map[string]string{
+	"":              "service",
+	"loadbalancer":  "service",
+	"nginx-ingress": "ingress-nginx",
+}
+
+func canonicalMode(mode string) string {
+	if canonical, ok := modeAliases[mode]; ok {
+		return canonical
+	}
+	return mode
+}
+
+// knownModes are the canonical names of the modes of the routers
+var knownModes = []string{"service", "ingress", "ingress-nginx", "istio-gateway", "gateway-api"}
+
+// ModeLabel returns the mode of a request as labeled in the metrics, the
+// canonical name of the mode, "default" when it is not set or "unknown" when
+// there is no such mode.
+func ModeLabel(mode string) string {
+	if mode == "" {
+		return "default"
+	}
+	mode = canonicalMode(mode)
+	if !slices.Contains(knownModes, mode) {
+		return "unknown"
+	}
+	return mode
+}
+
+// RouterSettings holds the mode-specific configuration used to build the
+// routers of a cluster, it mirrors the flags used to configure the routers
+// of the local cluster.
+type RouterSettings struct {
+	// Labels and Annotations are added to each resource created
+	Labels      map[string]string
+	Annotations map[string]string
+
+	// DomainSuffix is used by ingress, istio-gateway and gateway-api modes
+	DomainSuffix string
+
+	// ingress and nginx-ingress modes
+	IngressClass             string
+	IngressAnnotationsPrefix string
+	UseIngressClassName      bool
+	HTTPPort                 int
+	OptsAsAnnotations        map[string]string
+	OptsAsAnnotationsDocs    map[string]string
+
+	// gateway-api mode
+	GatewayName          string
+	GatewayNamespace     string
+	AcmeIssuer           string
+	GatewayHTTPListener  string
+	GatewayHTTPSListener string
+
+	// istio-gateway mode
+	GatewaySelector       map[string]string
+	IstioGatewayNamespace string
+
+	// service and loadbalancer modes
+	OptsAsLabels     map[string]string
+	OptsAsLabelsDocs map[string]string
+	PoolLabels       map[string]map[string]string
+}
+
+// withClusterOverrides returns a copy of the settings with the values declared
+// by the cluster config taking precedence.
+func (s RouterSettings) withClusterOverrides(cluster ClusterConfig) RouterSettings {
+	if cluster.IngressClass != "" {
+		s.IngressClass = cluster.IngressClass
+	}
+	if cluster.DomainSuffix != "" {
+		s.DomainSuffix = cluster.DomainSuffix
+	}
+	if cluster.GatewayName != "" {
+		s.GatewayName = cluster.GatewayName
+	}
+	if cluster.GatewayNamespace != "" {
+		s.GatewayNamespace = cluster.GatewayNamespace
+	}
+	if cluster.AcmeIssuer != "" {
+		s.AcmeIssuer = cluster.AcmeIssuer
+	}
+	if len(cluster.IstioGatewaySelector) > 0 {
+		s.GatewaySelector = cluster.IstioGatewaySelector
+	}
+	if cluster.IstioGatewayNamespace != "" {
+		s.IstioGatewayNamespace = cluster.IstioGatewayNamespace
+	}
+	if len(cluster.Labels) > 0 {
+		s.Labels = mergeMaps(s.Labels, cluster.Labels)
+	}
+	if len(cluster.Annotations) > 0 {
+		s.Annotations = mergeMaps(s.Annotations, cluster.Annotations)
+	}
+	return s
+}
+
+func mergeMaps(entries ...map[string]string) map[string]string {
+	result := make(map[string]string)
+	for _, entry := range entries {
+		for k, v := range entry {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+func (s *RouterSettings) router(mode string, base *kubernetes.BaseService, gatewayClient gatewayclient.Interface) (router.Router, error) {
+	base.Labels = s.Labels
+	base.Annotations = s.Annotations
+
+	switch mode {
+	case "service", "loadbalancer", "":
+		return &kubernetes.LBService{
+			BaseService:      base,
+			OptsAsLabels:     s.OptsAsLabels,
+			OptsAsLabelsDocs: s.OptsAsLabelsDocs,
+			PoolLabels:       s.PoolLabels,
+		}, nil
+	case "ingress":
+		return &kubernetes.IngressService{
+			BaseService:           base,
+			DomainSuffix:          s.DomainSuffix,
+			OptsAsAnnotations:     s.OptsAsAnnotations,
+			OptsAsAnnotationsDocs: s.OptsAsAnnotationsDocs,
+			IngressClass:          s.IngressClass,
+			AnnotationsPrefix:     s.IngressAnnotationsPrefix,
+			HTTPPort:              s.HTTPPort,
+			UseIngressClassName:   s.UseIngressClassName,
+		}, nil
+	case "nginx-ingress", "ingress-nginx":
+		return &kubernetes.IngressService{
+			BaseService:           base,
+			DomainSuffix:          s.DomainSuffix,
+			OptsAsAnnotations:     s.OptsAsAnnotations,
+			OptsAsAnnotationsDocs: s.OptsAsAnnotationsDocs,
+			IngressClass:          "nginx",
+			AnnotationsPrefix:     kubernetes.NginxAnnotationsPrefix,
+			HTTPPort:              s.HTTPPort,
+			UseIngressClassName:   s.UseIngressClassName,
+		}, nil
+	case "istio-gateway":
+		return &kubernetes.IstioGateway{
+			BaseService:      base,
+			DomainSuffix:     s.DomainSuffix,
+			GatewaySelector:  s.GatewaySelector,
+			GatewayNamespace: s.IstioGatewayNamespace,
+			AcmeIssuer:       s.AcmeIssuer,
+		}, nil
+	case "gateway-api":
+		return &kubernetes.GatewayAPIService{
+			BaseService:      base,
+			GatewayClient:    gatewayClient,
+			DomainSuffix:     s.DomainSuffix,
+			GatewayName:      s.GatewayName,
+			GatewayNamespace: s.GatewayNamespace,
+			AcmeIssuer:       s.AcmeIssuer,
+			HTTPListener:     s.GatewayHTTPListener,
+			HTTPSListener:    s.GatewayHTTPSListener,
+		}, nil
+	}
+
+	return nil, router.NewError(router.ErrorCodeNotFound, "Mode not found").WithDetail("mode", mode)
+}
@@ -2,7 +2,7 @@ package cmd
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/pprof"
 	"os"
@@ -54,7 +54,7 @@ func StartDaemon(opts DaemonOpts) {
 	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
-	n := negroni.New(observability.Middleware(), negroni.NewLogger(), negroni.NewRecovery())
+	n := negroni.New(observability.Middleware(), observability.LoggingMiddleware(), negroni.NewRecovery())
 	n.UseHandler(r)
 
 	server := http.Server{
@@ -67,15 +67,17 @@ func StartDaemon(opts DaemonOpts) {
 	go handleSignals(&server)
 
 	if opts.KeyFile != "" && opts.CertFile != "" {
-		log.Printf("Started listening and serving TLS at %s", opts.ListenAddr)
+		slog.Info("started listening and serving TLS", "addr", opts.ListenAddr)
 		if err := server.ListenAndServeTLS(opts.CertFile, opts.KeyFile); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("fail serve: %v", err)
+			slog.Error("fail serve", "error", err)
+			os.Exit(1)
 		}
 		return
 	}
-	log.Printf("Started listening and serving %s at %s", opts.Name, opts.ListenAddr)
+	slog.Info("started listening and serving", "name", opts.Name, "addr", opts.ListenAddr)
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("fail serve: %v", err)
+		slog.Error("fail serve", "error", err)
+		os.Exit(1)
 	}
 }
 
@@ -83,12 +85,13 @@ func handleSignals(server *http.Server) {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT)
 	sig := <-signals
-	log.Printf("Received %s. Terminating...", sig)
+	slog.Info("received signal, terminating", "signal", sig.String())
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 	err := server.Shutdown(ctx)
 	if err != nil {
-		log.Fatalf("Error during server shutdown: %v", err)
+		slog.Error("error during server shutdown", "error", err)
+		os.Exit(1)
 	}
-	log.Print("Server shutdown succeeded.")
+	slog.Info("server shutdown succeeded")
 }
@@ -5,17 +5,17 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"io"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 
-	"github.com/ghodss/yaml"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/tsuru/kubernetes-router/backend"
 	"github.com/tsuru/kubernetes-router/cmd"
 	"github.com/tsuru/kubernetes-router/kubernetes"
-	_ "github.com/tsuru/kubernetes-router/observability"
+	"github.com/tsuru/kubernetes-router/observability"
 	"github.com/tsuru/kubernetes-router/router"
 )
 
@@ -35,6 +35,7 @@ func main() {
 
 	istioGatewaySelector := &cmd.MapFlag{}
 	flag.Var(istioGatewaySelector, "istio-gateway.gateway-selector", "Gateway selector used in gateways created for apps.")
+	istioGatewayNamespace := flag.String("istio-gateway.gateway-namespace", kubernetes.DefaultIstioGatewayNamespace, "Namespace of the istio ingress gateway, TLS secrets of apps are created in it.")
 
 	certFile := flag.String("cert-file", "", "Path to certificate used to serve https requests")
 	keyFile := flag.String("key-file", "", "Path to private key used to serve https requests")
@@ -55,7 +56,9 @@ func main() {
 
 	gatewayName := flag.String("gateway-name", "", "Name of the Gateway resource to attach HTTPRoutes to (gateway-api mode)")
 	gatewayNamespace := flag.String("gateway-namespace", "", "Namespace of the Gateway resource (gateway-api mode)")
-	acmeIssuer := flag.String("acme-issuer", "", "Default cert-manager ClusterIssuer name to use when tls-acme=true (gateway-api mode)")
+	acmeIssuer := flag.String("acme-issuer", "", "Default cert-manager ClusterIssuer name to use when tls-acme=true (gateway-api and istio-gateway modes)")
+	gatewayHTTPListener := flag.String("gateway-http-listener", kubernetes.DefaultGatewayHTTPListener, "Name of the Gateway HTTP listener used by https-redirect routes (gateway-api mode)")
+	gatewayHTTPSListener := flag.String("gateway-https-listener", kubernetes.DefaultGatewayHTTPSListener, "Name of the Gateway HTTPS listener used by app routes with https-redirect (gateway-api mode)")
 
 	useIngressClassName := flag.Bool("use-ingress-class-name", false, "If true, the ingress.spec.ingressClassName will be used instead of the ingress.class annotation")
 
@@ -64,13 +67,32 @@ func main() {
 	poolLabels := &cmd.MultiMapFlag{}
 	flag.Var(poolLabels, "pool-labels", "Default labels for a given pool. Expects POOL={\"LABEL\":\"VALUE\"} format.")
 	clustersFilePath := flag.String("clusters-file", "", "Path to file that describes clusters, when inform this file enable the multi-cluster support")
+	clustersFileReloadInterval := flag.Duration("clusters-file-reload-interval", time.Second*30, "Interval to check the clusters file for changes, 0 disables reloading (multi-cluster)")
+	clientsCacheTTL := flag.Duration("clusters-clients-cache-ttl", time.Minute*10, "How long kubernetes clients of remote clusters are reused before being rebuilt (multi-cluster)")
+	clientsCacheSize := flag.Int("clusters-clients-cache-size", 100, "Maximum number of cached kubernetes clients of remote clusters, one per cluster and credentials (multi-cluster)")
+
+	logFormat := flag.String("log-format", "text", "Format of the log lines: text or json")
+	logLevel := flag.String("log-level", "info", "Minimum level of the log lines: debug, info, warn or error")
 
 	flag.Parse()
 
-	err := flag.Lookup("logtostderr").Value.Set("true")
+	logger, err := observability.NewLogger(os.Stderr, *logFormat, *logLevel)
 	if err != nil {
-		log.Printf("failed to set log to stderr: %v\n", err)
+		slog.Error("fail parameters", "error", err)
+		os.Exit(1)
 	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := observability.Init(context.Background())
+	if err != nil {
+		slog.Error("failed to initialize tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("failed to shutdown tracing", "error", err)
+		}
+	}()
 
 	base := &kubernetes.BaseService{
 		Namespace:   *k8sNamespace,
@@ -79,6 +101,30 @@ func main() {
 		Annotations: *k8sAnnotations,
 	}
 
+	// remote clusters share the router settings of the local cluster, they
+	// are captured before modes like ingress-nginx override them below.
+	routerSettings := backend.RouterSettings{
+		Labels:                   *k8sLabels,
+		Annotations:              *k8sAnnotations,
+		DomainSuffix:             *ingressDomain,
+		IngressClass:             *ingressClass,
+		IngressAnnotationsPrefix: *ingressAnnotationsPrefix,
+		UseIngressClassName:      *useIngressClassName,
+		HTTPPort:                 *ingressPort,
+		OptsAsAnnotations:        *optsToIngressAnnotations,
+		OptsAsAnnotationsDocs:    *optsToIngressAnnotationsDocs,
+		GatewayName:              *gatewayName,
+		GatewayNamespace:         *gatewayNamespace,
+		AcmeIssuer:               *acmeIssuer,
+		GatewayHTTPListener:      *gatewayHTTPListener,
+		GatewayHTTPSListener:     *gatewayHTTPSListener,
+		GatewaySelector:          *istioGatewaySelector,
+		IstioGatewayNamespace:    *istioGatewayNamespace,
+		OptsAsLabels:             *optsToLabels,
+		OptsAsLabelsDocs:         *optsToLabelsDocs,
+		PoolLabels:               *poolLabels,
+	}
+
 	if len(runModes) == 0 {
 		runModes = append(runModes, "service")
 	}
@@ -97,12 +143,16 @@ func main() {
 				GatewayName:      *gatewayName,
 				GatewayNamespace: *gatewayNamespace,
 				AcmeIssuer:       *acmeIssuer,
+				HTTPListener:     *gatewayHTTPListener,
+				HTTPSListener:    *gatewayHTTPSListener,
 			}
 		case "istio-gateway":
 			localBackend.Routers[mode] = &kubernetes.IstioGateway{
-				BaseService:     base,
-				DomainSuffix:    *ingressDomain,
-				GatewaySelector: *istioGatewaySelector,
+				BaseService:      base,
+				DomainSuffix:     *ingressDomain,
+				GatewaySelector:  *istioGatewaySelector,
+				GatewayNamespace: *istioGatewayNamespace,
+				AcmeIssuer:       *acmeIssuer,
 			}
 		case "ingress-nginx":
 			*ingressClass = "nginx"
@@ -127,37 +177,40 @@ func main() {
 				PoolLabels:       *poolLabels,
 			}
 		default:
-			log.Fatalf("fail parameters: Use one of the following modes: service, ingress, ingress-nginx or istio-gateway.")
+			slog.Error("fail parameters: Use one of the following modes: service, ingress, ingress-nginx or istio-gateway.", "mode", mode)
+			os.Exit(1)
 		}
 	}
 
+	prometheus.MustRegister(&kubernetes.ResourcesCollector{Routers: localBackend.Routers, Timeout: *k8sTimeout})
+
 	var routerBackend backend.Backend = localBackend
 	// enable multi-cluster support when file is provided
 	if *clustersFilePath != "" {
-		f, err := os.Open(*clustersFilePath)
-		if err != nil {
-			log.Printf("failed to load clusters file: %v\n", err)
-			return
+		multiCluster := &backend.MultiCluster{
+			Namespace:  *k8sNamespace,
+			Fallback:   routerBackend,
+			K8sTimeout: k8sTimeout,
+			Modes:      runModes,
+			Settings:   routerSettings,
+
+			ClientsCacheTTL:  *clientsCacheTTL,
+			ClientsCacheSize: *clientsCacheSize,
 		}
-		clustersFile := &backend.ClustersFile{}
-		data, err := io.ReadAll(f)
-		if err != nil {
-			log.Printf("failed to load clusters file: %v\n", err)
-			return
+		watcher := &backend.ClustersFileWatcher{
+			Path:     *clustersFilePath,
+			Interval: *clustersFileReloadInterval,
+			Backend:  multiCluster,
 		}
-		err = yaml.Unmarshal(data, clustersFile)
+		err := watcher.Load()
 		if err != nil {
-			log.Printf("failed to load clusters file: %v\n", err)
+			slog.Error("failed to load clusters file", "path", *clustersFilePath, "error", err)
 			return
 		}
-
-		routerBackend = &backend.MultiCluster{
-			Namespace:  *k8sNamespace,
-			Fallback:   routerBackend,
-			K8sTimeout: k8sTimeout,
-			Modes:      runModes,
-			Clusters:   clustersFile.Clusters,
+		if *clustersFileReloadInterval > 0 {
+			go watcher.Watch(context.Background())
 		}
+		routerBackend = multiCluster
 	}
 
 	cmd.StartDaemon(cmd.DaemonOpts{
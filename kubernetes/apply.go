@@ -0,0 +1,216 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+	"k8s.io/utils/ptr"
+)
+
+// fieldManager is the field manager of the resources applied by the router.
+// It is also the name of the manager of the fields updated by the previous
+// versions of the router, as it is set from the user agent of the binary.
+const fieldManager = "kubernetes-router"
+
+// applyClient is the typed client of a resource written with server-side
+// apply.
+type applyClient[T runtime.Object] interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (T, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (T, error)
+}
+
+// applyObject creates or updates obj with server-side apply. The router owns
+// the fields set in obj, the fields it owned and are not set anymore are
+// removed and the fields set by other managers are kept, unless obj sets
+// them too. obj must only set the fields managed by the router, the server
+// returns a conflict when its resourceVersion is set and outdated.
+func applyObject[T runtime.Object](ctx context.Context, client applyClient[T], gvk schema.GroupVersionKind, obj T) (T, error) {
+	var applied T
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return applied, err
+	}
+	data, err := applyConfiguration(obj, gvk)
+	if err != nil {
+		return applied, err
+	}
+	err = retryOnConflict(strings.ToLower(gvk.Kind), func(bool) error {
+		return upgradeManagedFields(ctx, client, accessor.GetName())
+	})
+	if err != nil {
+		return applied, err
+	}
+	return client.Patch(ctx, accessor.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: fieldManager,
+		Force:        ptr.To(true),
+	})
+}
+
+// applyConfiguration returns obj as the body of an apply patch, without the
+// fields set by the server.
+func applyConfiguration(obj runtime.Object, gvk schema.GroupVersionKind) ([]byte, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	u.SetCreationTimestamp(metav1.Time{})
+	u.SetManagedFields(nil)
+	unstructured.RemoveNestedField(u.Object, "status")
+	return u.MarshalJSON()
+}
+
+// upgradeManagedFields moves the fields updated by the previous versions of
+// the router to its apply ownership, otherwise they are not removed when
+// they are not applied anymore.
+func upgradeManagedFields[T runtime.Object](ctx context.Context, client applyClient[T], name string) error {
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(existing, sets.New(fieldManager), fieldManager)
+	if err != nil || patch == nil {
+		return err
+	}
+	_, err = client.Patch(ctx, name, types.JSONPatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// swapClient is the typed client of a resource written by a swap.
+type swapClient[T runtime.Object] interface {
+	applyClient[T]
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+}
+
+// swapWrites are the writes of a swap, each one is undone when a later one
+// fails, so the apps are not left half swapped.
+type swapWrites struct {
+	undo []func(ctx context.Context) error
+}
+
+// do runs write, undone by undo when a later write fails. The writes already
+// done are undone when it fails.
+func (s *swapWrites) do(ctx context.Context, write, undo func(ctx context.Context) error) error {
+	if err := write(ctx); err != nil {
+		return s.rollback(ctx, err)
+	}
+	s.undo = append(s.undo, undo)
+	return nil
+}
+
+// rollback undoes the writes done, the last one first, and returns err.
+func (s *swapWrites) rollback(ctx context.Context, err error) error {
+	var rollbackErrs []error
+	for i := len(s.undo) - 1; i >= 0; i-- {
+		if undoErr := s.undo[i](ctx); undoErr != nil {
+			rollbackErrs = append(rollbackErrs, undoErr)
+		}
+	}
+	s.undo = nil
+	if len(rollbackErrs) > 0 {
+		return fmt.Errorf("failed to swap: %w, rollback failed: %v", err, errors.Join(rollbackErrs...))
+	}
+	return err
+}
+
+// applySwapped applies obj, the write is undone by applying original.
+func applySwapped[T runtime.Object](ctx context.Context, s *swapWrites, client swapClient[T], gvk schema.GroupVersionKind, obj, original T) error {
+	restored, err := restorable(original)
+	if err != nil {
+		return s.rollback(ctx, err)
+	}
+	return s.do(ctx, func(ctx context.Context) error {
+		_, err := applyObject(ctx, client, gvk, obj)
+		return err
+	}, func(ctx context.Context) error {
+		_, err := applyObject(ctx, client, gvk, restored)
+		return err
+	})
+}
+
+// createSwapped applies obj, which did not exist, the write is undone by
+// deleting it.
+func createSwapped[T runtime.Object](ctx context.Context, s *swapWrites, client swapClient[T], gvk schema.GroupVersionKind, obj T) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return s.rollback(ctx, err)
+	}
+	return s.do(ctx, func(ctx context.Context) error {
+		_, err := applyObject(ctx, client, gvk, obj)
+		return err
+	}, func(ctx context.Context) error {
+		return client.Delete(ctx, accessor.GetName(), metav1.DeleteOptions{})
+	})
+}
+
+// deleteSwapped deletes obj, the write is undone by applying it again.
+func deleteSwapped[T runtime.Object](ctx context.Context, s *swapWrites, client swapClient[T], gvk schema.GroupVersionKind, obj T) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return s.rollback(ctx, err)
+	}
+	restored, err := restorable(obj)
+	if err != nil {
+		return s.rollback(ctx, err)
+	}
+	return s.do(ctx, func(ctx context.Context) error {
+		err := client.Delete(ctx, accessor.GetName(), metav1.DeleteOptions{})
+		if k8sErrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}, func(ctx context.Context) error {
+		_, err := applyObject(ctx, client, gvk, restored)
+		return err
+	})
+}
+
+// restorable returns a copy of obj applied regardless of the changes made to
+// it since it was read, or after it was deleted.
+func restorable[T runtime.Object](obj T) (T, error) {
+	restored := obj.DeepCopyObject().(T)
+	accessor, err := meta.Accessor(restored)
+	if err != nil {
+		return restored, err
+	}
+	accessor.SetResourceVersion("")
+	accessor.SetUID("")
+	return restored, nil
+}
+
+// applicable returns a copy of obj, read and then modified by the router, to
+// be applied. The metadata set by the server and by other controllers is
+// removed, the resourceVersion is kept so the apply conflicts when obj was
+// concurrently modified since it was read.
+func applicable[T runtime.Object](obj T) (T, error) {
+	applied := obj.DeepCopyObject().(T)
+	accessor, err := meta.Accessor(applied)
+	if err != nil {
+		return applied, err
+	}
+	accessor.SetUID("")
+	accessor.SetGeneration(0)
+	accessor.SetFinalizers(nil)
+	accessor.SetDeletionTimestamp(nil)
+	accessor.SetDeletionGracePeriodSeconds(nil)
+	return applied, nil
+}
@@ -0,0 +1,365 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tsuru/kubernetes-router/router"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var fieldNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+type dryRunContextKey struct{}
+
+// errDryRunNotIntercepted is returned when planning an Ensure whose writes
+// did not go through WrapDryRunTransport, they may have been applied.
+var errDryRunNotIntercepted = router.NewError(router.ErrorCodeInternal, "no write was sent as a dry run, the Kubernetes clients of the router do not support dry runs")
+
+// dryRun records the objects written while planning an Ensure, keyed by
+// their API path.
+type dryRun struct {
+	mu          sync.Mutex
+	objects     map[string]*dryRunObject
+	paths       []string
+	intercepted int
+}
+
+// dryRunObject is an object written in a dry run, live is nil when the
+// object does not exist and planned is nil when it would be deleted.
+type dryRunObject struct {
+	live    map[string]interface{}
+	planned map[string]interface{}
+}
+
+// planEnsure runs ensure sending the writes of the resources to the
+// Kubernetes API as dry runs, and returns the changes they would make. The
+// objects read after being written are the planned ones, but the lists of
+// objects are not changed by the dry runs. Ensure always writes the
+// resources of the app, so the plan fails when none of its writes went
+// through WrapDryRunTransport.
+func planEnsure(ctx context.Context, ensure func(ctx context.Context) error) (*router.Plan, error) {
+	d := &dryRun{objects: map[string]*dryRunObject{}}
+	err := ensure(context.WithValue(ctx, dryRunContextKey{}, d))
+	if err != nil {
+		return nil, err
+	}
+	if d.interceptedWrites() == 0 {
+		return nil, errDryRunNotIntercepted
+	}
+	return d.plan(), nil
+}
+
+// WrapDryRunTransport sends the writes of the requests made while planning
+// an Ensure to the Kubernetes API as dry runs, recording their results.
+func WrapDryRunTransport(rt http.RoundTripper) http.RoundTripper {
+	return &dryRunTransport{RoundTripper: rt}
+}
+
+type dryRunTransport struct {
+	http.RoundTripper
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.RoundTripper
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	d, _ := req.Context().Value(dryRunContextKey{}).(*dryRun)
+	if d == nil {
+		return rt.RoundTrip(req)
+	}
+	switch req.Method {
+	case http.MethodGet:
+		if obj, ok := d.get(req.URL.Path); ok {
+			return plannedResponse(req, obj)
+		}
+		return rt.RoundTrip(req)
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return t.write(rt, d, req)
+	}
+	return rt.RoundTrip(req)
+}
+
+// write sends req with dryRun=All and records the object it would write
+// along with the live one.
+func (t *dryRunTransport) write(rt http.RoundTripper, d *dryRun, req *http.Request) (*http.Response, error) {
+	d.intercept()
+	dryRunReq := req.Clone(req.Context())
+	query := dryRunReq.URL.Query()
+	query.Set("dryRun", "All")
+	dryRunReq.URL.RawQuery = query.Encode()
+	// the results are recorded as JSON, the clients decode them as such
+	// whatever the content type they prefer.
+	dryRunReq.Header.Set("Accept", "application/json")
+	response, err := rt.RoundTrip(dryRunReq)
+	if err != nil || response.StatusCode >= http.StatusMultipleChoices {
+		return response, err
+	}
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	path := req.URL.Path
+	var planned map[string]interface{}
+	if req.Method != http.MethodDelete {
+		err = json.Unmarshal(body, &planned)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode the dry run of %s %s: %w", req.Method, path, err)
+		}
+		if req.Method == http.MethodPost {
+			name, _ := objectMeta(planned)["name"].(string)
+			path += "/" + name
+		}
+	}
+
+	if !d.has(path) {
+		var live map[string]interface{}
+		if req.Method != http.MethodPost {
+			live, err = getLiveObject(rt, req, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		d.add(path, live)
+	}
+	d.set(path, planned)
+	return response, nil
+}
+
+// getLiveObject reads the object at path with the credentials of req, nil is
+// returned when it does not exist.
+func getLiveObject(rt http.RoundTripper, req *http.Request, path string) (map[string]interface{}, error) {
+	getReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, req.URL.Scheme+"://"+req.URL.Host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	getReq.Header = req.Header.Clone()
+	getReq.Header.Del("Content-Type")
+	getReq.Header.Set("Accept", "application/json")
+	response, err := rt.RoundTrip(getReq)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("unable to get %s before its dry run: %s", path, body)
+	}
+	var live map[string]interface{}
+	err = json.Unmarshal(body, &live)
+	return live, err
+}
+
+// plannedResponse answers req with the planned object, or as not found when
+// it would be deleted.
+func plannedResponse(req *http.Request, obj map[string]interface{}) (*http.Response, error) {
+	status := http.StatusOK
+	var body interface{} = obj
+	if obj == nil {
+		status = http.StatusNotFound
+		parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		resource, name := "", ""
+		if len(parts) >= 2 {
+			resource, name = parts[len(parts)-2], parts[len(parts)-1]
+		}
+		notFound := k8sErrors.NewNotFound(schema.GroupResource{Resource: resource}, name).ErrStatus
+		notFound.Kind = "Status"
+		notFound.APIVersion = "v1"
+		body = notFound
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode:    status,
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+func (d *dryRun) intercept() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.intercepted++
+}
+
+func (d *dryRun) interceptedWrites() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.intercepted
+}
+
+func (d *dryRun) has(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.objects[path]
+	return ok
+}
+
+func (d *dryRun) add(path string, live map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.objects[path]; ok {
+		return
+	}
+	d.objects[path] = &dryRunObject{live: live}
+	d.paths = append(d.paths, path)
+}
+
+func (d *dryRun) set(path string, planned map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.objects[path].planned = planned
+}
+
+func (d *dryRun) get(path string) (map[string]interface{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	obj, ok := d.objects[path]
+	if !ok {
+		return nil, false
+	}
+	return obj.planned, true
+}
+
+// plan returns the changes of the objects written, the ones left unchanged
+// are omitted.
+func (d *dryRun) plan() *router.Plan {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	plan := &router.Plan{Changes: []router.ResourceChange{}}
+	for _, path := range d.paths {
+		obj := d.objects[path]
+		var change router.ResourceChange
+		switch {
+		case obj.live == nil && obj.planned == nil:
+			continue
+		case obj.live == nil:
+			change = resourceChange(router.PlanActionCreate, obj.planned)
+			change.Diff = diffObjects(nil, obj.planned)
+		case obj.planned == nil:
+			change = resourceChange(router.PlanActionDelete, obj.live)
+		default:
+			change = resourceChange(router.PlanActionUpdate, obj.planned)
+			change.Diff = diffObjects(obj.live, obj.planned)
+			if len(change.Diff) == 0 {
+				continue
+			}
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+	return plan
+}
+
+func resourceChange(action router.PlanAction, obj map[string]interface{}) router.ResourceChange {
+	meta := objectMeta(obj)
+	change := router.ResourceChange{Action: action}
+	change.APIVersion, _ = obj["apiVersion"].(string)
+	change.Kind, _ = obj["kind"].(string)
+	change.Namespace, _ = meta["namespace"].(string)
+	change.Name, _ = meta["name"].(string)
+	return change
+}
+
+func objectMeta(obj map[string]interface{}) map[string]interface{} {
+	meta, _ := obj["metadata"].(map[string]interface{})
+	return meta
+}
+
+// diffObjects returns the fields changed from old to new, the status and the
+// metadata set by the server are ignored.
+func diffObjects(old, new map[string]interface{}) []router.FieldChange {
+	var changes []router.FieldChange
+	diffValues("", withoutServerFields(old), withoutServerFields(new), &changes)
+	return changes
+}
+
+func withoutServerFields(obj map[string]interface{}) map[string]interface{} {
+	if obj == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		result[k] = v
+	}
+	delete(result, "status")
+	if meta, ok := obj["metadata"].(map[string]interface{}); ok {
+		resultMeta := make(map[string]interface{}, len(meta))
+		for k, v := range meta {
+			resultMeta[k] = v
+		}
+		for _, field := range []string{"resourceVersion", "managedFields", "generation", "creationTimestamp", "uid", "selfLink"} {
+			delete(resultMeta, field)
+		}
+		result["metadata"] = resultMeta
+	}
+	return result
+}
+
+// diffValues appends the changes from old to new, objects are compared field
+// by field and lists as a whole.
+func diffValues(path string, old, new interface{}, changes *[]router.FieldChange) {
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if (oldIsMap || old == nil) && (newIsMap || new == nil) && (oldIsMap || newIsMap) {
+		keys := make([]string, 0, len(oldMap)+len(newMap))
+		for k := range oldMap {
+			keys = append(keys, k)
+		}
+		for k := range newMap {
+			if _, ok := oldMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			diffValues(fieldPath(path, k), oldMap[k], newMap[k], changes)
+		}
+		return
+	}
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, router.FieldChange{Path: path, Old: old, New: new})
+	}
+}
+
+func fieldPath(parent, field string) string {
+	if !fieldNameRegexp.MatchString(field) {
+		return parent + "[" + strconv.Quote(field) + "]"
+	}
+	if parent == "" {
+		return field
+	}
+	return parent + "." + field
+}
@@ -0,0 +1,344 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/kubernetes-router/router"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+// fakeAPIServer serves the objects stored by their API path, the
+// collections list the objects under them. Writes are answered as by the
+// Kubernetes API, the patches being merged over the stored object, and are
+// only stored when they are not dry runs. The clients must send JSON.
+type fakeAPIServer struct {
+	*httptest.Server
+	t         *testing.T
+	mu        sync.Mutex
+	objects   map[string]map[string]interface{}
+	persisted []string
+}
+
+func newFakeAPIServer(t *testing.T, objects map[string]string) *fakeAPIServer {
+	s := &fakeAPIServer{t: t, objects: map[string]map[string]interface{}{}}
+	for path, obj := range objects {
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(obj), &decoded), path)
+		s.objects[path] = decoded
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// snapshot returns a copy of the objects stored.
+func (s *fakeAPIServer) snapshot() map[string]map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.Marshal(s.objects)
+	require.NoError(s.t, err)
+	var objects map[string]map[string]interface{}
+	require.NoError(s.t, json.Unmarshal(data, &objects))
+	return objects
+}
+
+// resetPersisted forgets the writes stored so far.
+func (s *fakeAPIServer) resetPersisted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.persisted = nil
+}
+
+// restConfig returns the config of the clients of the server, sending the
+// writes made while planning as dry runs, without rate limiting.
+func (s *fakeAPIServer) restConfig() *rest.Config {
+	return &rest.Config{
+		Host:          s.URL,
+		ContentConfig: rest.ContentConfig{ContentType: "application/json"},
+		QPS:           -1,
+		WrapTransport: WrapDryRunTransport,
+	}
+}
+
+func (s *fakeAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	path := r.URL.Path
+	stored, exists := s.objects[path]
+	if r.Method == http.MethodGet {
+		switch {
+		case exists:
+			json.NewEncoder(w).Encode(stored)
+		case isCollectionPath(path):
+			s.list(w, r)
+		default:
+			writeNotFound(w, path)
+		}
+		return
+	}
+
+	var body map[string]interface{}
+	if r.Method != http.MethodDelete {
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			// JSON patches, as the upgrade of the managed fields, keep the object
+			body = nil
+		}
+	}
+	var result map[string]interface{}
+	switch r.Method {
+	case http.MethodPost:
+		name, _ := objectMeta(body)["name"].(string)
+		path += "/" + name
+		if _, ok := s.objects[path]; ok {
+			status := k8sErrors.NewAlreadyExists(schema.GroupResource{}, name).ErrStatus
+			status.Kind, status.APIVersion = "Status", "v1"
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(status)
+			return
+		}
+		result = body
+	case http.MethodPut:
+		result = body
+	case http.MethodPatch:
+		result = mergeObjects(stored, body)
+	case http.MethodDelete:
+		if !exists {
+			writeNotFound(w, path)
+			return
+		}
+		result = stored
+	}
+	if r.URL.Query().Get("dryRun") != "All" {
+		s.persisted = append(s.persisted, r.Method+" "+path)
+		if r.Method == http.MethodDelete {
+			delete(s.objects, path)
+		} else {
+			s.objects[path] = result
+		}
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// list answers the objects of the collection at r.URL.Path matching the
+// label selector of r.
+func (s *fakeAPIServer) list(w http.ResponseWriter, r *http.Request) {
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	require.NoError(s.t, err)
+	items := []interface{}{}
+	for path, obj := range s.objects {
+		if !strings.HasPrefix(path, r.URL.Path+"/") || strings.Contains(path[len(r.URL.Path)+1:], "/") {
+			continue
+		}
+		objLabels := labels.Set{}
+		if metaLabels, ok := objectMeta(obj)["labels"].(map[string]interface{}); ok {
+			for k, v := range metaLabels {
+				objLabels[k] = fmt.Sprint(v)
+			}
+		}
+		if selector.Matches(objLabels) {
+			items = append(items, obj)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"metadata": map[string]interface{}{}, "items": items})
+}
+
+// isCollectionPath reports whether path is the path of a collection, as
+// /api/v1/namespaces/default/services, instead of an object.
+func isCollectionPath(path string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) > 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) > 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return false
+	}
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+	return len(parts) == 1
+}
+
+func writeNotFound(w http.ResponseWriter, path string) {
+	status := k8sErrors.NewNotFound(schema.GroupResource{}, path[strings.LastIndex(path, "/")+1:]).ErrStatus
+	status.Kind, status.APIVersion = "Status", "v1"
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(status)
+}
+
+// mergeObjects returns patch merged over obj, as an apply or merge patch.
+func mergeObjects(obj, patch map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(obj)+len(patch))
+	for k, v := range obj {
+		result[k] = v
+	}
+	for k, v := range patch {
+		objValue, objIsMap := result[k].(map[string]interface{})
+		patchValue, patchIsMap := v.(map[string]interface{})
+		if objIsMap && patchIsMap {
+			result[k] = mergeObjects(objValue, patchValue)
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// webServiceObject is the web service of app in the default namespace, as
+// served by fakeAPIServer.
+func webServiceObject(app string) string {
+	return fmt.Sprintf(`{"apiVersion": "v1", "kind": "Service",
+		"metadata": {"name": "%[1]s-web", "namespace": "default", "uid": "%[1]s-uid"},
+		"spec": {"selector": {"tsuru.io/app-name": "%[1]s", "tsuru.io/app-process": "web"},
+			"ports": [{"protocol": "TCP", "port": 8888, "targetPort": 8888}]}}`, app)
+}
+
+// planChanges returns the action, kind and name of the changes of plan.
+func planChanges(plan *router.Plan) []string {
+	var changes []string
+	for _, change := range plan.Changes {
+		changes = append(changes, fmt.Sprintf("%s %s %s/%s", change.Action, change.Kind, change.Namespace, change.Name))
+	}
+	return changes
+}
+
+// planDiff returns the diff of the change of kind and name in plan.
+func planDiff(t *testing.T, plan *router.Plan, kind, name string) []router.FieldChange {
+	for _, change := range plan.Changes {
+		if change.Kind == kind && change.Name == name {
+			return change.Diff
+		}
+	}
+	t.Fatalf("no change of %s %s in the plan", kind, name)
+	return nil
+}
+
+func TestPlanEnsure(t *testing.T) {
+	server := newFakeAPIServer(t, map[string]string{
+		"/api/v1/namespaces/default/services/web": `{"apiVersion": "v1", "kind": "Service",
+			"metadata": {"name": "web", "namespace": "default", "resourceVersion": "10", "labels": {"app": "web"}},
+			"spec": {"type": "LoadBalancer"}, "status": {"loadBalancer": {}}}`,
+		"/api/v1/namespaces/default/services/same": `{"apiVersion": "v1", "kind": "Service",
+			"metadata": {"name": "same", "namespace": "default"}}`,
+		"/api/v1/namespaces/default/services/old": `{"apiVersion": "v1", "kind": "Service",
+			"metadata": {"name": "old", "namespace": "default"}}`,
+	})
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(server.restConfig())
+	require.NoError(t, err)
+
+	plan, err := planEnsure(ctx, func(ctx context.Context) error {
+		services := client.CoreV1().Services("default")
+		svc, err := services.Get(ctx, "web", metav1.GetOptions{})
+		require.NoError(t, err)
+		svc.Labels["app"] = "other"
+		svc.Annotations = map[string]string{"router.tsuru.io/freeze": "true"}
+		_, err = services.Update(ctx, svc, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		_, err = services.Create(ctx, &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "new", Namespace: "default"},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+		created, err := services.Get(ctx, "new", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, v1.ServiceTypeLoadBalancer, created.Spec.Type)
+
+		same, err := services.Get(ctx, "same", metav1.GetOptions{})
+		require.NoError(t, err)
+		_, err = services.Update(ctx, same, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		err = services.Delete(ctx, "old", metav1.DeleteOptions{})
+		require.NoError(t, err)
+		_, err = services.Get(ctx, "old", metav1.GetOptions{})
+		assert.True(t, k8sErrors.IsNotFound(err))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &router.Plan{Changes: []router.ResourceChange{
+		{
+			Action:     router.PlanActionUpdate,
+			APIVersion: "v1",
+			Kind:       "Service",
+			Namespace:  "default",
+			Name:       "web",
+			Diff: []router.FieldChange{
+				{Path: `metadata.annotations["router.tsuru.io/freeze"]`, New: "true"},
+				{Path: "metadata.labels.app", Old: "web", New: "other"},
+			},
+		},
+		{
+			Action:     router.PlanActionCreate,
+			APIVersion: "v1",
+			Kind:       "Service",
+			Namespace:  "default",
+			Name:       "new",
+			Diff: []router.FieldChange{
+				{Path: "apiVersion", New: "v1"},
+				{Path: "kind", New: "Service"},
+				{Path: "metadata.name", New: "new"},
+				{Path: "metadata.namespace", New: "default"},
+				{Path: "spec.type", New: "LoadBalancer"},
+			},
+		},
+		{
+			Action:     router.PlanActionDelete,
+			APIVersion: "v1",
+			Kind:       "Service",
+			Namespace:  "default",
+			Name:       "old",
+		},
+	}}, plan)
+
+	assert.Empty(t, server.persisted)
+
+	// Assert: the requests made out of a plan are not dry runs.
+	svc, err := client.CoreV1().Services("default").Get(ctx, "old", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "old", svc.Name)
+}
+
+func TestPlanEnsureError(t *testing.T) {
+	plan, err := planEnsure(ctx, func(ctx context.Context) error {
+		return ErrNoService{App: "myapp"}
+	})
+	assert.Equal(t, ErrNoService{App: "myapp"}, err)
+	assert.Nil(t, plan)
+}
+
+func TestPlanEnsureWithoutDryRunTransport(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	plan, err := planEnsure(ctx, func(ctx context.Context) error {
+		_, err := client.CoreV1().Services("default").Create(ctx, &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		}, metav1.CreateOptions{})
+		return err
+	})
+	assert.Equal(t, errDryRunNotIntercepted, err)
+	assert.Nil(t, plan)
+}
@@ -7,18 +7,25 @@ package kubernetes
 import (
 	"context"
 	"fmt"
-	"log"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
+	"github.com/tsuru/kubernetes-router/observability"
 	"github.com/tsuru/kubernetes-router/router"
 	corev1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/ptr"
 	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
 	gatewayclient "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
 )
@@ -27,25 +34,46 @@ const (
 	labelHTTPRouteHTTPOnly = "router.tsuru.io/http-only"
 	labelCNameHTTPRoute    = "router.tsuru.io/is-cname"
 	labelCertIssuer        = "router.tsuru.io/cert-issuer"
+	labelHTTPSRedirect     = "router.tsuru.io/https-redirect"
+	labelUserCertificate   = "router.tsuru.io/user-certificate"
 	annotationCNames       = "router.tsuru.io/cnames"
 	annotationCertIssuers  = "router.tsuru.io/cert-issuers"
+
+	// cnamesFieldManager is the field manager of the annotation tracking the
+	// CNames, applied apart from the HTTPRoute once the CNames are ensured.
+	cnamesFieldManager = "kubernetes-router-cnames"
+)
+
+const (
+	DefaultGatewayHTTPListener  = "http"
+	DefaultGatewayHTTPSListener = "https"
 )
 
 var (
 	_ router.Router       = &GatewayAPIService{}
 	_ router.RouterStatus = &GatewayAPIService{}
+	_ router.RouterSwap   = &GatewayAPIService{}
+	_ router.RouterTLS    = &GatewayAPIService{}
+	_ router.RouterPlan   = &GatewayAPIService{}
 
 	defaultGatewayOptsAsAnnotations     = map[string]string{}
 	defaultGatewayOptsAsAnnotationsDocs = map[string]string{}
+
+	httpRouteGVK   = gatewayv1.SchemeGroupVersion.WithKind("HTTPRoute")
+	listenerSetGVK = gatewayv1.SchemeGroupVersion.WithKind("ListenerSet")
 )
 
 // GatewayAPIService manages HTTPRoute resources using the Kubernetes Gateway API.
 type GatewayAPIService struct {
 	*BaseService
-	GatewayName           string
-	GatewayNamespace      string
-	DomainSuffix          string
-	AcmeIssuer            string
+	GatewayName      string
+	GatewayNamespace string
+	DomainSuffix     string
+	AcmeIssuer       string
+	// HTTPListener and HTTPSListener are the Gateway listeners used when
+	// redirecting HTTP requests to HTTPS.
+	HTTPListener          string
+	HTTPSListener         string
 	GatewayClient         gatewayclient.Interface
 	OptsAsAnnotations     map[string]string
 	OptsAsAnnotationsDocs map[string]string
@@ -109,7 +137,12 @@ func (g *GatewayAPIService) Ensure(ctx context.Context, id router.InstanceID, o
 	}
 
 	if g.GatewayName == "" {
-		err := fmt.Errorf("gateway name must be specified via startup flags or X-Gateway-Name header")
+		err := router.NewError(router.ErrorCodeInvalidRequest, "gateway name must be specified via startup flags or X-Gateway-Name header")
+		setSpanError(span, err)
+		return err
+	}
+	err = validateHTTPRouteRetryOn(o.Opts)
+	if err != nil {
 		setSpanError(span, err)
 		return err
 	}
@@ -135,15 +168,32 @@ func (g *GatewayAPIService) Ensure(ctx context.Context, id router.InstanceID, o
 		}
 	}
 
+	weightedServices, err := g.getWeightedServices(ctx, id.AppName, o.Prefixes, o.Opts.ExposeAllServices)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+
 	if o.Opts.DomainSuffix != "" {
 		g.DomainSuffix = o.Opts.DomainSuffix
 	}
 
 	rc := httpRouteContext{
-		ns:              ns,
-		backendTargets:  backendTargets,
-		backendServices: backendServices,
-		isHTTPOnly:      o.Opts.HTTPOnly,
+		ns:               ns,
+		backendTargets:   backendTargets,
+		backendServices:  backendServices,
+		weightedServices: weightedServices,
+		isHTTPOnly:       o.Opts.HTTPOnly,
+	}
+	rc.routeRules, err = g.buildRouteRules(ctx, id.AppName, o.Opts.RouteRules, o.Prefixes, routePath(o.Opts))
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	rc.mirror, err = g.getMirrorService(ctx, id.AppName, ns, o.Opts.MirrorTo)
+	if err != nil {
+		setSpanError(span, err)
+		return err
 	}
 
 	// Build prefix list from resolved backends (already filtered by getBackendTargets).
@@ -153,12 +203,32 @@ func (g *GatewayAPIService) Ensure(ctx context.Context, id router.InstanceID, o
 	}
 	sort.Strings(prefixes)
 
+	rc.tlsIssuer = g.hostsIssuer(ctx, o.Opts)
+	if rc.tlsIssuer != "" {
+		for _, prefixString := range prefixes {
+			rc.tlsHosts = append(rc.tlsHosts, g.buildHTTPRouteHostname(prefixString, id, o, g.DomainSuffix))
+		}
+	}
+
+	// The CNames tracked by the previous versions of the router are removed
+	// from the main HTTPRoute when it is applied.
+	existingCNames := g.getExistingCNames(ctx, client, id, ns)
+
 	// Ensure HTTPRoutes for all prefixes
 	desiredRouteNames, err := g.ensureHTTPRoutes(ctx, span, client, id, o, rc, prefixes)
 	if err != nil {
 		return err
 	}
 
+	if g.httpsRedirectEnabled(o.Opts) {
+		var routeName string
+		routeName, err = g.ensureHTTPSRedirectRoute(ctx, span, client, id, o, rc.ns, prefixes)
+		if err != nil {
+			return err
+		}
+		desiredRouteNames[routeName] = true
+	}
+
 	// Clean up obsolete routes
 	err = g.cleanupHTTPRoutes(ctx, span, client, rc.ns, id, desiredRouteNames)
 	if err != nil {
@@ -166,25 +236,73 @@ func (g *GatewayAPIService) Ensure(ctx context.Context, id router.InstanceID, o
 	}
 
 	// Handle CNames: ListenerSets + CName HTTPRoutes
-	if len(o.CNames) > 0 || g.hasExistingCNames(ctx, client, id, ns) {
-		err = g.ensureCNames(ctx, span, client, id, o, ns, backendTargets["default"])
+	if len(o.CNames) > 0 || len(existingCNames) > 0 {
+		err = g.ensureCNames(ctx, span, client, id, o, ns, existingCNames, backendTargets["default"], rc)
 		if err != nil {
 			setSpanError(span, err)
 			return err
 		}
 		// Store CNames in annotation on the main HTTPRoute for tracking
-		g.updateCNamesAnnotation(ctx, client, id, ns, o.CNames)
+		err = g.updateCNamesAnnotation(ctx, client, id, ns, o.CNames)
+		if err != nil {
+			setSpanError(span, err)
+			return err
+		}
+	} else {
+		err = g.cleanupOrphanedListenerSets(ctx, client, id, ns, rc.tlsHosts)
+		if err != nil {
+			setSpanError(span, err)
+			return err
+		}
 	}
 
 	return nil
 }
 
+// PlanEnsure returns the changes Ensure would make to the HTTPRoutes and
+// ListenerSets of the app, without writing them.
+func (g *GatewayAPIService) PlanEnsure(ctx context.Context, id router.InstanceID, o router.EnsureBackendOpts) (*router.Plan, error) {
+	return planEnsure(ctx, func(ctx context.Context) error {
+		return g.Ensure(ctx, id, o)
+	})
+}
+
+// hostsIssuer returns the cert-manager issuer of the certificates of the app
+// hostnames, they rely on the certificates of the Gateway when it is empty.
+func (g *GatewayAPIService) hostsIssuer(ctx context.Context, opts router.Opts) string {
+	if opts.HTTPOnly {
+		return ""
+	}
+	if opts.TLSIssuer != "" {
+		return opts.TLSIssuer
+	}
+	if !opts.Acme {
+		return ""
+	}
+	if g.AcmeIssuer == "" {
+		observability.Logger(ctx).Warn("no acme issuer set, the app hostnames rely on the certificates of the Gateway", "option", router.Acme)
+		return ""
+	}
+	return g.AcmeIssuer
+}
+
 // httpRouteContext holds the resolved configuration for creating/updating HTTPRoutes.
 type httpRouteContext struct {
 	ns              string
 	backendTargets  map[string]router.BackendTarget
 	backendServices map[string]*corev1.Service
-	isHTTPOnly      bool
+	// weightedServices holds the services of the prefixes with weighted targets
+	weightedServices map[string][]weightedService
+	// routeRules are the rules built from the route-rules option, added to
+	// the default and CName HTTPRoutes
+	routeRules []gatewayv1.HTTPRouteRule
+	// mirror is the service receiving a copy of the requests
+	mirror     *mirrorService
+	isHTTPOnly bool
+	// tlsIssuer is the cert-manager issuer of the certificates of tlsHosts,
+	// the app hostnames getting their own ListenerSet
+	tlsIssuer string
+	tlsHosts  []string
 }
 
 func (g *GatewayAPIService) buildHTTPRouteHostname(prefixString string, id router.InstanceID, o router.EnsureBackendOpts, domainSuffix string) string {
@@ -201,12 +319,7 @@ func (g *GatewayAPIService) buildHTTPRouteHostname(prefixString string, id route
 	return fmt.Sprintf("%s%s.%s.%s", prefix, o.Opts.DomainPrefix, id.AppName, domainSuffix)
 }
 
-func (g *GatewayAPIService) buildHTTPRouteRule(path string, svc *corev1.Service) gatewayv1.HTTPRouteRule {
-	port := gatewayv1.PortNumber(defaultServicePort)
-	if len(svc.Spec.Ports) > 0 {
-		port = gatewayv1.PortNumber(svc.Spec.Ports[0].Port)
-	}
-
+func (g *GatewayAPIService) buildHTTPRouteRule(path string, svc *corev1.Service, weighted ...weightedService) gatewayv1.HTTPRouteRule {
 	pathType := gatewayv1.PathMatchPathPrefix
 
 	return gatewayv1.HTTPRouteRule{
@@ -219,33 +332,433 @@ func (g *GatewayAPIService) buildHTTPRouteRule(path string, svc *corev1.Service)
 				},
 			},
 		},
-		BackendRefs: []gatewayv1.HTTPBackendRef{
-			{
-				BackendRef: gatewayv1.BackendRef{
-					BackendObjectReference: gatewayv1.BackendObjectReference{
-						Name: gatewayv1.ObjectName(svc.Name),
-						Port: &port,
+		BackendRefs: buildHTTPBackendRefs(svc, weighted),
+	}
+}
+
+func routePath(opts router.Opts) string {
+	if opts.Route == "" {
+		return "/"
+	}
+	return opts.Route
+}
+
+// buildRouteRules builds one HTTPRoute rule for each route rule, routing its
+// matches to the service of the rule prefix, even when the prefix is not
+// exposed by the router.
+func (g *GatewayAPIService) buildRouteRules(ctx context.Context, appName string, rules []router.RouteRule, prefixes []router.BackendPrefix, defaultPath string) ([]gatewayv1.HTTPRouteRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	targets := addAllBackends(prefixes)
+	weightedServices, err := g.getWeightedServices(ctx, appName, prefixes, true)
+	if err != nil {
+		return nil, err
+	}
+	var routeRules []gatewayv1.HTTPRouteRule
+	for i, rule := range rules {
+		prefix := "default"
+		if rule.Prefix != "" {
+			prefix = strings.ReplaceAll(rule.Prefix, "_", "-")
+		}
+		target, ok := targets[prefix]
+		if !ok {
+			return nil, router.NewError(router.ErrorCodeInvalidOptions, "route rule %d: prefix %q not found", i, rule.Prefix)
+		}
+		match, err := buildRouteRuleMatch(rule, defaultPath)
+		if err != nil {
+			return nil, router.NewError(router.ErrorCodeInvalidOptions, "route rule %d: %w", i, err)
+		}
+		svc, err := g.getWebService(ctx, appName, target)
+		if err != nil {
+			return nil, err
+		}
+		routeRules = append(routeRules, gatewayv1.HTTPRouteRule{
+			Matches:     []gatewayv1.HTTPRouteMatch{match},
+			BackendRefs: buildHTTPBackendRefs(svc, weightedServices[prefix]),
+		})
+	}
+	return routeRules, nil
+}
+
+func buildRouteRuleMatch(rule router.RouteRule, defaultPath string) (gatewayv1.HTTPRouteMatch, error) {
+	path := rule.Path
+	if path == "" {
+		path = defaultPath
+	}
+	pathType := gatewayv1.PathMatchPathPrefix
+	match := gatewayv1.HTTPRouteMatch{
+		Path: &gatewayv1.HTTPPathMatch{
+			Type:  &pathType,
+			Value: &path,
+		},
+	}
+
+	if rule.Method != "" {
+		method := gatewayv1.HTTPMethod(strings.ToUpper(rule.Method))
+		switch method {
+		case gatewayv1.HTTPMethodGet, gatewayv1.HTTPMethodHead, gatewayv1.HTTPMethodPost,
+			gatewayv1.HTTPMethodPut, gatewayv1.HTTPMethodDelete, gatewayv1.HTTPMethodConnect,
+			gatewayv1.HTTPMethodOptions, gatewayv1.HTTPMethodTrace, gatewayv1.HTTPMethodPatch:
+			match.Method = &method
+		default:
+			return match, fmt.Errorf("invalid method %q", rule.Method)
+		}
+	}
+
+	if len(rule.Cookies) > 1 {
+		return match, fmt.Errorf("only one cookie can be matched by rule")
+	}
+	headers := rule.Headers
+	for _, cookie := range rule.Cookies {
+		value := regexp.QuoteMeta(cookie.Value)
+		if cookie.Type == string(gatewayv1.HeaderMatchRegularExpression) {
+			value = cookie.Value
+		} else if cookie.Type != "" && cookie.Type != string(gatewayv1.HeaderMatchExact) {
+			return match, fmt.Errorf("invalid match type %q for cookie %q", cookie.Type, cookie.Name)
+		}
+		headers = append(headers, router.RouteRuleMatch{
+			Name:  "Cookie",
+			Type:  string(gatewayv1.HeaderMatchRegularExpression),
+			Value: fmt.Sprintf(`(^|;\s*)%s=%s(;|$)`, regexp.QuoteMeta(cookie.Name), value),
+		})
+	}
+
+	seenHeaders := map[string]bool{}
+	for _, header := range headers {
+		name := strings.ToLower(header.Name)
+		if seenHeaders[name] {
+			return match, fmt.Errorf("header %q matched more than once", header.Name)
+		}
+		seenHeaders[name] = true
+		matchType, err := routeRuleMatchType(header)
+		if err != nil {
+			return match, err
+		}
+		headerMatchType := gatewayv1.HeaderMatchType(matchType)
+		match.Headers = append(match.Headers, gatewayv1.HTTPHeaderMatch{
+			Type:  &headerMatchType,
+			Name:  gatewayv1.HTTPHeaderName(header.Name),
+			Value: header.Value,
+		})
+	}
+
+	for _, param := range rule.QueryParams {
+		matchType, err := routeRuleMatchType(param)
+		if err != nil {
+			return match, err
+		}
+		queryParamMatchType := gatewayv1.QueryParamMatchType(matchType)
+		match.QueryParams = append(match.QueryParams, gatewayv1.HTTPQueryParamMatch{
+			Type:  &queryParamMatchType,
+			Name:  gatewayv1.HTTPHeaderName(param.Name),
+			Value: param.Value,
+		})
+	}
+
+	if len(match.Headers) == 0 && len(match.QueryParams) == 0 && match.Method == nil {
+		return match, fmt.Errorf("at least one header, cookie, query param or method must be matched")
+	}
+	return match, nil
+}
+
+func routeRuleMatchType(m router.RouteRuleMatch) (string, error) {
+	if m.Name == "" {
+		return "", fmt.Errorf("match name must not be empty")
+	}
+	switch m.Type {
+	case "":
+		return string(gatewayv1.HeaderMatchExact), nil
+	case string(gatewayv1.HeaderMatchExact), string(gatewayv1.HeaderMatchRegularExpression):
+		return m.Type, nil
+	}
+	return "", fmt.Errorf("invalid match type %q for %q", m.Type, m.Name)
+}
+
+// buildHeaderModifierFilters returns the filters modifying the request and
+// response headers as set in the router options.
+func buildHeaderModifierFilters(opts router.Opts) []gatewayv1.HTTPRouteFilter {
+	var filters []gatewayv1.HTTPRouteFilter
+	if opts.RequestHeaders != nil {
+		filters = append(filters, gatewayv1.HTTPRouteFilter{
+			Type:                  gatewayv1.HTTPRouteFilterRequestHeaderModifier,
+			RequestHeaderModifier: buildHTTPHeaderFilter(opts.RequestHeaders),
+		})
+	}
+	if opts.ResponseHeaders != nil {
+		filters = append(filters, gatewayv1.HTTPRouteFilter{
+			Type:                   gatewayv1.HTTPRouteFilterResponseHeaderModifier,
+			ResponseHeaderModifier: buildHTTPHeaderFilter(opts.ResponseHeaders),
+		})
+	}
+	return filters
+}
+
+func buildHTTPHeaderFilter(modifier *router.HeaderModifier) *gatewayv1.HTTPHeaderFilter {
+	return &gatewayv1.HTTPHeaderFilter{
+		Set:    buildHTTPHeaders(modifier.Set),
+		Add:    buildHTTPHeaders(modifier.Add),
+		Remove: modifier.Remove,
+	}
+}
+
+func buildHTTPHeaders(headers map[string]string) []gatewayv1.HTTPHeader {
+	var result []gatewayv1.HTTPHeader
+	for _, name := range sortedKeys(headers) {
+		result = append(result, gatewayv1.HTTPHeader{
+			Name:  gatewayv1.HTTPHeaderName(name),
+			Value: headers[name],
+		})
+	}
+	return result
+}
+
+// applyHTTPRouteRuleOptions sets the filters, timeouts and retries of every
+// rule of the route as set in the router options. The url rewrite only applies
+// to the rule generated for the prefix, the first one, the rules of the
+// route-rules option keep their own paths.
+func applyHTTPRouteRuleOptions(route *gatewayv1.HTTPRoute, opts router.Opts, mirror *mirrorService) {
+	filters := buildHeaderModifierFilters(opts)
+	if mirror != nil {
+		filters = append(filters, buildRequestMirrorFilter(mirror, opts.MirrorPercent))
+	}
+	var timeouts *gatewayv1.HTTPRouteTimeouts
+	if opts.Timeout != "" {
+		timeout := gatewayv1.Duration(opts.Timeout)
+		timeouts = &gatewayv1.HTTPRouteTimeouts{Request: &timeout}
+	}
+	retry := buildHTTPRouteRetry(opts)
+
+	for i, rule := range route.Spec.Rules {
+		ruleFilters := slices.Clone(filters)
+		if opts.URLRewrite != "" && i == 0 && matchesPathPrefix(rule) {
+			prefix := opts.URLRewrite
+			ruleFilters = append(ruleFilters, gatewayv1.HTTPRouteFilter{
+				Type: gatewayv1.HTTPRouteFilterURLRewrite,
+				URLRewrite: &gatewayv1.HTTPURLRewriteFilter{
+					Path: &gatewayv1.HTTPPathModifier{
+						Type:               gatewayv1.PrefixMatchHTTPPathModifier,
+						ReplacePrefixMatch: &prefix,
 					},
 				},
+			})
+		}
+		route.Spec.Rules[i].Filters = ruleFilters
+		route.Spec.Rules[i].Timeouts = timeouts
+		route.Spec.Rules[i].Retry = retry
+	}
+}
+
+func matchesPathPrefix(rule gatewayv1.HTTPRouteRule) bool {
+	return len(rule.Matches) > 0 && rule.Matches[0].Path != nil &&
+		rule.Matches[0].Path.Type != nil && *rule.Matches[0].Path.Type == gatewayv1.PathMatchPathPrefix
+}
+
+func buildRequestMirrorFilter(mirror *mirrorService, percent *int32) gatewayv1.HTTPRouteFilter {
+	namespace := gatewayv1.Namespace(mirror.namespace)
+	port := gatewayv1.PortNumber(mirror.port)
+	return gatewayv1.HTTPRouteFilter{
+		Type: gatewayv1.HTTPRouteFilterRequestMirror,
+		RequestMirror: &gatewayv1.HTTPRequestMirrorFilter{
+			BackendRef: gatewayv1.BackendObjectReference{
+				Name:      gatewayv1.ObjectName(mirror.name),
+				Namespace: &namespace,
+				Port:      &port,
 			},
+			Percent: percent,
 		},
 	}
 }
 
-// mergeAnnotations preserves existing annotations not set by the new object.
-func mergeAnnotations(dst, src map[string]string) map[string]string {
-	if len(src) == 0 {
-		return dst
+// validateHTTPRouteRetryOn rejects the retry-on conditions with no status
+// codes, Gateway API retries have no way to select the connection errors.
+func validateHTTPRouteRetryOn(opts router.Opts) error {
+	for _, condition := range opts.RetryOn {
+		if condition == "connect-failure" || condition == "reset" {
+			return router.NewError(router.ErrorCodeUnsupported, "retry-on condition %q is not supported by the gateway-api mode, Gateway API retries only select status codes", condition)
+		}
+	}
+	return nil
+}
+
+// buildHTTPRouteRetry translates the retry options, the retry-on conditions
+// become status codes. The connection errors are not selected, see
+// validateHTTPRouteRetryOn.
+func buildHTTPRouteRetry(opts router.Opts) *gatewayv1.HTTPRouteRetry {
+	if opts.Retries == nil && len(opts.RetryOn) == 0 {
+		return nil
 	}
-	if dst == nil {
-		dst = make(map[string]string)
+	retry := &gatewayv1.HTTPRouteRetry{}
+	if opts.Retries != nil {
+		attempts := *opts.Retries
+		retry.Attempts = &attempts
+	}
+	for _, condition := range opts.RetryOn {
+		var codes []int
+		switch condition {
+		case "5xx":
+			codes = []int{500, 502, 503, 504}
+		case "gateway-error":
+			codes = []int{502, 503, 504}
+		case "retriable-4xx":
+			codes = []int{409}
+		default:
+			if code, err := strconv.Atoi(condition); err == nil {
+				codes = []int{code}
+			}
+		}
+		for _, code := range codes {
+			if !slices.Contains(retry.Codes, gatewayv1.HTTPRouteRetryStatusCode(code)) {
+				retry.Codes = append(retry.Codes, gatewayv1.HTTPRouteRetryStatusCode(code))
+			}
+		}
 	}
-	for k, v := range src {
-		if _, exists := dst[k]; !exists {
-			dst[k] = v
+	return retry
+}
+
+func (g *GatewayAPIService) httpsRedirectEnabled(opts router.Opts) bool {
+	return opts.HTTPSRedirectCode != 0 && !opts.HTTPOnly
+}
+
+// appParentRef returns the Gateway reference of the app routes, restricted to
+// the HTTPS listener when HTTP requests are redirected.
+func (g *GatewayAPIService) appParentRef(opts router.Opts) gatewayv1.ParentReference {
+	gwNamespace := gatewayv1.Namespace(g.GatewayNamespace)
+	parentRef := gatewayv1.ParentReference{
+		Name:      gatewayv1.ObjectName(g.GatewayName),
+		Namespace: &gwNamespace,
+	}
+	if g.httpsRedirectEnabled(opts) {
+		sectionName := gatewayv1.SectionName(g.HTTPSListener)
+		if sectionName == "" {
+			sectionName = DefaultGatewayHTTPSListener
 		}
+		parentRef.SectionName = &sectionName
+	}
+	return parentRef
+}
+
+func (g *GatewayAPIService) httpsRedirectRouteName(id router.InstanceID) string {
+	return g.hashedResourceName(id, "kube-router-"+id.AppName+"-https-redirect", 253)
+}
+
+// ensureHTTPSRedirectRoute creates or updates the HTTPRoute attached to the
+// HTTP listener redirecting the app and CName hosts to HTTPS.
+func (g *GatewayAPIService) ensureHTTPSRedirectRoute(
+	ctx context.Context,
+	span opentracing.Span,
+	client gatewayclient.Interface,
+	id router.InstanceID,
+	o router.EnsureBackendOpts,
+	ns string,
+	prefixes []string,
+) (string, error) {
+	routeName := g.httpsRedirectRouteName(id)
+
+	var hostnames []gatewayv1.Hostname
+	for _, prefixString := range prefixes {
+		hostnames = append(hostnames, gatewayv1.Hostname(g.buildHTTPRouteHostname(prefixString, id, o, g.DomainSuffix)))
+	}
+	for _, cname := range o.CNames {
+		hostnames = append(hostnames, gatewayv1.Hostname(cname))
+	}
+
+	existingHTTPRoute, err := g.getExistingHTTPRoute(ctx, span, client, ns, routeName)
+	if err != nil {
+		return "", err
+	}
+	if isFrozenHTTPRoute(existingHTTPRoute) {
+		observability.Logger(ctx).Info("HTTPRoute is frozen, skipping", "namespace", existingHTTPRoute.Namespace, "name", existingHTTPRoute.Name)
+		return routeName, nil
+	}
+
+	gwNamespace := gatewayv1.Namespace(g.GatewayNamespace)
+	sectionName := gatewayv1.SectionName(g.HTTPListener)
+	if sectionName == "" {
+		sectionName = DefaultGatewayHTTPListener
+	}
+	scheme := "https"
+	statusCode := o.Opts.HTTPSRedirectCode
+	labels, annotations := g.buildHTTPRouteLabelsAndAnnotations(
+		map[string]string{
+			routerInstanceLabel:    id.InstanceName,
+			labelHTTPRouteHTTPOnly: "false",
+			labelHTTPSRedirect:     "true",
+		},
+		o.Opts,
+		id,
+		o.Team,
+		o.Tags,
+	)
+	httpRoute := &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        routeName,
+			Namespace:   ns,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{
+				ParentRefs: []gatewayv1.ParentReference{
+					{
+						Name:        gatewayv1.ObjectName(g.GatewayName),
+						Namespace:   &gwNamespace,
+						SectionName: &sectionName,
+					},
+				},
+			},
+			Hostnames: hostnames,
+			Rules: []gatewayv1.HTTPRouteRule{
+				{
+					Filters: []gatewayv1.HTTPRouteFilter{
+						{
+							Type: gatewayv1.HTTPRouteFilterRequestRedirect,
+							RequestRedirect: &gatewayv1.HTTPRequestRedirectFilter{
+								Scheme:     &scheme,
+								StatusCode: &statusCode,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	err = g.applyHTTPRoute(ctx, span, client, ns, httpRoute)
+	if err != nil {
+		return "", err
+	}
+	return routeName, nil
+}
+
+// buildHTTPBackendRefs returns a backendRef to svc, or one weighted backendRef
+// per service when weighted services are given.
+func buildHTTPBackendRefs(svc *corev1.Service, weighted []weightedService) []gatewayv1.HTTPBackendRef {
+	if len(weighted) == 0 {
+		return []gatewayv1.HTTPBackendRef{buildHTTPBackendRef(svc, nil)}
+	}
+	backendRefs := make([]gatewayv1.HTTPBackendRef, 0, len(weighted))
+	for _, w := range weighted {
+		weight := w.weight
+		backendRefs = append(backendRefs, buildHTTPBackendRef(w.service, &weight))
+	}
+	return backendRefs
+}
+
+func buildHTTPBackendRef(svc *corev1.Service, weight *int32) gatewayv1.HTTPBackendRef {
+	port := gatewayv1.PortNumber(defaultServicePort)
+	if len(svc.Spec.Ports) > 0 {
+		port = gatewayv1.PortNumber(svc.Spec.Ports[0].Port)
+	}
+	return gatewayv1.HTTPBackendRef{
+		BackendRef: gatewayv1.BackendRef{
+			BackendObjectReference: gatewayv1.BackendObjectReference{
+				Name: gatewayv1.ObjectName(svc.Name),
+				Port: &port,
+			},
+			Weight: weight,
+		},
 	}
-	return dst
 }
 
 func isFrozenHTTPRoute(httpRoute *gatewayv1.HTTPRoute) bool {
@@ -267,15 +780,13 @@ func (g *GatewayAPIService) getExistingHTTPRoute(ctx context.Context, span opent
 	return existingHTTPRoute, nil
 }
 
-func (g *GatewayAPIService) upsertHTTPRoute(ctx context.Context, span opentracing.Span, client gatewayclient.Interface, ns string, httpRoute, existingHTTPRoute *gatewayv1.HTTPRoute) error {
-	var err error
-	if existingHTTPRoute == nil {
-		_, err = client.GatewayV1().HTTPRoutes(ns).Create(ctx, httpRoute, metav1.CreateOptions{})
-	} else {
-		httpRoute.ResourceVersion = existingHTTPRoute.ResourceVersion
-		httpRoute.Annotations = mergeAnnotations(httpRoute.Annotations, existingHTTPRoute.Annotations)
-		_, err = client.GatewayV1().HTTPRoutes(ns).Update(ctx, httpRoute, metav1.UpdateOptions{})
-	}
+// applyHTTPRoute creates or updates the HTTPRoute with server-side apply,
+// retried when the HTTPRoute is concurrently created.
+func (g *GatewayAPIService) applyHTTPRoute(ctx context.Context, span opentracing.Span, client gatewayclient.Interface, ns string, httpRoute *gatewayv1.HTTPRoute) error {
+	err := retryOnConflict("httproute", func(bool) error {
+		_, err := applyObject(ctx, client.GatewayV1().HTTPRoutes(ns), httpRouteGVK, httpRoute)
+		return err
+	})
 	if err != nil {
 		setSpanError(span, err)
 		return err
@@ -316,10 +827,7 @@ func (g *GatewayAPIService) ensureHTTPRoutes(
 ) (map[string]bool, error) {
 	desiredRouteNames := map[string]bool{}
 
-	path := o.Opts.Route
-	if path == "" {
-		path = "/"
-	}
+	path := routePath(o.Opts)
 
 	for _, prefixString := range prefixes {
 		svc := rc.backendServices[prefixString]
@@ -334,13 +842,21 @@ func (g *GatewayAPIService) ensureHTTPRoutes(
 		}
 
 		if isFrozenHTTPRoute(existingHTTPRoute) {
-			log.Printf("HTTPRoute is frozen, skipping: %s/%s", existingHTTPRoute.Namespace, existingHTTPRoute.Name)
+			observability.Logger(ctx).Info("HTTPRoute is frozen, skipping", "namespace", existingHTTPRoute.Namespace, "name", existingHTTPRoute.Name)
 			continue
 		}
 
 		host := g.buildHTTPRouteHostname(prefixString, id, o, g.DomainSuffix)
 
-		gwNamespace := gatewayv1.Namespace(g.GatewayNamespace)
+		parentRefs := []gatewayv1.ParentReference{g.appParentRef(o.Opts)}
+		if rc.tlsIssuer != "" {
+			err = g.ensureListenerSet(ctx, span, client, id, o, rc.ns, rc.tlsIssuer, host)
+			if err != nil {
+				return nil, fmt.Errorf("could not ensure TLS of %q: %w", host, err)
+			}
+			parentRefs = append(parentRefs, g.listenerSetParentRef(id, rc.ns, host))
+		}
+
 		labels, annotations := g.buildHTTPRouteLabelsAndAnnotations(
 			map[string]string{
 				routerInstanceLabel:          id.InstanceName,
@@ -362,19 +878,22 @@ func (g *GatewayAPIService) ensureHTTPRoutes(
 			},
 			Spec: gatewayv1.HTTPRouteSpec{
 				CommonRouteSpec: gatewayv1.CommonRouteSpec{
-					ParentRefs: []gatewayv1.ParentReference{
-						{
-							Name:      gatewayv1.ObjectName(g.GatewayName),
-							Namespace: &gwNamespace,
-						},
-					},
+					ParentRefs: parentRefs,
 				},
 				Hostnames: []gatewayv1.Hostname{gatewayv1.Hostname(host)},
-				Rules:     []gatewayv1.HTTPRouteRule{g.buildHTTPRouteRule(path, svc)},
+				Rules:     []gatewayv1.HTTPRouteRule{g.buildHTTPRouteRule(path, svc, rc.weightedServices[prefixString]...)},
 			},
 		}
+		if prefixString == "default" {
+			httpRoute.Spec.Rules = append(httpRoute.Spec.Rules, rc.routeRules...)
+		}
+		applyHTTPRouteRuleOptions(httpRoute, o.Opts, rc.mirror)
 
-		err = g.upsertHTTPRoute(ctx, span, client, rc.ns, httpRoute, existingHTTPRoute)
+		if existingHTTPRoute != nil && isSwapped(existingHTTPRoute.ObjectMeta) {
+			keepSwappedHTTPRouteBackends(httpRoute, existingHTTPRoute)
+		}
+
+		err = g.applyHTTPRoute(ctx, span, client, rc.ns, httpRoute)
 		if err != nil {
 			return nil, err
 		}
@@ -431,6 +950,402 @@ func (g *GatewayAPIService) Remove(ctx context.Context, id router.InstanceID) er
 	return nil
 }
 
+// keepSwappedHTTPRouteBackends copies the backendRefs of a swapped HTTPRoute so
+// that Ensure does not undo the swap.
+func keepSwappedHTTPRouteBackends(httpRoute, existing *gatewayv1.HTTPRoute) {
+	keepSwappedLabels(&httpRoute.ObjectMeta, existing.ObjectMeta)
+	for i := range httpRoute.Spec.Rules {
+		if i >= len(existing.Spec.Rules) {
+			break
+		}
+		httpRoute.Spec.Rules[i].BackendRefs = existing.Spec.Rules[i].BackendRefs
+	}
+}
+
+// Swap swaps the backendRefs of the HTTPRoutes of two apps, including their
+// prefix and CName HTTPRoutes, the apps must have the same prefixes. When
+// cnameOnly is set the CName HTTPRoutes are moved between the apps instead.
+func (g *GatewayAPIService) Swap(ctx context.Context, srcApp, dstApp router.InstanceID, cnameOnly bool) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "swapHTTPRoute")
+	defer span.Finish()
+
+	span.SetTag("srcApp", srcApp.AppName)
+	span.SetTag("dstApp", dstApp.AppName)
+	span.SetTag("cnameOnly", cnameOnly)
+
+	ns, err := g.getSwapNamespace(ctx, srcApp, dstApp)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	client, err := g.getGatewayClient()
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	srcRoute, err := client.GatewayV1().HTTPRoutes(ns).Get(ctx, g.httpRouteName(srcApp), metav1.GetOptions{})
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	dstRoute, err := client.GatewayV1().HTTPRoutes(ns).Get(ctx, g.httpRouteName(dstApp), metav1.GetOptions{})
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	if isFrozenHTTPRoute(srcRoute) || isFrozenHTTPRoute(dstRoute) {
+		err = router.NewError(router.ErrorCodeFrozen, "cannot swap frozen HTTPRoutes %s and %s", srcRoute.Name, dstRoute.Name)
+		setSpanError(span, err)
+		return err
+	}
+	srcBackend, err := httpRouteBaseBackendRef(srcRoute)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	dstBackend, err := httpRouteBaseBackendRef(dstRoute)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	srcCNames, err := g.listCNameHTTPRoutes(ctx, client, ns, srcApp)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	dstCNames, err := g.listCNameHTTPRoutes(ctx, client, ns, dstApp)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+
+	writes := &swapWrites{}
+	if cnameOnly {
+		for i := range srcCNames {
+			err = g.moveCNameHTTPRoute(ctx, client, writes, &srcCNames[i], srcApp, dstApp, dstRoute, srcBackend.Name, *dstBackend)
+			if err != nil {
+				setSpanError(span, err)
+				return err
+			}
+		}
+		for i := range dstCNames {
+			err = g.moveCNameHTTPRoute(ctx, client, writes, &dstCNames[i], dstApp, srcApp, srcRoute, dstBackend.Name, *srcBackend)
+			if err != nil {
+				setSpanError(span, err)
+				return err
+			}
+		}
+		srcAnnotationCNames := httpRouteCNames(srcRoute)
+		dstAnnotationCNames := httpRouteCNames(dstRoute)
+		for _, swap := range []struct {
+			id             router.InstanceID
+			cnames, undone []string
+		}{
+			{id: srcApp, cnames: dstAnnotationCNames, undone: srcAnnotationCNames},
+			{id: dstApp, cnames: srcAnnotationCNames, undone: dstAnnotationCNames},
+		} {
+			err = writes.do(ctx, func(ctx context.Context) error {
+				return g.updateCNamesAnnotation(ctx, client, swap.id, ns, swap.cnames)
+			}, func(ctx context.Context) error {
+				return g.updateCNamesAnnotation(ctx, client, swap.id, ns, swap.undone)
+			})
+			if err != nil {
+				setSpanError(span, err)
+				return err
+			}
+		}
+		return nil
+	}
+
+	srcPrefixRoutes, err := g.prefixHTTPRoutes(ctx, client, ns, srcApp, srcRoute)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	dstPrefixRoutes, err := g.prefixHTTPRoutes(ctx, client, ns, dstApp, dstRoute)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	prefixes := make([]string, 0, len(srcPrefixRoutes))
+	for prefix := range srcPrefixRoutes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	type prefixRoutes struct {
+		src, dst               *gatewayv1.HTTPRoute
+		srcBackend, dstBackend *gatewayv1.BackendObjectReference
+	}
+	var swappedPrefixes []prefixRoutes
+	for _, prefix := range prefixes {
+		dstPrefixRoute, ok := dstPrefixRoutes[prefix]
+		if !ok {
+			break
+		}
+		routes := prefixRoutes{src: srcPrefixRoutes[prefix], dst: dstPrefixRoute}
+		if isFrozenHTTPRoute(routes.src) || isFrozenHTTPRoute(routes.dst) {
+			err = router.NewError(router.ErrorCodeFrozen, "cannot swap frozen HTTPRoutes %s and %s", routes.src.Name, routes.dst.Name)
+			setSpanError(span, err)
+			return err
+		}
+		routes.srcBackend, err = httpRouteBaseBackendRef(routes.src)
+		if err != nil {
+			setSpanError(span, err)
+			return err
+		}
+		routes.dstBackend, err = httpRouteBaseBackendRef(routes.dst)
+		if err != nil {
+			setSpanError(span, err)
+			return err
+		}
+		swappedPrefixes = append(swappedPrefixes, routes)
+	}
+	if len(swappedPrefixes) != len(srcPrefixRoutes) || len(swappedPrefixes) != len(dstPrefixRoutes) {
+		err = router.NewError(router.ErrorCodeUnsupported, "cannot swap HTTPRoutes %s and %s with different prefixes", srcRoute.Name, dstRoute.Name)
+		setSpanError(span, err)
+		return err
+	}
+
+	toApply := []*gatewayv1.HTTPRoute{srcRoute, dstRoute}
+	for _, routes := range swappedPrefixes {
+		toApply = append(toApply, routes.src, routes.dst)
+	}
+	for i := range srcCNames {
+		toApply = append(toApply, &srcCNames[i])
+	}
+	for i := range dstCNames {
+		toApply = append(toApply, &dstCNames[i])
+	}
+	originals := make([]*gatewayv1.HTTPRoute, len(toApply))
+	for i, route := range toApply {
+		originals[i] = withoutCNamesAnnotation(route)
+	}
+	replaceHTTPRouteBackendRef(srcRoute, srcBackend.Name, *dstBackend)
+	toggleSwapped(&srcRoute.ObjectMeta, dstApp.AppName)
+	replaceHTTPRouteBackendRef(dstRoute, dstBackend.Name, *srcBackend)
+	toggleSwapped(&dstRoute.ObjectMeta, srcApp.AppName)
+	swapBaseServiceLabels(&srcRoute.ObjectMeta, &dstRoute.ObjectMeta)
+	for _, routes := range swappedPrefixes {
+		replaceHTTPRouteBackendRef(routes.src, routes.srcBackend.Name, *routes.dstBackend)
+		toggleSwapped(&routes.src.ObjectMeta, dstApp.AppName)
+		replaceHTTPRouteBackendRef(routes.dst, routes.dstBackend.Name, *routes.srcBackend)
+		toggleSwapped(&routes.dst.ObjectMeta, srcApp.AppName)
+		swapBaseServiceLabels(&routes.src.ObjectMeta, &routes.dst.ObjectMeta)
+	}
+	for i := range srcCNames {
+		replaceHTTPRouteBackendRef(&srcCNames[i], srcBackend.Name, *dstBackend)
+		toggleSwapped(&srcCNames[i].ObjectMeta, dstApp.AppName)
+	}
+	for i := range dstCNames {
+		replaceHTTPRouteBackendRef(&dstCNames[i], dstBackend.Name, *srcBackend)
+		toggleSwapped(&dstCNames[i].ObjectMeta, srcApp.AppName)
+	}
+
+	for i, route := range toApply {
+		err = applySwapped(ctx, writes, client.GatewayV1().HTTPRoutes(ns), httpRouteGVK, withoutCNamesAnnotation(route), originals[i])
+		if err != nil {
+			setSpanError(span, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixHTTPRoutes returns the HTTPRoutes of the prefixes of the app other
+// than the default one by prefix, their hostnames are subdomains of the
+// hostname of its main HTTPRoute.
+func (g *GatewayAPIService) prefixHTTPRoutes(ctx context.Context, client gatewayclient.Interface, ns string, id router.InstanceID, mainRoute *gatewayv1.HTTPRoute) (map[string]*gatewayv1.HTTPRoute, error) {
+	routes, err := g.listHTTPRoutesForApp(ctx, client, ns, id)
+	if err != nil {
+		return nil, err
+	}
+	prefixRoutes := map[string]*gatewayv1.HTTPRoute{}
+	if len(mainRoute.Spec.Hostnames) == 0 {
+		return prefixRoutes, nil
+	}
+	mainHost := string(mainRoute.Spec.Hostnames[0])
+	for i, route := range routes {
+		if route.Name == mainRoute.Name || route.Labels[labelHTTPSRedirect] == "true" || len(route.Spec.Hostnames) == 0 {
+			continue
+		}
+		prefix, ok := strings.CutSuffix(string(route.Spec.Hostnames[0]), "."+mainHost)
+		if !ok {
+			continue
+		}
+		prefixRoutes[prefix] = &routes[i]
+	}
+	return prefixRoutes, nil
+}
+
+// httpRouteCNames returns the CNames tracked in the annotation of the main
+// HTTPRoute.
+func httpRouteCNames(httpRoute *gatewayv1.HTTPRoute) []string {
+	cnames := httpRoute.Annotations[annotationCNames]
+	if cnames == "" {
+		return nil
+	}
+	return strings.Split(cnames, ",")
+}
+
+// withoutCNamesAnnotation returns a copy of the HTTPRoute to be applied, the
+// CNames annotation is left to its own field manager.
+func withoutCNamesAnnotation(httpRoute *gatewayv1.HTTPRoute) *gatewayv1.HTTPRoute {
+	httpRoute = httpRoute.DeepCopy()
+	delete(httpRoute.Annotations, annotationCNames)
+	return httpRoute
+}
+
+// listCNameHTTPRoutes lists the CName HTTPRoutes of the given app.
+func (g *GatewayAPIService) listCNameHTTPRoutes(ctx context.Context, client gatewayclient.Interface, ns string, id router.InstanceID) ([]gatewayv1.HTTPRoute, error) {
+	selector := labels.Set{
+		appLabel:            id.AppName,
+		routerInstanceLabel: id.InstanceName,
+		labelCNameHTTPRoute: "true",
+	}.AsSelector()
+	list, err := client.GatewayV1().HTTPRoutes(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// moveCNameHTTPRoute recreates a CName HTTPRoute as owned by another app,
+// pointing to the backend of that app. The ListenerSet holding the TLS
+// listener of the CName is moved along with it.
+func (g *GatewayAPIService) moveCNameHTTPRoute(
+	ctx context.Context,
+	client gatewayclient.Interface,
+	writes *swapWrites,
+	route *gatewayv1.HTTPRoute,
+	from, to router.InstanceID,
+	toRoute *gatewayv1.HTTPRoute,
+	fromService gatewayv1.ObjectName,
+	backend gatewayv1.BackendObjectReference,
+) error {
+	if len(route.Spec.Hostnames) == 0 {
+		return nil
+	}
+	cname := string(route.Spec.Hostnames[0])
+	err := g.moveListenerSet(ctx, client, writes, route.Namespace, cname, from, to, toRoute)
+	if err != nil {
+		return err
+	}
+
+	moved := &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        g.httpRouteCNameName(to, cname),
+			Namespace:   route.Namespace,
+			Labels:      mergeMaps(route.Labels),
+			Annotations: route.Annotations,
+		},
+		Spec: *route.Spec.DeepCopy(),
+	}
+	moved.Labels[appLabel] = to.AppName
+	moved.Labels[teamLabel] = toRoute.Labels[teamLabel]
+	delete(moved.Labels, swappedWithLabel)
+	replaceHTTPRouteBackendRef(moved, fromService, backend)
+	fromListenerSet := gatewayv1.ObjectName(g.listenerSetName(from, cname))
+	for i, ref := range moved.Spec.ParentRefs {
+		if ref.Kind != nil && *ref.Kind == "ListenerSet" && ref.Name == fromListenerSet {
+			moved.Spec.ParentRefs[i].Name = gatewayv1.ObjectName(g.listenerSetName(to, cname))
+		}
+	}
+
+	routes := client.GatewayV1().HTTPRoutes(route.Namespace)
+	existing, err := routes.Get(ctx, moved.Name, metav1.GetOptions{})
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return writes.rollback(ctx, err)
+		}
+		err = createSwapped(ctx, writes, routes, httpRouteGVK, moved)
+	} else {
+		err = applySwapped(ctx, writes, routes, httpRouteGVK, moved, existing)
+	}
+	if err != nil {
+		return err
+	}
+	if moved.Name == route.Name {
+		return nil
+	}
+	return deleteSwapped(ctx, writes, routes, httpRouteGVK, route)
+}
+
+// moveListenerSet recreates the ListenerSet of a CName as owned by another
+// app. CNames without TLS have no ListenerSet.
+func (g *GatewayAPIService) moveListenerSet(
+	ctx context.Context,
+	client gatewayclient.Interface,
+	writes *swapWrites,
+	ns, cname string,
+	from, to router.InstanceID,
+	toRoute *gatewayv1.HTTPRoute,
+) error {
+	listenerSets := client.GatewayV1().ListenerSets(ns)
+	listenerSet, err := listenerSets.Get(ctx, g.listenerSetName(from, cname), metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return nil
+		}
+		return writes.rollback(ctx, err)
+	}
+	moved := &gatewayv1.ListenerSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        g.listenerSetName(to, cname),
+			Namespace:   ns,
+			Labels:      mergeMaps(listenerSet.Labels),
+			Annotations: listenerSet.Annotations,
+		},
+		Spec: *listenerSet.Spec.DeepCopy(),
+	}
+	moved.Labels[appLabel] = to.AppName
+	moved.Labels[teamLabel] = toRoute.Labels[teamLabel]
+	if moved.Name == listenerSet.Name {
+		return nil
+	}
+
+	existing, err := listenerSets.Get(ctx, moved.Name, metav1.GetOptions{})
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return writes.rollback(ctx, err)
+		}
+		err = createSwapped(ctx, writes, listenerSets, listenerSetGVK, moved)
+	} else {
+		err = applySwapped(ctx, writes, listenerSets, listenerSetGVK, moved, existing)
+	}
+	if err != nil {
+		return err
+	}
+	return deleteSwapped(ctx, writes, listenerSets, listenerSetGVK, listenerSet)
+}
+
+// httpRouteBaseBackendRef returns the backendRef pointing to the base service
+// of the HTTPRoute.
+func httpRouteBaseBackendRef(httpRoute *gatewayv1.HTTPRoute) (*gatewayv1.BackendObjectReference, error) {
+	serviceName := gatewayv1.ObjectName(httpRoute.Labels[appBaseServiceNameLabel])
+	for _, rule := range httpRoute.Spec.Rules {
+		for _, ref := range rule.BackendRefs {
+			if ref.Name == serviceName {
+				backend := ref.BackendObjectReference
+				return &backend, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("HTTPRoute %s has no backendRef pointing to service %q", httpRoute.Name, serviceName)
+}
+
+// replaceHTTPRouteBackendRef points every backendRef using the service from to backend.
+func replaceHTTPRouteBackendRef(httpRoute *gatewayv1.HTTPRoute, from gatewayv1.ObjectName, backend gatewayv1.BackendObjectReference) {
+	for i := range httpRoute.Spec.Rules {
+		for j, ref := range httpRoute.Spec.Rules[i].BackendRefs {
+			if ref.Name == from {
+				httpRoute.Spec.Rules[i].BackendRefs[j].BackendObjectReference = *backend.DeepCopy()
+			}
+		}
+	}
+}
+
 // GetAddresses returns the hostnames configured on all HTTPRoutes for the given app.
 func (g *GatewayAPIService) GetAddresses(ctx context.Context, id router.InstanceID) ([]string, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "getGatewayAddresses")
@@ -451,19 +1366,113 @@ func (g *GatewayAPIService) GetAddresses(ctx context.Context, id router.Instance
 		return nil, err
 	}
 
-	schema := "http"
-	if len(routes) > 0 && routes[0].Labels[labelHTTPRouteHTTPOnly] != "true" {
-		schema = "https"
-	}
-
+	listeners := map[string][]*gatewayv1.Hostname{}
 	var addresses []string
 	for _, route := range routes {
+		if route.Labels[labelHTTPSRedirect] == "true" {
+			continue
+		}
 		for _, hostname := range route.Spec.Hostnames {
+			schema := "http"
+			if route.Labels[labelHTTPRouteHTTPOnly] != "true" {
+				var covered bool
+				covered, err = g.hasHTTPSListener(ctx, client, &route, string(hostname), listeners)
+				if err != nil {
+					return nil, err
+				}
+				if covered {
+					schema = "https"
+				}
+			}
 			addresses = append(addresses, fmt.Sprintf("%s://%s", schema, hostname))
 		}
 	}
 
-	return addresses, nil
+	return addresses, nil
+}
+
+// hasHTTPSListener checks whether a HTTPS listener of the parents of the
+// HTTPRoute covers the host. The hostnames of the HTTPS listeners are cached
+// by parent in listeners.
+func (g *GatewayAPIService) hasHTTPSListener(ctx context.Context, client gatewayclient.Interface, route *gatewayv1.HTTPRoute, host string, listeners map[string][]*gatewayv1.Hostname) (bool, error) {
+	for _, ref := range route.Spec.ParentRefs {
+		ns := route.Namespace
+		if ref.Namespace != nil {
+			ns = string(*ref.Namespace)
+		}
+		kind := "Gateway"
+		if ref.Kind != nil {
+			kind = string(*ref.Kind)
+		}
+		var section string
+		if ref.SectionName != nil {
+			section = string(*ref.SectionName)
+		}
+		key := strings.Join([]string{kind, ns, string(ref.Name), section}, "/")
+		hostnames, ok := listeners[key]
+		if !ok {
+			var err error
+			hostnames, err = g.httpsListenerHostnames(ctx, client, kind, ns, string(ref.Name), section)
+			if err != nil {
+				return false, err
+			}
+			listeners[key] = hostnames
+		}
+		for _, hostname := range hostnames {
+			if listenerHostnameMatches(hostname, host) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// httpsListenerHostnames returns the hostnames of the HTTPS listeners of a
+// Gateway or ListenerSet, restricted to the section when set. A nil hostname
+// matches every host.
+func (g *GatewayAPIService) httpsListenerHostnames(ctx context.Context, client gatewayclient.Interface, kind, ns, name, section string) ([]*gatewayv1.Hostname, error) {
+	var hostnames []*gatewayv1.Hostname
+	switch kind {
+	case "Gateway":
+		gateway, err := client.GatewayV1().Gateways(ns).Get(ctx, name, metav1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, listener := range gateway.Spec.Listeners {
+			if listener.Protocol == gatewayv1.HTTPSProtocolType && (section == "" || string(listener.Name) == section) {
+				hostnames = append(hostnames, listener.Hostname)
+			}
+		}
+	case "ListenerSet":
+		listenerSet, err := client.GatewayV1().ListenerSets(ns).Get(ctx, name, metav1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, listener := range listenerSet.Spec.Listeners {
+			if listener.Protocol == gatewayv1.HTTPSProtocolType && (section == "" || string(listener.Name) == section) {
+				hostnames = append(hostnames, listener.Hostname)
+			}
+		}
+	}
+	return hostnames, nil
+}
+
+// listenerHostnameMatches checks whether the hostname of a listener covers the
+// host, wildcard hostnames match any subdomain.
+func listenerHostnameMatches(hostname *gatewayv1.Hostname, host string) bool {
+	if hostname == nil || *hostname == "" {
+		return true
+	}
+	if wildcard, ok := strings.CutPrefix(string(*hostname), "*"); ok {
+		return strings.HasSuffix(host, wildcard)
+	}
+	return string(*hostname) == host
 }
 
 // GetStatus returns the readiness status of the HTTPRoutes by inspecting their parent conditions.
@@ -557,12 +1566,24 @@ func (g *GatewayAPIService) httpRouteStatus(ctx context.Context, ns string, http
 // SupportedOptions returns the options supported by this router.
 func (g *GatewayAPIService) SupportedOptions(ctx context.Context) map[string]string {
 	opts := map[string]string{
-		router.Domain:      "Domain used on router.",
-		router.Route:       "Path used on router rule.",
-		router.AllPrefixes: "",
+		router.Domain:          "Domain used on router.",
+		router.Route:           "Path used on router rule.",
+		router.Acme:            "If set to true, the app hostnames get a certificate issued by the acme issuer of the router. Defaults to false.",
+		router.TLSIssuer:       "",
+		router.AllPrefixes:     "",
+		router.RouteRules:      "",
+		router.RequestHeaders:  "",
+		router.ResponseHeaders: "",
+		router.HTTPSRedirect:   "",
+		router.URLRewrite:      "",
+		router.Timeout:         "",
+		router.Retries:         "",
+		router.RetryOn:         "",
+		router.MirrorTo:        "",
+		router.MirrorPercent:   "",
 	}
 	docs := mergeMaps(defaultGatewayOptsAsAnnotationsDocs, g.OptsAsAnnotationsDocs)
-	for _, k := range []string{router.Domain, router.Route, router.AllPrefixes} {
+	for k := range opts {
 		if docs[k] != "" {
 			opts[k] = docs[k]
 		}
@@ -677,12 +1698,17 @@ func (g *GatewayAPIService) ensureCNames(
 	id router.InstanceID,
 	o router.EnsureBackendOpts,
 	ns string,
+	existingCNames []string,
 	defaultTarget router.BackendTarget,
+	rc httpRouteContext,
 ) error {
-	// Determine existing CNames from annotation on the main HTTPRoute
-	existingCNames := g.getExistingCNames(ctx, client, id, ns)
 	_, cnamesToRemove := diffCNames(existingCNames, o.CNames)
 
+	weightedServices, err := g.getWeightedServices(ctx, id.AppName, o.Prefixes, false)
+	if err != nil {
+		return err
+	}
+
 	gwNamespace := gatewayv1.Namespace(g.GatewayNamespace)
 	if o.Opts.HTTPOnly {
 		// HTTP-only: CName HTTPRoutes connect directly to the Gateway (no TLS/ListenerSets).
@@ -699,6 +1725,9 @@ func (g *GatewayAPIService) ensureCNames(
 				cname:         cname,
 				team:          o.Team,
 				defaultTarget: defaultTarget,
+				weighted:      weightedServices["default"],
+				routeRules:    rc.routeRules,
+				mirror:        rc.mirror,
 				parentRefs:    parentRefs,
 				routerOpts:    o.Opts,
 				tags:          o.Tags,
@@ -725,28 +1754,22 @@ func (g *GatewayAPIService) ensureCNames(
 			issuer = g.AcmeIssuer
 		}
 		if issuer == "" {
-			// Without an issuer there is no TLS certificate to provision, so skip.
-			continue
+			hasCertificate, err := g.hasUserCertificate(ctx, client, id, ns, cname)
+			if err != nil {
+				return err
+			}
+			if !hasCertificate {
+				// Without an issuer or a certificate there is no TLS to provision, so skip.
+				continue
+			}
 		}
 
 		err := g.ensureListenerSet(ctx, span, client, id, o, ns, issuer, cname)
 		if err != nil {
-			return err
+			return fmt.Errorf("could not ensure CName %q: %w", cname, err)
 		}
 
-		lsNamespace := gatewayv1.Namespace(ns)
-		lsGroup := gatewayv1.Group("gateway.networking.k8s.io")
-		lsKind := gatewayv1.Kind("ListenerSet")
-		sectionName := listenerEntryName(cname)
-		parentRefs := []gatewayv1.ParentReference{
-			{
-				Group:       &lsGroup,
-				Kind:        &lsKind,
-				Name:        gatewayv1.ObjectName(g.listenerSetName(id, cname)),
-				Namespace:   &lsNamespace,
-				SectionName: &sectionName,
-			},
-		}
+		parentRefs := []gatewayv1.ParentReference{g.listenerSetParentRef(id, ns, cname)}
 
 		err = g.ensureCNameHTTPRoute(ctx, span, client, ensureCNameHTTPRouteOpts{
 			id:            id,
@@ -754,6 +1777,9 @@ func (g *GatewayAPIService) ensureCNames(
 			cname:         cname,
 			team:          o.Team,
 			defaultTarget: defaultTarget,
+			weighted:      weightedServices["default"],
+			routeRules:    rc.routeRules,
+			mirror:        rc.mirror,
 			parentRefs:    parentRefs,
 			routerOpts:    o.Opts,
 			tags:          o.Tags,
@@ -771,8 +1797,8 @@ func (g *GatewayAPIService) ensureCNames(
 		}
 	}
 
-	// Clean up ListenerSets whose CName is no longer desired
-	err := g.cleanupOrphanedListenerSets(ctx, client, id, ns, o.CNames)
+	// Clean up ListenerSets whose CName or app hostname is no longer desired
+	err = g.cleanupOrphanedListenerSets(ctx, client, id, ns, append(slices.Clone(o.CNames), rc.tlsHosts...))
 	if err != nil {
 		return err
 	}
@@ -780,6 +1806,21 @@ func (g *GatewayAPIService) ensureCNames(
 	return nil
 }
 
+// listenerSetParentRef returns the reference to the listener of the ListenerSet of a host.
+func (g *GatewayAPIService) listenerSetParentRef(id router.InstanceID, ns, host string) gatewayv1.ParentReference {
+	lsNamespace := gatewayv1.Namespace(ns)
+	lsGroup := gatewayv1.Group(gatewayv1.GroupName)
+	lsKind := gatewayv1.Kind("ListenerSet")
+	sectionName := listenerEntryName(host)
+	return gatewayv1.ParentReference{
+		Group:       &lsGroup,
+		Kind:        &lsKind,
+		Name:        gatewayv1.ObjectName(g.listenerSetName(id, host)),
+		Namespace:   &lsNamespace,
+		SectionName: &sectionName,
+	}
+}
+
 // ensureListenerSet creates or updates the ListenerSet that holds the single TLS
 // listener for a given CName. Keeping one CName per ListenerSet lets each one be
 // annotated with its own cert-manager.io/common-name.
@@ -791,6 +1832,16 @@ func (g *GatewayAPIService) ensureListenerSet(
 	o router.EnsureBackendOpts,
 	ns, issuer, cname string,
 ) error {
+	var annotations map[string]string
+	if issuer != "" {
+		issuerData, err := g.getCertManagerIssuerData(ctx, issuer, ns)
+		if err != nil {
+			setSpanError(span, err)
+			return err
+		}
+		annotations = g.listenerSetCertManagerAnnotations(issuerData, cname)
+	}
+
 	lsName := g.listenerSetName(id, cname)
 	gwNamespace := gatewayv1.Namespace(g.GatewayNamespace)
 
@@ -825,7 +1876,7 @@ func (g *GatewayAPIService) ensureListenerSet(
 			Name:        lsName,
 			Namespace:   ns,
 			Labels:      labels,
-			Annotations: g.listenerSetCertManagerAnnotations(issuer, cname),
+			Annotations: annotations,
 		},
 		Spec: gatewayv1.ListenerSetSpec{
 			ParentRef: gatewayv1.ParentGatewayReference{
@@ -842,18 +1893,23 @@ func (g *GatewayAPIService) ensureListenerSet(
 			setSpanError(span, err)
 			return err
 		}
-		// Create
-		_, err = client.GatewayV1().ListenerSets(ns).Create(ctx, listenerSet, metav1.CreateOptions{})
-		if err != nil {
-			setSpanError(span, err)
-			return err
-		}
+		existing = nil
+	}
+
+	if existing != nil && existing.Labels[labelUserCertificate] == "true" {
+		// The certificate set by the user takes precedence, the issuer is kept
+		// in the labels to switch back to cert-manager when it is removed.
+		listenerSet.Labels[labelUserCertificate] = "true"
+		listenerSet.Annotations = nil
+		listenerSet.Spec.Listeners = existing.Spec.Listeners
+	} else if issuer == "" {
 		return nil
 	}
 
-	// Update
-	listenerSet.ResourceVersion = existing.ResourceVersion
-	_, err = client.GatewayV1().ListenerSets(ns).Update(ctx, listenerSet, metav1.UpdateOptions{})
+	err = retryOnConflict("listenerset", func(bool) error {
+		_, err := applyObject(ctx, client.GatewayV1().ListenerSets(ns), listenerSetGVK, listenerSet)
+		return err
+	})
 	if err != nil {
 		setSpanError(span, err)
 		return err
@@ -868,6 +1924,9 @@ type ensureCNameHTTPRouteOpts struct {
 	cname         string
 	team          string
 	defaultTarget router.BackendTarget
+	weighted      []weightedService
+	routeRules    []gatewayv1.HTTPRouteRule
+	mirror        *mirrorService
 	parentRefs    []gatewayv1.ParentReference
 	routerOpts    router.Opts
 	tags          []string
@@ -889,11 +1948,6 @@ func (g *GatewayAPIService) ensureCNameHTTPRoute(
 		return err
 	}
 
-	port := gatewayv1.PortNumber(defaultServicePort)
-	if len(svc.Spec.Ports) > 0 {
-		port = gatewayv1.PortNumber(svc.Spec.Ports[0].Port)
-	}
-
 	labels, annotations := g.buildHTTPRouteLabelsAndAnnotations(
 		map[string]string{
 			routerInstanceLabel: opts.id.InstanceName,
@@ -916,50 +1970,27 @@ func (g *GatewayAPIService) ensureCNameHTTPRoute(
 				ParentRefs: opts.parentRefs,
 			},
 			Hostnames: []gatewayv1.Hostname{gatewayv1.Hostname(opts.cname)},
-			Rules: []gatewayv1.HTTPRouteRule{
+			Rules: append([]gatewayv1.HTTPRouteRule{
 				{
-					BackendRefs: []gatewayv1.HTTPBackendRef{
-						{
-							BackendRef: gatewayv1.BackendRef{
-								BackendObjectReference: gatewayv1.BackendObjectReference{
-									Name: gatewayv1.ObjectName(svc.Name),
-									Port: &port,
-								},
-							},
-						},
-					},
+					BackendRefs: buildHTTPBackendRefs(svc, opts.weighted),
 				},
-			},
+			}, opts.routeRules...),
 		},
 	}
+	applyHTTPRouteRuleOptions(httpRoute, opts.routerOpts, opts.mirror)
 
-	existing, err := client.GatewayV1().HTTPRoutes(opts.ns).Get(ctx, routeName, metav1.GetOptions{})
+	existing, err := g.getExistingHTTPRoute(ctx, span, client, opts.ns, routeName)
 	if err != nil {
-		if !k8sErrors.IsNotFound(err) {
-			setSpanError(span, err)
-			return err
-		}
-		_, err = client.GatewayV1().HTTPRoutes(opts.ns).Create(ctx, httpRoute, metav1.CreateOptions{})
-		if err != nil {
-			setSpanError(span, err)
-			return err
-		}
-		return nil
+		return err
 	}
-
-	if existing.Annotations[AnnotationFreeze] == "true" {
-		log.Printf("CName HTTPRoute is frozen, skipping: %s/%s", existing.Namespace, existing.Name)
+	if isFrozenHTTPRoute(existing) {
+		observability.Logger(ctx).Info("CName HTTPRoute is frozen, skipping", "namespace", existing.Namespace, "name", existing.Name)
 		return nil
 	}
-
-	httpRoute.ResourceVersion = existing.ResourceVersion
-	httpRoute.Annotations = mergeAnnotations(httpRoute.Annotations, existing.Annotations)
-	_, err = client.GatewayV1().HTTPRoutes(opts.ns).Update(ctx, httpRoute, metav1.UpdateOptions{})
-	if err != nil {
-		setSpanError(span, err)
-		return err
+	if existing != nil && isSwapped(existing.ObjectMeta) {
+		keepSwappedHTTPRouteBackends(httpRoute, existing)
 	}
-	return nil
+	return g.applyHTTPRoute(ctx, span, client, opts.ns, httpRoute)
 }
 
 // removeCNameHTTPRoute removes the HTTPRoute for a specific CName.
@@ -999,9 +2030,16 @@ func (g *GatewayAPIService) cleanupOrphanedListenerSets(
 	}
 
 	for _, ls := range existingListenerSets.Items {
-		if !desired[ls.Name] {
-			err = client.GatewayV1().ListenerSets(ns).Delete(ctx, ls.Name, metav1.DeleteOptions{})
-			if err != nil && !k8sErrors.IsNotFound(err) {
+		if desired[ls.Name] {
+			continue
+		}
+		err = client.GatewayV1().ListenerSets(ns).Delete(ctx, ls.Name, metav1.DeleteOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+		if ls.Labels[labelUserCertificate] == "true" {
+			err = g.removeListenerSetSecrets(ctx, &ls)
+			if err != nil {
 				return err
 			}
 		}
@@ -1047,11 +2085,38 @@ func (g *GatewayAPIService) removeCNameResources(ctx context.Context, client gat
 		if err != nil && !k8sErrors.IsNotFound(err) {
 			return err
 		}
+		if ls.Labels[labelUserCertificate] == "true" {
+			err = g.removeListenerSetSecrets(ctx, &ls)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
+// removeListenerSetSecrets removes the secrets with the certificates set by the
+// user referenced by the listeners of the ListenerSet.
+func (g *GatewayAPIService) removeListenerSetSecrets(ctx context.Context, listenerSet *gatewayv1.ListenerSet) error {
+	k8sClient, err := g.getClient()
+	if err != nil {
+		return err
+	}
+	for _, listener := range listenerSet.Spec.Listeners {
+		if listener.TLS == nil {
+			continue
+		}
+		for _, ref := range listener.TLS.CertificateRefs {
+			err = k8sClient.CoreV1().Secrets(listenerSet.Namespace).Delete(ctx, string(ref.Name), metav1.DeleteOptions{})
+			if err != nil && !k8sErrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // getExistingCNames retrieves the current CNames stored in the main HTTPRoute annotation.
 func (g *GatewayAPIService) getExistingCNames(ctx context.Context, client gatewayclient.Interface, id router.InstanceID, ns string) []string {
 	routeName := g.httpRouteName(id)
@@ -1077,53 +2142,358 @@ func (g *GatewayAPIService) tlsSecretName(id router.InstanceID, cname string) st
 	return name
 }
 
-// hasExistingCNames checks if the app currently has any CName annotations stored.
-func (g *GatewayAPIService) hasExistingCNames(ctx context.Context, client gatewayclient.Interface, id router.InstanceID, ns string) bool {
-	existing := g.getExistingCNames(ctx, client, id, ns)
-	return len(existing) > 0
-}
-
 // updateCNamesAnnotation stores the current CNames in the main HTTPRoute annotation for tracking.
-func (g *GatewayAPIService) updateCNamesAnnotation(ctx context.Context, client gatewayclient.Interface, id router.InstanceID, ns string, cnames []string) {
+// The annotation is applied by its own field manager, it is kept when the
+// HTTPRoute is applied and removed when there are no CNames.
+func (g *GatewayAPIService) updateCNamesAnnotation(ctx context.Context, client gatewayclient.Interface, id router.InstanceID, ns string, cnames []string) error {
 	routeName := g.httpRouteName(id)
-	httpRoute, err := client.GatewayV1().HTTPRoutes(ns).Get(ctx, routeName, metav1.GetOptions{})
+	_, err := client.GatewayV1().HTTPRoutes(ns).Get(ctx, routeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	httpRoute := &unstructured.Unstructured{}
+	httpRoute.SetGroupVersionKind(httpRouteGVK)
+	httpRoute.SetName(routeName)
+	httpRoute.SetNamespace(ns)
+	if len(cnames) > 0 {
+		httpRoute.SetAnnotations(map[string]string{annotationCNames: strings.Join(cnames, ",")})
+	}
+	data, err := httpRoute.MarshalJSON()
 	if err != nil {
-		return
+		return err
 	}
+	_, err = client.GatewayV1().HTTPRoutes(ns).Patch(ctx, routeName, types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: cnamesFieldManager,
+		Force:        ptr.To(true),
+	})
+	return err
+}
 
-	if httpRoute.Annotations == nil {
-		httpRoute.Annotations = map[string]string{}
+// listenerSetCertManagerAnnotations returns the cert-manager annotations for a ListenerSet
+// using the issuer resolved by getCertManagerIssuerData. Because each ListenerSet holds a
+// single CName, cert-manager.io/common-name is set to that CName so the issued Certificate
+// carries the correct CN.
+func (g *GatewayAPIService) listenerSetCertManagerAnnotations(issuer CertManagerIssuerData, cname string) map[string]string {
+	annotations := map[string]string{
+		certManagerCommonName: cname,
 	}
 
-	if len(cnames) > 0 {
-		httpRoute.Annotations[annotationCNames] = strings.Join(cnames, ",")
-	} else {
-		delete(httpRoute.Annotations, annotationCNames)
+	switch issuer.issuerType {
+	case certManagerIssuerTypeIssuer:
+		annotations[certManagerIssuerKey] = issuer.name
+	case certManagerIssuerTypeClusterIssuer:
+		annotations[certManagerClusterIssuerKey] = issuer.name
+	case certManagerIssuerTypeExternalIssuer:
+		annotations[certManagerIssuerKey] = issuer.name
+		annotations[certManagerIssuerKindKey] = issuer.kind
+		annotations[certManagerIssuerGroupKey] = issuer.group
 	}
 
-	_, _ = client.GatewayV1().HTTPRoutes(ns).Update(ctx, httpRoute, metav1.UpdateOptions{})
+	return annotations
 }
 
-// listenerSetCertManagerAnnotations returns the cert-manager annotations for a ListenerSet.
-// The caller only invokes this with a non-empty issuer and CName. Because each ListenerSet
-// holds a single CName, cert-manager.io/common-name is set to that CName so the issued
-// Certificate carries the correct CN.
-func (g *GatewayAPIService) listenerSetCertManagerAnnotations(issuer, cname string) map[string]string {
-	var annotations map[string]string
+// hasUserCertificate checks whether the ListenerSet of the CName serves a
+// certificate set by AddCertificate.
+func (g *GatewayAPIService) hasUserCertificate(ctx context.Context, client gatewayclient.Interface, id router.InstanceID, ns, cname string) (bool, error) {
+	listenerSet, err := client.GatewayV1().ListenerSets(ns).Get(ctx, g.listenerSetName(id, cname), metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return listenerSet.Labels[labelUserCertificate] == "true", nil
+}
+
+// certificateSecretName returns the name of the secret with the certificate set
+// by the user for the CName.
+func (g *GatewayAPIService) certificateSecretName(id router.InstanceID, cname string) string {
+	return g.hashedResourceName(id, "kr-"+id.AppName+"-"+cname, 253)
+}
+
+// AddCertificate stores the certificate of a CName in a TLS secret referenced by
+// the listener of the ListenerSet of the CName. ListenerSets managed by
+// cert-manager don't accept certificates.
+func (g *GatewayAPIService) AddCertificate(ctx context.Context, id router.InstanceID, certCname string, cert router.CertData) error {
+	ns, err := g.getAppNamespace(ctx, id.AppName)
+	if err != nil {
+		return err
+	}
+	client, err := g.getGatewayClient()
+	if err != nil {
+		return err
+	}
+	httpRoute, err := client.GatewayV1().HTTPRoutes(ns).Get(ctx, g.httpRouteName(id), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if httpRoute.Labels[labelHTTPRouteHTTPOnly] == "true" {
+		return router.NewError(router.ErrorCodeUnsupported, "cannot add certificate to httproute %s, the app is http-only", httpRoute.Name).WithDetail("cname", certCname)
+	}
+	cnames := g.getExistingCNames(ctx, client, id, ns)
+	if !slices.Contains(cnames, certCname) {
+		return router.NewError(router.ErrorCodeNotFound, "cname %s is not found in httproute %s, found cnames: %s", certCname, httpRoute.Name, strings.Join(cnames, ", ")).WithDetail("cname", certCname)
+	}
 
-	if parts := strings.SplitN(issuer, ".", 3); len(parts) == 3 {
-		annotations = map[string]string{
-			certManagerIssuerKey:      parts[0],
-			certManagerIssuerKindKey:  parts[1],
-			certManagerIssuerGroupKey: parts[2],
+	lsName := g.listenerSetName(id, certCname)
+	listenerSet, err := client.GatewayV1().ListenerSets(ns).Get(ctx, lsName, metav1.GetOptions{})
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return err
 		}
-	} else {
-		annotations = map[string]string{
-			certManagerClusterIssuerKey: issuer,
+		listenerSet = nil
+	}
+	if listenerSet != nil && isManagedByCertManager(listenerSet.Annotations) {
+		return router.NewError(router.ErrorCodeUnsupported, "cannot add certificate to listenerset %s, it is managed by cert-manager", listenerSet.Name).WithDetail("cname", certCname)
+	}
+
+	if err = cert.Validate(certCname, time.Now()); err != nil {
+		return err
+	}
+
+	k8sClient, err := g.getClient()
+	if err != nil {
+		return err
+	}
+	secretName := g.certificateSecretName(id, certCname)
+	tlsSecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: ns,
+			Labels: map[string]string{
+				appLabel:            id.AppName,
+				routerInstanceLabel: id.InstanceName,
+				domainLabel:         certCname,
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte(cert.Certificate),
+			corev1.TLSPrivateKeyKey: []byte(cert.Key),
+		},
+	}
+	_, err = k8sClient.CoreV1().Secrets(ns).Create(ctx, &tlsSecret, metav1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		var existingSecret *corev1.Secret
+		existingSecret, err = k8sClient.CoreV1().Secrets(ns).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		tlsSecret.ResourceVersion = existingSecret.ResourceVersion
+		_, err = k8sClient.CoreV1().Secrets(ns).Update(ctx, &tlsSecret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	if listenerSet != nil {
+		return retryOnConflict("listenerset", func(retrying bool) error {
+			if retrying {
+				listenerSet, err = client.GatewayV1().ListenerSets(ns).Get(ctx, lsName, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+			}
+			listenerSet.Labels[labelUserCertificate] = "true"
+			setListenerSetCertificate(listenerSet, secretName)
+			applied, err := applicable(listenerSet)
+			if err != nil {
+				return err
+			}
+			_, err = applyObject(ctx, client.GatewayV1().ListenerSets(ns), listenerSetGVK, applied)
+			return err
+		})
+	}
+	return g.createCertificateListenerSet(ctx, client, id, ns, certCname, secretName, httpRoute)
+}
+
+// createCertificateListenerSet creates the ListenerSet serving the certificate
+// of a CName without one and the CName HTTPRoute attached to it, built from the
+// rules of the app HTTPRoute until the app is ensured again.
+func (g *GatewayAPIService) createCertificateListenerSet(ctx context.Context, client gatewayclient.Interface, id router.InstanceID, ns, cname, secretName string, httpRoute *gatewayv1.HTTPRoute) error {
+	var gatewayRef *gatewayv1.ParentReference
+	for i, ref := range httpRoute.Spec.ParentRefs {
+		if ref.Kind == nil || *ref.Kind == "Gateway" {
+			gatewayRef = &httpRoute.Spec.ParentRefs[i]
+			break
 		}
 	}
+	if gatewayRef == nil {
+		return fmt.Errorf("no gateway found in httproute %s", httpRoute.Name)
+	}
+	gwNamespace := gatewayv1.Namespace(ns)
+	if gatewayRef.Namespace != nil {
+		gwNamespace = *gatewayRef.Namespace
+	}
 
-	annotations[certManagerCommonName] = cname
+	hostname := gatewayv1.Hostname(cname)
+	tlsMode := gatewayv1.TLSModeTerminate
+	labels := map[string]string{
+		appLabel:             id.AppName,
+		routerInstanceLabel:  id.InstanceName,
+		labelUserCertificate: "true",
+	}
+	if team := httpRoute.Labels[teamLabel]; team != "" {
+		labels[teamLabel] = team
+	}
+	listenerSet := &gatewayv1.ListenerSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      g.listenerSetName(id, cname),
+			Namespace: ns,
+			Labels:    labels,
+		},
+		Spec: gatewayv1.ListenerSetSpec{
+			ParentRef: gatewayv1.ParentGatewayReference{
+				Name:      gatewayRef.Name,
+				Namespace: &gwNamespace,
+			},
+			Listeners: []gatewayv1.ListenerEntry{{
+				Name:     listenerEntryName(cname),
+				Hostname: &hostname,
+				Port:     gatewayv1.PortNumber(443),
+				Protocol: gatewayv1.HTTPSProtocolType,
+				TLS: &gatewayv1.ListenerTLSConfig{
+					Mode:            &tlsMode,
+					CertificateRefs: []gatewayv1.SecretObjectReference{{Name: gatewayv1.ObjectName(secretName)}},
+				},
+			}},
+		},
+	}
+	_, err := applyObject(ctx, client.GatewayV1().ListenerSets(ns), listenerSetGVK, listenerSet)
+	if err != nil {
+		return err
+	}
 
-	return annotations
+	routeName := g.httpRouteCNameName(id, cname)
+	_, err = client.GatewayV1().HTTPRoutes(ns).Get(ctx, routeName, metav1.GetOptions{})
+	if err == nil || !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	cnameRoute := &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        routeName,
+			Namespace:   ns,
+			Labels:      mergeMaps(httpRoute.Labels, map[string]string{labelCNameHTTPRoute: "true"}),
+			Annotations: mergeMaps(httpRoute.Annotations),
+		},
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{
+				ParentRefs: []gatewayv1.ParentReference{g.listenerSetParentRef(id, ns, cname)},
+			},
+			Hostnames: []gatewayv1.Hostname{hostname},
+			Rules:     httpRoute.Spec.Rules,
+		},
+	}
+	delete(cnameRoute.Annotations, annotationCNames)
+	_, err = applyObject(ctx, client.GatewayV1().HTTPRoutes(ns), httpRouteGVK, cnameRoute)
+	return err
+}
+
+// setListenerSetCertificate makes the listeners of the ListenerSet serve the
+// certificate of the secret, removing the cert-manager annotations.
+func setListenerSetCertificate(listenerSet *gatewayv1.ListenerSet, secretName string) {
+	for _, annotation := range certManagerAnnotations {
+		delete(listenerSet.Annotations, annotation)
+	}
+	delete(listenerSet.Annotations, certManagerCommonName)
+	for i := range listenerSet.Spec.Listeners {
+		tlsMode := gatewayv1.TLSModeTerminate
+		listenerSet.Spec.Listeners[i].TLS = &gatewayv1.ListenerTLSConfig{
+			Mode:            &tlsMode,
+			CertificateRefs: []gatewayv1.SecretObjectReference{{Name: gatewayv1.ObjectName(secretName)}},
+		}
+	}
+}
+
+// GetCertificate returns the certificate set by the user for the CName.
+func (g *GatewayAPIService) GetCertificate(ctx context.Context, id router.InstanceID, certCname string) (*router.CertData, error) {
+	ns, err := g.getAppNamespace(ctx, id.AppName)
+	if err != nil {
+		return nil, err
+	}
+	k8sClient, err := g.getClient()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := k8sClient.CoreV1().Secrets(ns).Get(ctx, g.certificateSecretName(id, certCname), metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return nil, router.ErrCertificateNotFound
+		}
+		return nil, err
+	}
+	return &router.CertData{
+		Certificate: string(secret.Data[corev1.TLSCertKey]),
+		Key:         string(secret.Data[corev1.TLSPrivateKeyKey]),
+	}, nil
+}
+
+// RemoveCertificate removes the certificate set by the user for the CName. The
+// ListenerSet switches back to the cert-manager issuer of the CName when there
+// is one, otherwise it is removed along with the CName HTTPRoute.
+func (g *GatewayAPIService) RemoveCertificate(ctx context.Context, id router.InstanceID, certCname string) error {
+	ns, err := g.getAppNamespace(ctx, id.AppName)
+	if err != nil {
+		return err
+	}
+	client, err := g.getGatewayClient()
+	if err != nil {
+		return err
+	}
+	listenerSet, err := client.GatewayV1().ListenerSets(ns).Get(ctx, g.listenerSetName(id, certCname), metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return router.ErrCertificateNotFound
+		}
+		return err
+	}
+	if listenerSet.Labels[labelUserCertificate] != "true" {
+		if isManagedByCertManager(listenerSet.Annotations) {
+			return router.NewError(router.ErrorCodeUnsupported, "cannot remove certificate from listenerset %s, it is managed by cert-manager", listenerSet.Name).WithDetail("cname", certCname)
+		}
+		return router.ErrCertificateNotFound
+	}
+
+	if issuer := listenerSet.Labels[labelCertIssuer]; issuer != "" {
+		var issuerData CertManagerIssuerData
+		issuerData, err = g.getCertManagerIssuerData(ctx, issuer, ns)
+		if err != nil {
+			return err
+		}
+		err = retryOnConflict("listenerset", func(retrying bool) error {
+			if retrying {
+				listenerSet, err = client.GatewayV1().ListenerSets(ns).Get(ctx, g.listenerSetName(id, certCname), metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+			}
+			setListenerSetCertificate(listenerSet, g.tlsSecretName(id, certCname))
+			listenerSet.Annotations = mergeMaps(listenerSet.Annotations, g.listenerSetCertManagerAnnotations(issuerData, certCname))
+			delete(listenerSet.Labels, labelUserCertificate)
+			applied, err := applicable(listenerSet)
+			if err != nil {
+				return err
+			}
+			_, err = applyObject(ctx, client.GatewayV1().ListenerSets(ns), listenerSetGVK, applied)
+			return err
+		})
+	} else {
+		err = client.GatewayV1().ListenerSets(ns).Delete(ctx, listenerSet.Name, metav1.DeleteOptions{})
+		if err == nil {
+			err = g.removeCNameHTTPRoute(ctx, client, id, ns, certCname)
+		}
+	}
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+
+	k8sClient, err := g.getClient()
+	if err != nil {
+		return err
+	}
+	err = k8sClient.CoreV1().Secrets(ns).Delete(ctx, g.certificateSecretName(id, certCname), metav1.DeleteOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	return nil
 }
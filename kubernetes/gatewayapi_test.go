@@ -1,8 +1,10 @@
 package kubernetes
 
 import (
+	"errors"
 	"testing"
 
+	fakecertmanager "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned/fake"
 	"github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -12,7 +14,10 @@ import (
 	fakeapiextensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
 	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
 	gatewayfake "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned/fake"
 )
@@ -22,10 +27,11 @@ func newFakeGatewayAPIService() (*GatewayAPIService, *gatewayfake.Clientset) {
 	gwClient := gatewayfake.NewClientset()
 	return &GatewayAPIService{
 		BaseService: &BaseService{
-			Namespace:        "default",
-			Client:           k8sfake.NewClientset(),
-			TsuruClient:      faketsuru.NewSimpleClientset(),
-			ExtensionsClient: fakeapiextensions.NewClientset(),
+			Namespace:         "default",
+			Client:            k8sfake.NewClientset(),
+			TsuruClient:       faketsuru.NewSimpleClientset(),
+			ExtensionsClient:  fakeapiextensions.NewClientset(),
+			CertManagerClient: fakecertmanager.NewSimpleClientset(),
 		},
 		GatewayName:      "main-gw",
 		GatewayNamespace: "default",
@@ -135,18 +141,26 @@ func TestGatewayAPIServiceListenerSetName(t *testing.T) {
 }
 
 func TestGatewayAPIServiceListenerSetCertManagerAnnotations(t *testing.T) {
-	// Covers annotation generation for cluster and external issuer formats. The caller
-	// always resolves a non-empty issuer before invoking this, and every ListenerSet
+	// Covers annotation generation for each resolved issuer type, every ListenerSet
 	// gets a per-CName common-name annotation.
 	tests := []struct {
 		name     string
-		issuer   string
+		issuer   CertManagerIssuerData
 		cname    string
 		expected map[string]string
 	}{
 		{
-			name:   "uses explicit cluster issuer",
-			issuer: "my-cluster-issuer",
+			name:   "uses namespaced issuer",
+			issuer: CertManagerIssuerData{name: "my-issuer", issuerType: certManagerIssuerTypeIssuer},
+			cname:  "app.example.com",
+			expected: map[string]string{
+				certManagerIssuerKey:  "my-issuer",
+				certManagerCommonName: "app.example.com",
+			},
+		},
+		{
+			name:   "uses cluster issuer",
+			issuer: CertManagerIssuerData{name: "my-cluster-issuer", issuerType: certManagerIssuerTypeClusterIssuer},
 			cname:  "app.example.com",
 			expected: map[string]string{
 				certManagerClusterIssuerKey: "my-cluster-issuer",
@@ -154,9 +168,14 @@ func TestGatewayAPIServiceListenerSetCertManagerAnnotations(t *testing.T) {
 			},
 		},
 		{
-			name:   "uses external issuer format",
-			issuer: "foo.MyIssuer.my-group.io",
-			cname:  "app.example.com",
+			name: "uses external issuer",
+			issuer: CertManagerIssuerData{
+				name:       "foo",
+				kind:       "MyIssuer",
+				group:      "my-group.io",
+				issuerType: certManagerIssuerTypeExternalIssuer,
+			},
+			cname: "app.example.com",
 			expected: map[string]string{
 				certManagerIssuerKey:      "foo",
 				certManagerIssuerKindKey:  "MyIssuer",
@@ -164,15 +183,6 @@ func TestGatewayAPIServiceListenerSetCertManagerAnnotations(t *testing.T) {
 				certManagerCommonName:     "app.example.com",
 			},
 		},
-		{
-			name:   "falls back to cluster issuer for partial external format",
-			issuer: "foo.MyIssuer",
-			cname:  "app.example.com",
-			expected: map[string]string{
-				certManagerClusterIssuerKey: "foo.MyIssuer",
-				certManagerCommonName:       "app.example.com",
-			},
-		},
 	}
 
 	for _, tt := range tests {
@@ -220,6 +230,114 @@ func TestGatewayAPIServiceEnsureCreatesHTTPRoute(t *testing.T) {
 	assert.Equal(t, gatewayv1.PortNumber(defaultServicePort), *route.Spec.Rules[0].BackendRefs[0].Port)
 }
 
+func TestGatewayAPIServiceEnsureRetriesOnConflict(t *testing.T) {
+	// Applies of the HTTPRoute conflicting with concurrent writes are retried.
+	svc, gwClient := newFakeGatewayAPIService()
+	err := createAppWebService(svc.Client, svc.Namespace, "myapp")
+	require.NoError(t, err)
+	routeName := svc.httpRouteName(idForApp("myapp"))
+	applies := 0
+	gwClient.PrependReactor("patch", "httproutes", concurrentApply(gatewayv1.Resource("httproutes"), routeName, &applies))
+	retriesBefore := conflictRetriesValue(t, "httproute")
+
+	err = svc.Ensure(ctx, idForApp("myapp"), router.EnsureBackendOpts{
+		Opts: router.Opts{GatewayName: "main-gw", GatewayNamespace: "default"},
+		Team: "my-team",
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, applies)
+	assert.Equal(t, retriesBefore+2, conflictRetriesValue(t, "httproute"))
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, routeName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "my-team", route.Labels["tsuru.io/app-team"])
+}
+
+func TestGatewayAPIServiceEnsureServerSideApply(t *testing.T) {
+	// The HTTPRoutes are applied keeping the fields set by other managers.
+	svc, gwClient := newFakeGatewayAPIService()
+	err := createAppWebService(svc.Client, svc.Namespace, "myapp")
+	require.NoError(t, err)
+	opts := router.EnsureBackendOpts{
+		Opts:   router.Opts{HTTPOnly: true},
+		Team:   "my-team",
+		CNames: []string{"a.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	}
+	err = svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+
+	// Arrange: another controller annotates the main route.
+	routeName := svc.httpRouteName(idForApp("myapp"))
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, routeName, metav1.GetOptions{})
+	require.NoError(t, err)
+	route.Annotations["external-annotation"] = "external"
+	_, err = gwClient.GatewayV1().HTTPRoutes("default").Update(ctx, route, metav1.UpdateOptions{FieldManager: "other-controller"})
+	require.NoError(t, err)
+
+	// Act: change the team.
+	opts.Team = "other-team"
+	err = svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+
+	// Assert: the route is updated and the annotations of both managers kept.
+	route, err = gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, routeName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "other-team", route.Labels["tsuru.io/app-team"])
+	assert.Equal(t, "a.example.com", route.Annotations[annotationCNames])
+	assert.Equal(t, "external", route.Annotations["external-annotation"])
+
+	// Assert: failing to store the CNames annotation fails Ensure.
+	gwClient.PrependReactor("patch", "httproutes", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if action.(ktesting.PatchActionImpl).PatchOptions.FieldManager == cnamesFieldManager {
+			return true, nil, k8sErrors.NewForbidden(gatewayv1.Resource("httproutes"), routeName, errors.New("denied"))
+		}
+		return false, nil, nil
+	})
+	opts.CNames = []string{"a.example.com", "b.example.com"}
+	err = svc.Ensure(ctx, idForApp("myapp"), opts)
+	assert.True(t, k8sErrors.IsForbidden(err))
+}
+
+func TestGatewayAPIServiceEnsureRemovesLegacyCNamesAnnotation(t *testing.T) {
+	// The CNames tracked by previous versions of the router are removed.
+	svc, gwClient := newFakeGatewayAPIService()
+	err := createAppWebService(svc.Client, svc.Namespace, "myapp")
+	require.NoError(t, err)
+	id := idForApp("myapp")
+	routeName := svc.httpRouteName(id)
+
+	// Arrange: a route updated by a previous router version with a CName.
+	_, err = gwClient.GatewayV1().HTTPRoutes("default").Create(ctx, &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        routeName,
+			Namespace:   "default",
+			Labels:      map[string]string{appLabel: "myapp"},
+			Annotations: map[string]string{annotationCNames: "old.example.com"},
+		},
+	}, metav1.CreateOptions{FieldManager: fieldManager})
+	require.NoError(t, err)
+
+	// Act: ensure without CNames.
+	err = svc.Ensure(ctx, id, router.EnsureBackendOpts{
+		Opts: router.Opts{HTTPOnly: true},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+
+	// Assert: the annotation was removed.
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, routeName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, route.Annotations, annotationCNames)
+}
+
 func TestGatewayAPIServiceEnsureSkipsFrozenHTTPRoute(t *testing.T) {
 	// Verifies frozen routes are not modified by Ensure.
 	svc, gwClient := newFakeGatewayAPIService()
@@ -260,14 +378,14 @@ func TestGatewayAPIServiceEnsureSkipsFrozenHTTPRoute(t *testing.T) {
 	assert.Equal(t, gatewayv1.Hostname("old.myapp.local"), route.Spec.Hostnames[0])
 }
 
-func TestGatewayAPIServiceUpsertHTTPRoutePreservesAnnotations(t *testing.T) {
-	// Confirms update path keeps existing annotations when upserting a route.
+func TestGatewayAPIServiceApplyHTTPRoutePreservesAnnotations(t *testing.T) {
+	// Confirms applying a route keeps the annotations set by other managers.
 	svc, gwClient := newFakeGatewayAPIService()
 	span := opentracing.NoopTracer{}.StartSpan("test")
 	defer span.Finish()
 
 	// Arrange: create an existing route with metadata that must be preserved.
-	existing, err := gwClient.GatewayV1().HTTPRoutes("default").Create(ctx, &gatewayv1.HTTPRoute{
+	_, err := gwClient.GatewayV1().HTTPRoutes("default").Create(ctx, &gatewayv1.HTTPRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        "route1",
 			Namespace:   "default",
@@ -276,13 +394,13 @@ func TestGatewayAPIServiceUpsertHTTPRoutePreservesAnnotations(t *testing.T) {
 	}, metav1.CreateOptions{})
 	require.NoError(t, err)
 
-	// Act: upsert with a route object that does not contain annotations.
-	err = svc.upsertHTTPRoute(ctx, span, gwClient, "default", &gatewayv1.HTTPRoute{
+	// Act: apply a route object that does not contain annotations.
+	err = svc.applyHTTPRoute(ctx, span, gwClient, "default", &gatewayv1.HTTPRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "route1",
 			Namespace: "default",
 		},
-	}, existing)
+	})
 	require.NoError(t, err)
 
 	// Assert: previous annotations are still present.
@@ -324,7 +442,7 @@ func TestGatewayAPIServiceEnsureCNamesHTTPOnly(t *testing.T) {
 		Opts:   router.Opts{HTTPOnly: true},
 		CNames: []string{"new.example.com"},
 		Team:   "my-team",
-	}, "default", router.BackendTarget{Service: "myapp-web", Namespace: "default"})
+	}, "default", svc.getExistingCNames(ctx, gwClient, id, "default"), router.BackendTarget{Service: "myapp-web", Namespace: "default"}, httpRouteContext{})
 	require.NoError(t, err)
 
 	// Assert: new route exists and points to the Gateway.
@@ -345,6 +463,10 @@ func TestGatewayAPIServiceEnsureCNamesPerCNameListenerSets(t *testing.T) {
 	svc.AcmeIssuer = "letsencrypt"
 	err := createAppWebService(svc.Client, svc.Namespace, "myapp")
 	require.NoError(t, err)
+	err = createCertManagerIssuer(svc.CertManagerClient, "default", "custom-issuer")
+	require.NoError(t, err)
+	err = createCertManagerClusterIssuer(svc.CertManagerClient, "letsencrypt")
+	require.NoError(t, err)
 
 	id := idForApp("myapp")
 	span := opentracing.NoopTracer{}.StartSpan("test")
@@ -357,7 +479,7 @@ func TestGatewayAPIServiceEnsureCNamesPerCNameListenerSets(t *testing.T) {
 			"a.example.com": "custom-issuer",
 		},
 		Team: "my-team",
-	}, "default", router.BackendTarget{Service: "myapp-web", Namespace: "default"})
+	}, "default", nil, router.BackendTarget{Service: "myapp-web", Namespace: "default"}, httpRouteContext{})
 	require.NoError(t, err)
 
 	// Assert: a dedicated ListenerSet exists per CName, each with a single listener and
@@ -366,7 +488,8 @@ func TestGatewayAPIServiceEnsureCNamesPerCNameListenerSets(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, lsA.Spec.Listeners, 1)
 	assert.Equal(t, "a.example.com", lsA.Annotations[certManagerCommonName])
-	assert.Equal(t, "custom-issuer", lsA.Annotations[certManagerClusterIssuerKey])
+	assert.Equal(t, "custom-issuer", lsA.Annotations[certManagerIssuerKey])
+	assert.NotContains(t, lsA.Annotations, certManagerClusterIssuerKey)
 
 	lsB, err := gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(id, "b.example.com"), metav1.GetOptions{})
 	require.NoError(t, err)
@@ -381,34 +504,214 @@ func TestGatewayAPIServiceEnsureCNamesPerCNameListenerSets(t *testing.T) {
 	assert.Equal(t, gatewayv1.ObjectName(svc.listenerSetName(id, "a.example.com")), aRoute.Spec.ParentRefs[0].Name)
 }
 
+func TestGatewayAPIServiceEnsureCNamesInvalidIssuer(t *testing.T) {
+	// Unknown or malformed issuers fail the ensure instead of creating a ListenerSet
+	// that never gets a certificate.
+	tests := []struct {
+		name        string
+		issuer      string
+		expectedErr string
+	}{
+		{
+			name:        "issuer not found",
+			issuer:      "typo-issuer",
+			expectedErr: `could not ensure CName "a.example.com": issuer typo-issuer not found`,
+		},
+		{
+			name:        "partial external format",
+			issuer:      "foo.MyIssuer",
+			expectedErr: `could not ensure CName "a.example.com": invalid external issuer: foo.MyIssuer (requires <resource name>.<resource kind>.<resource group>)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, gwClient := newFakeGatewayAPIService()
+			id := idForApp("myapp")
+			span := opentracing.NoopTracer{}.StartSpan("test")
+			defer span.Finish()
+
+			err := svc.ensureCNames(ctx, span, gwClient, id, router.EnsureBackendOpts{
+				CNames:      []string{"a.example.com"},
+				CertIssuers: map[string]string{"a.example.com": tt.issuer},
+			}, "default", nil, router.BackendTarget{Service: "myapp-web", Namespace: "default"}, httpRouteContext{})
+			require.EqualError(t, err, tt.expectedErr)
+
+			_, err = gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(id, "a.example.com"), metav1.GetOptions{})
+			assert.True(t, k8sErrors.IsNotFound(err))
+		})
+	}
+}
+
+// createGateway serves the Gateway by a reactor, the field managed tracker of
+// the fake clientset does not handle Gateways.
+func createGateway(gwClient *gatewayfake.Clientset, ns, name string, listeners ...gatewayv1.Listener) {
+	gateway := &gatewayv1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+		Spec:       gatewayv1.GatewaySpec{Listeners: listeners},
+	}
+	gwClient.PrependReactor("get", "gateways", func(action ktesting.Action) (bool, runtime.Object, error) {
+		get := action.(ktesting.GetAction)
+		if get.GetNamespace() != ns || get.GetName() != name {
+			return false, nil, nil
+		}
+		return true, gateway.DeepCopy(), nil
+	})
+}
+
 func TestGatewayAPIServiceGetAddresses(t *testing.T) {
-	// GetAddresses should map the route label to protocol and return hostnames as URLs.
+	// GetAddresses reports https only for the hosts covered by a HTTPS listener of the route parents.
+	wildcard := gatewayv1.Hostname("*.local")
+	other := gatewayv1.Hostname("other.local")
+	tests := []struct {
+		name      string
+		httpOnly  string
+		listeners []gatewayv1.Listener
+		expected  []string
+	}{
+		{
+			name:      "wildcard https listener",
+			httpOnly:  "false",
+			listeners: []gatewayv1.Listener{{Name: "https", Protocol: gatewayv1.HTTPSProtocolType, Hostname: &wildcard}},
+			expected:  []string{"https://myapp.local"},
+		},
+		{
+			name:      "https listener without hostname",
+			httpOnly:  "false",
+			listeners: []gatewayv1.Listener{{Name: "https", Protocol: gatewayv1.HTTPSProtocolType}},
+			expected:  []string{"https://myapp.local"},
+		},
+		{
+			name:     "https listener of another host",
+			httpOnly: "false",
+			listeners: []gatewayv1.Listener{
+				{Name: "http", Protocol: gatewayv1.HTTPProtocolType},
+				{Name: "https", Protocol: gatewayv1.HTTPSProtocolType, Hostname: &other},
+			},
+			expected: []string{"http://myapp.local"},
+		},
+		{
+			name:      "http only",
+			httpOnly:  "true",
+			listeners: []gatewayv1.Listener{{Name: "https", Protocol: gatewayv1.HTTPSProtocolType}},
+			expected:  []string{"http://myapp.local"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, gwClient := newFakeGatewayAPIService()
+			id := idForApp("myapp")
+			createGateway(gwClient, "default", "main-gw", tt.listeners...)
+
+			// Arrange: create a managed HTTPRoute with protocol label, hostname and the Gateway as parent.
+			_, err := gwClient.GatewayV1().HTTPRoutes("default").Create(ctx, &gatewayv1.HTTPRoute{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      svc.httpRouteName(id),
+					Namespace: "default",
+					Labels: map[string]string{
+						appLabel:               "myapp",
+						routerInstanceLabel:    "",
+						labelHTTPRouteHTTPOnly: tt.httpOnly,
+					},
+				},
+				Spec: gatewayv1.HTTPRouteSpec{
+					CommonRouteSpec: gatewayv1.CommonRouteSpec{
+						ParentRefs: []gatewayv1.ParentReference{{Name: "main-gw"}},
+					},
+					Hostnames: []gatewayv1.Hostname{"myapp.local"},
+				},
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			// Act
+			addresses, err := svc.GetAddresses(ctx, id)
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, addresses)
+		})
+	}
+}
+
+func TestGatewayAPIServiceEnsureHostsTLS(t *testing.T) {
+	// tls-acme creates a ListenerSet with a certificate for the app hostname of each prefix.
 	svc, gwClient := newFakeGatewayAPIService()
+	svc.AcmeIssuer = "letsencrypt"
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createCertManagerClusterIssuer(svc.CertManagerClient, "letsencrypt"))
+	createGateway(gwClient, "default", "main-gw", gatewayv1.Listener{Name: "http", Protocol: gatewayv1.HTTPProtocolType})
 	id := idForApp("myapp")
-	routeName := svc.httpRouteName(id)
 
-	// Arrange: create a managed HTTPRoute with protocol label and hostname.
-	_, err := gwClient.GatewayV1().HTTPRoutes("default").Create(ctx, &gatewayv1.HTTPRoute{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      routeName,
-			Namespace: "default",
-			Labels: map[string]string{
-				appLabel:               "myapp",
-				routerInstanceLabel:    "",
-				labelHTTPRouteHTTPOnly: "false",
-			},
-		},
-		Spec: gatewayv1.HTTPRouteSpec{
-			Hostnames: []gatewayv1.Hostname{"myapp.local"},
+	opts := router.EnsureBackendOpts{
+		Opts: router.Opts{Acme: true, ExposeAllServices: true},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+			{Prefix: "v2", Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
 		},
-	}, metav1.CreateOptions{})
+	}
+	err := svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+
+	for prefix, host := range map[string]string{"default": "myapp.local", "v2": "v2.myapp.local"} {
+		ls, err := gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(id, host), metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "letsencrypt", ls.Annotations[certManagerClusterIssuerKey])
+		assert.Equal(t, host, ls.Annotations[certManagerCommonName])
+		require.Len(t, ls.Spec.Listeners, 1)
+		assert.Equal(t, gatewayv1.Hostname(host), *ls.Spec.Listeners[0].Hostname)
+
+		route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteNameForPrefix(id, prefix), metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Len(t, route.Spec.ParentRefs, 2)
+		assert.Equal(t, gatewayv1.ObjectName("main-gw"), route.Spec.ParentRefs[0].Name)
+		assert.Equal(t, svc.listenerSetParentRef(id, "default", host), route.Spec.ParentRefs[1])
+	}
+
+	addrs, err := svc.GetAddresses(ctx, id)
 	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"https://myapp.local", "https://v2.myapp.local"}, addrs)
 
-	// Act
-	addresses, err := svc.GetAddresses(ctx, id)
-	// Assert
+	// Act: disabling tls-acme removes the ListenerSets and the hosts are no longer reported as https.
+	opts.Opts.Acme = false
+	err = svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+
+	listenerSets, err := gwClient.GatewayV1().ListenerSets("default").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, listenerSets.Items)
+
+	addrs, err = svc.GetAddresses(ctx, id)
 	require.NoError(t, err)
-	assert.Equal(t, []string{"https://myapp.local"}, addresses)
+	assert.ElementsMatch(t, []string{"http://myapp.local", "http://v2.myapp.local"}, addrs)
+}
+
+func TestGatewayAPIServiceEnsureHostsTLSIssuer(t *testing.T) {
+	svc, gwClient := newFakeGatewayAPIService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createCertManagerIssuer(svc.CertManagerClient, "default", "my-issuer"))
+	id := idForApp("myapp")
+	prefixes := []router.BackendPrefix{
+		{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+	}
+
+	// without an acme issuer, tls-acme relies on the certificates of the Gateway
+	err := svc.Ensure(ctx, id, router.EnsureBackendOpts{Opts: router.Opts{Acme: true}, Prefixes: prefixes})
+	require.NoError(t, err)
+	listenerSets, err := gwClient.GatewayV1().ListenerSets("default").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, listenerSets.Items)
+	addrs, err := svc.GetAddresses(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://myapp.local"}, addrs)
+
+	err = svc.Ensure(ctx, id, router.EnsureBackendOpts{Opts: router.Opts{TLSIssuer: "unknown"}, Prefixes: prefixes})
+	assert.EqualError(t, err, `could not ensure TLS of "myapp.local": issuer unknown not found`)
+
+	err = svc.Ensure(ctx, id, router.EnsureBackendOpts{Opts: router.Opts{TLSIssuer: "my-issuer"}, Prefixes: prefixes})
+	require.NoError(t, err)
+	ls, err := gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(id, "myapp.local"), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "my-issuer", ls.Annotations[certManagerIssuerKey])
 }
 
 func TestGatewayAPIServiceGetStatusReady(t *testing.T) {
@@ -479,14 +782,14 @@ func TestGatewayAPIServiceBuildHTTPRouteRuleWithPort(t *testing.T) {
 	assert.Equal(t, gatewayv1.ObjectName("mysvc"), rule.BackendRefs[0].BackendRef.Name)
 }
 
-func TestGatewayAPIServiceUpsertHTTPRouteWhenCreate(t *testing.T) {
-	// When existingHTTPRoute is nil, upsertHTTPRoute should create a new route.
+func TestGatewayAPIServiceApplyHTTPRouteWhenCreate(t *testing.T) {
+	// When the route does not exist, applyHTTPRoute should create it.
 	svc, gwClient := newFakeGatewayAPIService()
 	span := opentracing.NoopTracer{}.StartSpan("test")
 	defer span.Finish()
 
-	// Act: upsert with no existing route (create path).
-	err := svc.upsertHTTPRoute(ctx, span, gwClient, "default", &gatewayv1.HTTPRoute{
+	// Act: apply with no existing route (create path).
+	err := svc.applyHTTPRoute(ctx, span, gwClient, "default", &gatewayv1.HTTPRoute{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "new-route",
 			Namespace: "default",
@@ -494,7 +797,7 @@ func TestGatewayAPIServiceUpsertHTTPRouteWhenCreate(t *testing.T) {
 		Spec: gatewayv1.HTTPRouteSpec{
 			Hostnames: []gatewayv1.Hostname{"app.local"},
 		},
-	}, nil)
+	})
 	require.NoError(t, err)
 
 	// Assert: the route was actually created in the fake client.
@@ -593,6 +896,8 @@ func TestGatewayAPIServiceRemove(t *testing.T) {
 	svc.AcmeIssuer = "letsencrypt"
 	err := createAppWebService(svc.Client, svc.Namespace, "myapp")
 	require.NoError(t, err)
+	err = createCertManagerClusterIssuer(svc.CertManagerClient, "letsencrypt")
+	require.NoError(t, err)
 
 	id := idForApp("myapp")
 
@@ -894,7 +1199,7 @@ func TestGatewayAPIServiceEnsureCNameFrozenSkipped(t *testing.T) {
 		Opts:   router.Opts{HTTPOnly: true},
 		CNames: []string{cname},
 		Team:   "my-team",
-	}, "default", router.BackendTarget{Service: "myapp-web", Namespace: "default"})
+	}, "default", nil, router.BackendTarget{Service: "myapp-web", Namespace: "default"}, httpRouteContext{})
 	require.NoError(t, err)
 
 	// Assert: hostname was not overwritten.
@@ -914,16 +1219,19 @@ func TestGatewayAPIServiceUpdateCNamesAnnotationRemovesWhenEmpty(t *testing.T) {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      routeName,
 			Namespace: "default",
-			Annotations: map[string]string{
-				annotationCNames: "old.example.com",
-			},
 		},
 	}, metav1.CreateOptions{})
 	require.NoError(t, err)
+	err = svc.updateCNamesAnnotation(ctx, gwClient, id, "default", []string{"old.example.com"})
+	require.NoError(t, err)
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, routeName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "old.example.com", route.Annotations[annotationCNames])
 
-	svc.updateCNamesAnnotation(ctx, gwClient, id, "default", nil)
+	err = svc.updateCNamesAnnotation(ctx, gwClient, id, "default", nil)
+	require.NoError(t, err)
 
-	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, routeName, metav1.GetOptions{})
+	route, err = gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, routeName, metav1.GetOptions{})
 	require.NoError(t, err)
 	_, found := route.Annotations[annotationCNames]
 	assert.False(t, found)
@@ -935,6 +1243,8 @@ func TestGatewayAPIServiceEnsureListenerSetUpdatesExisting(t *testing.T) {
 	id := idForApp("myapp")
 	span := opentracing.NoopTracer{}.StartSpan("test")
 	defer span.Finish()
+	require.NoError(t, createCertManagerClusterIssuer(svc.CertManagerClient, "custom-issuer"))
+	require.NoError(t, createCertManagerClusterIssuer(svc.CertManagerClient, "other-issuer"))
 
 	err := svc.ensureListenerSet(ctx, span, gwClient, id, router.EnsureBackendOpts{}, "ns-default", "custom-issuer", "a.example.com")
 	require.NoError(t, err)
@@ -972,6 +1282,8 @@ func TestGatewayAPIServiceEnsureListenerSetPropagatesLabels(t *testing.T) {
 		},
 	}
 
+	require.NoError(t, createCertManagerClusterIssuer(svc.CertManagerClient, "custom-issuer"))
+
 	err := svc.ensureListenerSet(ctx, span, gwClient, id, o, "ns-default", "custom-issuer", "a.example.com")
 	require.NoError(t, err)
 
@@ -1216,11 +1528,23 @@ func TestGatewayAPIServiceSupportedOptions(t *testing.T) {
 
 	options := svc.SupportedOptions(ctx)
 	expectedOptions := map[string]string{
-		router.Domain:      "Domain used on router.",
-		router.Route:       "Path used on router rule.",
-		router.AllPrefixes: "",
-		"my-opt":           "example.com/my-opt",
-		"my-opt2":          "User friendly option description.",
+		router.Domain:          "Domain used on router.",
+		router.Route:           "Path used on router rule.",
+		router.Acme:            "If set to true, the app hostnames get a certificate issued by the acme issuer of the router. Defaults to false.",
+		router.TLSIssuer:       "",
+		router.AllPrefixes:     "",
+		router.RouteRules:      "",
+		router.RequestHeaders:  "",
+		router.ResponseHeaders: "",
+		router.HTTPSRedirect:   "",
+		router.URLRewrite:      "",
+		router.Timeout:         "",
+		router.Retries:         "",
+		router.RetryOn:         "",
+		router.MirrorTo:        "",
+		router.MirrorPercent:   "",
+		"my-opt":               "example.com/my-opt",
+		"my-opt2":              "User friendly option description.",
 	}
 	assert.Equal(t, expectedOptions, options)
 }
@@ -1231,6 +1555,8 @@ func TestGatewayAPIServiceRemoveDeletesMultipleRoutes(t *testing.T) {
 	svc.AcmeIssuer = "letsencrypt"
 	err := createAppWebService(svc.Client, svc.Namespace, "myapp")
 	require.NoError(t, err)
+	err = createCertManagerClusterIssuer(svc.CertManagerClient, "letsencrypt")
+	require.NoError(t, err)
 
 	id := idForApp("myapp")
 
@@ -1347,3 +1673,863 @@ func TestGatewayAPIServiceTlsSecretName(t *testing.T) {
 		})
 	}
 }
+
+func ensureGatewayAPISwapApps(t *testing.T, svc *GatewayAPIService) {
+	for _, app := range []string{"app1", "app2"} {
+		err := createAppWebService(svc.Client, svc.Namespace, app)
+		require.NoError(t, err)
+		err = svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			Opts:   router.Opts{HTTPOnly: true},
+			CNames: []string{app + ".example.com"},
+			Team:   app + "-team",
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: app + "-web", Namespace: "default"}},
+			},
+		})
+		require.NoError(t, err)
+	}
+}
+
+func httpRouteBackendName(t *testing.T, gwClient *gatewayfake.Clientset, name string) gatewayv1.ObjectName {
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, route.Spec.Rules, 1)
+	require.Len(t, route.Spec.Rules[0].BackendRefs, 1)
+	return route.Spec.Rules[0].BackendRefs[0].Name
+}
+
+func TestGatewayAPIServiceSwap(t *testing.T) {
+	// Swapping exchanges the backendRefs of main and CName routes and survives Ensure
+	svc, gwClient := newFakeGatewayAPIService()
+	ensureGatewayAPISwapApps(t, svc)
+	app1, app2 := idForApp("app1"), idForApp("app2")
+
+	err := svc.Swap(ctx, app1, app2, false)
+	require.NoError(t, err)
+	assert.Equal(t, gatewayv1.ObjectName("app2-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app1)))
+	assert.Equal(t, gatewayv1.ObjectName("app1-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app2)))
+	assert.Equal(t, gatewayv1.ObjectName("app2-web"), httpRouteBackendName(t, gwClient, svc.httpRouteCNameName(app1, "app1.example.com")))
+	assert.Equal(t, gatewayv1.ObjectName("app1-web"), httpRouteBackendName(t, gwClient, svc.httpRouteCNameName(app2, "app2.example.com")))
+
+	err = svc.Ensure(ctx, app1, router.EnsureBackendOpts{
+		Opts:   router.Opts{HTTPOnly: true},
+		CNames: []string{"app1.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "app1-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, gatewayv1.ObjectName("app2-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app1)))
+	assert.Equal(t, gatewayv1.ObjectName("app2-web"), httpRouteBackendName(t, gwClient, svc.httpRouteCNameName(app1, "app1.example.com")))
+
+	// swapping back restores the original backendRefs
+	err = svc.Swap(ctx, app1, app2, false)
+	require.NoError(t, err)
+	assert.Equal(t, gatewayv1.ObjectName("app1-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app1)))
+	assert.Equal(t, gatewayv1.ObjectName("app1-web"), httpRouteBackendName(t, gwClient, svc.httpRouteCNameName(app1, "app1.example.com")))
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteName(app1), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, route.Labels, swappedWithLabel)
+	assert.Equal(t, "app1-web", route.Labels[appBaseServiceNameLabel])
+}
+
+func ensureGatewayAPISwapPrefixApps(t *testing.T, svc *GatewayAPIService, prefixes map[string][]string) {
+	for _, app := range []string{"app1", "app2"} {
+		err := createAppWebService(svc.Client, svc.Namespace, app)
+		require.NoError(t, err)
+		backendPrefixes := []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: app + "-web", Namespace: "default"}},
+		}
+		for _, prefix := range prefixes[app] {
+			_, err = svc.Client.CoreV1().Services(svc.Namespace).Create(ctx, &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: app + "-" + prefix},
+				Spec: corev1.ServiceSpec{
+					Selector: map[string]string{"tsuru.io/app-name": app, "tsuru.io/app-process": prefix},
+					Ports:    []corev1.ServicePort{{Protocol: "TCP", Port: defaultServicePort}},
+				},
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+			backendPrefixes = append(backendPrefixes, router.BackendPrefix{
+				Prefix: prefix + ".process",
+				Target: router.BackendTarget{Service: app + "-" + prefix, Namespace: "default"},
+			})
+		}
+		err = svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			Opts:     router.Opts{HTTPOnly: true, ExposeAllServices: true},
+			Team:     app + "-team",
+			Prefixes: backendPrefixes,
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestGatewayAPIServiceSwapPrefixes(t *testing.T) {
+	// Swapping exchanges the backendRefs of the prefix routes too
+	svc, gwClient := newFakeGatewayAPIService()
+	ensureGatewayAPISwapPrefixApps(t, svc, map[string][]string{"app1": {"worker"}, "app2": {"worker"}})
+	app1, app2 := idForApp("app1"), idForApp("app2")
+
+	err := svc.Swap(ctx, app1, app2, false)
+	require.NoError(t, err)
+	assert.Equal(t, gatewayv1.ObjectName("app2-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app1)))
+	assert.Equal(t, gatewayv1.ObjectName("app2-worker"), httpRouteBackendName(t, gwClient, svc.httpRouteNameForPrefix(app1, "worker.process")))
+	assert.Equal(t, gatewayv1.ObjectName("app1-worker"), httpRouteBackendName(t, gwClient, svc.httpRouteNameForPrefix(app2, "worker.process")))
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteNameForPrefix(app1, "worker.process"), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2", route.Labels[swappedWithLabel])
+
+	// the prefix routes swapped before a failure are restored
+	gwClient.PrependReactor("patch", "httproutes", failApply(svc.httpRouteNameForPrefix(app2, "worker.process")))
+	err = svc.Swap(ctx, app1, app2, false)
+	require.ErrorContains(t, err, "apply failed")
+	assert.Equal(t, gatewayv1.ObjectName("app2-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app1)))
+	assert.Equal(t, gatewayv1.ObjectName("app2-worker"), httpRouteBackendName(t, gwClient, svc.httpRouteNameForPrefix(app1, "worker.process")))
+	assert.Equal(t, gatewayv1.ObjectName("app1-worker"), httpRouteBackendName(t, gwClient, svc.httpRouteNameForPrefix(app2, "worker.process")))
+}
+
+func TestGatewayAPIServiceSwapDifferentPrefixes(t *testing.T) {
+	// Apps with different prefixes cannot be swapped
+	svc, gwClient := newFakeGatewayAPIService()
+	ensureGatewayAPISwapPrefixApps(t, svc, map[string][]string{"app1": {"worker"}})
+	app1, app2 := idForApp("app1"), idForApp("app2")
+
+	err := svc.Swap(ctx, app1, app2, false)
+	require.Error(t, err)
+	assert.Equal(t, router.ErrorCodeUnsupported, router.CodeOf(err))
+	assert.Equal(t, gatewayv1.ObjectName("app1-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app1)))
+	assert.Equal(t, gatewayv1.ObjectName("app2-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app2)))
+}
+
+func TestGatewayAPIServiceSwapCNameOnly(t *testing.T) {
+	// CName-only swaps move the CName routes to the other app
+	svc, gwClient := newFakeGatewayAPIService()
+	ensureGatewayAPISwapApps(t, svc)
+	app1, app2 := idForApp("app1"), idForApp("app2")
+
+	err := svc.Swap(ctx, app1, app2, true)
+	require.NoError(t, err)
+	assert.Equal(t, gatewayv1.ObjectName("app1-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app1)))
+	assert.Equal(t, gatewayv1.ObjectName("app2-web"), httpRouteBackendName(t, gwClient, svc.httpRouteCNameName(app2, "app1.example.com")))
+	assert.Equal(t, gatewayv1.ObjectName("app1-web"), httpRouteBackendName(t, gwClient, svc.httpRouteCNameName(app1, "app2.example.com")))
+
+	_, err = gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteCNameName(app1, "app1.example.com"), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err), "moved CName route should have been removed")
+
+	moved, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteCNameName(app2, "app1.example.com"), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2", moved.Labels[appLabel])
+	assert.Equal(t, "app2-team", moved.Labels[teamLabel])
+
+	mainRoute, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteName(app1), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2.example.com", mainRoute.Annotations[annotationCNames])
+}
+
+func TestGatewayAPIServiceSwapCNameOnlyMovesListenerSets(t *testing.T) {
+	// CName-only swaps move the ListenerSets of the TLS CNames along with their routes
+	svc, gwClient := newFakeGatewayAPIService()
+	svc.AcmeIssuer = "letsencrypt"
+	require.NoError(t, createCertManagerClusterIssuer(svc.CertManagerClient, "letsencrypt"))
+	for _, app := range []string{"app1", "app2"} {
+		require.NoError(t, createAppWebService(svc.Client, svc.Namespace, app))
+		err := svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			CNames: []string{app + ".example.com"},
+			Team:   app + "-team",
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: app + "-web", Namespace: "default"}},
+			},
+		})
+		require.NoError(t, err)
+	}
+	app1, app2 := idForApp("app1"), idForApp("app2")
+	original, err := gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(app1, "app1.example.com"), metav1.GetOptions{})
+	require.NoError(t, err)
+
+	err = svc.Swap(ctx, app1, app2, true)
+	require.NoError(t, err)
+
+	_, err = gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(app1, "app1.example.com"), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err), "moved ListenerSet should have been removed")
+	moved, err := gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(app2, "app1.example.com"), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2", moved.Labels[appLabel])
+	assert.Equal(t, "app2-team", moved.Labels[teamLabel])
+	assert.Equal(t, original.Spec, moved.Spec)
+
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteCNameName(app2, "app1.example.com"), metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, route.Spec.ParentRefs, 1)
+	assert.Equal(t, gatewayv1.ObjectName(svc.listenerSetName(app2, "app1.example.com")), route.Spec.ParentRefs[0].Name)
+}
+
+func TestGatewayAPIServiceSwapRollback(t *testing.T) {
+	// A failed swap restores the HTTPRoutes already swapped
+	svc, gwClient := newFakeGatewayAPIService()
+	ensureGatewayAPISwapApps(t, svc)
+	app1, app2 := idForApp("app1"), idForApp("app2")
+	gwClient.PrependReactor("patch", "httproutes", failApply(svc.httpRouteCNameName(app2, "app2.example.com")))
+
+	err := svc.Swap(ctx, app1, app2, false)
+	require.EqualError(t, err, "apply failed")
+	assert.Equal(t, gatewayv1.ObjectName("app1-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app1)))
+	assert.Equal(t, gatewayv1.ObjectName("app2-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(app2)))
+	assert.Equal(t, gatewayv1.ObjectName("app1-web"), httpRouteBackendName(t, gwClient, svc.httpRouteCNameName(app1, "app1.example.com")))
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteName(app1), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, route.Labels, swappedWithLabel)
+	assert.Equal(t, "app1.example.com", route.Annotations[annotationCNames])
+}
+
+func TestGatewayAPIServiceSwapCNameOnlyRollback(t *testing.T) {
+	// A failed CName-only swap moves back the CName routes already moved
+	svc, gwClient := newFakeGatewayAPIService()
+	ensureGatewayAPISwapApps(t, svc)
+	app1, app2 := idForApp("app1"), idForApp("app2")
+	gwClient.PrependReactor("patch", "httproutes", failApply(svc.httpRouteCNameName(app1, "app2.example.com")))
+
+	err := svc.Swap(ctx, app1, app2, true)
+	require.EqualError(t, err, "apply failed")
+	assert.Equal(t, gatewayv1.ObjectName("app1-web"), httpRouteBackendName(t, gwClient, svc.httpRouteCNameName(app1, "app1.example.com")))
+	_, err = gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteCNameName(app2, "app1.example.com"), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err), "moved CName route should have been removed")
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteName(app1), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app1.example.com", route.Annotations[annotationCNames])
+}
+
+func TestGatewayAPIServiceEnsureWeightedTargets(t *testing.T) {
+	// Weighted targets become weighted backendRefs on the main and CName routes
+	svc, gwClient := newFakeGatewayAPIService()
+	id := idForApp("myapp")
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp-canary"))
+
+	opts := router.EnsureBackendOpts{
+		Opts:   router.Opts{HTTPOnly: true},
+		CNames: []string{"myapp.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{
+				Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"},
+				WeightedTargets: []router.WeightedBackendTarget{
+					{BackendTarget: router.BackendTarget{Service: "myapp-canary-web", Namespace: "default"}, Weight: 20},
+				},
+			},
+		},
+	}
+	err := svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+
+	for _, name := range []string{svc.httpRouteName(id), svc.httpRouteCNameName(id, "myapp.example.com")} {
+		route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Len(t, route.Spec.Rules, 1)
+		backendRefs := route.Spec.Rules[0].BackendRefs
+		require.Len(t, backendRefs, 2)
+		assert.Equal(t, gatewayv1.ObjectName("myapp-web"), backendRefs[0].Name)
+		require.NotNil(t, backendRefs[0].Weight)
+		assert.Equal(t, int32(80), *backendRefs[0].Weight)
+		assert.Equal(t, gatewayv1.ObjectName("myapp-canary-web"), backendRefs[1].Name)
+		require.NotNil(t, backendRefs[1].Weight)
+		assert.Equal(t, int32(20), *backendRefs[1].Weight)
+	}
+
+	// removing the weighted targets restores a single backendRef
+	opts.Prefixes[0].WeightedTargets = nil
+	err = svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+	assert.Equal(t, gatewayv1.ObjectName("myapp-web"), httpRouteBackendName(t, gwClient, svc.httpRouteName(id)))
+	assert.Equal(t, gatewayv1.ObjectName("myapp-web"), httpRouteBackendName(t, gwClient, svc.httpRouteCNameName(id, "myapp.example.com")))
+}
+
+func TestGatewayAPIServiceEnsureRouteRules(t *testing.T) {
+	// Route rules become extra HTTPRoute rules on the default and CName routes
+	svc, gwClient := newFakeGatewayAPIService()
+	id := idForApp("myapp")
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp-beta"))
+
+	err := svc.Ensure(ctx, id, router.EnsureBackendOpts{
+		Opts: router.Opts{
+			HTTPOnly: true,
+			RouteRules: []router.RouteRule{
+				{Prefix: "beta", Cookies: []router.RouteRuleMatch{{Name: "beta", Value: "true"}}},
+				{Prefix: "beta", Path: "/api", Method: "post", Headers: []router.RouteRuleMatch{{Name: "X-Api-Version", Value: "v2"}}},
+				{QueryParams: []router.RouteRuleMatch{{Name: "version", Value: "v[0-9]+", Type: "RegularExpression"}}},
+			},
+		},
+		CNames: []string{"myapp.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+			{Prefix: "beta", Target: router.BackendTarget{Service: "myapp-beta-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+
+	exact := gatewayv1.HeaderMatchExact
+	regex := gatewayv1.HeaderMatchRegularExpression
+	queryRegex := gatewayv1.QueryParamMatchRegularExpression
+	post := gatewayv1.HTTPMethodPost
+	for _, name := range []string{svc.httpRouteName(id), svc.httpRouteCNameName(id, "myapp.example.com")} {
+		route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Len(t, route.Spec.Rules, 4)
+		assert.Equal(t, gatewayv1.ObjectName("myapp-web"), route.Spec.Rules[0].BackendRefs[0].Name)
+
+		rule := route.Spec.Rules[1]
+		assert.Equal(t, gatewayv1.ObjectName("myapp-beta-web"), rule.BackendRefs[0].Name)
+		assert.Equal(t, "/", *rule.Matches[0].Path.Value)
+		assert.Equal(t, []gatewayv1.HTTPHeaderMatch{
+			{Type: &regex, Name: "Cookie", Value: `(^|;\s*)beta=true(;|$)`},
+		}, rule.Matches[0].Headers)
+
+		rule = route.Spec.Rules[2]
+		assert.Equal(t, gatewayv1.ObjectName("myapp-beta-web"), rule.BackendRefs[0].Name)
+		assert.Equal(t, "/api", *rule.Matches[0].Path.Value)
+		assert.Equal(t, &post, rule.Matches[0].Method)
+		assert.Equal(t, []gatewayv1.HTTPHeaderMatch{
+			{Type: &exact, Name: "X-Api-Version", Value: "v2"},
+		}, rule.Matches[0].Headers)
+
+		rule = route.Spec.Rules[3]
+		assert.Equal(t, gatewayv1.ObjectName("myapp-web"), rule.BackendRefs[0].Name)
+		assert.Equal(t, []gatewayv1.HTTPQueryParamMatch{
+			{Type: &queryRegex, Name: "version", Value: "v[0-9]+"},
+		}, rule.Matches[0].QueryParams)
+	}
+
+	// the beta prefix is only reachable through the route rules
+	_, err = gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteNameForPrefix(id, "beta"), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+}
+
+func TestGatewayAPIServiceBuildRouteRulesErrors(t *testing.T) {
+	svc, _ := newFakeGatewayAPIService()
+	prefixes := []router.BackendPrefix{
+		{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+	}
+	header := []router.RouteRuleMatch{{Name: "X-Beta", Value: "1"}}
+
+	tests := []struct {
+		name     string
+		rule     router.RouteRule
+		expected string
+	}{
+		{
+			name:     "unknown prefix",
+			rule:     router.RouteRule{Prefix: "beta", Headers: header},
+			expected: `prefix "beta" not found`,
+		},
+		{
+			name:     "invalid method",
+			rule:     router.RouteRule{Method: "FETCH"},
+			expected: `invalid method "FETCH"`,
+		},
+		{
+			name:     "invalid match type",
+			rule:     router.RouteRule{Headers: []router.RouteRuleMatch{{Name: "X-Beta", Value: "1", Type: "Prefix"}}},
+			expected: `invalid match type "Prefix"`,
+		},
+		{
+			name:     "multiple cookies",
+			rule:     router.RouteRule{Cookies: []router.RouteRuleMatch{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}},
+			expected: "only one cookie",
+		},
+		{
+			name:     "duplicated header",
+			rule:     router.RouteRule{Headers: append(header, router.RouteRuleMatch{Name: "x-beta", Value: "2"})},
+			expected: `header "x-beta" matched more than once`,
+		},
+		{
+			name:     "without matches",
+			rule:     router.RouteRule{Path: "/api"},
+			expected: "at least one header",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.buildRouteRules(ctx, "myapp", []router.RouteRule{tt.rule}, prefixes, "/")
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+func TestGatewayAPIServiceEnsureHeaderModifiers(t *testing.T) {
+	// Header modifiers become filters on every rule of the app and CName routes
+	svc, gwClient := newFakeGatewayAPIService()
+	id := idForApp("myapp")
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+
+	err := svc.Ensure(ctx, id, router.EnsureBackendOpts{
+		Opts: router.Opts{
+			HTTPOnly: true,
+			RequestHeaders: &router.HeaderModifier{
+				Set:    map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Port": "443"},
+				Remove: []string{"X-Internal"},
+			},
+			ResponseHeaders: &router.HeaderModifier{
+				Add: map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+			},
+			RouteRules: []router.RouteRule{
+				{Headers: []router.RouteRuleMatch{{Name: "X-Beta", Value: "1"}}},
+			},
+		},
+		CNames: []string{"myapp.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+
+	expected := []gatewayv1.HTTPRouteFilter{
+		{
+			Type: gatewayv1.HTTPRouteFilterRequestHeaderModifier,
+			RequestHeaderModifier: &gatewayv1.HTTPHeaderFilter{
+				Set: []gatewayv1.HTTPHeader{
+					{Name: "X-Forwarded-Port", Value: "443"},
+					{Name: "X-Forwarded-Proto", Value: "https"},
+				},
+				Remove: []string{"X-Internal"},
+			},
+		},
+		{
+			Type: gatewayv1.HTTPRouteFilterResponseHeaderModifier,
+			ResponseHeaderModifier: &gatewayv1.HTTPHeaderFilter{
+				Add: []gatewayv1.HTTPHeader{
+					{Name: "Strict-Transport-Security", Value: "max-age=31536000"},
+				},
+			},
+		},
+	}
+	for _, name := range []string{svc.httpRouteName(id), svc.httpRouteCNameName(id, "myapp.example.com")} {
+		route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Len(t, route.Spec.Rules, 2)
+		for _, rule := range route.Spec.Rules {
+			assert.Equal(t, expected, rule.Filters)
+		}
+	}
+}
+
+func TestGatewayAPIServiceEnsureHTTPSRedirect(t *testing.T) {
+	// https-redirect creates a redirect route on the HTTP listener and attaches the app route to the HTTPS one
+	svc, gwClient := newFakeGatewayAPIService()
+	id := idForApp("myapp")
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	createGateway(gwClient, "default", "main-gw",
+		gatewayv1.Listener{Name: "http", Protocol: gatewayv1.HTTPProtocolType},
+		gatewayv1.Listener{Name: "https", Protocol: gatewayv1.HTTPSProtocolType},
+	)
+
+	opts := router.EnsureBackendOpts{
+		Opts:   router.Opts{HTTPSRedirectCode: 308},
+		CNames: []string{"myapp.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	}
+	err := svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteName(id), metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, route.Spec.ParentRefs, 1)
+	require.NotNil(t, route.Spec.ParentRefs[0].SectionName)
+	assert.Equal(t, gatewayv1.SectionName("https"), *route.Spec.ParentRefs[0].SectionName)
+
+	redirect, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpsRedirectRouteName(id), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "true", redirect.Labels[labelHTTPSRedirect])
+	assert.Equal(t, gatewayv1.SectionName("http"), *redirect.Spec.ParentRefs[0].SectionName)
+	assert.Equal(t, []gatewayv1.Hostname{"myapp.local", "myapp.example.com"}, redirect.Spec.Hostnames)
+	require.Len(t, redirect.Spec.Rules, 1)
+	assert.Empty(t, redirect.Spec.Rules[0].BackendRefs)
+	filter := redirect.Spec.Rules[0].Filters[0]
+	assert.Equal(t, gatewayv1.HTTPRouteFilterRequestRedirect, filter.Type)
+	assert.Equal(t, "https", *filter.RequestRedirect.Scheme)
+	assert.Equal(t, 308, *filter.RequestRedirect.StatusCode)
+
+	addrs, err := svc.GetAddresses(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://myapp.local"}, addrs)
+
+	// disabling the redirect removes the redirect route and the listener restriction
+	opts.Opts.HTTPSRedirectCode = 0
+	err = svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+	_, err = gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpsRedirectRouteName(id), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+	route, err = gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteName(id), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, route.Spec.ParentRefs[0].SectionName)
+}
+
+func TestGatewayAPIServiceEnsureURLRewrite(t *testing.T) {
+	// url-rewrite replaces the route prefix on the rules matching a path prefix
+	svc, gwClient := newFakeGatewayAPIService()
+	id := idForApp("myapp")
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+
+	err := svc.Ensure(ctx, id, router.EnsureBackendOpts{
+		Opts: router.Opts{
+			HTTPOnly:        true,
+			Route:           "/myapp",
+			URLRewrite:      "/",
+			ResponseHeaders: &router.HeaderModifier{Remove: []string{"Server"}},
+		},
+		CNames: []string{"myapp.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteName(id), metav1.GetOptions{})
+	require.NoError(t, err)
+	filters := route.Spec.Rules[0].Filters
+	require.Len(t, filters, 2)
+	assert.Equal(t, gatewayv1.HTTPRouteFilterResponseHeaderModifier, filters[0].Type)
+	assert.Equal(t, gatewayv1.HTTPRouteFilterURLRewrite, filters[1].Type)
+	assert.Equal(t, gatewayv1.PrefixMatchHTTPPathModifier, filters[1].URLRewrite.Path.Type)
+	assert.Equal(t, "/", *filters[1].URLRewrite.Path.ReplacePrefixMatch)
+
+	// CName rules do not match a path prefix
+	cnameRoute, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteCNameName(id, "myapp.example.com"), metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, cnameRoute.Spec.Rules[0].Filters, 1)
+	assert.Equal(t, gatewayv1.HTTPRouteFilterResponseHeaderModifier, cnameRoute.Spec.Rules[0].Filters[0].Type)
+}
+
+func TestGatewayAPIServiceEnsureURLRewriteRouteRules(t *testing.T) {
+	// url-rewrite does not apply to the rules of the route-rules option
+	svc, gwClient := newFakeGatewayAPIService()
+	id := idForApp("myapp")
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp-beta"))
+
+	err := svc.Ensure(ctx, id, router.EnsureBackendOpts{
+		Opts: router.Opts{
+			HTTPOnly:   true,
+			Route:      "/myapp",
+			URLRewrite: "/",
+			RouteRules: []router.RouteRule{
+				{Prefix: "beta", Path: "/api", Method: "get"},
+			},
+		},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+			{Prefix: "beta", Target: router.BackendTarget{Service: "myapp-beta-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteName(id), metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, route.Spec.Rules, 2)
+	require.Len(t, route.Spec.Rules[0].Filters, 1)
+	assert.Equal(t, gatewayv1.HTTPRouteFilterURLRewrite, route.Spec.Rules[0].Filters[0].Type)
+	assert.Equal(t, "/api", *route.Spec.Rules[1].Matches[0].Path.Value)
+	assert.Empty(t, route.Spec.Rules[1].Filters)
+}
+
+func TestGatewayAPIServiceEnsureTrafficPolicies(t *testing.T) {
+	// Timeouts, retries and mirroring are set on every rule of the app and CName routes
+	svc, gwClient := newFakeGatewayAPIService()
+	id := idForApp("myapp")
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "shadow"))
+
+	retries, percent := 3, int32(10)
+	err := svc.Ensure(ctx, id, router.EnsureBackendOpts{
+		Opts: router.Opts{
+			HTTPOnly:      true,
+			Timeout:       "30s",
+			Retries:       &retries,
+			RetryOn:       []string{"gateway-error", "500"},
+			MirrorTo:      "shadow-web",
+			MirrorPercent: &percent,
+		},
+		CNames: []string{"myapp.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+
+	timeout := gatewayv1.Duration("30s")
+	namespace := gatewayv1.Namespace("default")
+	port := gatewayv1.PortNumber(defaultServicePort)
+	for _, name := range []string{svc.httpRouteName(id), svc.httpRouteCNameName(id, "myapp.example.com")} {
+		route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		rule := route.Spec.Rules[0]
+		assert.Equal(t, &gatewayv1.HTTPRouteTimeouts{Request: &timeout}, rule.Timeouts)
+		assert.Equal(t, &gatewayv1.HTTPRouteRetry{
+			Attempts: &retries,
+			Codes:    []gatewayv1.HTTPRouteRetryStatusCode{502, 503, 504, 500},
+		}, rule.Retry)
+		assert.Equal(t, []gatewayv1.HTTPRouteFilter{
+			{
+				Type: gatewayv1.HTTPRouteFilterRequestMirror,
+				RequestMirror: &gatewayv1.HTTPRequestMirrorFilter{
+					BackendRef: gatewayv1.BackendObjectReference{
+						Name:      "shadow-web",
+						Namespace: &namespace,
+						Port:      &port,
+					},
+					Percent: &percent,
+				},
+			},
+		}, rule.Filters)
+	}
+}
+
+func TestGatewayAPIServiceEnsureRetryOnConnectionErrors(t *testing.T) {
+	// Gateway API retries select status codes only, the connection errors are rejected
+	svc, gwClient := newFakeGatewayAPIService()
+	id := idForApp("myapp")
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	for _, condition := range []string{"connect-failure", "reset"} {
+		err := svc.Ensure(ctx, id, router.EnsureBackendOpts{
+			Opts: router.Opts{HTTPOnly: true, RetryOn: []string{"5xx", condition}},
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+			},
+		})
+		assert.Equal(t, router.NewError(router.ErrorCodeUnsupported, "retry-on condition %q is not supported by the gateway-api mode, Gateway API retries only select status codes", condition), err)
+	}
+	_, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteName(id), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+}
+
+func TestGatewayAPIServiceEnsureMirrorServiceNotFound(t *testing.T) {
+	svc, _ := newFakeGatewayAPIService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	err := svc.Ensure(ctx, idForApp("myapp"), router.EnsureBackendOpts{
+		Opts: router.Opts{MirrorTo: "shadow"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	})
+	assert.Equal(t, ErrNoService{App: "myapp", Service: "shadow"}, err)
+}
+
+func TestGatewayAPIServiceCertificates(t *testing.T) {
+	svc, gwClient := newFakeGatewayAPIService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	id := idForApp("myapp")
+	opts := router.EnsureBackendOpts{
+		CNames: []string{"myapp.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	}
+	err := svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+
+	err = svc.AddCertificate(ctx, id, "other.example.com", router.CertData{Certificate: "cert", Key: "key"})
+	assert.EqualError(t, err, "cname other.example.com is not found in httproute kube-router-myapp, found cnames: myapp.example.com")
+
+	// Act: a CName without issuer gets a ListenerSet serving the certificate.
+	expectedCert := newTestCertData(t, "myapp.example.com")
+	err = svc.AddCertificate(ctx, id, "myapp.example.com", expectedCert)
+	require.NoError(t, err)
+
+	secretName := svc.certificateSecretName(id, "myapp.example.com")
+	ls, err := gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(id, "myapp.example.com"), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "true", ls.Labels[labelUserCertificate])
+	assert.Empty(t, ls.Annotations)
+	assert.Equal(t, gatewayv1.ParentGatewayReference{Name: "main-gw", Namespace: ptr.To(gatewayv1.Namespace("default"))}, ls.Spec.ParentRef)
+	require.Len(t, ls.Spec.Listeners, 1)
+	assert.Equal(t, gatewayv1.ObjectName(secretName), ls.Spec.Listeners[0].TLS.CertificateRefs[0].Name)
+
+	route, err := gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteCNameName(id, "myapp.example.com"), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []gatewayv1.ParentReference{svc.listenerSetParentRef(id, "default", "myapp.example.com")}, route.Spec.ParentRefs)
+	assert.Equal(t, []gatewayv1.Hostname{"myapp.example.com"}, route.Spec.Hostnames)
+
+	cert, err := svc.GetCertificate(ctx, id, "myapp.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &expectedCert, cert)
+
+	// Ensure keeps the certificate
+	err = svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+	ls, err = gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(id, "myapp.example.com"), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, gatewayv1.ObjectName(secretName), ls.Spec.Listeners[0].TLS.CertificateRefs[0].Name)
+	_, err = gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteCNameName(id, "myapp.example.com"), metav1.GetOptions{})
+	require.NoError(t, err)
+
+	// Act: removing the certificate removes the ListenerSet without issuer
+	err = svc.RemoveCertificate(ctx, id, "myapp.example.com")
+	require.NoError(t, err)
+	_, err = gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(id, "myapp.example.com"), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+	_, err = gwClient.GatewayV1().HTTPRoutes("default").Get(ctx, svc.httpRouteCNameName(id, "myapp.example.com"), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+	_, err = svc.GetCertificate(ctx, id, "myapp.example.com")
+	assert.Equal(t, router.ErrCertificateNotFound, err)
+	err = svc.RemoveCertificate(ctx, id, "myapp.example.com")
+	assert.Equal(t, router.ErrCertificateNotFound, err)
+}
+
+func TestGatewayAPIServiceCertificatesCertManager(t *testing.T) {
+	svc, gwClient := newFakeGatewayAPIService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createCertManagerClusterIssuer(svc.CertManagerClient, "letsencrypt"))
+	id := idForApp("myapp")
+	opts := router.EnsureBackendOpts{
+		CNames: []string{"myapp.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	}
+	err := svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+	err = svc.AddCertificate(ctx, id, "myapp.example.com", newTestCertData(t, "myapp.example.com"))
+	require.NoError(t, err)
+
+	// The certificate takes precedence over the issuer set afterwards
+	svc.AcmeIssuer = "letsencrypt"
+	err = svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+	lsName := svc.listenerSetName(id, "myapp.example.com")
+	ls, err := gwClient.GatewayV1().ListenerSets("default").Get(ctx, lsName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "letsencrypt", ls.Labels[labelCertIssuer])
+	assert.Equal(t, "true", ls.Labels[labelUserCertificate])
+	assert.Empty(t, ls.Annotations)
+	assert.Equal(t, gatewayv1.ObjectName(svc.certificateSecretName(id, "myapp.example.com")), ls.Spec.Listeners[0].TLS.CertificateRefs[0].Name)
+
+	// Act: removing the certificate switches back to cert-manager
+	err = svc.RemoveCertificate(ctx, id, "myapp.example.com")
+	require.NoError(t, err)
+	ls, err = gwClient.GatewayV1().ListenerSets("default").Get(ctx, lsName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, ls.Labels, labelUserCertificate)
+	assert.Equal(t, map[string]string{
+		certManagerClusterIssuerKey: "letsencrypt",
+		certManagerCommonName:       "myapp.example.com",
+	}, ls.Annotations)
+	assert.Equal(t, gatewayv1.ObjectName(svc.tlsSecretName(id, "myapp.example.com")), ls.Spec.Listeners[0].TLS.CertificateRefs[0].Name)
+	_, err = svc.Client.CoreV1().Secrets("default").Get(ctx, svc.certificateSecretName(id, "myapp.example.com"), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+
+	err = svc.AddCertificate(ctx, id, "myapp.example.com", newTestCertData(t, "myapp.example.com"))
+	assert.EqualError(t, err, "cannot add certificate to listenerset "+lsName+", it is managed by cert-manager")
+	err = svc.RemoveCertificate(ctx, id, "myapp.example.com")
+	assert.EqualError(t, err, "cannot remove certificate from listenerset "+lsName+", it is managed by cert-manager")
+}
+
+func TestGatewayAPIServiceCertificatesRetriesOnConflict(t *testing.T) {
+	svc, gwClient := newFakeGatewayAPIService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createCertManagerClusterIssuer(svc.CertManagerClient, "letsencrypt"))
+	id := idForApp("myapp")
+	opts := router.EnsureBackendOpts{
+		CNames: []string{"myapp.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	}
+	err := svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+	err = svc.AddCertificate(ctx, id, "myapp.example.com", newTestCertData(t, "myapp.example.com"))
+	require.NoError(t, err)
+	lsName := svc.listenerSetName(id, "myapp.example.com")
+	applies := 0
+	gwClient.PrependReactor("patch", "listenersets", concurrentApply(gatewayv1.Resource("listenersets"), lsName, &applies))
+	retriesBefore := conflictRetriesValue(t, "listenerset")
+
+	// replacing the certificate updates the existing ListenerSet
+	err = svc.AddCertificate(ctx, id, "myapp.example.com", newTestCertData(t, "myapp.example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, applies)
+	assert.Equal(t, retriesBefore+2, conflictRetriesValue(t, "listenerset"))
+	ls, err := gwClient.GatewayV1().ListenerSets("default").Get(ctx, lsName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "true", ls.Labels[labelUserCertificate])
+
+	svc.AcmeIssuer = "letsencrypt"
+	err = svc.Ensure(ctx, id, opts)
+	require.NoError(t, err)
+	applies = 0
+	err = svc.RemoveCertificate(ctx, id, "myapp.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 3, applies)
+	assert.Equal(t, retriesBefore+4, conflictRetriesValue(t, "listenerset"))
+	ls, err = gwClient.GatewayV1().ListenerSets("default").Get(ctx, lsName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, ls.Labels, labelUserCertificate)
+	assert.Equal(t, "letsencrypt", ls.Annotations[certManagerClusterIssuerKey])
+}
+
+func TestGatewayAPIServiceAddCertificateInvalid(t *testing.T) {
+	svc, gwClient := newFakeGatewayAPIService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	id := idForApp("myapp")
+	err := svc.Ensure(ctx, id, router.EnsureBackendOpts{
+		CNames: []string{"myapp.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+
+	err = svc.AddCertificate(ctx, id, "myapp.example.com", newTestCertData(t, "other.example.com"))
+	assert.EqualError(t, err, "invalid certificate: certificate is not valid for myapp.example.com, it is valid for other.example.com")
+	var certErr *router.InvalidCertificateError
+	assert.ErrorAs(t, err, &certErr)
+
+	// Assert: neither the secret nor the ListenerSet were created.
+	_, err = svc.Client.CoreV1().Secrets("default").Get(ctx, svc.certificateSecretName(id, "myapp.example.com"), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+	_, err = gwClient.GatewayV1().ListenerSets("default").Get(ctx, svc.listenerSetName(id, "myapp.example.com"), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+}
+
+func TestGatewayAPIServicePlanEnsure(t *testing.T) {
+	server := newFakeAPIServer(t, map[string]string{
+		"/api/v1/namespaces/default/services/myapp-web": webServiceObject("myapp"),
+	})
+	defer server.Close()
+	svc := &GatewayAPIService{
+		BaseService:      &BaseService{Namespace: "default", RestConfig: server.restConfig()},
+		GatewayName:      "main-gw",
+		GatewayNamespace: "default",
+		DomainSuffix:     "local",
+	}
+	opts := router.EnsureBackendOpts{
+		Opts:   router.Opts{HTTPOnly: true},
+		CNames: []string{"a.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	server.resetPersisted()
+	objects := server.snapshot()
+
+	opts.CNames = []string{"b.example.com"}
+	plan, err := svc.PlanEnsure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+
+	// Assert: the route of the new cname is created and the old one deleted.
+	assert.Equal(t, []string{
+		"update HTTPRoute default/kube-router-myapp",
+		"create HTTPRoute default/kube-router-myapp-b.example.com-cname",
+		"delete HTTPRoute default/kube-router-myapp-a.example.com-cname",
+	}, planChanges(plan))
+	assert.Equal(t, []router.FieldChange{
+		{Path: `metadata.annotations["router.tsuru.io/cnames"]`, Old: "a.example.com", New: "b.example.com"},
+	}, planDiff(t, plan, "HTTPRoute", "kube-router-myapp"))
+	assert.Contains(t, planDiff(t, plan, "HTTPRoute", "kube-router-myapp-b.example.com-cname"),
+		router.FieldChange{Path: "spec.hostnames", New: []interface{}{"b.example.com"}})
+
+	// Assert: nothing was written.
+	assert.Empty(t, server.persisted)
+	assert.Equal(t, objects, server.snapshot())
+}
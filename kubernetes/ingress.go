@@ -7,35 +7,49 @@ package kubernetes
 import (
 	"context"
 	"fmt"
-	"log"
+	"math"
 	"net"
-	"reflect"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
+	"github.com/tsuru/kubernetes-router/observability"
 	"github.com/tsuru/kubernetes-router/router"
 	v1 "k8s.io/api/core/v1"
 	networkingV1 "k8s.io/api/networking/v1"
 
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/validation"
 	typedV1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	networkingTypedV1 "k8s.io/client-go/kubernetes/typed/networking/v1"
 )
 
+// NginxAnnotationsPrefix is the prefix of the annotations of the nginx ingress
+// controller, the features relying on its annotations are only supported
+// with it.
+const NginxAnnotationsPrefix = "nginx.ingress.kubernetes.io"
+
 var (
 	// AnnotationsACMEKey defines the common annotation used to enable acme-tls
 	AnnotationsACMEKey = "kubernetes.io/tls-acme"
 	labelCNameIngress  = "router.tsuru.io/is-cname-ingress"
+	labelCanaryIngress = "router.tsuru.io/is-canary-ingress"
 	AnnotationsCNames  = "router.tsuru.io/cnames"
 	AnnotationFreeze   = "router.tsuru.io/freeze"
 
+	ingressGVK = networkingV1.SchemeGroupVersion.WithKind("Ingress")
+
+	nginxHeaderNameRegexp = regexp.MustCompile("^[A-Za-z0-9-]+$")
+
 	defaultClassOpt          = "class"
 	defaultOptsAsAnnotations = map[string]string{
 		defaultClassOpt: "kubernetes.io/ingress.class",
@@ -59,31 +73,12 @@ var (
 )
 
 var (
-	_ router.Router       = &IngressService{}
-	_ router.RouterTLS    = &IngressService{}
-	_ router.RouterStatus = &IngressService{}
-)
-
-// Cert-manager types
-type CertManagerIssuerType int
-
-const (
-	certManagerIssuerTypeIssuer = iota
-	certManagerIssuerTypeClusterIssuer
-	certManagerIssuerTypeExternalIssuer
-)
-
-type CertManagerIssuerData struct {
-	name       string
-	kind       string
-	group      string
-	issuerType CertManagerIssuerType
-}
-
-const (
-	errIssuerNotFound         = "issuer %s not found"
-	errExternalIssuerNotFound = "external issuer %s not found, err: %s"
-	errExternalIssuerInvalid  = "invalid external issuer: %s (requires <resource name>.<resource kind>.<resource group>)"
+	_ router.Router             = &IngressService{}
+	_ router.RouterTLS          = &IngressService{}
+	_ router.RouterStatus       = &IngressService{}
+	_ router.RouterSwap         = &IngressService{}
+	_ router.RouterCertificates = &IngressService{}
+	_ router.RouterPlan         = &IngressService{}
 )
 
 // IngressService manages ingresses in a Kubernetes cluster that uses ingress-nginx
@@ -127,11 +122,12 @@ func (k *IngressService) Ensure(ctx context.Context, id router.InstanceID, o rou
 			return err
 		}
 		isNew = true
+		existingIngress = nil
 	}
 
 	if !isNew && existingIngress != nil {
 		if existingIngress.Annotations[AnnotationFreeze] == "true" {
-			log.Printf("Ingress is frozen, skipping: %s/%s", existingIngress.Namespace, existingIngress.Name)
+			observability.Logger(ctx).Info("Ingress is frozen, skipping", "namespace", existingIngress.Namespace, "name", existingIngress.Name)
 			return nil
 		}
 	}
@@ -155,6 +151,39 @@ func (k *IngressService) Ensure(ctx context.Context, id router.InstanceID, o rou
 		}
 	}
 
+	weightedServices, err := k.getWeightedServices(ctx, id.AppName, o.Prefixes, o.Opts.ExposeAllServices)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	err = k.validateWeightedServices(weightedServices)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	err = k.validateHeaderModifiers(o.Opts.RequestHeaders, o.Opts.ResponseHeaders)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	policyAnnotations, err := k.trafficPolicyAnnotations(ctx, id.AppName, ns, o.Opts)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	if len(policyAnnotations) > 0 {
+		additionalOpts := mergeMaps(o.Opts.AdditionalOpts)
+		for name, value := range policyAnnotations {
+			// annotations set by the user options take precedence
+			_, hasOpt := additionalOpts[name]
+			_, hasAnnotation := additionalOpts[k.annotationWithPrefix(name)]
+			if !hasOpt && !hasAnnotation {
+				additionalOpts[name] = value
+			}
+		}
+		o.Opts.AdditionalOpts = additionalOpts
+	}
+
 	domainSuffix := o.Opts.DomainSuffix
 	if k.DomainSuffix != "" {
 		domainSuffix = k.DomainSuffix
@@ -194,6 +223,9 @@ func (k *IngressService) Ensure(ctx context.Context, id router.InstanceID, o rou
 		Spec: buildIngressSpec(vhosts, o.Opts.Route, backendServices, k),
 	}
 	k.fillIngressMeta(ingress, o.Opts, id, o.Team, o.Tags)
+	if !isNew && isSwapped(existingIngress.ObjectMeta) {
+		keepSwappedIngressBackends(ingress, existingIngress)
+	}
 	if o.Opts.Acme {
 		k.fillIngressTLS(ingress, id)
 		ingress.ObjectMeta.Annotations[AnnotationsACMEKey] = "true"
@@ -204,20 +236,10 @@ func (k *IngressService) Ensure(ctx context.Context, id router.InstanceID, o rou
 		ingress.Annotations[AnnotationsCNames] = strings.Join(o.CNames, ",")
 	}
 
-	if isNew {
-		ingress, err = ingressClient.Create(ctx, ingress, metav1.CreateOptions{})
-		if err != nil {
-			setSpanError(span, err)
-			return err
-		}
-	} else if ingressHasChanges(span, existingIngress, ingress) {
-		err = k.mergeIngressAndUpdate(ctx, ingress, existingIngress, id, ingressClient, span)
-		if err != nil {
-			setSpanError(span, err)
-			return err
-		}
-	} else {
-		ingress = existingIngress
+	ingress, err = k.applyIngress(ctx, ingressClient, ingress, existingIngress, id)
+	if err != nil {
+		setSpanError(span, err)
+		return err
 	}
 
 	var existingCNames []string
@@ -263,27 +285,169 @@ func (k *IngressService) Ensure(ctx context.Context, id router.InstanceID, o rou
 		}
 	}
 
+	err = k.ensureCanaryIngresses(ctx, ensureCanaryIngressesOpts{
+		namespace:        ns,
+		id:               id,
+		parent:           ingress,
+		vhosts:           vhosts,
+		weightedServices: weightedServices,
+		opts:             o,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "could not ensure canary ingresses")
+		setSpanError(span, err)
+		return err
+	}
+
 	return nil
 }
 
-func (k *IngressService) mergeIngressAndUpdate(ctx context.Context, ingress *networkingV1.Ingress, existingIngress *networkingV1.Ingress, id router.InstanceID, ingressClient networkingTypedV1.IngressInterface, span opentracing.Span) error {
-	ingress.ObjectMeta.ResourceVersion = existingIngress.ObjectMeta.ResourceVersion
-	ingress.ObjectMeta.UID = existingIngress.ObjectMeta.UID
-	if existingIngress.Spec.DefaultBackend != nil {
-		ingress.Spec.DefaultBackend = existingIngress.Spec.DefaultBackend
+// PlanEnsure returns the changes Ensure would make to the ingresses of the
+// app, without writing them.
+func (k *IngressService) PlanEnsure(ctx context.Context, id router.InstanceID, o router.EnsureBackendOpts) (*router.Plan, error) {
+	return planEnsure(ctx, func(ctx context.Context) error {
+		return k.Ensure(ctx, id, o)
+	})
+}
+
+// validateWeightedServices checks that the weighted targets can be served by
+// nginx canary ingresses, which support a single canary per host
+func (k *IngressService) validateWeightedServices(weightedServices map[string][]weightedService) error {
+	if len(weightedServices) == 0 {
+		return nil
+	}
+	if k.AnnotationsPrefix != NginxAnnotationsPrefix {
+		// weights are set with the canary annotations of nginx
+		return router.NewError(router.ErrorCodeUnsupported, "weighted targets are only supported by the nginx-ingress mode")
+	}
+	for prefix, services := range weightedServices {
+		if len(services) > 2 {
+			return router.NewError(router.ErrorCodeUnsupported, "prefix %q has %d weighted targets, nginx canary ingresses support a single one", prefix, len(services)-1)
+		}
 	}
+	return nil
+}
 
-	if len(existingIngress.Spec.TLS) > 0 && !isManagedByCertManager(existingIngress.Annotations) {
-		k.fillIngressTLS(ingress, id)
+type ensureCanaryIngressesOpts struct {
+	namespace        string
+	id               router.InstanceID
+	parent           *networkingV1.Ingress
+	vhosts           map[string]string
+	weightedServices map[string][]weightedService
+	opts             router.EnsureBackendOpts
+}
+
+// ensureCanaryIngresses creates one nginx canary ingress for each prefix with
+// a weighted target and removes the canary ingresses no longer needed
+func (k *IngressService) ensureCanaryIngresses(ctx context.Context, opts ensureCanaryIngressesOpts) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ensureCanaryIngresses")
+	defer span.Finish()
+
+	ingressClient, err := k.ingressClient(opts.namespace)
+	if err != nil {
+		return err
 	}
-	_, err := ingressClient.Update(ctx, ingress, metav1.UpdateOptions{})
+
+	desired := map[string]bool{}
+	for prefix, services := range opts.weightedServices {
+		if len(services) < 2 {
+			continue
+		}
+		canary := services[1]
+		hosts := map[string]string{prefix: opts.vhosts[prefix]}
+		if prefix == "default" {
+			for i, cname := range opts.opts.CNames {
+				hosts[fmt.Sprintf("cname-%d", i)] = cname
+			}
+		}
+		canaryServices := map[string]*v1.Service{}
+		for key := range hosts {
+			canaryServices[key] = canary.service
+		}
+
+		ingress := &networkingV1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      k.ingressCanaryName(opts.id, prefix),
+				Namespace: opts.namespace,
+				Labels: map[string]string{
+					appBaseServiceNamespaceLabel: canary.service.Namespace,
+					appBaseServiceNameLabel:      canary.service.Name,
+					routerInstanceLabel:          opts.id.InstanceName,
+					labelCanaryIngress:           "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(opts.parent, schema.GroupVersionKind{
+						Group:   networkingV1.SchemeGroupVersion.Group,
+						Version: networkingV1.SchemeGroupVersion.Version,
+						Kind:    "Ingress",
+					}),
+				},
+			},
+			Spec: buildIngressSpec(hosts, opts.opts.Opts.Route, canaryServices, k),
+		}
+		k.fillIngressMeta(ingress, opts.opts.Opts, opts.id, opts.opts.Team, opts.opts.Tags)
+		ingress.Annotations[k.annotationWithPrefix("canary")] = "true"
+		ingress.Annotations[k.annotationWithPrefix("canary-weight")] = strconv.Itoa(int(canary.weight))
+		desired[ingress.Name] = true
+
+		existing, err := ingressClient.Get(ctx, ingress.Name, metav1.GetOptions{})
+		if err != nil {
+			if !k8sErrors.IsNotFound(err) {
+				return err
+			}
+			existing = nil
+		}
+		if existing != nil && existing.Annotations[AnnotationFreeze] == "true" {
+			observability.Logger(ctx).Info("Ingress is frozen, skipping", "namespace", existing.Namespace, "name", existing.Name)
+			continue
+		}
+		err = retryOnConflict("ingress", func(bool) error {
+			_, err := applyObject(ctx, ingressClient, ingressGVK, ingress)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	existingCanaries, err := ingressClient.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{
+			appLabel:            opts.id.AppName,
+			routerInstanceLabel: opts.id.InstanceName,
+			labelCanaryIngress:  "true",
+		}.String(),
+	})
 	if err != nil {
-		setSpanError(span, err)
 		return err
 	}
+	for _, canary := range existingCanaries.Items {
+		if desired[canary.Name] {
+			continue
+		}
+		err = ingressClient.Delete(ctx, canary.Name, metav1.DeleteOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+	}
 	return nil
 }
 
+// applyIngress applies the ingress, the TLS of the certificates added to the
+// existing one is kept. The apply is retried when the ingress is concurrently
+// created.
+func (k *IngressService) applyIngress(ctx context.Context, ingressClient networkingTypedV1.IngressInterface, ingress, existing *networkingV1.Ingress, id router.InstanceID) (*networkingV1.Ingress, error) {
+	if existing != nil && len(existing.Spec.TLS) > 0 && !isManagedByCertManager(existing.Annotations) {
+		k.fillIngressTLS(ingress, id)
+	}
+	var applied *networkingV1.Ingress
+	err := retryOnConflict("ingress", func(bool) error {
+		var err error
+		applied, err = applyObject(ctx, ingressClient, ingressGVK, ingress)
+		return err
+	})
+	return applied, err
+}
+
 func buildIngressSpec(hosts map[string]string, path string, services map[string]*v1.Service, k *IngressService) networkingV1.IngressSpec {
 	pathType := networkingV1.PathTypeImplementationSpecific
 	rules := []networkingV1.IngressRule{}
@@ -326,6 +490,28 @@ func buildIngressSpec(hosts map[string]string, path string, services map[string]
 	}
 }
 
+// keepSwappedIngressBackends copies the backends of the rules of a swapped
+// ingress so that Ensure does not undo the swap
+func keepSwappedIngressBackends(ingress, existing *networkingV1.Ingress) {
+	keepSwappedLabels(&ingress.ObjectMeta, existing.ObjectMeta)
+	existingBackends := map[string]networkingV1.IngressBackend{}
+	for _, rule := range existing.Spec.Rules {
+		if rule.HTTP == nil || len(rule.HTTP.Paths) == 0 {
+			continue
+		}
+		existingBackends[rule.Host] = rule.HTTP.Paths[0].Backend
+	}
+	for _, rule := range ingress.Spec.Rules {
+		backend, ok := existingBackends[rule.Host]
+		if !ok || rule.HTTP == nil {
+			continue
+		}
+		for i := range rule.HTTP.Paths {
+			rule.HTTP.Paths[i].Backend = backend
+		}
+	}
+}
+
 func setSpanError(span opentracing.Span, err error) {
 	span.SetTag("error", true)
 	span.LogKV("error.message", err.Error())
@@ -358,14 +544,14 @@ func (k *IngressService) ensureCNameBackend(ctx context.Context, opts ensureCNam
 	if err != nil {
 		if !k8sErrors.IsNotFound(err) {
 			return err
-
 		}
 		isNew = true
+		existingIngress = nil
 	}
 
 	if !isNew && existingIngress != nil {
 		if existingIngress.Annotations[AnnotationFreeze] == "true" {
-			log.Printf("Ingress is frozen, skipping: %s/%s", existingIngress.Namespace, existingIngress.Name)
+			observability.Logger(ctx).Info("Ingress is frozen, skipping", "namespace", existingIngress.Namespace, "name", existingIngress.Name)
 			return nil
 		}
 	}
@@ -391,6 +577,9 @@ func (k *IngressService) ensureCNameBackend(ctx context.Context, opts ensureCNam
 	}
 
 	k.fillIngressMeta(ingress, opts.routerOpts, opts.id, opts.team, opts.tags)
+	if !isNew && isSwapped(existingIngress.ObjectMeta) {
+		keepSwappedIngressBackends(ingress, existingIngress)
+	}
 
 	if opts.routerOpts.HTTPOnly {
 		k.cleanupCertManagerAnnotations(ingress)
@@ -404,18 +593,11 @@ func (k *IngressService) ensureCNameBackend(ctx context.Context, opts ensureCNam
 		}
 	}
 
-	if isNew {
-		_, err = ingressClient.Create(ctx, ingress, metav1.CreateOptions{})
+	_, err = k.applyIngress(ctx, ingressClient, ingress, existingIngress, opts.id)
+	if err != nil || isNew {
 		return err
 	}
 
-	if ingressHasChanges(span, existingIngress, ingress) {
-		err = k.mergeIngressAndUpdate(ctx, ingress, existingIngress, opts.id, ingressClient, span)
-		if err != nil {
-			return err
-		}
-	}
-
 	if len(ingress.Spec.TLS) == 0 {
 		certificateName := k.secretName(opts.id, opts.cname)
 		return k.ensureCertmanagerCertificateDeleted(ctx, opts.namespace, certificateName)
@@ -449,11 +631,11 @@ func (k *IngressService) ensureCNAMECertManagerIssuer(ctx context.Context, opts
 
 		certIssuerData, err := k.getCertManagerIssuerData(ctx, opts.certIssuer, opts.namespace)
 		if err != nil {
-			log.Printf("Error getting cert manager issuer data: %v", err)
+			observability.Logger(ctx).Error("failed to get cert-manager issuer data", "issuer", opts.certIssuer, "error", err)
 			return err
 		}
 
-		log.Printf("Cert manager issuer data: %v", certIssuerData)
+		observability.Logger(ctx).Debug("cert-manager issuer data", "issuer", opts.certIssuer, "data", certIssuerData)
 
 		// Remove previous cermanager annotations if needed and
 		// add cert-manager annotations to the ingress.
@@ -520,6 +702,265 @@ func (k *IngressService) Remove(ctx context.Context, id router.InstanceID) error
 	return err
 }
 
+// Swap swaps the backend services of the ingresses of two apps, including
+// their cname ingresses. When cnameOnly is set the cname ingresses are moved
+// between the apps instead.
+func (k *IngressService) Swap(ctx context.Context, srcApp, dstApp router.InstanceID, cnameOnly bool) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "swapIngress")
+	defer span.Finish()
+
+	span.SetTag("srcApp", srcApp.AppName)
+	span.SetTag("dstApp", dstApp.AppName)
+	span.SetTag("cnameOnly", cnameOnly)
+
+	ns, err := k.getSwapNamespace(ctx, srcApp, dstApp)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	ingressClient, err := k.ingressClient(ns)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	srcIngress, err := k.get(ctx, srcApp)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	dstIngress, err := k.get(ctx, dstApp)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	if srcIngress.Annotations[AnnotationFreeze] == "true" || dstIngress.Annotations[AnnotationFreeze] == "true" {
+		err = router.NewError(router.ErrorCodeFrozen, "cannot swap frozen ingresses %s and %s", srcIngress.Name, dstIngress.Name)
+		setSpanError(span, err)
+		return err
+	}
+	srcBackend, err := ingressBaseBackend(srcIngress)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	dstBackend, err := ingressBaseBackend(dstIngress)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	if !cnameOnly {
+		err = checkIngressPrefixes(srcIngress, dstIngress, srcBackend.Name, dstBackend.Name)
+		if err != nil {
+			setSpanError(span, err)
+			return err
+		}
+	}
+	srcCNames, err := k.cnameIngresses(ctx, ingressClient, srcApp, srcIngress)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+	dstCNames, err := k.cnameIngresses(ctx, ingressClient, dstApp, dstIngress)
+	if err != nil {
+		setSpanError(span, err)
+		return err
+	}
+
+	for _, ingress := range []*networkingV1.Ingress{srcIngress, dstIngress} {
+		if ingress.Annotations == nil {
+			ingress.Annotations = map[string]string{}
+		}
+	}
+
+	toApply := []*networkingV1.Ingress{srcIngress, dstIngress}
+	toApply = append(toApply, srcCNames...)
+	toApply = append(toApply, dstCNames...)
+	originals := make([]*networkingV1.Ingress, len(toApply))
+	for i, ingress := range toApply {
+		originals[i] = ingress.DeepCopy()
+	}
+
+	if cnameOnly {
+		for _, cnameIngress := range srcCNames {
+			moveCNameIngress(cnameIngress, srcIngress, dstIngress, srcBackend.Name, *dstBackend)
+		}
+		for _, cnameIngress := range dstCNames {
+			moveCNameIngress(cnameIngress, dstIngress, srcIngress, dstBackend.Name, *srcBackend)
+		}
+		swapAnnotation(srcIngress.Annotations, dstIngress.Annotations, AnnotationsCNames)
+	} else {
+		swapIngressPrefixBackends(srcIngress, dstIngress, srcBackend.Name, dstBackend.Name)
+		for _, ingress := range append([]*networkingV1.Ingress{srcIngress}, srcCNames...) {
+			replaceIngressBackend(ingress, srcBackend.Name, *dstBackend)
+			toggleSwapped(&ingress.ObjectMeta, dstApp.AppName)
+		}
+		for _, ingress := range append([]*networkingV1.Ingress{dstIngress}, dstCNames...) {
+			replaceIngressBackend(ingress, dstBackend.Name, *srcBackend)
+			toggleSwapped(&ingress.ObjectMeta, srcApp.AppName)
+		}
+		swapBaseServiceLabels(&srcIngress.ObjectMeta, &dstIngress.ObjectMeta)
+		for _, ingress := range srcCNames {
+			keepSwappedLabels(&ingress.ObjectMeta, srcIngress.ObjectMeta)
+		}
+		for _, ingress := range dstCNames {
+			keepSwappedLabels(&ingress.ObjectMeta, dstIngress.ObjectMeta)
+		}
+	}
+
+	writes := &swapWrites{}
+	for i, ingress := range toApply {
+		err = applySwapped(ctx, writes, ingressClient, ingressGVK, ingress, originals[i])
+		if err != nil {
+			err = errors.Wrapf(err, "could not apply ingress %q", ingress.Name)
+			setSpanError(span, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// cnameIngresses returns the cname ingresses of an app listed on the cnames
+// annotation of its ingress
+func (k *IngressService) cnameIngresses(ctx context.Context, ingressClient networkingTypedV1.IngressInterface, id router.InstanceID, ingress *networkingV1.Ingress) ([]*networkingV1.Ingress, error) {
+	var result []*networkingV1.Ingress
+	for _, cname := range strings.Split(ingress.Annotations[AnnotationsCNames], ",") {
+		if cname == "" {
+			continue
+		}
+		cnameIngress, err := ingressClient.Get(ctx, k.ingressCName(id, cname), metav1.GetOptions{})
+		if err != nil {
+			if k8sErrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		result = append(result, cnameIngress)
+	}
+	return result, nil
+}
+
+// ingressBaseBackend returns the backend of the rules pointing to the base
+// service of the ingress
+func ingressBaseBackend(ingress *networkingV1.Ingress) (*networkingV1.IngressServiceBackend, error) {
+	serviceName := ingress.Labels[appBaseServiceNameLabel]
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil && path.Backend.Service.Name == serviceName {
+				backend := *path.Backend.Service
+				return &backend, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("ingress %s has no rule pointing to service %q", ingress.Name, serviceName)
+}
+
+// ingressPrefixRules returns the rules of the prefixes of the ingress other
+// than the default one by prefix, their hosts are subdomains of the host of
+// the rule pointing to the base service.
+func ingressPrefixRules(ingress *networkingV1.Ingress, baseService string) map[string]*networkingV1.IngressRule {
+	var defaultHost string
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP != nil && len(rule.HTTP.Paths) > 0 && rule.HTTP.Paths[0].Backend.Service != nil && rule.HTTP.Paths[0].Backend.Service.Name == baseService {
+			defaultHost = rule.Host
+			break
+		}
+	}
+	rules := map[string]*networkingV1.IngressRule{}
+	for i, rule := range ingress.Spec.Rules {
+		prefix, ok := strings.CutSuffix(rule.Host, "."+defaultHost)
+		if !ok || rule.HTTP == nil || defaultHost == "" {
+			continue
+		}
+		rules[prefix] = &ingress.Spec.Rules[i]
+	}
+	return rules
+}
+
+// checkIngressPrefixes returns an error when the ingresses do not have the
+// same prefixes, their rules could not be swapped.
+func checkIngressPrefixes(src, dst *networkingV1.Ingress, srcService, dstService string) error {
+	srcRules := ingressPrefixRules(src, srcService)
+	dstRules := ingressPrefixRules(dst, dstService)
+	if len(srcRules) != len(dstRules) {
+		return router.NewError(router.ErrorCodeUnsupported, "cannot swap ingresses %s and %s with different prefixes", src.Name, dst.Name)
+	}
+	for prefix := range srcRules {
+		if _, ok := dstRules[prefix]; !ok {
+			return router.NewError(router.ErrorCodeUnsupported, "cannot swap ingresses %s and %s with different prefixes", src.Name, dst.Name)
+		}
+	}
+	return nil
+}
+
+// swapIngressPrefixBackends swaps the backends of the rules of the prefixes
+// of the ingresses, checked by checkIngressPrefixes.
+func swapIngressPrefixBackends(src, dst *networkingV1.Ingress, srcService, dstService string) {
+	dstRules := ingressPrefixRules(dst, dstService)
+	for prefix, srcRule := range ingressPrefixRules(src, srcService) {
+		dstRule := dstRules[prefix]
+		if len(srcRule.HTTP.Paths) == 0 || len(dstRule.HTTP.Paths) == 0 {
+			continue
+		}
+		srcBackend, dstBackend := srcRule.HTTP.Paths[0].Backend, dstRule.HTTP.Paths[0].Backend
+		for i := range srcRule.HTTP.Paths {
+			srcRule.HTTP.Paths[i].Backend = dstBackend
+		}
+		for i := range dstRule.HTTP.Paths {
+			dstRule.HTTP.Paths[i].Backend = srcBackend
+		}
+	}
+}
+
+// replaceIngressBackend points every rule using the service from to backend
+func replaceIngressBackend(ingress *networkingV1.Ingress, from string, backend networkingV1.IngressServiceBackend) {
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for i, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil && path.Backend.Service.Name == from {
+				serviceBackend := backend
+				rule.HTTP.Paths[i].Backend.Service = &serviceBackend
+			}
+		}
+	}
+}
+
+// moveCNameIngress makes a cname ingress owned by the ingress of another app
+func moveCNameIngress(cnameIngress, from, to *networkingV1.Ingress, fromService string, backend networkingV1.IngressServiceBackend) {
+	replaceIngressBackend(cnameIngress, fromService, backend)
+	if cnameIngress.Labels == nil {
+		cnameIngress.Labels = map[string]string{}
+	}
+	cnameIngress.Labels[appLabel] = to.Labels[appLabel]
+	cnameIngress.Labels[teamLabel] = to.Labels[teamLabel]
+	cnameIngress.Labels[appBaseServiceNamespaceLabel] = to.Labels[appBaseServiceNamespaceLabel]
+	cnameIngress.Labels[appBaseServiceNameLabel] = to.Labels[appBaseServiceNameLabel]
+	delete(cnameIngress.Labels, swappedWithLabel)
+	for i, ref := range cnameIngress.OwnerReferences {
+		if ref.UID == from.UID {
+			cnameIngress.OwnerReferences[i].Name = to.Name
+			cnameIngress.OwnerReferences[i].UID = to.UID
+		}
+	}
+}
+
+func swapAnnotation(src, dst map[string]string, key string) {
+	srcValue, srcOk := src[key]
+	dstValue, dstOk := dst[key]
+	delete(src, key)
+	delete(dst, key)
+	if dstOk {
+		src[key] = dstValue
+	}
+	if srcOk {
+		dst[key] = srcValue
+	}
+}
+
 // Get gets the address of the loadbalancer associated with
 // the app Ingress resource
 func (k *IngressService) GetAddresses(ctx context.Context, id router.InstanceID) ([]string, error) {
@@ -609,6 +1050,13 @@ func (s *IngressService) ingressCName(id router.InstanceID, cname string) string
 	return s.hashedResourceName(id, "kubernetes-router-cname-"+cname, 253)
 }
 
+func (s *IngressService) ingressCanaryName(id router.InstanceID, prefix string) string {
+	if prefix == "default" {
+		return s.hashedResourceName(id, "kubernetes-router-"+id.AppName+"-canary-ingress", 253)
+	}
+	return s.hashedResourceName(id, "kubernetes-router-"+id.AppName+"-"+prefix+"-canary-ingress", 253)
+}
+
 func (s *IngressService) secretName(id router.InstanceID, certName string) string {
 	return s.hashedResourceName(id, "kr-"+id.AppName+"-"+certName, 253)
 }
@@ -640,7 +1088,7 @@ func (k *IngressService) AddCertificate(ctx context.Context, id router.InstanceI
 	}
 
 	if isManagedByCertManager(ingress.Annotations) {
-		return fmt.Errorf("cannot add certificate to ingress %s, it is managed by cert-manager", ingress.Name)
+		return router.NewError(router.ErrorCodeUnsupported, "cannot add certificate to ingress %s, it is managed by cert-manager", ingress.Name).WithDetail("cname", certCname)
 	}
 
 	foundCname := false
@@ -655,11 +1103,15 @@ func (k *IngressService) AddCertificate(ctx context.Context, id router.InstanceI
 	}
 
 	if !foundCname {
-		return fmt.Errorf("cname %s is not found in ingress %s, found cnames: %s", certCname, ingress.Name, strings.Join(foundCNames, ", "))
+		return router.NewError(router.ErrorCodeNotFound, "cname %s is not found in ingress %s, found cnames: %s", certCname, ingress.Name, strings.Join(foundCNames, ", ")).WithDetail("cname", certCname)
 	}
 
 	if ingress.Annotations[AnnotationsACMEKey] == "true" {
-		return fmt.Errorf("cannot add certificate to ingress %s, it is managed by ACME", ingress.Name)
+		return router.NewError(router.ErrorCodeUnsupported, "cannot add certificate to ingress %s, it is managed by ACME", ingress.Name).WithDetail("cname", certCname)
+	}
+
+	if err = cert.Validate(certCname, time.Now()); err != nil {
+		return err
 	}
 
 	secretName := k.secretName(id, certCname)
@@ -695,26 +1147,38 @@ func (k *IngressService) AddCertificate(ctx context.Context, id router.InstanceI
 		return err
 	}
 
-	tlsSpecExists := false
-	for index, ingressTLS := range ingress.Spec.TLS {
-		if ingressTLS.SecretName == tlsSecret.Name {
-			ingress.Spec.TLS[index].Hosts = []string{certCname}
-			tlsSpecExists = true
-			break
+	return retryOnConflict("ingress", func(retrying bool) error {
+		if retrying {
+			ingress, err = k.targetIngressForCertificate(ctx, id, certCname)
+			if err != nil {
+				return err
+			}
+		}
+		tlsSpecExists := false
+		for index, ingressTLS := range ingress.Spec.TLS {
+			if ingressTLS.SecretName == tlsSecret.Name {
+				ingress.Spec.TLS[index].Hosts = []string{certCname}
+				tlsSpecExists = true
+				break
+			}
 		}
-	}
 
-	if !tlsSpecExists {
-		ingress.Spec.TLS = append(ingress.Spec.TLS,
-			[]networkingV1.IngressTLS{
-				{
-					Hosts:      []string{certCname},
-					SecretName: tlsSecret.Name,
-				},
-			}...)
-	}
-	_, err = ingressClient.Update(ctx, ingress, metav1.UpdateOptions{})
-	return err
+		if !tlsSpecExists {
+			ingress.Spec.TLS = append(ingress.Spec.TLS,
+				[]networkingV1.IngressTLS{
+					{
+						Hosts:      []string{certCname},
+						SecretName: tlsSecret.Name,
+					},
+				}...)
+		}
+		applied, err := applicable(ingress)
+		if err != nil {
+			return err
+		}
+		_, err = applyObject(ctx, ingressClient, ingressGVK, applied)
+		return err
+	})
 }
 
 func (k *IngressService) targetIngressForCertificate(ctx context.Context, id router.InstanceID, certCname string) (*networkingV1.Ingress, error) {
@@ -753,7 +1217,7 @@ func (k *IngressService) GetCertificate(ctx context.Context, id router.InstanceI
 	retSecret, err := secret.Get(ctx, secretName, metav1.GetOptions{})
 	if err != nil {
 		if k8sErrors.IsNotFound(err) {
-			log.Printf("Secret %s/%s is not found\n", ns, secretName)
+			observability.Logger(ctx).Info("certificate secret not found", "namespace", ns, "name", secretName)
 			return nil, router.ErrCertificateNotFound
 		}
 		return nil, err
@@ -779,25 +1243,38 @@ func (k *IngressService) RemoveCertificate(ctx context.Context, id router.Instan
 		return err
 	}
 	if ingress.Annotations[AnnotationsACMEKey] == "true" {
-		return fmt.Errorf("cannot remove certificate from ingress %s, it is managed by ACME", ingress.Name)
+		return router.NewError(router.ErrorCodeUnsupported, "cannot remove certificate from ingress %s, it is managed by ACME", ingress.Name).WithDetail("cname", certCname)
 	}
 
 	if isManagedByCertManager(ingress.Annotations) {
-		return fmt.Errorf("cannot remove certificate to ingress %s, it is managed by cert-manager", ingress.Name)
+		return router.NewError(router.ErrorCodeUnsupported, "cannot remove certificate to ingress %s, it is managed by cert-manager", ingress.Name).WithDetail("cname", certCname)
 	}
 
 	secret, err := k.secretClient(ns)
 	if err != nil {
 		return err
 	}
-	for k := range ingress.Spec.TLS {
-		for _, host := range ingress.Spec.TLS[k].Hosts {
-			if strings.Compare(certCname, host) == 0 {
-				ingress.Spec.TLS = append(ingress.Spec.TLS[:k], ingress.Spec.TLS[k+1:]...)
+	err = retryOnConflict("ingress", func(retrying bool) error {
+		if retrying {
+			ingress, err = k.targetIngressForCertificate(ctx, id, certCname)
+			if err != nil {
+				return err
 			}
 		}
-	}
-	_, err = ingressClient.Update(ctx, ingress, metav1.UpdateOptions{})
+		for k := range ingress.Spec.TLS {
+			for _, host := range ingress.Spec.TLS[k].Hosts {
+				if strings.Compare(certCname, host) == 0 {
+					ingress.Spec.TLS = append(ingress.Spec.TLS[:k], ingress.Spec.TLS[k+1:]...)
+				}
+			}
+		}
+		applied, err := applicable(ingress)
+		if err != nil {
+			return err
+		}
+		_, err = applyObject(ctx, ingressClient, ingressGVK, applied)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -805,6 +1282,113 @@ func (k *IngressService) RemoveCertificate(ctx context.Context, id router.Instan
 	return err
 }
 
+// ListCertificates describes the certificates of the TLS hosts of the app and
+// cname ingresses
+func (k *IngressService) ListCertificates(ctx context.Context, id router.InstanceID) ([]router.CertificateInfo, error) {
+	ns, err := k.getAppNamespace(ctx, id.AppName)
+	if err != nil {
+		return nil, err
+	}
+	ingressClient, err := k.ingressClient(ns)
+	if err != nil {
+		return nil, err
+	}
+	certificates := []router.CertificateInfo{}
+	ingress, err := ingressClient.Get(ctx, k.ingressName(id), metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return certificates, nil
+		}
+		return nil, err
+	}
+	cnameIngresses, err := k.cnameIngresses(ctx, ingressClient, id, ingress)
+	if err != nil {
+		return nil, err
+	}
+	for _, ing := range append([]*networkingV1.Ingress{ingress}, cnameIngresses...) {
+		source := router.CertificateSourceManual
+		if ing.Annotations[AnnotationsACMEKey] == "true" {
+			source = router.CertificateSourceACME
+		} else if isManagedByCertManager(ing.Annotations) {
+			source = router.CertificateSourceCertManager
+		}
+		for _, tls := range ing.Spec.TLS {
+			for _, host := range tls.Hosts {
+				info := router.CertificateInfo{
+					Host:       host,
+					Source:     source,
+					SecretName: tls.SecretName,
+				}
+				if source != router.CertificateSourceManual {
+					info.Issuer = certManagerIssuerFromAnnotations(ing.Annotations)
+				}
+				err = k.describeCertificate(ctx, ns, &info)
+				if err != nil {
+					return nil, err
+				}
+				certificates = append(certificates, info)
+			}
+		}
+	}
+	return certificates, nil
+}
+
+// describeCertificate fills the certificate details from the TLS secret and
+// the Ready condition of the cert-manager Certificate issuing it
+func (k *IngressService) describeCertificate(ctx context.Context, ns string, info *router.CertificateInfo) error {
+	secret, err := k.secretClient(ns)
+	if err != nil {
+		return err
+	}
+	tlsSecret, err := secret.Get(ctx, info.SecretName, metav1.GetOptions{})
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return err
+		}
+		info.Error = fmt.Sprintf("secret %s not found", info.SecretName)
+	} else {
+		info.Describe(tlsSecret.Data["tls.crt"])
+	}
+	if info.Source == router.CertificateSourceManual {
+		return nil
+	}
+
+	cmClient, err := k.getCertManagerClient()
+	if err != nil {
+		return err
+	}
+	certificate, err := cmClient.CertmanagerV1().Certificates(ns).Get(ctx, info.SecretName, metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, condition := range certificate.Status.Conditions {
+		if condition.Type == certmanagerv1.CertificateConditionReady {
+			info.Ready = &router.CertificateCondition{
+				Status:  string(condition.Status),
+				Reason:  condition.Reason,
+				Message: condition.Message,
+			}
+		}
+	}
+	return nil
+}
+
+// certManagerIssuerFromAnnotations returns the issuer set by the cert-manager
+// annotations, external issuers as name.kind.group
+func certManagerIssuerFromAnnotations(annotations map[string]string) string {
+	if issuer := annotations[certManagerClusterIssuerKey]; issuer != "" {
+		return issuer
+	}
+	issuer := annotations[certManagerIssuerKey]
+	if issuer != "" && annotations[certManagerIssuerKindKey] != "" {
+		return issuer + "." + annotations[certManagerIssuerKindKey] + "." + annotations[certManagerIssuerGroupKey]
+	}
+	return issuer
+}
+
 // SupportedOptions returns the supported options
 func (s *IngressService) SupportedOptions(ctx context.Context) map[string]string {
 	opts := map[string]string{
@@ -813,6 +1397,15 @@ func (s *IngressService) SupportedOptions(ctx context.Context) map[string]string
 		router.Route:       "",
 		router.AllPrefixes: "",
 	}
+	if s.AnnotationsPrefix == NginxAnnotationsPrefix {
+		described := router.DescribedOptions()
+		for _, opt := range []string{router.RequestHeaders, router.ResponseHeaders} {
+			opts[opt] = described[opt] + " Written to the configuration-snippet annotation, requires snippet annotations to be allowed on ingress-nginx (allow-snippet-annotations)."
+		}
+		for _, opt := range []string{router.Timeout, router.Retries, router.RetryOn, router.MirrorTo} {
+			opts[opt] = ""
+		}
+	}
 	docs := mergeMaps(defaultOptsAsAnnotationsDocs, s.OptsAsAnnotationsDocs)
 	for k, v := range mergeMaps(defaultOptsAsAnnotations, s.OptsAsAnnotations) {
 		opts[k] = v
@@ -863,6 +1456,17 @@ func (s *IngressService) fillIngressMeta(i *networkingV1.Ingress, routerOpts rou
 		}
 	}
 
+	if s.AnnotationsPrefix == NginxAnnotationsPrefix {
+		snippet := headerModifiersSnippet(routerOpts.RequestHeaders, routerOpts.ResponseHeaders)
+		if snippet != "" {
+			snippetAnnotation := s.annotationWithPrefix("configuration-snippet")
+			if existing := i.ObjectMeta.Annotations[snippetAnnotation]; existing != "" {
+				snippet = strings.TrimRight(existing, "\n") + "\n" + snippet
+			}
+			i.ObjectMeta.Annotations[snippetAnnotation] = snippet
+		}
+	}
+
 	for _, tag := range tags {
 		parts := strings.SplitN(tag, "=", 2)
 		var key, value string
@@ -885,93 +1489,139 @@ func (s *IngressService) fillIngressMeta(i *networkingV1.Ingress, routerOpts rou
 	}
 }
 
-func (s *IngressService) validateCustomIssuer(ctx context.Context, resource CertManagerIssuerData, ns string) error {
-	sigsClient, err := s.getSigsClient()
-	if err != nil {
-		return err
+// trafficPolicyAnnotations returns the nginx annotations, without prefix, of
+// the timeout, retry and mirror options.
+func (k *IngressService) trafficPolicyAnnotations(ctx context.Context, appName, ns string, opts router.Opts) (map[string]string, error) {
+	if opts.Timeout == "" && opts.Retries == nil && len(opts.RetryOn) == 0 && opts.MirrorTo == "" {
+		return nil, nil
+	}
+	if k.AnnotationsPrefix != NginxAnnotationsPrefix {
+		return nil, router.NewError(router.ErrorCodeUnsupported, "timeouts, retries and mirroring are only supported by the nginx-ingress mode")
 	}
 
-	mapping, err := sigsClient.RESTMapper().RESTMapping(schema.GroupKind{
-		Group: resource.group,
-		Kind:  resource.kind,
-	})
-	if err != nil {
-		return err
+	annotations := map[string]string{}
+	if opts.Timeout != "" {
+		timeout, err := time.ParseDuration(opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		seconds := strconv.Itoa(int(math.Ceil(timeout.Seconds())))
+		annotations["proxy-read-timeout"] = seconds
+		annotations["proxy-send-timeout"] = seconds
 	}
 
-	u := &unstructured.Unstructured{}
-	u.Object = map[string]interface{}{}
-	u.SetGroupVersionKind(schema.GroupVersionKind{
-		Group:   mapping.GroupVersionKind.Group,
-		Kind:    mapping.GroupVersionKind.Kind,
-		Version: mapping.GroupVersionKind.Version,
-	})
+	if opts.Retries != nil {
+		if *opts.Retries == 0 {
+			annotations["proxy-next-upstream"] = "off"
+		} else {
+			annotations["proxy-next-upstream-tries"] = strconv.Itoa(*opts.Retries + 1)
+		}
+	}
+	if len(opts.RetryOn) > 0 && (opts.Retries == nil || *opts.Retries > 0) {
+		var conditions []string
+		for _, condition := range opts.RetryOn {
+			var nginxConditions []string
+			switch condition {
+			case "5xx":
+				nginxConditions = []string{"http_500", "http_502", "http_503", "http_504"}
+			case "gateway-error":
+				nginxConditions = []string{"http_502", "http_503", "http_504"}
+			case "connect-failure":
+				nginxConditions = []string{"error", "timeout"}
+			case "reset":
+				nginxConditions = []string{"error"}
+			case "403", "404", "429", "500", "502", "503", "504":
+				nginxConditions = []string{"http_" + condition}
+			default:
+				return nil, router.NewError(router.ErrorCodeUnsupported, "retry-on condition %q is not supported by the nginx-ingress mode", condition)
+			}
+			for _, c := range nginxConditions {
+				if !slices.Contains(conditions, c) {
+					conditions = append(conditions, c)
+				}
+			}
+		}
+		annotations["proxy-next-upstream"] = strings.Join(conditions, " ")
+	}
 
-	err = sigsClient.Get(ctx, types.NamespacedName{
-		Name:      resource.name,
-		Namespace: ns,
-	}, u)
+	mirror, err := k.getMirrorService(ctx, appName, ns, opts.MirrorTo)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return nil
+	if mirror != nil {
+		if opts.MirrorPercent != nil && *opts.MirrorPercent != 100 {
+			return nil, router.NewError(router.ErrorCodeUnsupported, "mirror-percent is not supported by the nginx-ingress mode")
+		}
+		annotations["mirror-target"] = fmt.Sprintf("http://%s.%s.svc.cluster.local:%d$request_uri", mirror.name, mirror.namespace, mirror.port)
+	}
+	return annotations, nil
 }
 
-func (s *IngressService) getCertManagerIssuerData(ctx context.Context, issuerName, namespace string) (CertManagerIssuerData, error) {
-	if strings.Contains(issuerName, ".") {
-		// Treat as external issuer since it's more general
-		parts := strings.SplitN(issuerName, ".", 3)
-		if len(parts) != 3 {
-			return CertManagerIssuerData{}, fmt.Errorf(errExternalIssuerInvalid, issuerName)
+// validateHeaderModifiers checks that the header modifiers can be written to
+// the nginx configuration snippet, only accepting names made of letters,
+// digits and dashes.
+func (k *IngressService) validateHeaderModifiers(modifiers ...*router.HeaderModifier) error {
+	for _, modifier := range modifiers {
+		if modifier == nil {
+			continue
 		}
-		cmIssuerData := CertManagerIssuerData{
-			name:       parts[0],
-			kind:       parts[1],
-			group:      parts[2],
-			issuerType: certManagerIssuerTypeExternalIssuer,
+		if k.AnnotationsPrefix != NginxAnnotationsPrefix {
+			return router.NewError(router.ErrorCodeUnsupported, "header modifiers are only supported by the nginx-ingress mode")
 		}
-
-		if err := s.validateCustomIssuer(ctx, cmIssuerData, namespace); err != nil {
-			return CertManagerIssuerData{}, fmt.Errorf(errExternalIssuerNotFound, issuerName, err.Error())
+		names := append(append(sortedKeys(modifier.Set), sortedKeys(modifier.Add)...), modifier.Remove...)
+		for _, name := range names {
+			if !nginxHeaderNameRegexp.MatchString(name) {
+				return router.NewError(router.ErrorCodeInvalidOptions, "invalid header name %q: only letters, digits and dashes are supported by the nginx-ingress mode", name)
+			}
 		}
-
-		return cmIssuerData, nil
 	}
+	return nil
+}
 
-	// Treat as CertManager issuer
-	cmClient, err := s.getCertManagerClient()
-	if err != nil {
-		return CertManagerIssuerData{}, err
+// headerModifiersSnippet returns the nginx configuration modifying the request
+// and response headers.
+func headerModifiersSnippet(request, response *router.HeaderModifier) string {
+	var lines []string
+	if request != nil {
+		for _, name := range sortedKeys(request.Set) {
+			lines = append(lines, fmt.Sprintf("proxy_set_header %s %s;", name, nginxQuote(request.Set[name])))
+		}
+		for _, name := range sortedKeys(request.Add) {
+			lines = append(lines, fmt.Sprintf("proxy_set_header %s %s;", name, nginxQuote(request.Add[name])))
+		}
+		for _, name := range request.Remove {
+			lines = append(lines, fmt.Sprintf(`proxy_set_header %s "";`, name))
+		}
 	}
-
-	_, err = cmClient.CertmanagerV1().Issuers(namespace).Get(ctx, issuerName, metav1.GetOptions{})
-	if err != nil && !k8sErrors.IsNotFound(err) {
-		return CertManagerIssuerData{}, err
+	if response != nil {
+		for _, name := range sortedKeys(response.Set) {
+			lines = append(lines, fmt.Sprintf("more_set_headers %s;", nginxQuote(name+": "+response.Set[name])))
+		}
+		for _, name := range sortedKeys(response.Add) {
+			lines = append(lines, fmt.Sprintf("add_header %s %s always;", name, nginxQuote(response.Add[name])))
+		}
+		for _, name := range response.Remove {
+			lines = append(lines, fmt.Sprintf("more_clear_headers %s;", nginxQuote(name)))
+		}
 	}
-
-	if err == nil {
-		return CertManagerIssuerData{
-			name:       issuerName,
-			issuerType: certManagerIssuerTypeIssuer,
-		}, nil
+	if len(lines) == 0 {
+		return ""
 	}
+	return strings.Join(lines, "\n") + "\n"
+}
 
-	// Check if it's a cluster issuer
-	_, err = cmClient.CertmanagerV1().ClusterIssuers().Get(ctx, issuerName, metav1.GetOptions{})
-	if err != nil && !k8sErrors.IsNotFound(err) {
-		return CertManagerIssuerData{}, err
-	}
+func nginxQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
 
-	if err == nil {
-		return CertManagerIssuerData{
-			name:       issuerName,
-			issuerType: certManagerIssuerTypeClusterIssuer,
-		}, nil
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-
-	// Issuer not found
-	return CertManagerIssuerData{}, fmt.Errorf(errIssuerNotFound, issuerName)
+	sort.Strings(keys)
+	return keys
 }
 
 func (s *IngressService) fillIngressTLS(i *networkingV1.Ingress, id router.InstanceID) {
@@ -987,59 +1637,6 @@ func (s *IngressService) fillIngressTLS(i *networkingV1.Ingress, id router.Insta
 	i.Spec.TLS = tlsRules
 }
 
-func ingressHasChanges(span opentracing.Span, existing *networkingV1.Ingress, ing *networkingV1.Ingress) (hasChanges bool) {
-	if !reflect.DeepEqual(existing.Spec, ing.Spec) {
-		span.LogKV(
-			"message", "ingress has changed the spec",
-			"ingress", existing.Name,
-		)
-		return true
-	}
-
-	if !reflect.DeepEqual(existing.OwnerReferences, ing.OwnerReferences) {
-		span.LogKV(
-			"message", "ingress has changed the ownerReferences",
-			"ingress", existing.Name,
-		)
-		return true
-	}
-
-	if existing.Annotations[AnnotationsCNames] != ing.Annotations[AnnotationsCNames] {
-		return true
-	}
-
-	for key, value := range ing.Annotations {
-		if existing.Annotations[key] != value {
-			span.LogKV(
-				"message", "ingress has changed the annotation",
-				"ingress", existing.Name,
-				"annotation", key,
-				"existingValue", existing.Annotations[key],
-				"newValue", value,
-			)
-
-			return true
-		}
-	}
-	for key, value := range ing.Labels {
-		if existing.Labels[key] != value {
-			span.LogKV(
-				"message", "ingress has changed the label",
-				"ingress", existing.Name,
-				"label", key,
-				"existingValue", existing.Labels[key],
-				"newValue", value,
-			)
-			return true
-		}
-	}
-	span.LogKV(
-		"message", "ingress has no changes",
-		"ingress", existing.Name,
-	)
-	return false
-}
-
 func isIngressReady(ingress *networkingV1.Ingress) bool {
 	if len(ingress.Status.LoadBalancer.Ingress) == 0 {
 		return false
@@ -6,14 +6,23 @@ package kubernetes
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"math/big"
 	"testing"
+	"time"
 
-	"github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tsuru/kubernetes-router/router"
+	tsuruv1 "github.com/tsuru/tsuru/provision/kubernetes/pkg/apis/tsuru/v1"
 	faketsuru "github.com/tsuru/tsuru/provision/kubernetes/pkg/client/clientset/versioned/fake"
 	v1 "k8s.io/api/core/v1"
 	networkingV1 "k8s.io/api/networking/v1"
@@ -26,6 +35,7 @@ import (
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
@@ -33,7 +43,7 @@ import (
 )
 
 func createFakeService(useIngressClassName bool) IngressService {
-	client := fake.NewSimpleClientset()
+	client := newFakeClientset()
 	err := createAppWebService(client, "default", "test")
 	if err != nil {
 		panic(err)
@@ -75,6 +85,30 @@ func createAppWebService(client kubernetes.Interface, namespace, appName string)
 	return err
 }
 
+func newTestCertificatePEM(t *testing.T, dnsNames []string, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func newTestCertData(t *testing.T, host string) router.CertData {
+	certPEM, keyPEM := newTestCertificatePEM(t, []string{host}, time.Now().Add(30*24*time.Hour))
+	return router.CertData{Certificate: string(certPEM), Key: string(keyPEM)}
+}
+
 func createCertManagerIssuer(client certmanagerv1clientset.Interface, namespace, name string) error {
 	_, err := client.CertmanagerV1().Issuers(namespace).Create(
 		context.TODO(),
@@ -151,8 +185,88 @@ func TestIngressEnsure(t *testing.T) {
 	assert.Equal(t, expectedIngress, ingressFound)
 }
 
+func TestIngressEnsureRetriesOnConflict(t *testing.T) {
+	svc := createFakeService(false)
+	applies := 0
+	svc.Client.(*fake.Clientset).PrependReactor("patch", "ingresses", concurrentApply(networkingV1.Resource("ingresses"), "kubernetes-router-test-ingress", &applies))
+	retriesBefore := conflictRetriesValue(t, "ingress")
+
+	err := svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
+		Team: "other-team",
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "test-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, applies)
+	assert.Equal(t, retriesBefore+2, conflictRetriesValue(t, "ingress"))
+	ingressFound, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-test-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "other-team", ingressFound.Labels["tsuru.io/app-team"])
+}
+
+func TestIngressEnsureServerSideApply(t *testing.T) {
+	svc := createFakeService(false)
+	opts := router.EnsureBackendOpts{
+		Opts: router.Opts{
+			AdditionalOpts: map[string]string{"my-opt": "value", "other-opt": "other-value"},
+		},
+		Team: "default",
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "test-web", Namespace: "default"}},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("test"), opts)
+	require.NoError(t, err)
+
+	ingressClient := svc.Client.NetworkingV1().Ingresses(svc.Namespace)
+	ingress, err := ingressClient.Get(ctx, "kubernetes-router-test-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	ingress.Annotations["external-annotation"] = "external"
+	ingress.Spec.DefaultBackend = &networkingV1.IngressBackend{
+		Service: &networkingV1.IngressServiceBackend{Name: "default-backend", Port: networkingV1.ServiceBackendPort{Number: 80}},
+	}
+	_, err = ingressClient.Update(ctx, ingress, metav1.UpdateOptions{FieldManager: "other-controller"})
+	require.NoError(t, err)
+
+	opts.Opts.AdditionalOpts = map[string]string{"my-opt": "new-value"}
+	err = svc.Ensure(ctx, idForApp("test"), opts)
+	require.NoError(t, err)
+
+	ingress, err = ingressClient.Get(ctx, "kubernetes-router-test-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"my-opt":              "new-value",
+		"external-annotation": "external",
+	}, ingress.Annotations)
+	require.NotNil(t, ingress.Spec.DefaultBackend)
+	assert.Equal(t, "default-backend", ingress.Spec.DefaultBackend.Service.Name)
+}
+
+func TestIngressEnsureUpgradesManagedFields(t *testing.T) {
+	svc := createFakeService(false)
+	// the managed fields of the objects read are kept by this clientset
+	svc.Client = fake.NewClientset()
+	require.NoError(t, createAppWebService(svc.Client, "default", "test"))
+	ingress := defaultIngress("test", "default")
+	ingress.Annotations["removed-opt"] = "value"
+	_, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Create(ctx, ingress, metav1.CreateOptions{FieldManager: fieldManager})
+	require.NoError(t, err)
+
+	err = svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "test-web", Namespace: "default"}},
+		},
+	})
+	require.NoError(t, err)
+
+	ingress, err = svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-test-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, ingress.Annotations)
+}
+
 func TestIngressEnsureWithMultipleBackends(t *testing.T) {
-	client := fake.NewSimpleClientset()
+	client := newFakeClientset()
 	err := createAppWebService(client, "default", "test")
 	require.NoError(t, err)
 	_, err = client.CoreV1().Services("default").Create(context.TODO(), &v1.Service{
@@ -287,7 +401,7 @@ func TestIngressEnsureWithMultipleBackends(t *testing.T) {
 }
 
 func TestIngressEnsureWithMultipleBackendsWithTLS(t *testing.T) {
-	client := fake.NewSimpleClientset()
+	client := newFakeClientset()
 	err := createAppWebService(client, "default", "test")
 	require.NoError(t, err)
 	_, err = client.CoreV1().Services("default").Create(context.TODO(), &v1.Service{
@@ -839,6 +953,8 @@ func TestEnsureCertManagerIssuerNotFound(t *testing.T) {
 	// cert-manager issuer not found
 	assert.Error(t, err)
 	assert.ErrorContains(t, err, fmt.Sprintf(errIssuerNotFound, "letsencrypt"))
+	assert.Equal(t, router.ErrorCodeInvalidIssuer, router.CodeOf(err))
+	assert.Equal(t, map[string]string{"issuer": "letsencrypt"}, router.DetailsOf(err))
 }
 
 func TestIngressCreateDefaultClass(t *testing.T) {
@@ -1027,11 +1143,13 @@ func TestIngressCreateDefaultPort(t *testing.T) {
 	err = createAppWebService(svc.Client, svc.Namespace, "myapp")
 	require.NoError(t, err)
 
-	svc.BaseService.Client.(*fake.Clientset).PrependReactor("create", "ingresses", func(action ktesting.Action) (bool, runtime.Object, error) {
-		newIng, ok := action.(ktesting.UpdateAction).GetObject().(*networkingV1.Ingress)
-		if !ok {
-			t.Errorf("Error creating ingress.")
+	svc.BaseService.Client.(*fake.Clientset).PrependReactor("patch", "ingresses", func(action ktesting.Action) (bool, runtime.Object, error) {
+		patch := action.(ktesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
 		}
+		var newIng networkingV1.Ingress
+		require.NoError(t, json.Unmarshal(patch.GetPatch(), &newIng))
 		port := newIng.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number
 		require.Equal(t, int32(8888), port)
 		return false, nil, nil
@@ -1053,48 +1171,37 @@ func TestIngressCreateDefaultPort(t *testing.T) {
 		},
 	})
 	require.NoError(t, err)
+
+	ingress, err := svc.Client.NetworkingV1().Ingresses("custom-namespace").Get(ctx, "kubernetes-router-myapp-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(8888), ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number)
 }
 
 func TestEnsureExistingIngress(t *testing.T) {
 	svc := createFakeService(false)
 	svcName := "test"
 	svcPort := 8000
-	resourceVersion := "123"
 	svc.Labels = map[string]string{"controller": "my-controller", "XPTO": "true"}
 	svc.Annotations = map[string]string{"ann1": "val1", "ann2": "val2"}
 
-	svc.BaseService.Client.(*fake.Clientset).PrependReactor("get", "ingresses", func(action ktesting.Action) (bool, runtime.Object, error) {
-		ingress := &networkingV1.Ingress{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:            svcName,
-				ResourceVersion: resourceVersion,
-			},
-			Spec: networkingV1.IngressSpec{
-				DefaultBackend: &networkingV1.IngressBackend{
-					Service: &networkingV1.IngressServiceBackend{
-						Name: svcName,
-						Port: networkingV1.ServiceBackendPort{Number: int32(svcPort)},
-					},
+	// the default backend is set by another manager and kept by the apply
+	_, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Create(ctx, &networkingV1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kubernetes-router-test-ingress",
+			Namespace: svc.Namespace,
+		},
+		Spec: networkingV1.IngressSpec{
+			DefaultBackend: &networkingV1.IngressBackend{
+				Service: &networkingV1.IngressServiceBackend{
+					Name: svcName,
+					Port: networkingV1.ServiceBackendPort{Number: int32(svcPort)},
 				},
 			},
-		}
-		return true, ingress, nil
-	})
-	svc.BaseService.Client.(*fake.Clientset).PrependReactor("update", "ingresses", func(action ktesting.Action) (bool, runtime.Object, error) {
-		newIng, ok := action.(ktesting.UpdateAction).GetObject().(*networkingV1.Ingress)
-		if !ok {
-			t.Fatalf("Error updating ingress.")
-		}
-		if newIng.ObjectMeta.ResourceVersion != resourceVersion {
-			t.Errorf("Expected ResourceVersion %q. Got %s", resourceVersion, newIng.ObjectMeta.ResourceVersion)
-		}
-		if newIng.Spec.DefaultBackend == nil || newIng.Spec.DefaultBackend.Service.Name != svcName || newIng.Spec.DefaultBackend.Service.Port.Number != int32(svcPort) {
-			t.Errorf("Expected Backend with name %q and port %d. Got %v", svcName, svcPort, newIng.Spec.DefaultBackend)
-		}
-		return true, newIng, nil
-	})
+		},
+	}, metav1.CreateOptions{FieldManager: "my-controller"})
+	require.NoError(t, err)
 
-	err := svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
+	err = svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
 		Opts: router.Opts{
 			Pool: "mypool",
 			AdditionalOpts: map[string]string{
@@ -1111,6 +1218,15 @@ func TestEnsureExistingIngress(t *testing.T) {
 		},
 	})
 	require.NoError(t, err)
+
+	ingress, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-test-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	if ingress.Spec.DefaultBackend == nil || ingress.Spec.DefaultBackend.Service.Name != svcName || ingress.Spec.DefaultBackend.Service.Port.Number != int32(svcPort) {
+		t.Errorf("Expected Backend with name %q and port %d. Got %v", svcName, svcPort, ingress.Spec.DefaultBackend)
+	}
+	assert.Equal(t, "val1", ingress.Annotations["ann1"])
+	require.Len(t, ingress.Spec.Rules, 1)
+	assert.Equal(t, "test-web", ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name)
 }
 
 func TestEnsureExistingIngressWithFreeze(t *testing.T) {
@@ -1145,7 +1261,7 @@ func TestEnsureExistingIngressWithFreeze(t *testing.T) {
 	})
 
 	called := false
-	svc.BaseService.Client.(*fake.Clientset).PrependReactor("update", "ingresses", func(action ktesting.Action) (bool, runtime.Object, error) {
+	svc.BaseService.Client.(*fake.Clientset).PrependReactor("patch", "ingresses", func(action ktesting.Action) (bool, runtime.Object, error) {
 		called = true
 		return true, nil, errors.New("must never called")
 	})
@@ -1293,7 +1409,7 @@ func TestIngressGetAddressTLS(t *testing.T) {
 }
 
 func TestIngressGetMultipleAddresses(t *testing.T) {
-	client := fake.NewSimpleClientset()
+	client := newFakeClientset()
 	err := createAppWebService(client, "default", "test")
 	require.NoError(t, err)
 	_, err = client.CoreV1().Services("default").Create(context.TODO(), &v1.Service{
@@ -1414,7 +1530,7 @@ func TestRemoveCertificate(t *testing.T) {
 		},
 	})
 	require.NoError(t, err)
-	expectedCert := router.CertData{Certificate: "Certz", Key: "keyz"}
+	expectedCert := newTestCertData(t, "test-blue.mycloud.com")
 	err = svc.AddCertificate(ctx, idForApp("test-blue"), "test-blue.mycloud.com", expectedCert)
 	require.NoError(t, err)
 	err = svc.RemoveCertificate(ctx, idForApp("test-blue"), "test-blue.mycloud.com")
@@ -1458,7 +1574,7 @@ func TestAddCertificate(t *testing.T) {
 		},
 	})
 	require.NoError(t, err)
-	expectedCert := router.CertData{Certificate: "Certz", Key: "keyz"}
+	expectedCert := newTestCertData(t, "test-blue.mycloud.com")
 	err = svc.AddCertificate(ctx, idForApp("test-blue"), "test-blue.mycloud.com", expectedCert)
 	require.NoError(t, err)
 
@@ -1490,6 +1606,37 @@ func TestAddCertificate(t *testing.T) {
 	assert.Equal(t, certTest.Spec.TLS, ingress.Spec.TLS)
 }
 
+func TestCertificatesRetriesOnConflict(t *testing.T) {
+	svc := createFakeService(false)
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "test-blue"))
+	err := svc.Ensure(ctx, idForApp("test-blue"), router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "test-blue-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+	applies := 0
+	svc.Client.(*fake.Clientset).PrependReactor("patch", "ingresses", concurrentApply(networkingV1.Resource("ingresses"), "kubernetes-router-test-blue-ingress", &applies))
+	retriesBefore := conflictRetriesValue(t, "ingress")
+
+	err = svc.AddCertificate(ctx, idForApp("test-blue"), "test-blue.mycloud.com", newTestCertData(t, "test-blue.mycloud.com"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, applies)
+	assert.Equal(t, retriesBefore+2, conflictRetriesValue(t, "ingress"))
+	ingress, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-test-blue-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, ingress.Spec.TLS, 1)
+
+	applies = 0
+	err = svc.RemoveCertificate(ctx, idForApp("test-blue"), "test-blue.mycloud.com")
+	require.NoError(t, err)
+	assert.Equal(t, 3, applies)
+	assert.Equal(t, retriesBefore+4, conflictRetriesValue(t, "ingress"))
+	ingress, err = svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-test-blue-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, ingress.Spec.TLS)
+}
+
 func TestAddCertificateACMEHandled(t *testing.T) {
 	svc := createFakeService(false)
 	err := createAppWebService(svc.Client, svc.Namespace, "test-blue")
@@ -1514,6 +1661,33 @@ func TestAddCertificateACMEHandled(t *testing.T) {
 
 }
 
+func TestAddCertificateInvalid(t *testing.T) {
+	svc := createFakeService(false)
+	err := createAppWebService(svc.Client, svc.Namespace, "test-blue")
+	require.NoError(t, err)
+	err = svc.Ensure(ctx, idForApp("test-blue"), router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{
+				Target: router.BackendTarget{
+					Service:   "test-blue-web",
+					Namespace: svc.Namespace,
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	err = svc.AddCertificate(ctx, idForApp("test-blue"), "test-blue.mycloud.com", newTestCertData(t, "other.mycloud.com"))
+	assert.EqualError(t, err, "invalid certificate: certificate is not valid for test-blue.mycloud.com, it is valid for other.mycloud.com")
+	var certErr *router.InvalidCertificateError
+	assert.ErrorAs(t, err, &certErr)
+
+	_, err = svc.Client.CoreV1().Secrets(svc.Namespace).Get(ctx, svc.secretName(idForApp("test-blue"), "test-blue.mycloud.com"), metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+	ingress, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-test-blue-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, ingress.Spec.TLS)
+}
+
 func TestAddCertificateWithOverride(t *testing.T) {
 	svc := createFakeService(false)
 	err := createAppWebService(svc.Client, svc.Namespace, "test-blue")
@@ -1529,8 +1703,8 @@ func TestAddCertificateWithOverride(t *testing.T) {
 		},
 	})
 	require.NoError(t, err)
-	firstCert := router.CertData{Certificate: "FirstCert", Key: "FirstKey"}
-	expectedCert := router.CertData{Certificate: "Certz", Key: "keyz"}
+	firstCert := newTestCertData(t, "test-blue.mycloud.com")
+	expectedCert := newTestCertData(t, "test-blue.mycloud.com")
 	err = svc.AddCertificate(ctx, idForApp("test-blue"), "test-blue.mycloud.com", firstCert)
 	require.NoError(t, err)
 
@@ -1575,7 +1749,7 @@ func TestAddCertificateWithCName(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	expectedCert := router.CertData{Certificate: "Certz", Key: "keyz"}
+	expectedCert := newTestCertData(t, "mydomain.com")
 	err = svc.AddCertificate(ctx, idForApp("test-blue"), "mydomain.com", expectedCert)
 	require.NoError(t, err)
 
@@ -1633,7 +1807,7 @@ func TestGetCertificate(t *testing.T) {
 		},
 	})
 	require.NoError(t, err)
-	expectedCert := router.CertData{Certificate: "Certz", Key: "keyz"}
+	expectedCert := newTestCertData(t, "test-blue.mycloud.com")
 	err = svc.AddCertificate(ctx, idForApp("test-blue"), "test-blue.mycloud.com", expectedCert)
 	require.NoError(t, err)
 
@@ -1655,6 +1829,90 @@ func TestGetCertificate(t *testing.T) {
 	assert.Equal(t, &router.CertData{Certificate: "", Key: ""}, cert)
 }
 
+func TestIngressListCertificates(t *testing.T) {
+	svc := createFakeService(false)
+	err := createAppWebService(svc.Client, svc.Namespace, "test-blue")
+	require.NoError(t, err)
+	err = createCertManagerClusterIssuer(svc.CertManagerClient, "letsencrypt")
+	require.NoError(t, err)
+	err = svc.Ensure(ctx, idForApp("test-blue"), router.EnsureBackendOpts{
+		Team: "default",
+		Prefixes: []router.BackendPrefix{
+			{
+				Target: router.BackendTarget{
+					Service:   "test-blue-web",
+					Namespace: svc.Namespace,
+				},
+			},
+		},
+		CNames: []string{"mydomain.com"},
+		CertIssuers: map[string]string{
+			"mydomain.com": "letsencrypt",
+		},
+	})
+	require.NoError(t, err)
+	notAfter := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second).UTC()
+	certPEM, keyPEM := newTestCertificatePEM(t, []string{"test-blue.mycloud.com"}, notAfter)
+	err = svc.AddCertificate(ctx, idForApp("test-blue"), "test-blue.mycloud.com", router.CertData{Certificate: string(certPEM), Key: string(keyPEM)})
+	require.NoError(t, err)
+	// the fake client does not convert StringData to Data
+	secretName := svc.secretName(idForApp("test-blue"), "test-blue.mycloud.com")
+	_, err = svc.Client.CoreV1().Secrets(svc.Namespace).Update(ctx, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: svc.Namespace},
+		Type:       v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       certPEM,
+			v1.TLSPrivateKeyKey: keyPEM,
+		},
+	}, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	_, err = svc.CertManagerClient.CertmanagerV1().Certificates(svc.Namespace).Create(ctx, &certmanagerv1.Certificate{
+		ObjectMeta: metav1.ObjectMeta{Name: "kr-test-blue-mydomain.com"},
+		Status: certmanagerv1.CertificateStatus{
+			Conditions: []certmanagerv1.CertificateCondition{
+				{
+					Type:    certmanagerv1.CertificateConditionReady,
+					Status:  "False",
+					Reason:  "Pending",
+					Message: "Issuing certificate as Secret does not exist",
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	certificates, err := svc.ListCertificates(ctx, idForApp("test-blue"))
+	require.NoError(t, err)
+	require.Len(t, certificates, 2)
+
+	assert.Equal(t, "test-blue.mycloud.com", certificates[0].Host)
+	assert.Equal(t, router.CertificateSourceManual, certificates[0].Source)
+	assert.Equal(t, secretName, certificates[0].SecretName)
+	assert.Equal(t, "CN=test-blue.mycloud.com", certificates[0].Subject)
+	require.NotNil(t, certificates[0].NotAfter)
+	assert.Equal(t, notAfter, certificates[0].NotAfter.UTC())
+	assert.True(t, certificates[0].ValidChain)
+	assert.Empty(t, certificates[0].Error)
+	assert.Nil(t, certificates[0].Ready)
+
+	assert.Equal(t, router.CertificateInfo{
+		Host:       "mydomain.com",
+		Source:     router.CertificateSourceCertManager,
+		Issuer:     "letsencrypt",
+		SecretName: "kr-test-blue-mydomain.com",
+		Error:      "secret kr-test-blue-mydomain.com not found",
+		Ready: &router.CertificateCondition{
+			Status:  "False",
+			Reason:  "Pending",
+			Message: "Issuing certificate as Secret does not exist",
+		},
+	}, certificates[1])
+
+	certificates, err = svc.ListCertificates(ctx, idForApp("other-app"))
+	require.NoError(t, err)
+	assert.Empty(t, certificates)
+}
+
 func TestEnsureWithTLSAndCName(t *testing.T) {
 	svc := createFakeService(false)
 	err := svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
@@ -1711,6 +1969,7 @@ func TestEnsureWithTLSAndCName(t *testing.T) {
 	expectedIngress.Labels["router.tsuru.io/is-cname-ingress"] = "true"
 	expectedIngress.Labels["tsuru.io/app-name"] = "test"
 	expectedIngress.Labels["tsuru.io/app-team"] = "default"
+	expectedIngress.Annotations = nil
 
 	assert.Equal(t, expectedIngress, foundIngress)
 }
@@ -1834,34 +2093,462 @@ func defaultIngress(name, namespace string) *networkingV1.Ingress {
 	}
 }
 
-func TestIngressHasChanges(t *testing.T) {
-	span := opentracing.NoopTracer{}.StartSpan("test")
-	existing := &networkingV1.Ingress{
-		ObjectMeta: metav1.ObjectMeta{
-			OwnerReferences: []metav1.OwnerReference{
-				{
-					APIVersion:         "v1",
-					Kind:               "Service",
-					Name:               "test-web",
-					BlockOwnerDeletion: ptr.To(true),
-					Controller:         ptr.To(true),
+func ensureIngressSwapApps(t *testing.T, svc IngressService) {
+	for _, app := range []string{"app1", "app2"} {
+		err := createAppWebService(svc.Client, svc.Namespace, app)
+		require.NoError(t, err)
+		err = svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			CNames: []string{app + ".io"},
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: app + "-web", Namespace: svc.Namespace}},
+			},
+		})
+		require.NoError(t, err)
+	}
+}
+
+func ingressBackendService(t *testing.T, svc IngressService, name string) string {
+	ingress, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, ingress.Spec.Rules, 1)
+	return ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name
+}
+
+func TestIngressSwap(t *testing.T) {
+	svc := createFakeService(false)
+	ensureIngressSwapApps(t, svc)
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.NoError(t, err)
+	assert.Equal(t, "app2-web", ingressBackendService(t, svc, "kubernetes-router-app1-ingress"))
+	assert.Equal(t, "app1-web", ingressBackendService(t, svc, "kubernetes-router-app2-ingress"))
+	assert.Equal(t, "app2-web", ingressBackendService(t, svc, "kubernetes-router-cname-app1.io"))
+	assert.Equal(t, "app1-web", ingressBackendService(t, svc, "kubernetes-router-cname-app2.io"))
+
+	app1, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-app1-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2", app1.Labels[swappedWithLabel])
+	assert.Equal(t, "app2-web", app1.Labels[appBaseServiceNameLabel])
+
+	// Ensure keeps the swapped backends
+	err = svc.Ensure(ctx, idForApp("app1"), router.EnsureBackendOpts{
+		CNames: []string{"app1.io"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "app1-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "app2-web", ingressBackendService(t, svc, "kubernetes-router-app1-ingress"))
+	assert.Equal(t, "app2-web", ingressBackendService(t, svc, "kubernetes-router-cname-app1.io"))
+
+	// swapping back restores the original backends
+	err = svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.NoError(t, err)
+	assert.Equal(t, "app1-web", ingressBackendService(t, svc, "kubernetes-router-app1-ingress"))
+	assert.Equal(t, "app2-web", ingressBackendService(t, svc, "kubernetes-router-app2-ingress"))
+	assert.Equal(t, "app1-web", ingressBackendService(t, svc, "kubernetes-router-cname-app1.io"))
+	app1, err = svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-app1-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, app1.Labels, swappedWithLabel)
+	assert.Equal(t, "app1-web", app1.Labels[appBaseServiceNameLabel])
+}
+
+func TestIngressSwapCNameOnly(t *testing.T) {
+	svc := createFakeService(false)
+	ensureIngressSwapApps(t, svc)
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), true)
+	require.NoError(t, err)
+	assert.Equal(t, "app1-web", ingressBackendService(t, svc, "kubernetes-router-app1-ingress"))
+	assert.Equal(t, "app2-web", ingressBackendService(t, svc, "kubernetes-router-app2-ingress"))
+	assert.Equal(t, "app2-web", ingressBackendService(t, svc, "kubernetes-router-cname-app1.io"))
+	assert.Equal(t, "app1-web", ingressBackendService(t, svc, "kubernetes-router-cname-app2.io"))
+
+	app1, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-app1-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2.io", app1.Annotations[AnnotationsCNames])
+	assert.NotContains(t, app1.Labels, swappedWithLabel)
+
+	cname, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-cname-app1.io", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2", cname.Labels[appLabel])
+	require.Len(t, cname.OwnerReferences, 1)
+	assert.Equal(t, "kubernetes-router-app2-ingress", cname.OwnerReferences[0].Name)
+}
+
+func TestIngressSwapRollback(t *testing.T) {
+	svc := createFakeService(false)
+	ensureIngressSwapApps(t, svc)
+	svc.Client.(*fake.Clientset).PrependReactor("patch", "ingresses", failApply("kubernetes-router-cname-app2.io"))
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.ErrorContains(t, err, "apply failed")
+
+	// the ingresses swapped before the failure are restored
+	assert.Equal(t, "app1-web", ingressBackendService(t, svc, "kubernetes-router-app1-ingress"))
+	assert.Equal(t, "app2-web", ingressBackendService(t, svc, "kubernetes-router-app2-ingress"))
+	assert.Equal(t, "app1-web", ingressBackendService(t, svc, "kubernetes-router-cname-app1.io"))
+	assert.Equal(t, "app2-web", ingressBackendService(t, svc, "kubernetes-router-cname-app2.io"))
+	app1, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-app1-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, app1.Labels, swappedWithLabel)
+	assert.Equal(t, "app1-web", app1.Labels[appBaseServiceNameLabel])
+}
+
+func ensureIngressSwapPrefixApps(t *testing.T, svc IngressService, prefixes map[string][]string) {
+	for _, app := range []string{"app1", "app2"} {
+		err := createAppWebService(svc.Client, svc.Namespace, app)
+		require.NoError(t, err)
+		backendPrefixes := []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: app + "-web", Namespace: svc.Namespace}},
+		}
+		for _, prefix := range prefixes[app] {
+			_, err = svc.Client.CoreV1().Services(svc.Namespace).Create(ctx, &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: app + "-" + prefix},
+				Spec: v1.ServiceSpec{
+					Selector: map[string]string{"tsuru.io/app-name": app, "tsuru.io/app-process": prefix},
+					Ports:    []v1.ServicePort{{Protocol: "TCP", Port: defaultServicePort, TargetPort: intstr.FromInt(defaultServicePort)}},
+				},
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+			backendPrefixes = append(backendPrefixes, router.BackendPrefix{
+				Prefix: prefix + ".process",
+				Target: router.BackendTarget{Service: app + "-" + prefix, Namespace: svc.Namespace},
+			})
+		}
+		err = svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			Opts:     router.Opts{ExposeAllServices: true},
+			Prefixes: backendPrefixes,
+		})
+		require.NoError(t, err)
+	}
+}
+
+func ingressRuleServices(t *testing.T, svc IngressService, name string) map[string]string {
+	ingress, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, name, metav1.GetOptions{})
+	require.NoError(t, err)
+	services := map[string]string{}
+	for _, rule := range ingress.Spec.Rules {
+		services[rule.Host] = rule.HTTP.Paths[0].Backend.Service.Name
+	}
+	return services
+}
+
+func TestIngressSwapPrefixes(t *testing.T) {
+	svc := createFakeService(false)
+	svc.DomainSuffix = "mycloud.com"
+	ensureIngressSwapPrefixApps(t, svc, map[string][]string{"app1": {"worker"}, "app2": {"worker"}})
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"app1.mycloud.com":                "app2-web",
+		"worker.process.app1.mycloud.com": "app2-worker",
+	}, ingressRuleServices(t, svc, "kubernetes-router-app1-ingress"))
+	assert.Equal(t, map[string]string{
+		"app2.mycloud.com":                "app1-web",
+		"worker.process.app2.mycloud.com": "app1-worker",
+	}, ingressRuleServices(t, svc, "kubernetes-router-app2-ingress"))
+
+	err = svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"app1.mycloud.com":                "app1-web",
+		"worker.process.app1.mycloud.com": "app1-worker",
+	}, ingressRuleServices(t, svc, "kubernetes-router-app1-ingress"))
+}
+
+func TestIngressSwapDifferentPrefixes(t *testing.T) {
+	svc := createFakeService(false)
+	ensureIngressSwapPrefixApps(t, svc, map[string][]string{"app1": {"worker"}})
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.Error(t, err)
+	assert.Equal(t, router.ErrorCodeUnsupported, router.CodeOf(err))
+	assert.Equal(t, "app1-web", ingressRuleServices(t, svc, "kubernetes-router-app1-ingress")["app1.mycloud.com"])
+	assert.Equal(t, "app2-web", ingressBackendService(t, svc, "kubernetes-router-app2-ingress"))
+}
+
+func TestIngressSwapDifferentNamespaces(t *testing.T) {
+	svc := createFakeService(false)
+	err := createCRD(svc.BaseService, "app1", "namespace1", nil)
+	require.NoError(t, err)
+	_, err = svc.TsuruClient.TsuruV1().Apps(svc.Namespace).Create(ctx, &tsuruv1.App{
+		ObjectMeta: metav1.ObjectMeta{Name: "app2"},
+		Spec:       tsuruv1.AppSpec{NamespaceName: "namespace2"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	err = svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	assert.Equal(t, ErrSwapDifferentNamespaces, err)
+}
+
+func TestIngressEnsureWeightedTargets(t *testing.T) {
+	svc := createFakeService(false)
+	svc.AnnotationsPrefix = "nginx.ingress.kubernetes.io"
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "test-canary"))
+
+	opts := router.EnsureBackendOpts{
+		CNames: []string{"test.io"},
+		Prefixes: []router.BackendPrefix{
+			{
+				Target: router.BackendTarget{Service: "test-web", Namespace: svc.Namespace},
+				WeightedTargets: []router.WeightedBackendTarget{
+					{BackendTarget: router.BackendTarget{Service: "test-canary-web", Namespace: svc.Namespace}, Weight: 25},
 				},
 			},
 		},
 	}
-	ing := &networkingV1.Ingress{
-		ObjectMeta: metav1.ObjectMeta{
-			OwnerReferences: []metav1.OwnerReference{
-				{
-					APIVersion:         "networking.k8s.io/v1",
-					Kind:               "Ingress",
-					Name:               "test-web",
-					BlockOwnerDeletion: ptr.To(true),
-					Controller:         ptr.To(true),
+	err := svc.Ensure(ctx, idForApp("test"), opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, "test-web", ingressBackendService(t, svc, "kubernetes-router-test-ingress"))
+	canary, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-test-canary-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "true", canary.Annotations["nginx.ingress.kubernetes.io/canary"])
+	assert.Equal(t, "25", canary.Annotations["nginx.ingress.kubernetes.io/canary-weight"])
+	assert.Equal(t, "true", canary.Labels[labelCanaryIngress])
+	assert.Equal(t, "test-canary-web", canary.Labels[appBaseServiceNameLabel])
+	require.Len(t, canary.OwnerReferences, 1)
+	assert.Equal(t, "kubernetes-router-test-ingress", canary.OwnerReferences[0].Name)
+	var hosts []string
+	for _, rule := range canary.Spec.Rules {
+		hosts = append(hosts, rule.Host)
+		assert.Equal(t, "test-canary-web", rule.HTTP.Paths[0].Backend.Service.Name)
+	}
+	assert.ElementsMatch(t, []string{"test.mycloud.com", "test.io"}, hosts)
+
+	// removing the weighted targets removes the canary ingress
+	opts.Prefixes[0].WeightedTargets = nil
+	err = svc.Ensure(ctx, idForApp("test"), opts)
+	require.NoError(t, err)
+	_, err = svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-test-canary-ingress", metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+}
+
+func TestIngressEnsureWeightedTargetsUnsupported(t *testing.T) {
+	svc := createFakeService(false)
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "test-canary"))
+	opts := router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{
+				Target: router.BackendTarget{Service: "test-web", Namespace: svc.Namespace},
+				WeightedTargets: []router.WeightedBackendTarget{
+					{BackendTarget: router.BackendTarget{Service: "test-canary-web", Namespace: svc.Namespace}, Weight: 25},
 				},
 			},
 		},
 	}
+	err := svc.Ensure(ctx, idForApp("test"), opts)
+	assert.Equal(t, router.NewError(router.ErrorCodeUnsupported, "weighted targets are only supported by the nginx-ingress mode"), err)
+
+	// other ingress controllers do not have the canary annotations of nginx
+	svc.AnnotationsPrefix = "traefik.ingress.kubernetes.io"
+	err = svc.Ensure(ctx, idForApp("test"), opts)
+	assert.Equal(t, router.NewError(router.ErrorCodeUnsupported, "weighted targets are only supported by the nginx-ingress mode"), err)
+
+	svc.AnnotationsPrefix = "nginx.ingress.kubernetes.io"
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "test-canary2"))
+	opts.Prefixes[0].WeightedTargets = append(opts.Prefixes[0].WeightedTargets, router.WeightedBackendTarget{
+		BackendTarget: router.BackendTarget{Service: "test-canary2-web", Namespace: svc.Namespace}, Weight: 10,
+	})
+	err = svc.Ensure(ctx, idForApp("test"), opts)
+	assert.ErrorContains(t, err, "support a single one")
+}
+
+func TestIngressEnsureHeaderModifiers(t *testing.T) {
+	svc := createFakeService(false)
+	svc.AnnotationsPrefix = "nginx.ingress.kubernetes.io"
+	err := svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
+		Opts: router.Opts{
+			AdditionalOpts: map[string]string{"configuration-snippet": "more_set_headers \"X-Custom: 1\";"},
+			RequestHeaders: &router.HeaderModifier{
+				Set:    map[string]string{"X-Forwarded-Proto": "https"},
+				Remove: []string{"X-Internal"},
+			},
+			ResponseHeaders: &router.HeaderModifier{
+				Set:    map[string]string{"Content-Security-Policy": `default-src 'self'; script-src "x"`},
+				Add:    map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+				Remove: []string{"Server"},
+			},
+		},
+		CNames: []string{"test.io"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "test-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+
+	expected := `more_set_headers "X-Custom: 1";
+proxy_set_header X-Forwarded-Proto "https";
+proxy_set_header X-Internal "";
+more_set_headers "Content-Security-Policy: default-src 'self'; script-src \"x\"";
+add_header Strict-Transport-Security "max-age=31536000" always;
+more_clear_headers "Server";
+`
+	for _, name := range []string{"kubernetes-router-test-ingress", "kubernetes-router-cname-test.io"} {
+		ingress, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, expected, ingress.Annotations["nginx.ingress.kubernetes.io/configuration-snippet"])
+	}
+}
+
+func TestIngressEnsureHeaderModifiersUnsupported(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		opts     router.Opts
+		expected string
+	}{
+		{
+			opts:     router.Opts{ResponseHeaders: &router.HeaderModifier{Remove: []string{"Server"}}},
+			expected: "only supported by the nginx-ingress mode",
+		},
+		{
+			prefix:   "ingress.kubernetes.io",
+			opts:     router.Opts{RequestHeaders: &router.HeaderModifier{Set: map[string]string{"X-Env": "prod"}}},
+			expected: "only supported by the nginx-ingress mode",
+		},
+		{
+			prefix:   NginxAnnotationsPrefix,
+			opts:     router.Opts{RequestHeaders: &router.HeaderModifier{Set: map[string]string{"X-Env;": "prod"}}},
+			expected: `invalid header name "X-Env;"`,
+		},
+		{
+			prefix:   NginxAnnotationsPrefix,
+			opts:     router.Opts{ResponseHeaders: &router.HeaderModifier{Add: map[string]string{"X-$host": "1"}}},
+			expected: `invalid header name "X-$host"`,
+		},
+	}
+	for _, tt := range tests {
+		svc := createFakeService(false)
+		svc.AnnotationsPrefix = tt.prefix
+		err := svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
+			Opts: tt.opts,
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: "test-web", Namespace: svc.Namespace}},
+			},
+		})
+		assert.ErrorContains(t, err, tt.expected)
+	}
+}
+
+func TestIngressSupportedOptions(t *testing.T) {
+	svc := createFakeService(false)
+	svc.AnnotationsPrefix = "ingress.kubernetes.io"
+	options := svc.SupportedOptions(ctx)
+	assert.NotContains(t, options, router.RequestHeaders)
+	assert.NotContains(t, options, router.ResponseHeaders)
+	for _, opt := range []string{router.Timeout, router.Retries, router.RetryOn, router.MirrorTo} {
+		assert.NotContains(t, options, opt)
+	}
+	assert.Equal(t, "Ingress class for the Ingress object", options["class"])
+
+	svc.AnnotationsPrefix = NginxAnnotationsPrefix
+	options = svc.SupportedOptions(ctx)
+	assert.Contains(t, options[router.RequestHeaders], "allow-snippet-annotations")
+	assert.Contains(t, options[router.ResponseHeaders], "allow-snippet-annotations")
+	for _, opt := range []string{router.Timeout, router.Retries, router.RetryOn, router.MirrorTo} {
+		assert.Contains(t, options, opt)
+	}
+}
+
+func TestIngressEnsureTrafficPolicies(t *testing.T) {
+	svc := createFakeService(false)
+	svc.AnnotationsPrefix = "nginx.ingress.kubernetes.io"
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "shadow"))
+
+	retries := 2
+	err := svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
+		Opts: router.Opts{
+			Timeout:        "1m500ms",
+			Retries:        &retries,
+			RetryOn:        []string{"gateway-error", "5xx", "connect-failure"},
+			MirrorTo:       "shadow-web",
+			AdditionalOpts: map[string]string{"proxy-send-timeout": "5"},
+		},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "test-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+
+	ingress, err := svc.Client.NetworkingV1().Ingresses(svc.Namespace).Get(ctx, "kubernetes-router-test-ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "61", ingress.Annotations["nginx.ingress.kubernetes.io/proxy-read-timeout"])
+	assert.Equal(t, "5", ingress.Annotations["nginx.ingress.kubernetes.io/proxy-send-timeout"])
+	assert.Equal(t, "3", ingress.Annotations["nginx.ingress.kubernetes.io/proxy-next-upstream-tries"])
+	assert.Equal(t, "http_502 http_503 http_504 http_500 error timeout", ingress.Annotations["nginx.ingress.kubernetes.io/proxy-next-upstream"])
+	assert.Equal(t, "http://shadow-web.default.svc.cluster.local:8888$request_uri", ingress.Annotations["nginx.ingress.kubernetes.io/mirror-target"])
+}
+
+func TestIngressEnsureTrafficPoliciesUnsupported(t *testing.T) {
+	svc := createFakeService(false)
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "shadow"))
+	percent := int32(50)
+	tests := []struct {
+		prefix   string
+		opts     router.Opts
+		expected string
+	}{
+		{opts: router.Opts{Timeout: "10s"}, expected: "only supported by the nginx-ingress mode"},
+		{prefix: "ingress.kubernetes.io", opts: router.Opts{Timeout: "10s"}, expected: "only supported by the nginx-ingress mode"},
+		{prefix: "ingress.kubernetes.io", opts: router.Opts{MirrorTo: "shadow-web"}, expected: "only supported by the nginx-ingress mode"},
+		{prefix: "nginx.ingress.kubernetes.io", opts: router.Opts{RetryOn: []string{"retriable-4xx"}}, expected: `retry-on condition "retriable-4xx" is not supported`},
+		{prefix: "nginx.ingress.kubernetes.io", opts: router.Opts{MirrorTo: "shadow-web", MirrorPercent: &percent}, expected: "mirror-percent is not supported"},
+	}
+	for _, tt := range tests {
+		svc.AnnotationsPrefix = tt.prefix
+		err := svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
+			Opts: tt.opts,
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: "test-web", Namespace: svc.Namespace}},
+			},
+		})
+		assert.ErrorContains(t, err, tt.expected)
+	}
+}
 
-	assert.True(t, ingressHasChanges(span, existing, ing))
+func TestIngressPlanEnsure(t *testing.T) {
+	server := newFakeAPIServer(t, map[string]string{
+		"/api/v1/namespaces/default/services/myapp-web": webServiceObject("myapp"),
+	})
+	defer server.Close()
+	svc := &IngressService{
+		DomainSuffix:        "mycloud.com",
+		IngressClass:        "nginx",
+		UseIngressClassName: true,
+		BaseService:         &BaseService{Namespace: "default", RestConfig: server.restConfig()},
+	}
+	opts := router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	server.resetPersisted()
+	objects := server.snapshot()
+
+	opts.Opts.Domain = "myapp.io"
+	opts.CNames = []string{"www.myapp.io"}
+	plan, err := svc.PlanEnsure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+
+	// Assert: the main ingress is updated and the cname one created.
+	assert.Equal(t, []string{
+		"update Ingress default/kubernetes-router-myapp-ingress",
+		"create Ingress default/kubernetes-router-cname-www.myapp.io",
+	}, planChanges(plan))
+	diff := planDiff(t, plan, "Ingress", "kubernetes-router-myapp-ingress")
+	assert.Contains(t, diff, router.FieldChange{Path: `metadata.annotations["router.tsuru.io/cnames"]`, New: "www.myapp.io"})
+	require.Len(t, diff, 2)
+	assert.Equal(t, "spec.rules", diff[1].Path)
+	assert.Equal(t, "myapp.mycloud.com", diff[1].Old.([]interface{})[0].(map[string]interface{})["host"])
+	assert.Equal(t, "myapp.io", diff[1].New.([]interface{})[0].(map[string]interface{})["host"])
+	assert.Contains(t, planDiff(t, plan, "Ingress", "kubernetes-router-cname-www.myapp.io"),
+		router.FieldChange{Path: "spec.ingressClassName", New: "nginx"})
+
+	// Assert: nothing was written.
+	assert.Empty(t, server.persisted)
+	assert.Equal(t, objects, server.snapshot())
 }
@@ -6,25 +6,55 @@ package kubernetes
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/gogo/protobuf/types"
 	"github.com/tsuru/kubernetes-router/router"
+	analysisv1alpha1 "istio.io/api/analysis/v1alpha1"
 	apiNetworking "istio.io/api/networking/v1beta1"
 	networking "istio.io/client-go/pkg/apis/networking/v1beta1"
 	networkingClientSet "istio.io/client-go/pkg/clientset/versioned/typed/networking/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 const (
-	hostsAnnotation = "tsuru.io/additional-hosts"
+	hostsAnnotation                = "tsuru.io/additional-hosts"
+	weightedDestinationsAnnotation = "router.tsuru.io/weighted-destinations"
+	headerModifiersAnnotation      = "router.tsuru.io/header-modifiers"
+	trafficPoliciesAnnotation      = "router.tsuru.io/traffic-policies"
+	acmeHostsAnnotation            = "router.tsuru.io/acme-hosts"
+
+	// prefixRouteNamePrefix is the prefix of the names of the routes of the
+	// app prefixes exposed with all-prefixes
+	prefixRouteNamePrefix = "prefix-"
+
+	// DefaultIstioGatewayNamespace is the namespace of the istio ingress
+	// gateway used when none is set
+	DefaultIstioGatewayNamespace = "istio-system"
 )
 
 var (
-	_ router.Router = &IstioGateway{}
+	_ router.Router       = &IstioGateway{}
+	_ router.RouterSwap   = &IstioGateway{}
+	_ router.RouterStatus = &IstioGateway{}
+	_ router.RouterTLS    = &IstioGateway{}
+	_ router.RouterPlan   = &IstioGateway{}
+
+	virtualServiceGVK = networking.SchemeGroupVersion.WithKind("VirtualService")
+	istioGatewayGVK   = networking.SchemeGroupVersion.WithKind("Gateway")
+	certificateGVK    = certmanagerv1.SchemeGroupVersion.WithKind("Certificate")
 )
 
 // IstioGateway manages gateways in a Kubernetes cluster with istio enabled.
@@ -33,6 +63,13 @@ type IstioGateway struct {
 	istioClient     networkingClientSet.NetworkingV1beta1Interface
 	DomainSuffix    string
 	GatewaySelector map[string]string
+	// GatewayNamespace is the namespace of the istio ingress gateway, the TLS
+	// secrets referenced by the gateways must be created in it.
+	GatewayNamespace string
+	// AcmeIssuer is the cert-manager issuer of the certificates of apps
+	// using tls-acme, a ClusterIssuer or an external issuer as
+	// name.kind.group.
+	AcmeIssuer string
 }
 
 func (k *IstioGateway) gatewayName(id router.InstanceID) string {
@@ -43,6 +80,19 @@ func (k *IstioGateway) vsName(id router.InstanceID) string {
 	return k.hashedResourceName(id, id.AppName, 63)
 }
 
+func (k *IstioGateway) secretName(id router.InstanceID, host string) string {
+	return k.hashedResourceName(id, "kr-"+id.AppName+"-"+host, 253)
+}
+
+// gatewayNamespace returns the namespace of the istio ingress gateway, holding
+// its pods and the TLS secrets of the gateways.
+func (k *IstioGateway) gatewayNamespace() string {
+	if k.GatewayNamespace == "" {
+		return DefaultIstioGatewayNamespace
+	}
+	return k.GatewayNamespace
+}
+
 func (k *IstioGateway) gatewayHost(id router.InstanceID) string {
 	if id.InstanceName == "" {
 		return fmt.Sprintf("%v.%v", id.AppName, k.DomainSuffix)
@@ -152,16 +202,31 @@ func vsRemoveHost(v *networking.VirtualService, host string) {
 	v.Annotations[hostsAnnotation] = strings.Join(hosts, ",")
 }
 
-func (k *IstioGateway) updateVirtualService(v *networking.VirtualService, id router.InstanceID, dstHost string) {
+func (k *IstioGateway) updateVirtualService(v *networking.VirtualService, id router.InstanceID, dstHost string, weighted []weightedService) {
 	v.Spec.Gateways = addToSet(v.Spec.Gateways, k.gatewayName(id))
 	v.Spec.Hosts = addToSet(v.Spec.Hosts, k.gatewayHost(id))
 	v.Spec.Hosts = addToSet(v.Spec.Hosts, dstHost)
 
-	if len(v.Spec.Http) == 0 {
-		v.Spec.Http = append(v.Spec.Http, &apiNetworking.HTTPRoute{})
+	mainRoute := vsMainRoute(v)
+	if mainRoute == nil {
+		mainRoute = &apiNetworking.HTTPRoute{}
+		v.Spec.Http = append(v.Spec.Http, mainRoute)
+	}
+	if len(weighted) > 0 {
+		mainRoute.Route = weightedDestinations(weighted)
+		if v.Annotations == nil {
+			v.Annotations = map[string]string{}
+		}
+		v.Annotations[weightedDestinationsAnnotation] = "true"
+		return
+	}
+	if v.Annotations[weightedDestinationsAnnotation] == "true" {
+		// the weighted targets were removed, only the main destination is kept
+		mainRoute.Route = nil
+		delete(v.Annotations, weightedDestinationsAnnotation)
 	}
 	dstIdx := -1
-	for i, dst := range v.Spec.Http[0].Route {
+	for i, dst := range mainRoute.Route {
 		if dst.Destination != nil &&
 			(dst.Destination.Host == dstHost) {
 			dstIdx = i
@@ -169,15 +234,196 @@ func (k *IstioGateway) updateVirtualService(v *networking.VirtualService, id rou
 		}
 	}
 	if dstIdx == -1 {
-		v.Spec.Http[0].Route = append(v.Spec.Http[0].Route, &apiNetworking.HTTPRouteDestination{})
-		dstIdx = len(v.Spec.Http[0].Route) - 1
+		mainRoute.Route = append(mainRoute.Route, &apiNetworking.HTTPRouteDestination{})
+		dstIdx = len(mainRoute.Route) - 1
 	}
-	v.Spec.Http[0].Route[dstIdx].Destination = &apiNetworking.Destination{
+	mainRoute.Route[dstIdx].Destination = &apiNetworking.Destination{
 		Host: dstHost,
 	}
 }
 
-// Create adds a new gateway and a virtualservice for the app
+// weightedDestinations returns the destinations of the weighted services,
+// addressed by their FQDN as they may be in other namespaces.
+func weightedDestinations(weighted []weightedService) []*apiNetworking.HTTPRouteDestination {
+	var destinations []*apiNetworking.HTTPRouteDestination
+	for _, w := range weighted {
+		destinations = append(destinations, &apiNetworking.HTTPRouteDestination{
+			Destination: &apiNetworking.Destination{
+				Host: fmt.Sprintf("%s.%s.svc.cluster.local", w.service.Name, w.service.Namespace),
+			},
+			Weight: w.weight,
+		})
+	}
+	return destinations
+}
+
+// vsMainRoute returns the route of the default prefix. The routes of the
+// other prefixes come before it, as it matches every request.
+func vsMainRoute(v *networking.VirtualService) *apiNetworking.HTTPRoute {
+	for _, route := range v.Spec.Http {
+		if route != nil && !isPrefixRoute(route) {
+			return route
+		}
+	}
+	return nil
+}
+
+func isPrefixRoute(route *apiNetworking.HTTPRoute) bool {
+	return strings.HasPrefix(route.Name, prefixRouteNamePrefix)
+}
+
+func (k *IstioGateway) prefixHost(id router.InstanceID, prefix string) string {
+	return prefix + "." + k.gatewayHost(id)
+}
+
+// updatePrefixRoutes replaces the routes of the prefixes other than the
+// default one, each one matching the host of its prefix. The headers and
+// policies of the main route are copied to them. The routes of swapped
+// virtualservices keep their current destinations.
+func (k *IstioGateway) updatePrefixRoutes(v *networking.VirtualService, id router.InstanceID, services map[string]*corev1.Service, weighted map[string][]weightedService) {
+	mainRoute := vsMainRoute(v)
+	existingRoutes := vsPrefixRoutes(v)
+	var routes []*apiNetworking.HTTPRoute
+	for _, route := range v.Spec.Http {
+		if route == nil || !isPrefixRoute(route) {
+			continue
+		}
+		prefix := strings.TrimPrefix(route.Name, prefixRouteNamePrefix)
+		v.Spec.Hosts = removeFromSet(v.Spec.Hosts, k.prefixHost(id, prefix))
+	}
+	prefixes := make([]string, 0, len(services))
+	for prefix := range services {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		host := k.prefixHost(id, prefix)
+		route := &apiNetworking.HTTPRoute{
+			Name: prefixRouteNamePrefix + prefix,
+			Match: []*apiNetworking.HTTPMatchRequest{
+				{
+					Authority: &apiNetworking.StringMatch{
+						MatchType: &apiNetworking.StringMatch_Regex{
+							Regex: "^" + regexp.QuoteMeta(host) + "(:[0-9]+)?$",
+						},
+					},
+				},
+			},
+			Route: []*apiNetworking.HTTPRouteDestination{
+				{Destination: &apiNetworking.Destination{Host: services[prefix].Name}},
+			},
+		}
+		if len(weighted[prefix]) > 0 {
+			route.Route = weightedDestinations(weighted[prefix])
+		}
+		if existing := existingRoutes[route.Name]; existing != nil && isSwapped(v.ObjectMeta) {
+			route.Route = existing.Route
+		}
+		if mainRoute != nil {
+			route.Headers = mainRoute.Headers
+			route.Timeout = mainRoute.Timeout
+			route.Retries = mainRoute.Retries
+			route.Mirror = mainRoute.Mirror
+			route.MirrorPercentage = mainRoute.MirrorPercentage
+		}
+		routes = append(routes, route)
+		v.Spec.Hosts = addToSet(v.Spec.Hosts, host)
+	}
+	for _, route := range v.Spec.Http {
+		if route == nil || !isPrefixRoute(route) {
+			routes = append(routes, route)
+		}
+	}
+	v.Spec.Http = routes
+	sort.Strings(v.Spec.Hosts)
+}
+
+// updateVirtualServiceHeaders sets the header operations of the route as set
+// in the router options, header operations not added by the router are kept.
+func updateVirtualServiceHeaders(v *networking.VirtualService, opts router.Opts) {
+	if opts.RequestHeaders == nil && opts.ResponseHeaders == nil {
+		if v.Annotations[headerModifiersAnnotation] == "true" {
+			vsMainRoute(v).Headers = nil
+			delete(v.Annotations, headerModifiersAnnotation)
+		}
+		return
+	}
+	vsMainRoute(v).Headers = &apiNetworking.Headers{
+		Request:  istioHeaderOperations(opts.RequestHeaders),
+		Response: istioHeaderOperations(opts.ResponseHeaders),
+	}
+	if v.Annotations == nil {
+		v.Annotations = map[string]string{}
+	}
+	v.Annotations[headerModifiersAnnotation] = "true"
+}
+
+// updateVirtualServicePolicies sets the timeout, retries and mirror of the
+// route as set in the router options, policies not added by the router are
+// kept.
+func updateVirtualServicePolicies(v *networking.VirtualService, opts router.Opts, mirror *mirrorService) error {
+	route := vsMainRoute(v)
+	if opts.Timeout == "" && opts.Retries == nil && len(opts.RetryOn) == 0 && mirror == nil {
+		if v.Annotations[trafficPoliciesAnnotation] == "true" {
+			route.Timeout = nil
+			route.Retries = nil
+			route.Mirror = nil
+			route.MirrorPercentage = nil
+			delete(v.Annotations, trafficPoliciesAnnotation)
+		}
+		return nil
+	}
+
+	route.Timeout = nil
+	if opts.Timeout != "" {
+		timeout, err := time.ParseDuration(opts.Timeout)
+		if err != nil {
+			return err
+		}
+		route.Timeout = types.DurationProto(timeout)
+	}
+
+	route.Retries = nil
+	if opts.Retries != nil || len(opts.RetryOn) > 0 {
+		// attempts is required by istio, 2 is its default
+		route.Retries = &apiNetworking.HTTPRetry{Attempts: 2}
+		if opts.Retries != nil {
+			route.Retries.Attempts = int32(*opts.Retries)
+		}
+		route.Retries.RetryOn = strings.Join(opts.RetryOn, ",")
+	}
+
+	route.Mirror = nil
+	route.MirrorPercentage = nil
+	if mirror != nil {
+		route.Mirror = &apiNetworking.Destination{
+			Host: mirror.name,
+			Port: &apiNetworking.PortSelector{Number: uint32(mirror.port)},
+		}
+		if opts.MirrorPercent != nil {
+			route.MirrorPercentage = &apiNetworking.Percent{Value: float64(*opts.MirrorPercent)}
+		}
+	}
+
+	if v.Annotations == nil {
+		v.Annotations = map[string]string{}
+	}
+	v.Annotations[trafficPoliciesAnnotation] = "true"
+	return nil
+}
+
+func istioHeaderOperations(modifier *router.HeaderModifier) *apiNetworking.Headers_HeaderOperations {
+	if modifier == nil {
+		return nil
+	}
+	return &apiNetworking.Headers_HeaderOperations{
+		Set:    modifier.Set,
+		Add:    modifier.Add,
+		Remove: modifier.Remove,
+	}
+}
+
+// Ensure applies the gateway and the virtualservice of the app
 func (k *IstioGateway) Ensure(ctx context.Context, id router.InstanceID, o router.EnsureBackendOpts) error {
 	cli, err := k.getClient()
 	if err != nil {
@@ -193,9 +439,58 @@ func (k *IstioGateway) Ensure(ctx context.Context, id router.InstanceID, o route
 		return err
 	}
 
+	backendTargets, err := k.getBackendTargets(o.Prefixes, o.Opts.ExposeAllServices)
+	if err != nil {
+		return err
+	}
+	prefixServices := map[string]*corev1.Service{}
+	for prefix, target := range backendTargets {
+		if prefix == "default" {
+			continue
+		}
+		prefixServices[prefix], err = k.getWebService(ctx, id.AppName, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	acmeHosts, err := k.acmeHosts(id, o)
+	if err != nil {
+		return err
+	}
+
+	err = retryOnConflict("gateway", func(bool) error {
+		return k.ensureGateway(ctx, cli, namespace, id, o.Opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = retryOnConflict("virtualservice", func(bool) error {
+		return k.ensureVirtualService(ctx, cli, namespace, id, o, defaultTarget, prefixServices)
+	})
+	if err != nil {
+		return err
+	}
+
+	return k.ensureAcmeCertificates(ctx, cli, namespace, id, acmeHosts)
+}
+
+// PlanEnsure returns the changes Ensure would make to the gateway and
+// virtualservice of the app, without writing them.
+func (k *IstioGateway) PlanEnsure(ctx context.Context, id router.InstanceID, o router.EnsureBackendOpts) (*router.Plan, error) {
+	return planEnsure(ctx, func(ctx context.Context) error {
+		return k.Ensure(ctx, id, o)
+	})
+}
+
+// ensureGateway applies the gateway of the app, the HTTPS servers of its
+// certificates and the annotation tracking the hosts using ACME are kept.
+func (k *IstioGateway) ensureGateway(ctx context.Context, cli networkingClientSet.NetworkingV1beta1Interface, namespace string, id router.InstanceID, opts router.Opts) error {
 	gateway := &networking.Gateway{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: id.AppName,
+			Name:      k.gatewayName(id),
+			Namespace: namespace,
 		},
 		Spec: apiNetworking.Gateway{
 			Servers: []*apiNetworking.Server{
@@ -211,17 +506,29 @@ func (k *IstioGateway) Ensure(ctx context.Context, id router.InstanceID, o route
 			Selector: k.GatewaySelector,
 		},
 	}
+	k.updateObjectMeta(&gateway.ObjectMeta, id.AppName, opts)
 
-	k.updateObjectMeta(&gateway.ObjectMeta, id.AppName, o.Opts)
-
-	_, err = cli.Gateways(namespace).Create(ctx, gateway, metav1.CreateOptions{})
-	isAlreadyExists := false
-	if k8sErrors.IsAlreadyExists(err) {
-		isAlreadyExists = true
-	} else if err != nil {
+	existing, err := cli.Gateways(namespace).Get(ctx, gateway.Name, metav1.GetOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
 		return err
 	}
+	if err == nil {
+		// the servers are kept from the current ones, the apply conflicts if
+		// they were changed meanwhile.
+		gateway.ResourceVersion = existing.ResourceVersion
+		gateway.Spec.Servers = existing.Spec.Servers
+		if hosts, ok := existing.Annotations[acmeHostsAnnotation]; ok {
+			gateway.Annotations[acmeHostsAnnotation] = hosts
+		}
+	}
+	_, err = applyObject(ctx, cli.Gateways(namespace), istioGatewayGVK, gateway)
+	return err
+}
 
+// ensureVirtualService applies the virtualservice of the app updated from the
+// current one, its labels and annotations are set again but the ones tracking
+// its hosts and routes.
+func (k *IstioGateway) ensureVirtualService(ctx context.Context, cli networkingClientSet.NetworkingV1beta1Interface, namespace string, id router.InstanceID, o router.EnsureBackendOpts, defaultTarget *router.BackendTarget, prefixServices map[string]*corev1.Service) error {
 	existingSvc := true
 	virtualSvc, err := k.getVS(ctx, cli, id)
 
@@ -232,15 +539,27 @@ func (k *IstioGateway) Ensure(ctx context.Context, id router.InstanceID, o route
 	if k8sErrors.IsNotFound(err) {
 		existingSvc = false
 		virtualSvc = &networking.VirtualService{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: k.vsName(id),
-			},
 			Spec: apiNetworking.VirtualService{
 				Gateways: []string{"mesh"},
 			},
 		}
 	}
 
+	existingMeta := virtualSvc.ObjectMeta
+	virtualSvc.ObjectMeta = metav1.ObjectMeta{
+		Name:      k.vsName(id),
+		Namespace: namespace,
+		// the spec is updated from the current one, the apply conflicts if
+		// it was changed meanwhile.
+		ResourceVersion: existingMeta.ResourceVersion,
+		Annotations:     map[string]string{},
+	}
+	for _, annotation := range []string{hostsAnnotation, weightedDestinationsAnnotation, headerModifiersAnnotation, trafficPoliciesAnnotation} {
+		if value, ok := existingMeta.Annotations[annotation]; ok {
+			virtualSvc.Annotations[annotation] = value
+		}
+	}
+	keepSwappedLabels(&virtualSvc.ObjectMeta, existingMeta)
 	k.updateObjectMeta(&virtualSvc.ObjectMeta, id.AppName, o.Opts)
 
 	webService, err := k.getWebService(ctx, id.AppName, *defaultTarget)
@@ -248,9 +567,30 @@ func (k *IstioGateway) Ensure(ctx context.Context, id router.InstanceID, o route
 		return err
 	}
 
-	k.updateVirtualService(virtualSvc, id, webService.Name)
-	virtualSvc.Labels[appBaseServiceNamespaceLabel] = defaultTarget.Namespace
-	virtualSvc.Labels[appBaseServiceNameLabel] = defaultTarget.Service
+	weightedServices, err := k.getWeightedServices(ctx, id.AppName, o.Prefixes, o.Opts.ExposeAllServices)
+	if err != nil {
+		return err
+	}
+
+	if existingSvc && isSwapped(virtualSvc.ObjectMeta) {
+		// swapped virtualservices keep their current destination
+		k.updateVirtualService(virtualSvc, id, vsDestinationHost(virtualSvc, webService.Name), nil)
+	} else {
+		k.updateVirtualService(virtualSvc, id, webService.Name, weightedServices["default"])
+		virtualSvc.Labels[appBaseServiceNamespaceLabel] = defaultTarget.Namespace
+		virtualSvc.Labels[appBaseServiceNameLabel] = defaultTarget.Service
+	}
+
+	updateVirtualServiceHeaders(virtualSvc, o.Opts)
+	mirror, err := k.getMirrorService(ctx, id.AppName, namespace, o.Opts.MirrorTo)
+	if err != nil {
+		return err
+	}
+	err = updateVirtualServicePolicies(virtualSvc, o.Opts, mirror)
+	if err != nil {
+		return err
+	}
+	k.updatePrefixRoutes(virtualSvc, id, prefixServices, weightedServices)
 
 	existingCNames := hostsFromAnnotation(virtualSvc.Annotations)
 	cnamesToAdd, cnamesToRemove := diffCNames(existingCNames, o.CNames)
@@ -261,29 +601,154 @@ func (k *IstioGateway) Ensure(ctx context.Context, id router.InstanceID, o route
 		vsRemoveHost(virtualSvc, cname)
 	}
 
-	if existingSvc {
-		_, err = cli.VirtualServices(namespace).Update(ctx, virtualSvc, metav1.UpdateOptions{})
+	_, err = applyObject(ctx, cli.VirtualServices(namespace), virtualServiceGVK, virtualSvc)
+	return err
+}
+
+// SupportedOptions returns the options supported by the virtualservices
+func (k *IstioGateway) SupportedOptions(ctx context.Context) map[string]string {
+	return map[string]string{
+		router.AllPrefixes:     "",
+		router.Acme:            "",
+		router.AcmeCName:       "",
+		router.RequestHeaders:  "",
+		router.ResponseHeaders: "",
+		router.Timeout:         "",
+		router.Retries:         "",
+		router.RetryOn:         "",
+		router.MirrorTo:        "",
+		router.MirrorPercent:   "",
+	}
+}
+
+// Get returns the address in the gateway and the addresses of the exposed
+// prefixes
+func (k *IstioGateway) GetAddresses(ctx context.Context, id router.InstanceID) ([]string, error) {
+	addresses := []string{k.gatewayHost(id)}
+	cli, err := k.getClient()
+	if err != nil {
+		return nil, err
+	}
+	virtualSvc, err := k.getVS(ctx, cli, id)
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return addresses, nil
+		}
+		return nil, err
+	}
+	for _, route := range virtualSvc.Spec.Http {
+		if route != nil && isPrefixRoute(route) {
+			addresses = append(addresses, k.prefixHost(id, strings.TrimPrefix(route.Name, prefixRouteNamePrefix)))
+		}
+	}
+	return addresses, nil
+}
+
+// Swap swaps the destinations of the virtualservices of two apps, the routes
+// of their other prefixes included. When cnameOnly is set the additional hosts
+// are moved between the apps instead.
+func (k *IstioGateway) Swap(ctx context.Context, srcApp, dstApp router.InstanceID, cnameOnly bool) error {
+	cli, err := k.getClient()
+	if err != nil {
+		return err
+	}
+	ns, err := k.getSwapNamespace(ctx, srcApp, dstApp)
+	if err != nil {
+		return err
+	}
+	srcVS, err := k.getVS(ctx, cli, srcApp)
+	if err != nil {
+		return err
+	}
+	dstVS, err := k.getVS(ctx, cli, dstApp)
+	if err != nil {
+		return err
+	}
+	for _, v := range []*networking.VirtualService{srcVS, dstVS} {
+		if v.Annotations == nil {
+			v.Annotations = map[string]string{}
+		}
+	}
+	srcOriginal, dstOriginal := srcVS.DeepCopy(), dstVS.DeepCopy()
+
+	if cnameOnly {
+		srcHosts := hostsFromAnnotation(srcVS.Annotations)
+		dstHosts := hostsFromAnnotation(dstVS.Annotations)
+		for _, host := range srcHosts {
+			vsRemoveHost(srcVS, host)
+			vsAddHost(dstVS, host)
+		}
+		for _, host := range dstHosts {
+			vsRemoveHost(dstVS, host)
+			vsAddHost(srcVS, host)
+		}
 	} else {
-		_, err = cli.VirtualServices(namespace).Create(ctx, virtualSvc, metav1.CreateOptions{})
+		err = swapVirtualServiceRoutes(srcVS, dstVS)
+		if err != nil {
+			return err
+		}
+		swapBaseServiceLabels(&srcVS.ObjectMeta, &dstVS.ObjectMeta)
+		toggleSwapped(&srcVS.ObjectMeta, dstApp.AppName)
+		toggleSwapped(&dstVS.ObjectMeta, srcApp.AppName)
 	}
+
+	writes := &swapWrites{}
+	err = applySwapped(ctx, writes, cli.VirtualServices(ns), virtualServiceGVK, srcVS, srcOriginal)
 	if err != nil {
 		return err
 	}
+	return applySwapped(ctx, writes, cli.VirtualServices(ns), virtualServiceGVK, dstVS, dstOriginal)
+}
 
-	if isAlreadyExists {
-		return router.ErrIngressAlreadyExists
+// swapVirtualServiceRoutes swaps the destinations of the main routes and of
+// the routes of each prefix of two virtualservices, which must have the same
+// prefixes.
+func swapVirtualServiceRoutes(src, dst *networking.VirtualService) error {
+	srcRoute, dstRoute := vsMainRoute(src), vsMainRoute(dst)
+	if srcRoute == nil || dstRoute == nil {
+		return router.NewError(router.ErrorCodeUnsupported, "cannot swap virtualservices without http routes")
+	}
+	srcPrefixRoutes, dstPrefixRoutes := vsPrefixRoutes(src), vsPrefixRoutes(dst)
+	if len(srcPrefixRoutes) != len(dstPrefixRoutes) {
+		return router.NewError(router.ErrorCodeUnsupported, "cannot swap virtualservices %s and %s with different prefixes", src.Name, dst.Name)
+	}
+	for name := range srcPrefixRoutes {
+		if dstPrefixRoutes[name] == nil {
+			return router.NewError(router.ErrorCodeUnsupported, "cannot swap virtualservices %s and %s with different prefixes", src.Name, dst.Name)
+		}
+	}
+	srcRoute.Route, dstRoute.Route = dstRoute.Route, srcRoute.Route
+	for name, route := range srcPrefixRoutes {
+		route.Route, dstPrefixRoutes[name].Route = dstPrefixRoutes[name].Route, route.Route
 	}
 	return nil
 }
 
-// Get returns the address in the gateway
-func (k *IstioGateway) GetAddresses(ctx context.Context, id router.InstanceID) ([]string, error) {
-	return []string{k.gatewayHost(id)}, nil
+// vsPrefixRoutes returns the routes of the prefixes other than the default
+// one by name.
+func vsPrefixRoutes(v *networking.VirtualService) map[string]*apiNetworking.HTTPRoute {
+	routes := map[string]*apiNetworking.HTTPRoute{}
+	for _, route := range v.Spec.Http {
+		if route != nil && isPrefixRoute(route) {
+			routes[route.Name] = route
+		}
+	}
+	return routes
 }
 
-// Swap is not implemented
-func (k *IstioGateway) Swap(ctx context.Context, srcApp, dstApp router.InstanceID) error {
-	return errors.New("swap is not supported, the virtualservice should be edited manually")
+// vsDestinationHost returns the host of the current destination of the
+// virtualservice, or defaultHost when there is none.
+func vsDestinationHost(v *networking.VirtualService, defaultHost string) string {
+	mainRoute := vsMainRoute(v)
+	if mainRoute == nil {
+		return defaultHost
+	}
+	for _, route := range mainRoute.Route {
+		if route.Destination != nil && route.Destination.Host != "" {
+			return route.Destination.Host
+		}
+	}
+	return defaultHost
 }
 
 // Remove removes the application gateway and removes it from the virtualservice
@@ -292,7 +757,247 @@ func (k *IstioGateway) Remove(ctx context.Context, id router.InstanceID) error {
 	if err != nil {
 		return err
 	}
-	virtualSvc, err := k.getVS(ctx, cli, id)
+	ns, err := k.getAppNamespace(ctx, id.AppName)
+	if err != nil {
+		return err
+	}
+	err = retryOnConflict("virtualservice", func(bool) error {
+		virtualSvc, err := k.getVS(ctx, cli, id)
+		if err != nil {
+			return err
+		}
+		var gateways []string
+		for _, g := range virtualSvc.Spec.Gateways {
+			if g != k.gatewayName(id) {
+				gateways = append(gateways, g)
+			}
+		}
+		virtualSvc.Spec.Gateways = gateways
+		virtualSvc, err = applicable(virtualSvc)
+		if err != nil {
+			return err
+		}
+		_, err = applyObject(ctx, cli.VirtualServices(ns), virtualServiceGVK, virtualSvc)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	gateway, err := cli.Gateways(ns).Get(ctx, k.gatewayName(id), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	err = k.removeAcmeCertificates(ctx, id, hostsFromAcmeAnnotation(gateway.Annotations))
+	if err != nil {
+		return err
+	}
+	return cli.Gateways(ns).Delete(ctx, k.gatewayName(id), metav1.DeleteOptions{})
+}
+
+// acmeHosts returns the issuer of each host getting a certificate from
+// cert-manager: the app host when tls-acme is set and the cnames with an
+// issuer or with tls-acme-cname set.
+func (k *IstioGateway) acmeHosts(id router.InstanceID, o router.EnsureBackendOpts) (map[string]string, error) {
+	hosts := map[string]string{}
+	if o.Opts.Acme {
+		hosts[k.gatewayHost(id)] = k.AcmeIssuer
+	}
+	for _, cname := range o.CNames {
+		if issuer := o.CertIssuers[cname]; issuer != "" {
+			hosts[cname] = issuer
+		} else if o.Opts.AcmeCName {
+			hosts[cname] = k.AcmeIssuer
+		}
+	}
+	for host, issuer := range hosts {
+		if issuer == "" {
+			return nil, router.NewError(router.ErrorCodeInvalidOptions, "no cert-manager issuer set to issue a certificate for %s", host).WithDetail("host", host)
+		}
+	}
+	return hosts, nil
+}
+
+func hostsFromAcmeAnnotation(annotations map[string]string) []string {
+	if annotations[acmeHostsAnnotation] == "" {
+		return nil
+	}
+	return strings.Split(annotations[acmeHostsAnnotation], ",")
+}
+
+// ensureAcmeCertificates creates the cert-manager certificates of the hosts
+// using ACME and adds their HTTPS servers to the gateway, the certificates
+// and servers of hosts no longer using ACME are removed.
+func (k *IstioGateway) ensureAcmeCertificates(ctx context.Context, cli networkingClientSet.NetworkingV1beta1Interface, ns string, id router.InstanceID, hosts map[string]string) error {
+	var removedHosts []string
+	err := retryOnConflict("gateway", func(bool) error {
+		gateway, err := cli.Gateways(ns).Get(ctx, k.gatewayName(id), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		existingHosts := hostsFromAcmeAnnotation(gateway.Annotations)
+		if len(hosts) == 0 && len(existingHosts) == 0 {
+			return nil
+		}
+
+		removedHosts = nil
+		for _, host := range existingHosts {
+			if _, ok := hosts[host]; !ok {
+				removedHosts = append(removedHosts, host)
+				gatewayRemoveHTTPSServer(gateway, host)
+			}
+		}
+		var acmeHosts []string
+		for host, issuer := range hosts {
+			err = k.ensureCertificate(ctx, id, host, issuer)
+			if err != nil {
+				return err
+			}
+			gatewayAddHTTPSServer(gateway, host, k.secretName(id, host))
+			acmeHosts = append(acmeHosts, host)
+		}
+		sort.Strings(acmeHosts)
+
+		if gateway.Annotations == nil {
+			gateway.Annotations = map[string]string{}
+		}
+		if len(acmeHosts) > 0 {
+			gateway.Annotations[acmeHostsAnnotation] = strings.Join(acmeHosts, ",")
+		} else {
+			delete(gateway.Annotations, acmeHostsAnnotation)
+		}
+		gateway, err = applicable(gateway)
+		if err != nil {
+			return err
+		}
+		_, err = applyObject(ctx, cli.Gateways(ns), istioGatewayGVK, gateway)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return k.removeAcmeCertificates(ctx, id, removedHosts)
+}
+
+func (k *IstioGateway) ensureCertificate(ctx context.Context, id router.InstanceID, host, issuer string) error {
+	cmClient, err := k.getCertManagerClient()
+	if err != nil {
+		return err
+	}
+	ns := k.gatewayNamespace()
+	certificate := &certmanagerv1.Certificate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      k.secretName(id, host),
+			Namespace: ns,
+			Labels: map[string]string{
+				appLabel:    id.AppName,
+				domainLabel: host,
+			},
+		},
+		Spec: certmanagerv1.CertificateSpec{
+			SecretName: k.secretName(id, host),
+			DNSNames:   []string{host},
+			IssuerRef:  certManagerIssuerRef(issuer),
+		},
+	}
+	k.updateObjectMeta(&certificate.ObjectMeta, id.AppName, router.Opts{})
+	_, err = applyObject(ctx, cmClient.CertmanagerV1().Certificates(ns), certificateGVK, certificate)
+	return err
+}
+
+func (k *IstioGateway) removeAcmeCertificates(ctx context.Context, id router.InstanceID, hosts []string) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+	cmClient, err := k.getCertManagerClient()
+	if err != nil {
+		return err
+	}
+	client, err := k.BaseService.getClient()
+	if err != nil {
+		return err
+	}
+	ns := k.gatewayNamespace()
+	for _, host := range hosts {
+		err = cmClient.CertmanagerV1().Certificates(ns).Delete(ctx, k.secretName(id, host), metav1.DeleteOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+		err = client.CoreV1().Secrets(ns).Delete(ctx, k.secretName(id, host), metav1.DeleteOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// certManagerIssuerRef returns the reference to a ClusterIssuer or, when the
+// issuer is set as name.kind.group, to an external issuer.
+func certManagerIssuerRef(issuer string) cmmeta.ObjectReference {
+	if parts := strings.SplitN(issuer, ".", 3); len(parts) == 3 {
+		return cmmeta.ObjectReference{
+			Name:  parts[0],
+			Kind:  parts[1],
+			Group: parts[2],
+		}
+	}
+	return cmmeta.ObjectReference{
+		Name: issuer,
+		Kind: certmanagerv1.ClusterIssuerKind,
+	}
+}
+
+func httpsServerName(host string) string {
+	return "https-" + strings.NewReplacer(".", "-", "*", "wildcard").Replace(host)
+}
+
+// gatewayAddHTTPSServer adds, or updates, the HTTPS server of the host using
+// the certificate of the secret.
+func gatewayAddHTTPSServer(g *networking.Gateway, host, secretName string) {
+	server := &apiNetworking.Server{
+		Name: httpsServerName(host),
+		Port: &apiNetworking.Port{
+			Number:   443,
+			Name:     httpsServerName(host),
+			Protocol: "HTTPS",
+		},
+		Hosts: []string{host},
+		Tls: &apiNetworking.ServerTLSSettings{
+			Mode:           apiNetworking.ServerTLSSettings_SIMPLE,
+			CredentialName: secretName,
+		},
+	}
+	for i, s := range g.Spec.Servers {
+		if s != nil && s.Name == server.Name {
+			g.Spec.Servers[i] = server
+			return
+		}
+	}
+	g.Spec.Servers = append(g.Spec.Servers, server)
+}
+
+func gatewayRemoveHTTPSServer(g *networking.Gateway, host string) {
+	servers := g.Spec.Servers[:0]
+	for _, s := range g.Spec.Servers {
+		if s == nil || s.Name != httpsServerName(host) {
+			servers = append(servers, s)
+		}
+	}
+	g.Spec.Servers = servers
+}
+
+func gatewayHasHTTPSServer(g *networking.Gateway, host string) bool {
+	for _, s := range g.Spec.Servers {
+		if s != nil && s.Name == httpsServerName(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddCertificate stores the certificate in the namespace of the ingress
+// gateway and adds a HTTPS server for the host to the app gateway
+func (k *IstioGateway) AddCertificate(ctx context.Context, id router.InstanceID, certCname string, cert router.CertData) error {
+	cli, err := k.getClient()
 	if err != nil {
 		return err
 	}
@@ -300,18 +1005,305 @@ func (k *IstioGateway) Remove(ctx context.Context, id router.InstanceID) error {
 	if err != nil {
 		return err
 	}
-	var gateways []string
-	for _, g := range virtualSvc.Spec.Gateways {
-		if g != k.gatewayName(id) {
-			gateways = append(gateways, g)
+	virtualSvc, err := cli.VirtualServices(ns).Get(ctx, k.vsName(id), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(virtualSvc.Spec.Hosts, certCname) {
+		return router.NewError(router.ErrorCodeNotFound, "cname %s is not found in virtualservice %s, found hosts: %s", certCname, virtualSvc.Name, strings.Join(virtualSvc.Spec.Hosts, ", ")).WithDetail("cname", certCname)
+	}
+	gateway, err := cli.Gateways(ns).Get(ctx, k.gatewayName(id), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if slices.Contains(hostsFromAcmeAnnotation(gateway.Annotations), certCname) {
+		return router.NewError(router.ErrorCodeUnsupported, "cannot add certificate to gateway %s, %s is managed by ACME", gateway.Name, certCname).WithDetail("cname", certCname)
+	}
+
+	if err = cert.Validate(certCname, time.Now()); err != nil {
+		return err
+	}
+
+	client, err := k.BaseService.getClient()
+	if err != nil {
+		return err
+	}
+	secretsNamespace := k.gatewayNamespace()
+	secretName := k.secretName(id, certCname)
+	tlsSecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: secretsNamespace,
+			Labels: map[string]string{
+				appLabel:    id.AppName,
+				domainLabel: certCname,
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		StringData: map[string]string{
+			"tls.key": cert.Key,
+			"tls.crt": cert.Certificate,
+		},
+	}
+	_, err = client.CoreV1().Secrets(secretsNamespace).Create(ctx, &tlsSecret, metav1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		var existingSecret *corev1.Secret
+		existingSecret, err = client.CoreV1().Secrets(secretsNamespace).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			return err
 		}
+		tlsSecret.ResourceVersion = existingSecret.ResourceVersion
+		_, err = client.CoreV1().Secrets(secretsNamespace).Update(ctx, &tlsSecret, metav1.UpdateOptions{})
 	}
-	virtualSvc.Spec.Gateways = gateways
-	_, err = cli.VirtualServices(ns).Update(ctx, virtualSvc, metav1.UpdateOptions{})
 	if err != nil {
 		return err
 	}
-	return cli.Gateways(ns).Delete(ctx, k.gatewayName(id), metav1.DeleteOptions{})
+
+	return retryOnConflict("gateway", func(retrying bool) error {
+		if retrying {
+			gateway, err = cli.Gateways(ns).Get(ctx, k.gatewayName(id), metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+		}
+		gatewayAddHTTPSServer(gateway, certCname, secretName)
+		applied, err := applicable(gateway)
+		if err != nil {
+			return err
+		}
+		_, err = applyObject(ctx, cli.Gateways(ns), istioGatewayGVK, applied)
+		return err
+	})
+}
+
+// GetCertificate returns the certificate of the host stored in the namespace
+// of the ingress gateway
+func (k *IstioGateway) GetCertificate(ctx context.Context, id router.InstanceID, certCname string) (*router.CertData, error) {
+	client, err := k.BaseService.getClient()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := client.CoreV1().Secrets(k.gatewayNamespace()).Get(ctx, k.secretName(id, certCname), metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return nil, router.ErrCertificateNotFound
+		}
+		return nil, err
+	}
+	return &router.CertData{
+		Certificate: string(secret.Data["tls.crt"]),
+		Key:         string(secret.Data["tls.key"]),
+	}, nil
+}
+
+// RemoveCertificate removes the HTTPS server of the host from the app gateway
+// and deletes its certificate
+func (k *IstioGateway) RemoveCertificate(ctx context.Context, id router.InstanceID, certCname string) error {
+	cli, err := k.getClient()
+	if err != nil {
+		return err
+	}
+	ns, err := k.getAppNamespace(ctx, id.AppName)
+	if err != nil {
+		return err
+	}
+	gateway, err := cli.Gateways(ns).Get(ctx, k.gatewayName(id), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if slices.Contains(hostsFromAcmeAnnotation(gateway.Annotations), certCname) {
+		return router.NewError(router.ErrorCodeUnsupported, "cannot remove certificate from gateway %s, %s is managed by ACME", gateway.Name, certCname).WithDetail("cname", certCname)
+	}
+	err = retryOnConflict("gateway", func(retrying bool) error {
+		if retrying {
+			gateway, err = cli.Gateways(ns).Get(ctx, k.gatewayName(id), metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+		}
+		if !gatewayHasHTTPSServer(gateway, certCname) {
+			return nil
+		}
+		gatewayRemoveHTTPSServer(gateway, certCname)
+		applied, err := applicable(gateway)
+		if err != nil {
+			return err
+		}
+		_, err = applyObject(ctx, cli.Gateways(ns), istioGatewayGVK, applied)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	client, err := k.BaseService.getClient()
+	if err != nil {
+		return err
+	}
+	err = client.CoreV1().Secrets(k.gatewayNamespace()).Delete(ctx, k.secretName(id, certCname), metav1.DeleteOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return router.ErrCertificateNotFound
+	}
+	return err
+}
+
+// GetStatus checks the gateway and virtualservice of the app, the pods
+// selected by the gateway and the services and endpoints of the destinations.
+func (k *IstioGateway) GetStatus(ctx context.Context, id router.InstanceID) (router.BackendStatus, string, error) {
+	cli, err := k.getClient()
+	if err != nil {
+		return router.BackendStatusNotReady, "", err
+	}
+	ns, err := k.getAppNamespace(ctx, id.AppName)
+	if err != nil {
+		return router.BackendStatusNotReady, "", err
+	}
+	virtualSvc, err := cli.VirtualServices(ns).Get(ctx, k.vsName(id), metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return router.BackendStatusNotReady, "waiting for deploy", nil
+		}
+		return router.BackendStatusNotReady, "", err
+	}
+
+	var problems []string
+	gateway, err := cli.Gateways(ns).Get(ctx, k.gatewayName(id), metav1.GetOptions{})
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return router.BackendStatusNotReady, "", err
+		}
+		problems = append(problems, fmt.Sprintf("gateway %q not found", k.gatewayName(id)))
+	} else {
+		gatewayProblems, err := k.gatewayPodsProblems(ctx, gateway)
+		if err != nil {
+			return router.BackendStatusNotReady, "", err
+		}
+		problems = append(problems, gatewayProblems...)
+	}
+
+	problems = append(problems, istioStatusProblems(virtualSvc)...)
+
+	destinationProblems, err := k.destinationsProblems(ctx, virtualSvc)
+	if err != nil {
+		return router.BackendStatusNotReady, "", err
+	}
+	problems = append(problems, destinationProblems...)
+
+	if len(problems) == 0 {
+		return router.BackendStatusReady, "", nil
+	}
+	detail, err := k.getStatusForRuntimeObject(ctx, ns, "VirtualService", virtualSvc.UID)
+	if err != nil {
+		return router.BackendStatusNotReady, "", err
+	}
+	return router.BackendStatusNotReady, strings.Join(problems, "\n") + "\n" + detail, nil
+}
+
+// gatewayPodsProblems checks that the gateway selector matches running pods
+// in any namespace.
+func (k *IstioGateway) gatewayPodsProblems(ctx context.Context, gateway *networking.Gateway) ([]string, error) {
+	if len(gateway.Spec.Selector) == 0 {
+		return nil, nil
+	}
+	client, err := k.BaseService.getClient()
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.SelectorFromSet(labels.Set(gateway.Spec.Selector)).String()
+	// a single running pod of the gateway is enough
+	pods, err := client.CoreV1().Pods(k.gatewayNamespace()).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+		FieldSelector: fields.OneTermEqualSelector("status.phase", string(corev1.PodRunning)).String(),
+		Limit:         1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodRunning {
+			return nil, nil
+		}
+	}
+	return []string{fmt.Sprintf("no running pods match the gateway selector %q", selector)}, nil
+}
+
+// istioStatusProblems returns the error validation messages and the false
+// conditions reported by istio.
+func istioStatusProblems(v *networking.VirtualService) []string {
+	var problems []string
+	for _, msg := range v.Status.ValidationMessages {
+		if msg == nil || msg.Level != analysisv1alpha1.AnalysisMessageBase_ERROR {
+			continue
+		}
+		name := ""
+		if msg.Type != nil {
+			name = fmt.Sprintf("%s %s", msg.Type.Code, msg.Type.Name)
+		}
+		problems = append(problems, fmt.Sprintf("validation error: %s", strings.TrimSpace(name)))
+	}
+	for _, condition := range v.Status.Conditions {
+		if condition == nil || condition.Status != "False" {
+			continue
+		}
+		problems = append(problems, strings.TrimSpace(fmt.Sprintf("condition %s is false: %s %s", condition.Type, condition.Reason, condition.Message)))
+	}
+	return problems
+}
+
+// destinationsProblems checks that the services of the virtualservice
+// destinations exist and have ready endpoints.
+func (k *IstioGateway) destinationsProblems(ctx context.Context, v *networking.VirtualService) ([]string, error) {
+	client, err := k.BaseService.getClient()
+	if err != nil {
+		return nil, err
+	}
+	checked := map[string]bool{}
+	var problems []string
+	for _, httpRoute := range v.Spec.Http {
+		if httpRoute == nil {
+			continue
+		}
+		for _, dst := range httpRoute.Route {
+			if dst == nil || dst.Destination == nil || checked[dst.Destination.Host] {
+				continue
+			}
+			checked[dst.Destination.Host] = true
+			name, ns, _ := strings.Cut(dst.Destination.Host, ".")
+			if ns == "" {
+				ns = v.Namespace
+			} else {
+				ns, _, _ = strings.Cut(ns, ".")
+			}
+			_, err = client.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				if !k8sErrors.IsNotFound(err) {
+					return nil, err
+				}
+				problems = append(problems, fmt.Sprintf("destination service %s/%s not found", ns, name))
+				continue
+			}
+			endpointSlices, err := client.DiscoveryV1().EndpointSlices(ns).List(ctx, metav1.ListOptions{
+				LabelSelector: labels.Set{discoveryv1.LabelServiceName: name}.String(),
+			})
+			if err != nil {
+				return nil, err
+			}
+			if !hasReadyEndpoints(endpointSlices.Items) {
+				problems = append(problems, fmt.Sprintf("destination service %s/%s has no ready endpoints", ns, name))
+			}
+		}
+	}
+	return problems, nil
+}
+
+func hasReadyEndpoints(slices []discoveryv1.EndpointSlice) bool {
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func diffCNames(existing []string, expected []string) (toAdd []string, toRemove []string) {
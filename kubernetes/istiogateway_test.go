@@ -8,31 +8,83 @@ import (
 	"fmt"
 	"testing"
 
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tsuru/kubernetes-router/router"
 	faketsuru "github.com/tsuru/tsuru/provision/kubernetes/pkg/client/clientset/versioned/fake"
+	analysisv1alpha1 "istio.io/api/analysis/v1alpha1"
+	istiometa "istio.io/api/meta/v1alpha1"
 	apiNetworking "istio.io/api/networking/v1beta1"
 	networking "istio.io/client-go/pkg/apis/networking/v1beta1"
 	fakeistio "istio.io/client-go/pkg/clientset/versioned/fake"
 	networkingClientSet "istio.io/client-go/pkg/clientset/versioned/typed/networking/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	fakeapiextensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
+// newFakeIstioClientset returns a fake istio clientset tracking the managed
+// fields of its objects, as required by server-side apply.
+func newFakeIstioClientset() *fakeistio.Clientset {
+	react := fieldManagedReactor(networking.AddToScheme, networking.SchemeGroupVersion)
+	client := fakeistio.NewSimpleClientset()
+	client.PrependReactor("*", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		// the tracker of the managed fields guesses gatewaies as the
+		// resource of the Gateway kind.
+		if action.GetResource().Resource == "gateways" {
+			action = withResource(action, action.GetResource().GroupVersion().WithResource("gatewaies"))
+		}
+		return react(action)
+	})
+	return client
+}
+
+// withResource returns the action on the resource.
+func withResource(action k8stesting.Action, resource schema.GroupVersionResource) k8stesting.Action {
+	switch a := action.(type) {
+	case k8stesting.GetActionImpl:
+		a.Resource = resource
+		return a
+	case k8stesting.ListActionImpl:
+		a.Resource = resource
+		return a
+	case k8stesting.CreateActionImpl:
+		a.Resource = resource
+		return a
+	case k8stesting.UpdateActionImpl:
+		a.Resource = resource
+		return a
+	case k8stesting.PatchActionImpl:
+		a.Resource = resource
+		return a
+	case k8stesting.DeleteActionImpl:
+		a.Resource = resource
+		return a
+	}
+	return action
+}
+
 func fakeService() (IstioGateway, networkingClientSet.NetworkingV1beta1Interface) {
-	fakeIstio := fakeistio.NewSimpleClientset().NetworkingV1beta1()
+	fakeIstio := newFakeIstioClientset().NetworkingV1beta1()
 	return IstioGateway{
 		BaseService: &BaseService{
-			Namespace:        "default",
-			Client:           fake.NewSimpleClientset(),
-			TsuruClient:      faketsuru.NewSimpleClientset(),
-			ExtensionsClient: fakeapiextensions.NewSimpleClientset(),
+			Namespace:         "default",
+			Client:            newFakeClientset(),
+			TsuruClient:       faketsuru.NewSimpleClientset(),
+			ExtensionsClient:  fakeapiextensions.NewSimpleClientset(),
+			CertManagerClient: newFakeCertManagerClientset(),
 		},
 		istioClient:     fakeIstio,
 		DomainSuffix:    "my.domain",
 		GatewaySelector: map[string]string{"istio": "ingress"},
+		AcmeIssuer:      "letsencrypt",
 	}, fakeIstio
 }
 
@@ -56,7 +108,7 @@ func TestIstioGateway_Ensure(t *testing.T) {
 	virtualSvc, err := istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
 	require.NoError(t, err)
 	assert.Equal(t, map[string]string{"tsuru.io/app-name": "myapp"}, gateway.Labels)
-	assert.Equal(t, map[string]string{}, gateway.Annotations)
+	assert.Empty(t, gateway.Annotations)
 	assert.Equal(t, apiNetworking.Gateway{
 		Servers: []*apiNetworking.Server{
 			{
@@ -79,7 +131,7 @@ func TestIstioGateway_Ensure(t *testing.T) {
 		"router.tsuru.io/base-service-name":      "myapp-web",
 		"router.tsuru.io/base-service-namespace": "default",
 	}, virtualSvc.Labels)
-	assert.Equal(t, map[string]string{}, virtualSvc.Annotations)
+	assert.Empty(t, virtualSvc.Annotations)
 	assert.Equal(t, apiNetworking.VirtualService{
 		Gateways: []string{
 			"mesh",
@@ -103,6 +155,28 @@ func TestIstioGateway_Ensure(t *testing.T) {
 	}, virtualSvc.Spec)
 }
 
+func TestIstioGateway_EnsureRetriesOnConflict(t *testing.T) {
+	svc, _ := fakeService()
+	istioClient := newFakeIstioClientset()
+	svc.istioClient = istioClient.NetworkingV1beta1()
+	err := createAppWebService(svc.Client, svc.Namespace, "myapp")
+	require.NoError(t, err)
+	applies := 0
+	istioClient.PrependReactor("patch", "virtualservices", concurrentApply(networking.Resource("virtualservices"), "myapp", &applies))
+	retriesBefore := conflictRetriesValue(t, "virtualservice")
+
+	err = svc.Ensure(ctx, idForApp("myapp"), router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, applies)
+	assert.Equal(t, retriesBefore+2, conflictRetriesValue(t, "virtualservice"))
+	_, err = svc.istioClient.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+}
+
 func TestIstioGateway_EnsureWithCNames(t *testing.T) {
 	svc, istio := fakeService()
 	err := createAppWebService(svc.Client, svc.Namespace, "myapp")
@@ -124,7 +198,7 @@ func TestIstioGateway_EnsureWithCNames(t *testing.T) {
 	virtualSvc, err := istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
 	require.NoError(t, err)
 	assert.Equal(t, map[string]string{"tsuru.io/app-name": "myapp"}, gateway.Labels)
-	assert.Equal(t, map[string]string{}, gateway.Annotations)
+	assert.Empty(t, gateway.Annotations)
 	assert.Equal(t, apiNetworking.Gateway{
 		Servers: []*apiNetworking.Server{
 			{
@@ -220,7 +294,7 @@ func TestIstioGateway_Create_existingVirtualService(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, map[string]string{"tsuru.io/app-name": "myapp"}, gateway.Labels)
-	assert.Equal(t, map[string]string{}, gateway.Annotations)
+	assert.Empty(t, gateway.Annotations)
 
 	assert.Equal(t, apiNetworking.Gateway{
 		Servers: []*apiNetworking.Server{
@@ -244,7 +318,7 @@ func TestIstioGateway_Create_existingVirtualService(t *testing.T) {
 		"router.tsuru.io/base-service-name":      "myapp-web",
 		"router.tsuru.io/base-service-namespace": "default",
 	}, virtualSvc.Labels)
-	assert.Equal(t, map[string]string{}, virtualSvc.Annotations)
+	assert.Empty(t, virtualSvc.Annotations)
 	assert.Equal(t, apiNetworking.VirtualService{
 		Gateways: []string{
 			"myapp",
@@ -274,6 +348,57 @@ func TestIstioGateway_Create_existingVirtualService(t *testing.T) {
 	}, virtualSvc.Spec)
 }
 
+func TestIstioGateway_EnsureServerSideApply(t *testing.T) {
+	svc, istio := fakeService()
+	err := createAppWebService(svc.Client, svc.Namespace, "myapp")
+	require.NoError(t, err)
+	opts := router.EnsureBackendOpts{
+		Opts: router.Opts{
+			AdditionalOpts: map[string]string{"my-opt": "value"},
+		},
+		CNames: []string{"test.io"},
+		Prefixes: []router.BackendPrefix{
+			{
+				Target: router.BackendTarget{
+					Service:   "myapp-web",
+					Namespace: svc.Namespace,
+				},
+			},
+		},
+	}
+	err = svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	virtualSvc, err := istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	virtualSvc.Annotations["external-annotation"] = "external"
+	_, err = istio.VirtualServices("default").Update(ctx, virtualSvc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	gateway, err := istio.Gateways("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "value", gateway.Annotations["my-opt"])
+	gateway.Annotations["external-annotation"] = "external"
+	_, err = istio.Gateways("default").Update(ctx, gateway, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	opts.Opts.AdditionalOpts = map[string]string{"other-opt": "value"}
+	err = svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	gateway, err = istio.Gateways("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"external-annotation": "external",
+		"other-opt":           "value",
+	}, gateway.Annotations)
+	virtualSvc, err = istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"external-annotation": "external",
+		"other-opt":           "value",
+		hostsAnnotation:       "test.io",
+	}, virtualSvc.Annotations)
+	assert.Equal(t, []string{"myapp-web", "myapp.my.domain", "test.io"}, virtualSvc.Spec.Hosts)
+}
+
 func TestIstioGateway_CNameLifeCycle(t *testing.T) {
 	tests := []struct {
 		annotation         string
@@ -392,3 +517,700 @@ func TestIstioGateway_CNameLifeCycle(t *testing.T) {
 		})
 	}
 }
+
+func TestIstioGateway_Swap(t *testing.T) {
+	svc, istio := fakeService()
+	for _, app := range []string{"app1", "app2"} {
+		err := createAppWebService(svc.Client, svc.Namespace, app)
+		require.NoError(t, err)
+		err = svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			CNames: []string{app + ".example.com"},
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: app + "-web", Namespace: svc.Namespace}},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.NoError(t, err)
+	app1, err := istio.VirtualServices("default").Get(ctx, "app1", metav1.GetOptions{})
+	require.NoError(t, err)
+	app2, err := istio.VirtualServices("default").Get(ctx, "app2", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2-web", app1.Spec.Http[0].Route[0].Destination.Host)
+	assert.Equal(t, "app1-web", app2.Spec.Http[0].Route[0].Destination.Host)
+	assert.Equal(t, "app2", app1.Labels[swappedWithLabel])
+
+	// Ensure keeps the swapped destination
+	err = svc.Ensure(ctx, idForApp("app1"), router.EnsureBackendOpts{
+		CNames: []string{"app1.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "app1-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+	app1, err = istio.VirtualServices("default").Get(ctx, "app1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, app1.Spec.Http[0].Route, 1)
+	assert.Equal(t, "app2-web", app1.Spec.Http[0].Route[0].Destination.Host)
+}
+
+func TestIstioGateway_SwapCNameOnly(t *testing.T) {
+	svc, istio := fakeService()
+	for _, app := range []string{"app1", "app2"} {
+		err := createAppWebService(svc.Client, svc.Namespace, app)
+		require.NoError(t, err)
+		err = svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			CNames: []string{app + ".example.com"},
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: app + "-web", Namespace: svc.Namespace}},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), true)
+	require.NoError(t, err)
+	app1, err := istio.VirtualServices("default").Get(ctx, "app1", metav1.GetOptions{})
+	require.NoError(t, err)
+	app2, err := istio.VirtualServices("default").Get(ctx, "app2", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2.example.com", app1.Annotations[hostsAnnotation])
+	assert.Equal(t, "app1.example.com", app2.Annotations[hostsAnnotation])
+	assert.Contains(t, app1.Spec.Hosts, "app2.example.com")
+	assert.NotContains(t, app1.Spec.Hosts, "app1.example.com")
+	assert.Equal(t, "app1-web", app1.Spec.Http[0].Route[0].Destination.Host)
+	assert.NotContains(t, app1.Labels, swappedWithLabel)
+}
+
+func TestIstioGateway_SwapPrefixes(t *testing.T) {
+	svc, istio := fakeService()
+	for _, app := range []string{"app1", "app2"} {
+		require.NoError(t, createAppWebService(svc.Client, svc.Namespace, app))
+		require.NoError(t, createAppWebService(svc.Client, svc.Namespace, app+"-worker"))
+		err := svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			Opts: router.Opts{ExposeAllServices: true},
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: app + "-web", Namespace: svc.Namespace}},
+				{Prefix: "worker", Target: router.BackendTarget{Service: app + "-worker-web", Namespace: svc.Namespace}},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.NoError(t, err)
+	app1, err := istio.VirtualServices("default").Get(ctx, "app1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, app1.Spec.Http, 2)
+	assert.Equal(t, "prefix-worker", app1.Spec.Http[0].Name)
+	assert.Equal(t, "app2-worker-web", app1.Spec.Http[0].Route[0].Destination.Host)
+	assert.Equal(t, "app2-web", app1.Spec.Http[1].Route[0].Destination.Host)
+	app2, err := istio.VirtualServices("default").Get(ctx, "app2", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app1-worker-web", app2.Spec.Http[0].Route[0].Destination.Host)
+
+	// Ensure keeps the swapped destinations of the prefixes
+	err = svc.Ensure(ctx, idForApp("app1"), router.EnsureBackendOpts{
+		Opts: router.Opts{ExposeAllServices: true},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "app1-web", Namespace: svc.Namespace}},
+			{Prefix: "worker", Target: router.BackendTarget{Service: "app1-worker-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+	app1, err = istio.VirtualServices("default").Get(ctx, "app1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2-worker-web", app1.Spec.Http[0].Route[0].Destination.Host)
+	assert.Equal(t, "app2-web", app1.Spec.Http[1].Route[0].Destination.Host)
+}
+
+func TestIstioGateway_SwapDifferentPrefixes(t *testing.T) {
+	svc, _ := fakeService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "app1-worker"))
+	for _, app := range []string{"app1", "app2"} {
+		require.NoError(t, createAppWebService(svc.Client, svc.Namespace, app))
+		prefixes := []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: app + "-web", Namespace: svc.Namespace}},
+		}
+		if app == "app1" {
+			prefixes = append(prefixes, router.BackendPrefix{Prefix: "worker", Target: router.BackendTarget{Service: "app1-worker-web", Namespace: svc.Namespace}})
+		}
+		err := svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			Opts:     router.Opts{ExposeAllServices: true},
+			Prefixes: prefixes,
+		})
+		require.NoError(t, err)
+	}
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	assert.Equal(t, router.NewError(router.ErrorCodeUnsupported, "cannot swap virtualservices app1 and app2 with different prefixes"), err)
+}
+
+func TestIstioGateway_SwapRollback(t *testing.T) {
+	svc, _ := fakeService()
+	istioClient := newFakeIstioClientset()
+	svc.istioClient = istioClient.NetworkingV1beta1()
+	for _, app := range []string{"app1", "app2"} {
+		require.NoError(t, createAppWebService(svc.Client, svc.Namespace, app))
+		err := svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: app + "-web", Namespace: svc.Namespace}},
+			},
+		})
+		require.NoError(t, err)
+	}
+	istioClient.PrependReactor("patch", "virtualservices", failApply("app2"))
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.EqualError(t, err, "apply failed")
+
+	// the virtualservice swapped first is restored
+	app1, err := istioClient.NetworkingV1beta1().VirtualServices("default").Get(ctx, "app1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app1-web", app1.Spec.Http[0].Route[0].Destination.Host)
+	assert.NotContains(t, app1.Labels, swappedWithLabel)
+	assert.Equal(t, "app1-web", app1.Labels[appBaseServiceNameLabel])
+}
+
+func TestIstioGateway_EnsureWeightedTargets(t *testing.T) {
+	svc, istio := fakeService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp-canary"))
+
+	opts := router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{
+				Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace},
+				WeightedTargets: []router.WeightedBackendTarget{
+					{BackendTarget: router.BackendTarget{Service: "myapp-canary-web", Namespace: svc.Namespace}, Weight: 10},
+				},
+			},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	virtualSvc, err := istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []*apiNetworking.HTTPRouteDestination{
+		{Destination: &apiNetworking.Destination{Host: "myapp-web.default.svc.cluster.local"}, Weight: 90},
+		{Destination: &apiNetworking.Destination{Host: "myapp-canary-web.default.svc.cluster.local"}, Weight: 10},
+	}, virtualSvc.Spec.Http[0].Route)
+
+	// removing the weighted targets keeps only the main destination
+	opts.Prefixes[0].WeightedTargets = nil
+	err = svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	virtualSvc, err = istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []*apiNetworking.HTTPRouteDestination{
+		{Destination: &apiNetworking.Destination{Host: "myapp-web"}},
+	}, virtualSvc.Spec.Http[0].Route)
+	assert.NotContains(t, virtualSvc.Annotations, weightedDestinationsAnnotation)
+}
+
+func TestIstioGateway_EnsureHeaderModifiers(t *testing.T) {
+	svc, istio := fakeService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+
+	opts := router.EnsureBackendOpts{
+		Opts: router.Opts{
+			RequestHeaders:  &router.HeaderModifier{Set: map[string]string{"X-Forwarded-Proto": "https"}},
+			ResponseHeaders: &router.HeaderModifier{Remove: []string{"Server"}},
+		},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	virtualSvc, err := istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, &apiNetworking.Headers{
+		Request:  &apiNetworking.Headers_HeaderOperations{Set: map[string]string{"X-Forwarded-Proto": "https"}},
+		Response: &apiNetworking.Headers_HeaderOperations{Remove: []string{"Server"}},
+	}, virtualSvc.Spec.Http[0].Headers)
+
+	// removing the options removes the header operations
+	opts.Opts = router.Opts{}
+	err = svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	virtualSvc, err = istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, virtualSvc.Spec.Http[0].Headers)
+	assert.NotContains(t, virtualSvc.Annotations, headerModifiersAnnotation)
+}
+
+func TestIstioGateway_EnsureTrafficPolicies(t *testing.T) {
+	svc, istio := fakeService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "shadow"))
+
+	percent := int32(25)
+	opts := router.EnsureBackendOpts{
+		Opts: router.Opts{
+			Timeout:       "1m30s",
+			RetryOn:       []string{"5xx", "reset"},
+			MirrorTo:      "shadow-web:9000",
+			MirrorPercent: &percent,
+		},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	virtualSvc, err := istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	route := virtualSvc.Spec.Http[0]
+	assert.Equal(t, int64(90), route.Timeout.Seconds)
+	assert.Equal(t, &apiNetworking.HTTPRetry{Attempts: 2, RetryOn: "5xx,reset"}, route.Retries)
+	assert.Equal(t, &apiNetworking.Destination{Host: "shadow-web", Port: &apiNetworking.PortSelector{Number: 9000}}, route.Mirror)
+	assert.Equal(t, &apiNetworking.Percent{Value: 25}, route.MirrorPercentage)
+
+	// removing the options removes the policies
+	opts.Opts = router.Opts{}
+	err = svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	virtualSvc, err = istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	route = virtualSvc.Spec.Http[0]
+	assert.Nil(t, route.Timeout)
+	assert.Nil(t, route.Retries)
+	assert.Nil(t, route.Mirror)
+	assert.Nil(t, route.MirrorPercentage)
+	assert.NotContains(t, virtualSvc.Annotations, trafficPoliciesAnnotation)
+}
+
+func ensureIstioStatusApp(t *testing.T, svc IstioGateway) {
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	err := svc.Ensure(ctx, idForApp("myapp"), router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+}
+
+func createIstioGatewayPod(t *testing.T, svc IstioGateway, phase corev1.PodPhase) {
+	_, err := svc.Client.CoreV1().Pods("istio-system").Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "istio-ingressgateway",
+			Labels: map[string]string{"istio": "ingress"},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func createEndpointSlice(t *testing.T, svc IstioGateway, service string, ready bool) {
+	_, err := svc.Client.DiscoveryV1().EndpointSlices(svc.Namespace).Create(ctx, &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   service + "-abcde",
+			Labels: map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}},
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func TestIstioGateway_GetStatusReady(t *testing.T) {
+	svc, _ := fakeService()
+	ensureIstioStatusApp(t, svc)
+	createIstioGatewayPod(t, svc, corev1.PodRunning)
+	createEndpointSlice(t, svc, "myapp-web", true)
+
+	status, detail, err := svc.GetStatus(ctx, idForApp("myapp"))
+	require.NoError(t, err)
+	assert.Equal(t, router.BackendStatusReady, status)
+	assert.Equal(t, "", detail)
+}
+
+func TestIstioGateway_GetStatusWaitingForDeploy(t *testing.T) {
+	svc, _ := fakeService()
+	status, detail, err := svc.GetStatus(ctx, idForApp("myapp"))
+	require.NoError(t, err)
+	assert.Equal(t, router.BackendStatusNotReady, status)
+	assert.Equal(t, "waiting for deploy", detail)
+}
+
+func TestIstioGateway_GetStatusNoGatewayPods(t *testing.T) {
+	svc, _ := fakeService()
+	ensureIstioStatusApp(t, svc)
+	createIstioGatewayPod(t, svc, corev1.PodPending)
+	createEndpointSlice(t, svc, "myapp-web", true)
+
+	status, detail, err := svc.GetStatus(ctx, idForApp("myapp"))
+	require.NoError(t, err)
+	assert.Equal(t, router.BackendStatusNotReady, status)
+	assert.Contains(t, detail, `no running pods match the gateway selector "istio=ingress"`)
+}
+
+func TestIstioGateway_GetStatusGatewayPodsNamespace(t *testing.T) {
+	svc, _ := fakeService()
+	ensureIstioStatusApp(t, svc)
+	createEndpointSlice(t, svc, "myapp-web", true)
+	_, err := svc.Client.CoreV1().Pods("other").Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "other-gateway",
+			Labels: map[string]string{"istio": "ingress"},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	client := svc.Client.(*fake.Clientset)
+	client.ClearActions()
+
+	status, detail, err := svc.GetStatus(ctx, idForApp("myapp"))
+	require.NoError(t, err)
+	assert.Equal(t, router.BackendStatusNotReady, status)
+	assert.Contains(t, detail, `no running pods match the gateway selector "istio=ingress"`)
+
+	// Assert: a single running pod is listed in the namespace of the gateway.
+	var lists []k8stesting.ListActionImpl
+	for _, action := range client.Actions() {
+		if list, ok := action.(k8stesting.ListActionImpl); ok && list.GetResource().Resource == "pods" {
+			lists = append(lists, list)
+		}
+	}
+	require.Len(t, lists, 1)
+	assert.Equal(t, "istio-system", lists[0].GetNamespace())
+	assert.Equal(t, "status.phase=Running", lists[0].GetListRestrictions().Fields.String())
+	assert.Equal(t, int64(1), lists[0].ListOptions.Limit)
+}
+
+func TestIstioGateway_GetStatusDestinationProblems(t *testing.T) {
+	svc, istio := fakeService()
+	ensureIstioStatusApp(t, svc)
+	createIstioGatewayPod(t, svc, corev1.PodRunning)
+	createEndpointSlice(t, svc, "myapp-web", false)
+
+	status, detail, err := svc.GetStatus(ctx, idForApp("myapp"))
+	require.NoError(t, err)
+	assert.Equal(t, router.BackendStatusNotReady, status)
+	assert.Contains(t, detail, "destination service default/myapp-web has no ready endpoints")
+
+	virtualSvc, err := istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	virtualSvc.Spec.Http[0].Route[0].Destination.Host = "other-web.other-ns.svc.cluster.local"
+	_, err = istio.VirtualServices("default").Update(ctx, virtualSvc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	status, detail, err = svc.GetStatus(ctx, idForApp("myapp"))
+	require.NoError(t, err)
+	assert.Equal(t, router.BackendStatusNotReady, status)
+	assert.Contains(t, detail, "destination service other-ns/other-web not found")
+}
+
+func TestIstioGateway_GetStatusIstioValidation(t *testing.T) {
+	svc, istio := fakeService()
+	ensureIstioStatusApp(t, svc)
+	createIstioGatewayPod(t, svc, corev1.PodRunning)
+	createEndpointSlice(t, svc, "myapp-web", true)
+
+	virtualSvc, err := istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	virtualSvc.Status = istiometa.IstioStatus{
+		ValidationMessages: []*analysisv1alpha1.AnalysisMessageBase{
+			{
+				Type:  &analysisv1alpha1.AnalysisMessageBase_Type{Code: "IST0101", Name: "ReferencedResourceNotFound"},
+				Level: analysisv1alpha1.AnalysisMessageBase_ERROR,
+			},
+			{
+				Type:  &analysisv1alpha1.AnalysisMessageBase_Type{Code: "IST0102", Name: "NamespaceNotInjected"},
+				Level: analysisv1alpha1.AnalysisMessageBase_INFO,
+			},
+		},
+		Conditions: []*istiometa.IstioCondition{
+			{Type: "Reconciled", Status: "False", Reason: "NotReconciled", Message: "pending"},
+		},
+	}
+	_, err = istio.VirtualServices("default").Update(ctx, virtualSvc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	status, detail, err := svc.GetStatus(ctx, idForApp("myapp"))
+	require.NoError(t, err)
+	assert.Equal(t, router.BackendStatusNotReady, status)
+	assert.Contains(t, detail, "validation error: IST0101 ReferencedResourceNotFound")
+	assert.NotContains(t, detail, "IST0102")
+	assert.Contains(t, detail, "condition Reconciled is false: NotReconciled pending")
+}
+
+func TestIstioGateway_Certificates(t *testing.T) {
+	svc, istio := fakeService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	err := svc.Ensure(ctx, idForApp("myapp"), router.EnsureBackendOpts{
+		CNames: []string{"www.test.io"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+
+	err = svc.AddCertificate(ctx, idForApp("myapp"), "other.test.io", router.CertData{Certificate: "cert", Key: "key"})
+	assert.EqualError(t, err, "cname other.test.io is not found in virtualservice myapp, found hosts: myapp-web, myapp.my.domain, www.test.io")
+
+	expectedCert := newTestCertData(t, "www.test.io")
+	err = svc.AddCertificate(ctx, idForApp("myapp"), "www.test.io", expectedCert)
+	require.NoError(t, err)
+	secret, err := svc.Client.CoreV1().Secrets("istio-system").Get(ctx, "kr-myapp-www.test.io", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	assert.Equal(t, map[string]string{"tls.crt": expectedCert.Certificate, "tls.key": expectedCert.Key}, secret.StringData)
+	gateway, err := istio.Gateways("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, gateway.Spec.Servers, 2)
+	assert.Equal(t, &apiNetworking.Server{
+		Name: "https-www-test-io",
+		Port: &apiNetworking.Port{
+			Number:   443,
+			Name:     "https-www-test-io",
+			Protocol: "HTTPS",
+		},
+		Hosts: []string{"www.test.io"},
+		Tls: &apiNetworking.ServerTLSSettings{
+			Mode:           apiNetworking.ServerTLSSettings_SIMPLE,
+			CredentialName: "kr-myapp-www.test.io",
+		},
+	}, gateway.Spec.Servers[1])
+
+	// ensuring the app again keeps the HTTPS server of the certificate
+	err = svc.Ensure(ctx, idForApp("myapp"), router.EnsureBackendOpts{
+		CNames: []string{"www.test.io"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+	gateway, err = istio.Gateways("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, gateway.Spec.Servers, 2)
+
+	secret.Data = map[string][]byte{"tls.crt": []byte(expectedCert.Certificate), "tls.key": []byte(expectedCert.Key)}
+	_, err = svc.Client.CoreV1().Secrets("istio-system").Update(ctx, secret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	cert, err := svc.GetCertificate(ctx, idForApp("myapp"), "www.test.io")
+	require.NoError(t, err)
+	assert.Equal(t, &expectedCert, cert)
+
+	err = svc.RemoveCertificate(ctx, idForApp("myapp"), "www.test.io")
+	require.NoError(t, err)
+	gateway, err = istio.Gateways("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, gateway.Spec.Servers, 1)
+	_, err = svc.GetCertificate(ctx, idForApp("myapp"), "www.test.io")
+	assert.Equal(t, router.ErrCertificateNotFound, err)
+}
+
+func TestIstioGateway_CertificatesRetriesOnConflict(t *testing.T) {
+	svc, _ := fakeService()
+	istioClient := newFakeIstioClientset()
+	svc.istioClient = istioClient.NetworkingV1beta1()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	err := svc.Ensure(ctx, idForApp("myapp"), router.EnsureBackendOpts{
+		CNames: []string{"www.test.io"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+	applies := 0
+	istioClient.PrependReactor("patch", "gateways", concurrentApply(networking.Resource("gateways"), "myapp", &applies))
+	retriesBefore := conflictRetriesValue(t, "gateway")
+
+	err = svc.AddCertificate(ctx, idForApp("myapp"), "www.test.io", newTestCertData(t, "www.test.io"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, applies)
+	assert.Equal(t, retriesBefore+2, conflictRetriesValue(t, "gateway"))
+	gateway, err := svc.istioClient.Gateways("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, gateway.Spec.Servers, 2)
+
+	applies = 0
+	err = svc.RemoveCertificate(ctx, idForApp("myapp"), "www.test.io")
+	require.NoError(t, err)
+	assert.Equal(t, 3, applies)
+	assert.Equal(t, retriesBefore+4, conflictRetriesValue(t, "gateway"))
+	gateway, err = svc.istioClient.Gateways("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, gateway.Spec.Servers, 1)
+}
+
+func TestIstioGateway_AddCertificateInvalid(t *testing.T) {
+	svc, istio := fakeService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	err := svc.Ensure(ctx, idForApp("myapp"), router.EnsureBackendOpts{
+		CNames: []string{"www.test.io"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+
+	err = svc.AddCertificate(ctx, idForApp("myapp"), "www.test.io", newTestCertData(t, "other.test.io"))
+	assert.EqualError(t, err, "invalid certificate: certificate is not valid for www.test.io, it is valid for other.test.io")
+	var certErr *router.InvalidCertificateError
+	assert.ErrorAs(t, err, &certErr)
+
+	_, err = svc.Client.CoreV1().Secrets("istio-system").Get(ctx, "kr-myapp-www.test.io", metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+	gateway, err := istio.Gateways("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, gateway.Spec.Servers, 1)
+}
+
+func TestIstioGateway_EnsureAcme(t *testing.T) {
+	svc, istio := fakeService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	opts := router.EnsureBackendOpts{
+		Opts:        router.Opts{Acme: true},
+		CNames:      []string{"www.test.io", "api.test.io"},
+		CertIssuers: map[string]string{"api.test.io": "myissuer.CustomIssuer.example.com"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+
+	gateway, err := istio.Gateways("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "api.test.io,myapp.my.domain", gateway.Annotations[acmeHostsAnnotation])
+	require.Len(t, gateway.Spec.Servers, 3)
+	certificates, err := svc.CertManagerClient.CertmanagerV1().Certificates("istio-system").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, certificates.Items, 2)
+	appCert, err := svc.CertManagerClient.CertmanagerV1().Certificates("istio-system").Get(ctx, "kr-myapp-myapp.my.domain", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myapp.my.domain"}, appCert.Spec.DNSNames)
+	assert.Equal(t, "kr-myapp-myapp.my.domain", appCert.Spec.SecretName)
+	assert.Equal(t, "letsencrypt", appCert.Spec.IssuerRef.Name)
+	assert.Equal(t, certmanagerv1.ClusterIssuerKind, appCert.Spec.IssuerRef.Kind)
+	cnameCert, err := svc.CertManagerClient.CertmanagerV1().Certificates("istio-system").Get(ctx, "kr-myapp-api.test.io", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "myissuer", cnameCert.Spec.IssuerRef.Name)
+	assert.Equal(t, "CustomIssuer", cnameCert.Spec.IssuerRef.Kind)
+	assert.Equal(t, "example.com", cnameCert.Spec.IssuerRef.Group)
+
+	err = svc.AddCertificate(ctx, idForApp("myapp"), "myapp.my.domain", router.CertData{Certificate: "cert", Key: "key"})
+	assert.EqualError(t, err, "cannot add certificate to gateway myapp, myapp.my.domain is managed by ACME")
+
+	opts.Opts.Acme = false
+	opts.CertIssuers = nil
+	err = svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	gateway, err = istio.Gateways("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, gateway.Annotations, acmeHostsAnnotation)
+	assert.Len(t, gateway.Spec.Servers, 1)
+	certificates, err = svc.CertManagerClient.CertmanagerV1().Certificates("istio-system").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, certificates.Items, 0)
+}
+
+func TestIstioGateway_EnsureAcmeWithoutIssuer(t *testing.T) {
+	svc, _ := fakeService()
+	svc.AcmeIssuer = ""
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	err := svc.Ensure(ctx, idForApp("myapp"), router.EnsureBackendOpts{
+		Opts: router.Opts{Acme: true},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+		},
+	})
+	assert.EqualError(t, err, "no cert-manager issuer set to issue a certificate for myapp.my.domain")
+}
+
+func TestIstioGateway_EnsureAllPrefixes(t *testing.T) {
+	svc, istio := fakeService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp"))
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "myapp-worker"))
+	opts := router.EnsureBackendOpts{
+		Opts: router.Opts{ExposeAllServices: true, RequestHeaders: &router.HeaderModifier{Set: map[string]string{"X-Env": "prod"}}},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: svc.Namespace}},
+			{Prefix: "worker", Target: router.BackendTarget{Service: "myapp-worker-web", Namespace: svc.Namespace}},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+
+	virtualSvc, err := istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myapp-web", "myapp.my.domain", "worker.myapp.my.domain"}, virtualSvc.Spec.Hosts)
+	require.Len(t, virtualSvc.Spec.Http, 2)
+	assert.Equal(t, &apiNetworking.HTTPRoute{
+		Name: "prefix-worker",
+		Match: []*apiNetworking.HTTPMatchRequest{
+			{
+				Authority: &apiNetworking.StringMatch{
+					MatchType: &apiNetworking.StringMatch_Regex{Regex: `^worker\.myapp\.my\.domain(:[0-9]+)?$`},
+				},
+			},
+		},
+		Route: []*apiNetworking.HTTPRouteDestination{
+			{Destination: &apiNetworking.Destination{Host: "myapp-worker-web"}},
+		},
+		Headers: &apiNetworking.Headers{
+			Request: &apiNetworking.Headers_HeaderOperations{Set: map[string]string{"X-Env": "prod"}},
+		},
+	}, virtualSvc.Spec.Http[0])
+	assert.Equal(t, "myapp-web", virtualSvc.Spec.Http[1].Route[0].Destination.Host)
+
+	addresses, err := svc.GetAddresses(ctx, idForApp("myapp"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myapp.my.domain", "worker.myapp.my.domain"}, addresses)
+
+	opts.Opts.ExposeAllServices = false
+	err = svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	virtualSvc, err = istio.VirtualServices("default").Get(ctx, "myapp", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myapp-web", "myapp.my.domain"}, virtualSvc.Spec.Hosts)
+	require.Len(t, virtualSvc.Spec.Http, 1)
+	assert.Equal(t, "myapp-web", virtualSvc.Spec.Http[0].Route[0].Destination.Host)
+}
+
+func TestIstioGateway_PlanEnsure(t *testing.T) {
+	server := newFakeAPIServer(t, map[string]string{
+		"/api/v1/namespaces/default/services/myapp-web": webServiceObject("myapp"),
+	})
+	defer server.Close()
+	svc := &IstioGateway{
+		BaseService:     &BaseService{Namespace: "default", RestConfig: server.restConfig()},
+		DomainSuffix:    "my.domain",
+		GatewaySelector: map[string]string{"istio": "ingress"},
+	}
+	opts := router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	server.resetPersisted()
+	objects := server.snapshot()
+
+	opts.CNames = []string{"www.myapp.io"}
+	plan, err := svc.PlanEnsure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+
+	// Assert: the existing gateway is kept and the cname added to the
+	// virtualservice.
+	assert.Equal(t, []string{"update VirtualService default/myapp"}, planChanges(plan))
+	assert.Equal(t, []router.FieldChange{
+		{Path: `metadata.annotations["tsuru.io/additional-hosts"]`, New: "www.myapp.io"},
+		{
+			Path: "spec.hosts",
+			Old:  []interface{}{"myapp-web", "myapp.my.domain"},
+			New:  []interface{}{"myapp-web", "myapp.my.domain", "www.myapp.io"},
+		},
+	}, planDiff(t, plan, "VirtualService", "myapp"))
+
+	// Assert: nothing was written.
+	assert.Empty(t, server.persisted)
+	assert.Equal(t, objects, server.snapshot())
+}
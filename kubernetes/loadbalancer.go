@@ -6,9 +6,8 @@ package kubernetes
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -17,6 +16,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
@@ -28,16 +28,24 @@ const (
 	exposeAllPortsOpt = "expose-all-ports"
 
 	annotationOptPrefix = "svc-annotation-"
+
+	// lbPrefixLabel is the backend prefix exposed by the LoadBalancer service
+	// of a prefix other than the default one
+	lbPrefixLabel = "router.tsuru.io/prefix"
 )
 
 var (
 	// ErrLoadBalancerNotReady is returned when a given LB has no IP
-	ErrLoadBalancerNotReady = errors.New("load balancer is not ready")
+	ErrLoadBalancerNotReady = &router.Error{Code: router.ErrorCodeUnavailable, Message: "load balancer is not ready"}
+
+	serviceGVK = v1.SchemeGroupVersion.WithKind("Service")
 )
 
 var (
 	_ router.Router       = &LBService{}
 	_ router.RouterStatus = &LBService{}
+	_ router.RouterSwap   = &LBService{}
+	_ router.RouterPlan   = &LBService{}
 )
 
 // LBService manages LoadBalancer services
@@ -72,10 +80,42 @@ func (s *LBService) Remove(ctx context.Context, id router.InstanceID) error {
 		return err
 	}
 	err = client.CoreV1().Services(ns).Delete(ctx, service.Name, metav1.DeleteOptions{})
-	if k8sErrors.IsNotFound(err) {
-		return nil
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
 	}
-	return err
+	return s.removePrefixServices(ctx, id, ns, nil)
+}
+
+// removePrefixServices removes the LoadBalancer services of the prefixes not
+// in the expected prefixes.
+func (s *LBService) removePrefixServices(ctx context.Context, id router.InstanceID, ns string, expected map[string]router.BackendTarget) error {
+	client, err := s.getClient()
+	if err != nil {
+		return err
+	}
+	selector, err := labels.Parse(fmt.Sprintf("%s=%s,%s", appLabel, id.AppName, lbPrefixLabel))
+	if err != nil {
+		return err
+	}
+	services, err := client.CoreV1().Services(ns).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	for _, svc := range services.Items {
+		prefix := svc.Labels[lbPrefixLabel]
+		if svc.Name != s.serviceNameForPrefix(id, prefix) {
+			// service of another instance of the app
+			continue
+		}
+		if _, ok := expected[prefix]; ok {
+			continue
+		}
+		err = client.CoreV1().Services(ns).Delete(ctx, svc.Name, metav1.DeleteOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
 }
 
 // Get returns the LoadBalancer IP
@@ -106,10 +146,51 @@ func (s *LBService) GetAddresses(ctx context.Context, id router.InstanceID) ([]s
 	return []string{addr}, nil
 }
 
+// Swap swaps the selectors of the LoadBalancer services of two apps, services
+// have no cnames so a cnameOnly swap does nothing
+func (s *LBService) Swap(ctx context.Context, srcApp, dstApp router.InstanceID, cnameOnly bool) error {
+	if cnameOnly {
+		return nil
+	}
+	ns, err := s.getSwapNamespace(ctx, srcApp, dstApp)
+	if err != nil {
+		return err
+	}
+	srcService, err := s.getLBService(ctx, srcApp)
+	if err != nil {
+		return err
+	}
+	dstService, err := s.getLBService(ctx, dstApp)
+	if err != nil {
+		return err
+	}
+	if isFrozenSvc(srcService) || isFrozenSvc(dstService) {
+		return router.NewError(router.ErrorCodeFrozen, "cannot swap frozen services %s and %s", srcService.Name, dstService.Name)
+	}
+	client, err := s.getClient()
+	if err != nil {
+		return err
+	}
+
+	srcOriginal, dstOriginal := srcService.DeepCopy(), dstService.DeepCopy()
+	srcService.Spec.Selector, dstService.Spec.Selector = dstService.Spec.Selector, srcService.Spec.Selector
+	swapBaseServiceLabels(&srcService.ObjectMeta, &dstService.ObjectMeta)
+	toggleSwapped(&srcService.ObjectMeta, dstApp.AppName)
+	toggleSwapped(&dstService.ObjectMeta, srcApp.AppName)
+
+	writes := &swapWrites{}
+	err = applySwapped(ctx, writes, client.CoreV1().Services(ns), serviceGVK, srcService, srcOriginal)
+	if err != nil {
+		return err
+	}
+	return applySwapped(ctx, writes, client.CoreV1().Services(ns), serviceGVK, dstService, dstOriginal)
+}
+
 // SupportedOptions returns all the supported options
 func (s *LBService) SupportedOptions(ctx context.Context) map[string]string {
 	opts := map[string]string{
 		router.ExposedPort: "",
+		router.AllPrefixes: "",
 		exposeAllPortsOpt:  "Expose all ports used by application in the Load Balancer. Defaults to false.",
 	}
 	for k, v := range s.OptsAsLabels {
@@ -156,6 +237,13 @@ func (s *LBService) serviceName(id router.InstanceID) string {
 	return s.hashedResourceName(id, fmt.Sprintf("%s-router-lb", id.AppName), 63)
 }
 
+func (s *LBService) serviceNameForPrefix(id router.InstanceID, prefix string) string {
+	if prefix == "default" {
+		return s.serviceName(id)
+	}
+	return s.hashedResourceName(id, fmt.Sprintf("%s-%s-router-lb", id.AppName, prefix), 63)
+}
+
 func isReady(service *v1.Service) bool {
 	if len(service.Status.LoadBalancer.Ingress) == 0 {
 		return false
@@ -165,8 +253,8 @@ func isReady(service *v1.Service) bool {
 }
 
 // Ensure creates or updates the LoadBalancer service copying the web service
-// labels, selectors, annotations and ports
-
+// labels, selectors, annotations and ports. With all-prefixes set every
+// prefix gets its own LoadBalancer service.
 func (s *LBService) Ensure(ctx context.Context, id router.InstanceID, o router.EnsureBackendOpts) error {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "ensureLoadbalancer")
 	defer span.Finish()
@@ -175,79 +263,109 @@ func (s *LBService) Ensure(ctx context.Context, id router.InstanceID, o router.E
 	if err != nil {
 		return err
 	}
-	isNew := false
-	existingLBService, err := s.getLBService(ctx, id)
-	var lbService *v1.Service
+	ns := s.Namespace
+	if app != nil {
+		ns = app.Spec.NamespaceName
+	}
+
+	backendTargets, err := s.getBackendTargets(o.Prefixes, o.Opts.ExposeAllServices)
 	if err != nil {
-		if !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	if _, ok := backendTargets["default"]; !ok {
+		return ErrNoBackendTarget
+	}
+	for _, prefix := range sortedTargetKeys(backendTargets) {
+		err = retryOnConflict("service", func(bool) error {
+			return s.ensureLBService(ctx, id, ns, prefix, backendTargets[prefix], o)
+		})
+		if err != nil {
 			return err
 		}
-		isNew = true
-		ns := s.Namespace
-		if app != nil {
-			ns = app.Spec.NamespaceName
-		}
-		lbService = &v1.Service{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      s.serviceName(id),
-				Namespace: ns,
-			},
-			Spec: v1.ServiceSpec{
-				Type: v1.ServiceTypeLoadBalancer,
-			},
-		}
 	}
-	if !isNew {
-		lbService = existingLBService.DeepCopy()
+	return s.removePrefixServices(ctx, id, ns, backendTargets)
+}
+
+// PlanEnsure returns the changes Ensure would make to the LoadBalancer
+// services of the app, without writing them.
+func (s *LBService) PlanEnsure(ctx context.Context, id router.InstanceID, o router.EnsureBackendOpts) (*router.Plan, error) {
+	return planEnsure(ctx, func(ctx context.Context) error {
+		return s.Ensure(ctx, id, o)
+	})
+}
+
+func (s *LBService) ensureLBService(ctx context.Context, id router.InstanceID, ns, prefix string, target router.BackendTarget, o router.EnsureBackendOpts) error {
+	client, err := s.getClient()
+	if err != nil {
+		return err
 	}
-	if isFrozenSvc(lbService) {
+	existingLBService, err := client.CoreV1().Services(ns).Get(ctx, s.serviceNameForPrefix(id, prefix), metav1.GetOptions{})
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return err
+		}
+		existingLBService = nil
+	}
+	if isFrozenSvc(existingLBService) {
 		return nil
 	}
+	lbService := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.serviceNameForPrefix(id, prefix),
+			Namespace: ns,
+		},
+		Spec: v1.ServiceSpec{
+			Type: v1.ServiceTypeLoadBalancer,
+		},
+	}
 
 	if o.Opts.ExternalTrafficPolicy == "Cluster" || o.Opts.ExternalTrafficPolicy == "Local" {
 		lbService.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyType(o.Opts.ExternalTrafficPolicy)
 	}
 
-	defaultTarget, err := s.getDefaultBackendTarget(o.Prefixes)
+	webService, err := s.getWebService(ctx, id.AppName, target)
 	if err != nil {
 		return err
 	}
 
-	webService, err := s.getWebService(ctx, id.AppName, *defaultTarget)
-	if err != nil {
-		return err
+	swapped := existingLBService != nil && isSwapped(existingLBService.ObjectMeta)
+	if swapped {
+		lbService.Spec.Selector = existingLBService.Spec.Selector
+	} else {
+		lbService.Spec.Selector = webService.Spec.Selector
 	}
 
-	lbService.Spec.Selector = webService.Spec.Selector
-
-	err = s.fillLabelsAndAnnotations(ctx, lbService, id, webService, o.Opts, *defaultTarget, o.Team)
+	err = s.fillLabelsAndAnnotations(ctx, lbService, id, webService, o.Opts, target, o.Team)
 	if err != nil {
 		return err
 	}
-
-	ports, err := s.portsForService(lbService, o.Opts, webService)
-	if err != nil {
-		return err
+	if swapped {
+		keepSwappedLabels(&lbService.ObjectMeta, existingLBService.ObjectMeta)
 	}
-	lbService.Spec.Ports = ports
-	client, err := s.getClient()
-	if err != nil {
-		return err
+	if prefix != "default" {
+		lbService.Labels[lbPrefixLabel] = prefix
+		if vhost := lbService.Annotations[externalDNSHostnameLabel]; vhost != "" {
+			lbService.Annotations[externalDNSHostnameLabel] = prefix + "." + vhost
+		}
 	}
 
-	if isNew {
-		_, err = client.CoreV1().Services(lbService.Namespace).Create(ctx, lbService, metav1.CreateOptions{})
+	ports, err := s.portsForService(existingLBService, o.Opts, webService)
+	if err != nil {
 		return err
 	}
+	lbService.Spec.Ports = ports
 
-	hasChanges := serviceHasChanges(span, existingLBService, lbService)
+	_, err = applyObject(ctx, client.CoreV1().Services(ns), serviceGVK, lbService)
+	return err
+}
 
-	if hasChanges {
-		_, err = client.CoreV1().Services(lbService.Namespace).Update(ctx, lbService, metav1.UpdateOptions{})
-		return err
+func sortedTargetKeys(targets map[string]router.BackendTarget) []string {
+	keys := make([]string, 0, len(targets))
+	for k := range targets {
+		keys = append(keys, k)
 	}
-
-	return nil
+	sort.Strings(keys)
+	return keys
 }
 
 func (s *LBService) fillLabelsAndAnnotations(ctx context.Context, svc *v1.Service, id router.InstanceID, webService *v1.Service, opts router.Opts, backendTarget router.BackendTarget, team string) error {
@@ -333,15 +451,19 @@ func (s *LBService) fillLabelsAndAnnotations(ctx context.Context, svc *v1.Servic
 	return nil
 }
 
-func (s *LBService) portsForService(svc *v1.Service, opts router.Opts, baseSvc *v1.Service) ([]v1.ServicePort, error) {
+// portsForService returns the ports of the LoadBalancer service, keeping the
+// node ports of the existing service, which may be nil.
+func (s *LBService) portsForService(existing *v1.Service, opts router.Opts, baseSvc *v1.Service) ([]v1.ServicePort, error) {
 	additionalPort, _ := strconv.Atoi(opts.ExposedPort)
 	if additionalPort == 0 {
 		additionalPort = defaultLBPort
 	}
 
 	existingPorts := map[int32]*v1.ServicePort{}
-	for i, port := range svc.Spec.Ports {
-		existingPorts[port.Port] = &svc.Spec.Ports[i]
+	if existing != nil {
+		for i, port := range existing.Spec.Ports {
+			existingPorts[port.Port] = &existing.Spec.Ports[i]
+		}
 	}
 
 	exposeAllPorts, _ := strconv.ParseBool(opts.AdditionalOpts[exposeAllPortsOpt])
@@ -397,45 +519,6 @@ func (s *LBService) portsForService(svc *v1.Service, opts router.Opts, baseSvc *
 	return wantedPorts, nil
 }
 
-func serviceHasChanges(span opentracing.Span, existing *v1.Service, svc *v1.Service) (hasChanges bool) {
-	if !reflect.DeepEqual(existing.Spec, svc.Spec) {
-		span.LogKV(
-			"message", "service has changed the spec",
-			"service", existing.Name,
-		)
-		return true
-	}
-	for key, value := range svc.Annotations {
-		if existing.Annotations[key] != value {
-			span.LogKV(
-				"message", "service has changed the annotation",
-				"service", existing.Name,
-				"annotation", key,
-				"existingValue", existing.Annotations[key],
-				"newValue", value,
-			)
-			return true
-		}
-	}
-	for key, value := range svc.Labels {
-		if existing.Labels[key] != value {
-			span.LogKV(
-				"message", "service has changed the label",
-				"service", existing.Name,
-				"label", key,
-				"existingValue", existing.Labels[key],
-				"newValue", value,
-			)
-			return true
-		}
-	}
-	span.LogKV(
-		"message", "service has no changes",
-		"service", existing.Name,
-	)
-	return false
-}
-
 func mergeMaps(entries ...map[string]string) map[string]string {
 	result := make(map[string]string)
 	for _, entry := range entries {
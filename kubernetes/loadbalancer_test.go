@@ -18,11 +18,10 @@ import (
 	faketsuru "github.com/tsuru/tsuru/provision/kubernetes/pkg/client/clientset/versioned/fake"
 	v1 "k8s.io/api/core/v1"
 	fakeapiextensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes/fake"
-	ktesting "k8s.io/client-go/testing"
 )
 
 var ctx = context.Background()
@@ -31,7 +30,7 @@ func createFakeLBService() LBService {
 	return LBService{
 		BaseService: &BaseService{
 			Namespace:        "default",
-			Client:           fake.NewSimpleClientset(),
+			Client:           newFakeClientset(),
 			TsuruClient:      faketsuru.NewSimpleClientset(),
 			ExtensionsClient: fakeapiextensions.NewSimpleClientset(),
 		},
@@ -76,6 +75,27 @@ func TestLBEnsure(t *testing.T) {
 	assert.Equal(t, expectedService, foundService)
 }
 
+func TestLBEnsureRetriesOnConflict(t *testing.T) {
+	svc := createFakeLBService()
+	err := createAppWebService(svc.Client, svc.Namespace, "test")
+	require.NoError(t, err)
+	applies := 0
+	svc.Client.(*fake.Clientset).PrependReactor("patch", "services", concurrentApply(v1.Resource("services"), "test-router-lb", &applies))
+	retriesBefore := conflictRetriesValue(t, "service")
+
+	err = svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
+		Team: "default",
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "test-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, applies)
+	assert.Equal(t, retriesBefore+2, conflictRetriesValue(t, "service"))
+	_, err = svc.Client.CoreV1().Services(svc.Namespace).Get(ctx, "test-router-lb", metav1.GetOptions{})
+	require.NoError(t, err)
+}
+
 func TestLBEnsureWithExternalTrafficPolicy(t *testing.T) {
 	svc := createFakeLBService()
 	err := createAppWebService(svc.Client, svc.Namespace, "test")
@@ -209,17 +229,6 @@ func TestLBEnsureDefaultPort(t *testing.T) {
 	err = createAppWebService(svc.Client, svc.Namespace, "myapp")
 	require.NoError(t, err)
 
-	svc.BaseService.Client.(*fake.Clientset).PrependReactor("create", "services", func(action ktesting.Action) (bool, runtime.Object, error) {
-		newSvc, ok := action.(ktesting.CreateAction).GetObject().(*v1.Service)
-		if !ok {
-			t.Errorf("Error creating service.")
-		}
-		ports := newSvc.Spec.Ports
-		if len(ports) != 1 || ports[0].TargetPort != intstr.FromInt(8888) {
-			t.Errorf("Expected service with targetPort 8888. Got %#v", ports)
-		}
-		return false, nil, nil
-	})
 	err = svc.Ensure(ctx, idForApp("myapp"), router.EnsureBackendOpts{
 		Opts: router.Opts{
 			Pool: "mypool",
@@ -237,6 +246,51 @@ func TestLBEnsureDefaultPort(t *testing.T) {
 		},
 	})
 	require.NoError(t, err)
+
+	service, err := svc.Client.CoreV1().Services("custom-namespace").Get(ctx, svc.serviceName(idForApp("myapp")), metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, service.Spec.Ports, 1)
+	assert.Equal(t, intstr.FromInt(8888), service.Spec.Ports[0].TargetPort)
+}
+
+func TestLBEnsureAllPrefixes(t *testing.T) {
+	svc := createFakeLBService()
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "test"))
+	require.NoError(t, createAppWebService(svc.Client, svc.Namespace, "test-worker"))
+	opts := router.EnsureBackendOpts{
+		Opts: router.Opts{ExposeAllServices: true, DomainSuffix: "myapps.io"},
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "test-web", Namespace: svc.Namespace}},
+			{Prefix: "worker", Target: router.BackendTarget{Service: "test-worker-web", Namespace: svc.Namespace}},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("test"), opts)
+	require.NoError(t, err)
+
+	prefixService, err := svc.Client.CoreV1().Services(svc.Namespace).Get(ctx, "test-worker-router-lb", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "worker", prefixService.Labels[lbPrefixLabel])
+	assert.Equal(t, "test-worker-web", prefixService.Labels[appBaseServiceNameLabel])
+	assert.Equal(t, "worker.test.myapps.io", prefixService.Annotations[externalDNSHostnameLabel])
+	assert.Equal(t, v1.ServiceTypeLoadBalancer, prefixService.Spec.Type)
+	mainService, err := svc.Client.CoreV1().Services(svc.Namespace).Get(ctx, "test-router-lb", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, mainService.Labels, lbPrefixLabel)
+
+	opts.Opts.ExposeAllServices = false
+	err = svc.Ensure(ctx, idForApp("test"), opts)
+	require.NoError(t, err)
+	_, err = svc.Client.CoreV1().Services(svc.Namespace).Get(ctx, "test-worker-router-lb", metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+
+	opts.Opts.ExposeAllServices = true
+	err = svc.Ensure(ctx, idForApp("test"), opts)
+	require.NoError(t, err)
+	err = svc.Remove(ctx, idForApp("test"))
+	require.NoError(t, err)
+	serviceList, err := svc.Client.CoreV1().Services(svc.Namespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, serviceList.Items, 2)
 }
 
 func TestLBSupportedOptions(t *testing.T) {
@@ -248,6 +302,7 @@ func TestLBSupportedOptions(t *testing.T) {
 	expectedOptions := map[string]string{
 		"my-opt2":          "User friendly option description.",
 		"exposed-port":     "",
+		"all-prefixes":     "",
 		"my-opt":           "my-opt-as-label",
 		"expose-all-ports": "Expose all ports used by application in the Load Balancer. Defaults to false.",
 	}
@@ -649,6 +704,8 @@ func TestLBUpdate(t *testing.T) {
 
 func TestLBUpdatePortDiffAndPreserveNodePort(t *testing.T) {
 	svc := createFakeLBService()
+	// the managed fields of the objects read are kept by this clientset
+	svc.Client = fake.NewClientset()
 	err := createAppWebService(svc.Client, svc.Namespace, "test")
 	require.NoError(t, err)
 	err = svc.Ensure(ctx, idForApp("test"), router.EnsureBackendOpts{
@@ -699,7 +756,8 @@ func TestLBUpdatePortDiffAndPreserveNodePort(t *testing.T) {
 			NodePort:   31902,
 		},
 	}
-	_, err = svc.Client.CoreV1().Services(svc.Namespace).Update(ctx, service, metav1.UpdateOptions{})
+	// ports updated by the previous versions of the router
+	_, err = svc.Client.CoreV1().Services(svc.Namespace).Update(ctx, service, metav1.UpdateOptions{FieldManager: fieldManager})
 	require.NoError(t, err)
 	webSvc := v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -801,7 +859,6 @@ func TestLBUpdatePortDiffAndPreserveNodePort(t *testing.T) {
 			NodePort:   31999,
 		},
 	}, service.Spec.Ports)
-
 }
 
 func TestLBUpdateNoChangeInFrozenService(t *testing.T) {
@@ -1269,3 +1326,116 @@ func setIP(t *testing.T, svc LBService, appName string) {
 		t.Fatalf("Expected err to be nil. Got %v", err)
 	}
 }
+
+func TestLBSwap(t *testing.T) {
+	svc := createFakeLBService()
+	for _, app := range []string{"app1", "app2"} {
+		err := createAppWebService(svc.Client, svc.Namespace, app)
+		require.NoError(t, err)
+		err = svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: app + "-web", Namespace: svc.Namespace}},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.NoError(t, err)
+
+	app1, err := svc.Client.CoreV1().Services(svc.Namespace).Get(ctx, "app1-router-lb", metav1.GetOptions{})
+	require.NoError(t, err)
+	app2, err := svc.Client.CoreV1().Services(svc.Namespace).Get(ctx, "app2-router-lb", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2", app1.Spec.Selector["tsuru.io/app-name"])
+	assert.Equal(t, "app1", app2.Spec.Selector["tsuru.io/app-name"])
+	assert.Equal(t, "app2", app1.Labels[swappedWithLabel])
+	assert.Equal(t, "app1", app2.Labels[swappedWithLabel])
+	assert.Equal(t, "app2-web", app1.Labels[appBaseServiceNameLabel])
+
+	// Ensure keeps the swapped selector
+	err = svc.Ensure(ctx, idForApp("app1"), router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "app1-web", Namespace: svc.Namespace}},
+		},
+	})
+	require.NoError(t, err)
+	app1, err = svc.Client.CoreV1().Services(svc.Namespace).Get(ctx, "app1-router-lb", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app2", app1.Spec.Selector["tsuru.io/app-name"])
+	assert.Equal(t, "app2-web", app1.Labels[appBaseServiceNameLabel])
+
+	// swapping back restores the original selectors
+	err = svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.NoError(t, err)
+	app1, err = svc.Client.CoreV1().Services(svc.Namespace).Get(ctx, "app1-router-lb", metav1.GetOptions{})
+	require.NoError(t, err)
+	app2, err = svc.Client.CoreV1().Services(svc.Namespace).Get(ctx, "app2-router-lb", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app1", app1.Spec.Selector["tsuru.io/app-name"])
+	assert.Equal(t, "app2", app2.Spec.Selector["tsuru.io/app-name"])
+	assert.NotContains(t, app1.Labels, swappedWithLabel)
+	assert.NotContains(t, app2.Labels, swappedWithLabel)
+	assert.Equal(t, "app1-web", app1.Labels[appBaseServiceNameLabel])
+}
+
+func TestLBSwapRollback(t *testing.T) {
+	svc := createFakeLBService()
+	for _, app := range []string{"app1", "app2"} {
+		err := createAppWebService(svc.Client, svc.Namespace, app)
+		require.NoError(t, err)
+		err = svc.Ensure(ctx, idForApp(app), router.EnsureBackendOpts{
+			Prefixes: []router.BackendPrefix{
+				{Target: router.BackendTarget{Service: app + "-web", Namespace: svc.Namespace}},
+			},
+		})
+		require.NoError(t, err)
+	}
+	svc.Client.(*fake.Clientset).PrependReactor("patch", "services", failApply("app2-router-lb"))
+
+	err := svc.Swap(ctx, idForApp("app1"), idForApp("app2"), false)
+	require.EqualError(t, err, "apply failed")
+
+	// the service swapped first is restored
+	app1, err := svc.Client.CoreV1().Services(svc.Namespace).Get(ctx, "app1-router-lb", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "app1", app1.Spec.Selector["tsuru.io/app-name"])
+	assert.NotContains(t, app1.Labels, swappedWithLabel)
+	assert.Equal(t, "app1-web", app1.Labels[appBaseServiceNameLabel])
+}
+
+func TestLBPlanEnsure(t *testing.T) {
+	server := newFakeAPIServer(t, map[string]string{
+		"/api/v1/namespaces/default/services/myapp-web": webServiceObject("myapp"),
+	})
+	defer server.Close()
+	svc := &LBService{
+		BaseService:      &BaseService{Namespace: "default", RestConfig: server.restConfig()},
+		OptsAsLabels:     map[string]string{},
+		OptsAsLabelsDocs: map[string]string{},
+	}
+	opts := router.EnsureBackendOpts{
+		Prefixes: []router.BackendPrefix{
+			{Target: router.BackendTarget{Service: "myapp-web", Namespace: "default"}},
+		},
+	}
+	err := svc.Ensure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+	server.resetPersisted()
+	objects := server.snapshot()
+
+	opts.Opts.AdditionalOpts = map[string]string{"my-opt": "value"}
+	plan, err := svc.PlanEnsure(ctx, idForApp("myapp"), opts)
+	require.NoError(t, err)
+
+	// Assert: the option is planned as an annotation of the service.
+	assert.Equal(t, []string{"update Service default/myapp-router-lb"}, planChanges(plan))
+	assert.Equal(t, []router.FieldChange{
+		{Path: `metadata.annotations["my-opt"]`, New: "value"},
+		{Path: `metadata.annotations["router.tsuru.io/opts"]`, Old: "{}", New: `{"AdditionalOpts":{"my-opt":"value"}}`},
+	}, planDiff(t, plan, "Service", "myapp-router-lb"))
+
+	// Assert: nothing was written.
+	assert.Empty(t, server.persisted)
+	assert.Equal(t, objects, server.snapshot())
+}
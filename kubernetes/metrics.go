@@ -0,0 +1,219 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tsuru/kubernetes-router/observability"
+	"github.com/tsuru/kubernetes-router/router"
+	networkingV1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+var (
+	managedResourcesDesc = prometheus.NewDesc(
+		"kubernetes_router_managed_resources",
+		"Number of resources managed by the router by mode and kind.",
+		[]string{"mode", "kind"}, nil,
+	)
+	frozenResourcesDesc = prometheus.NewDesc(
+		"kubernetes_router_frozen_resources",
+		"Number of resources managed by the router frozen by the router.tsuru.io/freeze label or annotation, by mode and kind.",
+		[]string{"mode", "kind"}, nil,
+	)
+)
+
+// resourceCount is the number of resources of a kind managed by a router
+type resourceCount struct {
+	kind    string
+	managed int
+	frozen  int
+}
+
+type resourceCounter interface {
+	countResources(ctx context.Context) (resourceCount, error)
+}
+
+var (
+	_ resourceCounter = &IngressService{}
+	_ resourceCounter = &GatewayAPIService{}
+	_ resourceCounter = &LBService{}
+	_ resourceCounter = &IstioGateway{}
+)
+
+// ResourcesCollector is a prometheus collector reporting the number of
+// ingresses, HTTPRoutes, virtualservices and load balancer services managed by
+// each router mode and how many of them are frozen. Counting them lists every
+// resource of the kind managed by the router in the cluster, so the counts are
+// cached and listed again only once CacheTTL has passed since the last count.
+type ResourcesCollector struct {
+	Routers map[string]router.Router
+	// Timeout of the list requests, defaults to 10 seconds.
+	Timeout time.Duration
+	// CacheTTL is how long the counts are reported before being listed
+	// again, defaults to 1 minute.
+	CacheTTL time.Duration
+
+	mu        sync.Mutex
+	metrics   []prometheus.Metric
+	countedAt time.Time
+}
+
+var _ prometheus.Collector = &ResourcesCollector{}
+
+func (c *ResourcesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- managedResourcesDesc
+	ch <- frozenResourcesDesc
+}
+
+func (c *ResourcesCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ttl := c.CacheTTL
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+	if c.metrics == nil || time.Since(c.countedAt) >= ttl {
+		c.metrics = c.count()
+		c.countedAt = time.Now()
+	}
+	for _, metric := range c.metrics {
+		ch <- metric
+	}
+}
+
+func (c *ResourcesCollector) count() []prometheus.Metric {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	modes := make([]string, 0, len(c.Routers))
+	for mode := range c.Routers {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	metrics := []prometheus.Metric{}
+	for _, mode := range modes {
+		counter, ok := c.Routers[mode].(resourceCounter)
+		if !ok {
+			continue
+		}
+		count, err := counter.countResources(ctx)
+		if err != nil {
+			observability.Logger(ctx).Error("failed to count resources", "mode", mode, "error", err)
+			continue
+		}
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(managedResourcesDesc, prometheus.GaugeValue, float64(count.managed), mode, count.kind),
+			prometheus.MustNewConstMetric(frozenResourcesDesc, prometheus.GaugeValue, float64(count.frozen), mode, count.kind),
+		)
+	}
+	return metrics
+}
+
+func appLabelSelector() string {
+	hasApp, _ := labels.NewRequirement(appLabel, selection.Exists, nil)
+	return labels.NewSelector().Add(*hasApp).String()
+}
+
+func (k *IngressService) countResources(ctx context.Context) (resourceCount, error) {
+	count := resourceCount{kind: "Ingress"}
+	client, err := k.getClient()
+	if err != nil {
+		return count, err
+	}
+	ingresses, err := client.NetworkingV1().Ingresses("").List(ctx, metav1.ListOptions{LabelSelector: appLabelSelector()})
+	if err != nil {
+		return count, err
+	}
+	for _, ingress := range ingresses.Items {
+		if !k.hasIngressClass(&ingress) {
+			continue
+		}
+		count.managed++
+		if ingress.Annotations[AnnotationFreeze] == "true" {
+			count.frozen++
+		}
+	}
+	return count, nil
+}
+
+// hasIngressClass returns whether the ingress belongs to the ingress class of
+// the router, every ingress does when no class is set.
+func (k *IngressService) hasIngressClass(ingress *networkingV1.Ingress) bool {
+	if k.IngressClass == "" {
+		return true
+	}
+	if ingress.Spec.IngressClassName != nil && *ingress.Spec.IngressClassName == k.IngressClass {
+		return true
+	}
+	classAnnotation := mergeMaps(defaultOptsAsAnnotations, k.OptsAsAnnotations)[defaultClassOpt]
+	return ingress.Annotations[classAnnotation] == k.IngressClass
+}
+
+func (g *GatewayAPIService) countResources(ctx context.Context) (resourceCount, error) {
+	count := resourceCount{kind: "HTTPRoute"}
+	client, err := g.getGatewayClient()
+	if err != nil {
+		return count, err
+	}
+	routes, err := client.GatewayV1().HTTPRoutes("").List(ctx, metav1.ListOptions{LabelSelector: appLabelSelector()})
+	if err != nil {
+		return count, err
+	}
+	for _, route := range routes.Items {
+		count.managed++
+		if isFrozenHTTPRoute(&route) {
+			count.frozen++
+		}
+	}
+	return count, nil
+}
+
+func (s *LBService) countResources(ctx context.Context) (resourceCount, error) {
+	count := resourceCount{kind: "Service"}
+	client, err := s.getClient()
+	if err != nil {
+		return count, err
+	}
+	services, err := client.CoreV1().Services("").List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{managedServiceLabel: "true"}.String(),
+	})
+	if err != nil {
+		return count, err
+	}
+	for _, svc := range services.Items {
+		count.managed++
+		if isFrozenSvc(&svc) {
+			count.frozen++
+		}
+	}
+	return count, nil
+}
+
+// countResources counts the virtualservices of the apps, the istio gateway
+// mode does not freeze them so none is reported as frozen.
+func (k *IstioGateway) countResources(ctx context.Context) (resourceCount, error) {
+	count := resourceCount{kind: "VirtualService"}
+	client, err := k.getClient()
+	if err != nil {
+		return count, err
+	}
+	virtualServices, err := client.VirtualServices("").List(ctx, metav1.ListOptions{LabelSelector: appLabelSelector()})
+	if err != nil {
+		return count, err
+	}
+	count.managed = len(virtualServices.Items)
+	return count, nil
+}
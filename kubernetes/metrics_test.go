@@ -0,0 +1,124 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/kubernetes-router/router"
+	networking "istio.io/client-go/pkg/apis/networking/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+func TestResourcesCollector(t *testing.T) {
+	ingressSvc := createFakeService(false)
+	ingressSvc.IngressClass = "nginx"
+	gatewaySvc, gwClient := newFakeGatewayAPIService()
+	lbSvc := createFakeLBService()
+	istioSvc, istioClient := fakeService()
+
+	ingresses := []networkingV1.Ingress{
+		{ObjectMeta: metav1.ObjectMeta{Name: "app1", Namespace: "ns1", Labels: map[string]string{appLabel: "app1"}, Annotations: map[string]string{"kubernetes.io/ingress.class": "nginx"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "app2", Namespace: "ns2", Labels: map[string]string{appLabel: "app2"}, Annotations: map[string]string{AnnotationFreeze: "true"}}, Spec: networkingV1.IngressSpec{IngressClassName: ptr.To("nginx")}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "app3", Namespace: "ns1", Labels: map[string]string{appLabel: "app3"}, Annotations: map[string]string{"kubernetes.io/ingress.class": "other"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "ns1", Annotations: map[string]string{"kubernetes.io/ingress.class": "nginx"}}},
+	}
+	for _, ingress := range ingresses {
+		_, err := ingressSvc.Client.NetworkingV1().Ingresses(ingress.Namespace).Create(ctx, &ingress, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	routes := []gatewayv1.HTTPRoute{
+		{ObjectMeta: metav1.ObjectMeta{Name: "app1", Namespace: "ns1", Labels: map[string]string{appLabel: "app1"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "app1-cname", Namespace: "ns1", Labels: map[string]string{appLabel: "app1", labelCNameHTTPRoute: "true"}, Annotations: map[string]string{AnnotationFreeze: "true"}}},
+	}
+	for _, route := range routes {
+		_, err := gwClient.GatewayV1().HTTPRoutes(route.Namespace).Create(ctx, &route, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	services := []v1.Service{
+		{ObjectMeta: metav1.ObjectMeta{Name: "app1-router-lb", Namespace: "ns1", Labels: map[string]string{managedServiceLabel: "true", routerFreezeLabel: "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "app1-web", Namespace: "ns1", Labels: map[string]string{appLabel: "app1"}}},
+	}
+	for _, svc := range services {
+		_, err := lbSvc.Client.CoreV1().Services(svc.Namespace).Create(ctx, &svc, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	virtualServices := []networking.VirtualService{
+		{ObjectMeta: metav1.ObjectMeta{Name: "app1", Namespace: "ns1", Labels: map[string]string{appLabel: "app1"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "ns1"}},
+	}
+	for _, vs := range virtualServices {
+		_, err := istioClient.VirtualServices(vs.Namespace).Create(ctx, &vs, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(&ResourcesCollector{
+		Routers: map[string]router.Router{
+			"ingress-nginx": &ingressSvc,
+			"gateway-api":   gatewaySvc,
+			"service":       &lbSvc,
+			"istio-gateway": &istioSvc,
+		},
+	})
+	values := gatherGauges(t, registry)
+	assert.Equal(t, map[string]float64{
+		"kubernetes_router_managed_resources HTTPRoute gateway-api":        2,
+		"kubernetes_router_frozen_resources HTTPRoute gateway-api":         1,
+		"kubernetes_router_managed_resources Ingress ingress-nginx":        2,
+		"kubernetes_router_frozen_resources Ingress ingress-nginx":         1,
+		"kubernetes_router_managed_resources Service service":              1,
+		"kubernetes_router_frozen_resources Service service":               1,
+		"kubernetes_router_managed_resources VirtualService istio-gateway": 1,
+		"kubernetes_router_frozen_resources VirtualService istio-gateway":  0,
+	}, values)
+}
+
+func TestResourcesCollectorCache(t *testing.T) {
+	lbSvc := createFakeLBService()
+	collector := &ResourcesCollector{
+		Routers: map[string]router.Router{"service": &lbSvc},
+	}
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+	createSvc := func(name string) {
+		svc := v1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1", Labels: map[string]string{managedServiceLabel: "true"}}}
+		_, err := lbSvc.Client.CoreV1().Services(svc.Namespace).Create(ctx, &svc, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	createSvc("app1-router-lb")
+	assert.Equal(t, float64(1), gatherGauges(t, registry)["kubernetes_router_managed_resources Service service"])
+	createSvc("app2-router-lb")
+	assert.Equal(t, float64(1), gatherGauges(t, registry)["kubernetes_router_managed_resources Service service"])
+
+	collector.countedAt = collector.countedAt.Add(-time.Minute)
+	assert.Equal(t, float64(2), gatherGauges(t, registry)["kubernetes_router_managed_resources Service service"])
+}
+
+func gatherGauges(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range metric.GetLabel() {
+				key += " " + label.GetValue()
+			}
+			values[key] = metric.GetGauge().GetValue()
+		}
+	}
+	return values
+}
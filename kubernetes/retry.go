@@ -0,0 +1,36 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+)
+
+var conflictRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kubernetes_router_conflict_retries_total",
+	Help: "The number of writes of resources retried after a conflict with a concurrent write",
+}, []string{"resource"})
+
+// retryOnConflict calls fn until the resource it writes is not concurrently
+// modified or created, backing off as retry.DefaultRetry. fn must re-read the
+// resource and re-apply the desired state to it when retrying.
+func retryOnConflict(resource string, fn func(retrying bool) error) error {
+	retrying := false
+	return retry.OnError(retry.DefaultRetry, isConflict, func() error {
+		if retrying {
+			conflictRetries.WithLabelValues(resource).Inc()
+		}
+		err := fn(retrying)
+		retrying = true
+		return err
+	})
+}
+
+func isConflict(err error) bool {
+	return k8sErrors.IsConflict(err) || k8sErrors.IsAlreadyExists(err)
+}
@@ -0,0 +1,58 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"errors"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func conflictRetriesValue(t *testing.T, resource string) float64 {
+	var m dto.Metric
+	require.NoError(t, conflictRetries.WithLabelValues(resource).Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestRetryOnConflict(t *testing.T) {
+	conflict := k8sErrors.NewConflict(schema.GroupResource{Resource: "services"}, "myapp", errors.New("the object has been modified"))
+	before := conflictRetriesValue(t, "test")
+
+	var calls []bool
+	err := retryOnConflict("test", func(retrying bool) error {
+		calls = append(calls, retrying)
+		if len(calls) < 3 {
+			return conflict
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, true}, calls)
+	assert.Equal(t, before+2, conflictRetriesValue(t, "test"))
+
+	calls = nil
+	err = retryOnConflict("test", func(retrying bool) error {
+		calls = append(calls, retrying)
+		if len(calls) == 1 {
+			return k8sErrors.NewAlreadyExists(schema.GroupResource{Resource: "services"}, "myapp")
+		}
+		return errors.New("failed")
+	})
+	assert.EqualError(t, err, "failed")
+	assert.Equal(t, []bool{false, true}, calls)
+
+	calls = nil
+	err = retryOnConflict("test", func(retrying bool) error {
+		calls = append(calls, retrying)
+		return conflict
+	})
+	assert.True(t, k8sErrors.IsConflict(err))
+	assert.Len(t, calls, 5)
+}
@@ -8,7 +8,6 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
-	"errors"
 	"fmt"
 	"net/http"
 	"sort"
@@ -25,7 +24,9 @@ import (
 	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -43,6 +44,7 @@ const (
 	appBaseServiceNamespaceLabel = "router.tsuru.io/base-service-namespace"
 	appBaseServiceNameLabel      = "router.tsuru.io/base-service-name"
 	routerFreezeLabel            = "router.tsuru.io/freeze"
+	swappedWithLabel             = "router.tsuru.io/swapped-with"
 
 	externalDNSHostnameLabel = "external-dns.alpha.kubernetes.io/hostname"
 
@@ -60,7 +62,8 @@ const (
 )
 
 var (
-	ErrNoBackendTarget = errors.New("No default backend target found")
+	ErrNoBackendTarget         = &router.Error{Code: router.ErrorCodeNotFound, Message: "No default backend target found"}
+	ErrSwapDifferentNamespaces = &router.Error{Code: router.ErrorCodeUnsupported, Message: "cannot swap apps from different namespaces"}
 )
 
 // ErrNoService indicates that the app has no service running
@@ -73,6 +76,38 @@ func (e ErrNoService) Error() string {
 	return fmt.Sprintf("service %q is not found for app %q", e.Service, e.App)
 }
 
+// ErrorCode returns router.ErrorCodeNotFound.
+func (e ErrNoService) ErrorCode() router.ErrorCode {
+	return router.ErrorCodeNotFound
+}
+
+// ErrorDetails returns the app and service not found.
+func (e ErrNoService) ErrorDetails() map[string]string {
+	return map[string]string{"app": e.App, "service": e.Service}
+}
+
+// Cert-manager types
+type CertManagerIssuerType int
+
+const (
+	certManagerIssuerTypeIssuer = iota
+	certManagerIssuerTypeClusterIssuer
+	certManagerIssuerTypeExternalIssuer
+)
+
+type CertManagerIssuerData struct {
+	name       string
+	kind       string
+	group      string
+	issuerType CertManagerIssuerType
+}
+
+const (
+	errIssuerNotFound         = "issuer %s not found"
+	errExternalIssuerNotFound = "external issuer %s not found, err: %s"
+	errExternalIssuerInvalid  = "invalid external issuer: %s (requires <resource name>.<resource kind>.<resource group>)"
+)
+
 // BaseService has the base functionality needed by router.Service implementations
 // targeting kubernetes
 type BaseService struct {
@@ -173,7 +208,7 @@ func (k *BaseService) getConfig() (*rest.Config, error) {
 	}
 	k.RestConfig.Timeout = k.Timeout
 	k.RestConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
-		return transport.DebugWrappers(observability.WrapTransport(rt))
+		return transport.DebugWrappers(observability.WrapTransport(WrapDryRunTransport(rt)))
 	}
 	return k.RestConfig, nil
 }
@@ -194,6 +229,36 @@ func (k *BaseService) getWebService(ctx context.Context, appName string, target
 	return svc, nil
 }
 
+// mirrorService is the service receiving a copy of the app requests
+type mirrorService struct {
+	name      string
+	namespace string
+	port      int32
+}
+
+// getMirrorService resolves the service of the mirror-to option in the app
+// namespace, using its first port when the option does not set one.
+func (k *BaseService) getMirrorService(ctx context.Context, appName, namespace, mirrorTo string) (*mirrorService, error) {
+	if mirrorTo == "" {
+		return nil, nil
+	}
+	name, port, err := router.ParseMirrorTo(mirrorTo)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := k.getWebService(ctx, appName, router.BackendTarget{Namespace: namespace, Service: name})
+	if err != nil {
+		return nil, err
+	}
+	if port == 0 {
+		port = defaultServicePort
+		if len(svc.Spec.Ports) > 0 {
+			port = svc.Spec.Ports[0].Port
+		}
+	}
+	return &mirrorService{name: svc.Name, namespace: namespace, port: port}, nil
+}
+
 func (k *BaseService) getApp(ctx context.Context, app string) (*tsuruv1.App, error) {
 	hasCRD, err := k.hasCRD(ctx)
 	if err != nil {
@@ -235,6 +300,68 @@ func (k *BaseService) hasCRD(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
+// getSwapNamespace returns the namespace shared by both apps of a swap
+func (k *BaseService) getSwapNamespace(ctx context.Context, srcApp, dstApp router.InstanceID) (string, error) {
+	srcNs, err := k.getAppNamespace(ctx, srcApp.AppName)
+	if err != nil {
+		return "", err
+	}
+	dstNs, err := k.getAppNamespace(ctx, dstApp.AppName)
+	if err != nil {
+		return "", err
+	}
+	if srcNs != dstNs {
+		return "", ErrSwapDifferentNamespaces
+	}
+	return srcNs, nil
+}
+
+// isSwapped returns whether the resource had its backend swapped with
+// another app, Ensure must keep the backends of swapped resources.
+func isSwapped(meta metav1.ObjectMeta) bool {
+	return meta.Labels[swappedWithLabel] != ""
+}
+
+// toggleSwapped marks the resource as swapped with app, swapping a resource
+// back with the same app removes the mark.
+func toggleSwapped(meta *metav1.ObjectMeta, app string) {
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	if meta.Labels[swappedWithLabel] == app {
+		delete(meta.Labels, swappedWithLabel)
+		return
+	}
+	meta.Labels[swappedWithLabel] = app
+}
+
+// keepSwappedLabels copies the labels that describe the current backend of a
+// swapped resource from existing.
+func keepSwappedLabels(meta *metav1.ObjectMeta, existing metav1.ObjectMeta) {
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	for _, label := range []string{swappedWithLabel, appBaseServiceNamespaceLabel, appBaseServiceNameLabel} {
+		if value, ok := existing.Labels[label]; ok {
+			meta.Labels[label] = value
+		}
+	}
+}
+
+// swapBaseServiceLabels exchanges the labels that describe the backend of
+// two resources.
+func swapBaseServiceLabels(src, dst *metav1.ObjectMeta) {
+	if src.Labels == nil {
+		src.Labels = map[string]string{}
+	}
+	if dst.Labels == nil {
+		dst.Labels = map[string]string{}
+	}
+	for _, label := range []string{appBaseServiceNamespaceLabel, appBaseServiceNameLabel} {
+		src.Labels[label], dst.Labels[label] = dst.Labels[label], src.Labels[label]
+	}
+}
+
 func (s *BaseService) getDefaultBackendTarget(prefixes []router.BackendPrefix) (*router.BackendTarget, error) {
 	for _, prefix := range prefixes {
 		if prefix.Prefix == "" {
@@ -279,6 +406,43 @@ func (s *BaseService) getBackendTargets(prefixes []router.BackendPrefix, allBack
 	return allTargets, nil
 }
 
+// weightedService is a backend service receiving a percentage of the traffic
+type weightedService struct {
+	service *corev1.Service
+	weight  int32
+}
+
+// getWeightedServices returns the services of every prefix with weighted
+// targets, keyed as in getBackendTargets. The first service of each prefix is
+// the one of its main target.
+func (s *BaseService) getWeightedServices(ctx context.Context, appName string, prefixes []router.BackendPrefix, allBackends bool) (map[string][]weightedService, error) {
+	result := map[string][]weightedService{}
+	for _, prefix := range prefixes {
+		if len(prefix.WeightedTargets) == 0 {
+			continue
+		}
+		key := "default"
+		if prefix.Prefix != "" {
+			if !allBackends {
+				continue
+			}
+			key = strings.ReplaceAll(prefix.Prefix, "_", "-")
+		}
+		targets, err := prefix.AllTargets()
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			svc, err := s.getWebService(ctx, appName, target.BackendTarget)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = append(result[key], weightedService{service: svc, weight: target.Weight})
+		}
+	}
+	return result, nil
+}
+
 func (s *BaseService) hashedResourceName(id router.InstanceID, name string, limit int) string {
 	if id.InstanceName != "" {
 		name += "-" + id.InstanceName
@@ -335,3 +499,94 @@ func isFrozenSvc(svc *corev1.Service) bool {
 	frozen, _ := strconv.ParseBool(svc.Labels[routerFreezeLabel])
 	return frozen
 }
+
+func (s *BaseService) validateCustomIssuer(ctx context.Context, resource CertManagerIssuerData, ns string) error {
+	sigsClient, err := s.getSigsClient()
+	if err != nil {
+		return err
+	}
+
+	mapping, err := sigsClient.RESTMapper().RESTMapping(schema.GroupKind{
+		Group: resource.group,
+		Kind:  resource.kind,
+	})
+	if err != nil {
+		return err
+	}
+
+	u := &unstructured.Unstructured{}
+	u.Object = map[string]interface{}{}
+	u.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   mapping.GroupVersionKind.Group,
+		Kind:    mapping.GroupVersionKind.Kind,
+		Version: mapping.GroupVersionKind.Version,
+	})
+
+	err = sigsClient.Get(ctx, types.NamespacedName{
+		Name:      resource.name,
+		Namespace: ns,
+	}, u)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getCertManagerIssuerData resolves the issuer as an Issuer of the namespace,
+// a ClusterIssuer or, when set as name.kind.group, an external issuer.
+func (s *BaseService) getCertManagerIssuerData(ctx context.Context, issuerName, namespace string) (CertManagerIssuerData, error) {
+	if strings.Contains(issuerName, ".") {
+		// Treat as external issuer since it's more general
+		parts := strings.SplitN(issuerName, ".", 3)
+		if len(parts) != 3 {
+			return CertManagerIssuerData{}, router.NewError(router.ErrorCodeInvalidIssuer, errExternalIssuerInvalid, issuerName).WithDetail("issuer", issuerName)
+		}
+		cmIssuerData := CertManagerIssuerData{
+			name:       parts[0],
+			kind:       parts[1],
+			group:      parts[2],
+			issuerType: certManagerIssuerTypeExternalIssuer,
+		}
+
+		if err := s.validateCustomIssuer(ctx, cmIssuerData, namespace); err != nil {
+			return CertManagerIssuerData{}, router.NewError(router.ErrorCodeInvalidIssuer, errExternalIssuerNotFound, issuerName, err.Error()).WithDetail("issuer", issuerName)
+		}
+
+		return cmIssuerData, nil
+	}
+
+	// Treat as CertManager issuer
+	cmClient, err := s.getCertManagerClient()
+	if err != nil {
+		return CertManagerIssuerData{}, err
+	}
+
+	_, err = cmClient.CertmanagerV1().Issuers(namespace).Get(ctx, issuerName, metav1.GetOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return CertManagerIssuerData{}, err
+	}
+
+	if err == nil {
+		return CertManagerIssuerData{
+			name:       issuerName,
+			issuerType: certManagerIssuerTypeIssuer,
+		}, nil
+	}
+
+	// Check if it's a cluster issuer
+	_, err = cmClient.CertmanagerV1().ClusterIssuers().Get(ctx, issuerName, metav1.GetOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return CertManagerIssuerData{}, err
+	}
+
+	if err == nil {
+		return CertManagerIssuerData{
+			name:       issuerName,
+			issuerType: certManagerIssuerTypeClusterIssuer,
+		}, nil
+	}
+
+	// Issuer not found
+	return CertManagerIssuerData{}, router.NewError(router.ErrorCodeInvalidIssuer, errIssuerNotFound, issuerName).WithDetail("issuer", issuerName)
+}
@@ -5,8 +5,11 @@
 package kubernetes
 
 import (
+	"errors"
 	"testing"
 
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	fakecertmanager "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned/fake"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tsuru/kubernetes-router/router"
@@ -16,15 +19,125 @@ import (
 	v1 "k8s.io/api/core/v1"
 	apiextensionsV1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	fakeapiextensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/managedfields"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
 )
 
+// newFakeClientset returns a fake clientset tracking the managed fields of
+// its objects, as required by server-side apply. The managed fields are
+// omitted from the objects returned, use fake.NewClientset to check them.
+func newFakeClientset() *fake.Clientset {
+	client := fake.NewClientset()
+	client.PrependReactor("*", "*", withoutManagedFields(client.Tracker()))
+	return client
+}
+
+// withoutManagedFields reacts to the actions with the tracker, omitting the
+// managed fields and type meta of the objects returned, so they can be
+// compared with the expected ones.
+func withoutManagedFields(tracker k8stesting.ObjectTracker) k8stesting.ReactionFunc {
+	react := k8stesting.ObjectReaction(tracker)
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		handled, obj, err := react(action)
+		if err != nil || obj == nil {
+			return handled, obj, err
+		}
+		clearObject := func(o runtime.Object) error {
+			o.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+			accessor, err := meta.Accessor(o)
+			if err != nil {
+				return err
+			}
+			accessor.SetManagedFields(nil)
+			return nil
+		}
+		if meta.IsListType(obj) {
+			err = meta.EachListItem(obj, clearObject)
+		} else {
+			err = clearObject(obj)
+		}
+		return handled, obj, err
+	}
+}
+
+// fieldManagedReactor reacts to the actions with a tracker of the managed
+// fields of the objects of the group version, for the fake clientsets that
+// do not track them.
+func fieldManagedReactor(addToScheme func(*runtime.Scheme) error, gv schema.GroupVersion) k8stesting.ReactionFunc {
+	scheme := runtime.NewScheme()
+	if err := addToScheme(scheme); err != nil {
+		panic(err)
+	}
+	if err := scheme.SetVersionPriority(gv); err != nil {
+		panic(err)
+	}
+	tracker := k8stesting.NewFieldManagedObjectTracker(scheme, serializer.NewCodecFactory(scheme).UniversalDecoder(), managedfields.NewDeducedTypeConverter())
+	react := withoutManagedFields(tracker)
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		// the fake clientsets do not send the options of the patches, the
+		// ones set by applyObject are restored.
+		if patch, ok := action.(k8stesting.PatchActionImpl); ok && patch.GetPatchType() == types.ApplyPatchType {
+			patch.PatchOptions = metav1.PatchOptions{FieldManager: fieldManager, Force: ptr.To(true)}
+			action = patch
+		}
+		return react(action)
+	}
+}
+
+// newFakeCertManagerClientset returns a fake cert-manager clientset tracking
+// the managed fields of its objects, as required by server-side apply.
+func newFakeCertManagerClientset() *fakecertmanager.Clientset {
+	client := fakecertmanager.NewSimpleClientset()
+	client.PrependReactor("*", "*", fieldManagedReactor(certmanagerv1.AddToScheme, certmanagerv1.SchemeGroupVersion))
+	return client
+}
+
+// failApply fails the server-side apply of the named object, the other
+// actions are left to the next reactors.
+func failApply(name string) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch, ok := action.(k8stesting.PatchAction)
+		if !ok || patch.GetPatchType() != types.ApplyPatchType || patch.GetName() != name {
+			return false, nil, nil
+		}
+		return true, nil, errors.New("apply failed")
+	}
+}
+
+// concurrentApply fails the first apply of the resource name with a conflict
+// and the second one as already existing, as when it is concurrently written
+// and created, the applies are counted by applies.
+func concurrentApply(resource schema.GroupResource, name string, applies *int) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch, ok := action.(k8stesting.PatchAction)
+		if !ok || patch.GetPatchType() != types.ApplyPatchType || patch.GetName() != name {
+			return false, nil, nil
+		}
+		*applies++
+		switch *applies {
+		case 1:
+			return true, nil, k8sErrors.NewConflict(resource, name, errors.New("the object has been modified"))
+		case 2:
+			return true, nil, k8sErrors.NewAlreadyExists(resource, name)
+		}
+		return false, nil, nil
+	}
+}
+
 func TestGetWebService(t *testing.T) {
 	svc := BaseService{
 		Namespace:        "default",
-		Client:           fake.NewSimpleClientset(),
+		Client:           newFakeClientset(),
 		TsuruClient:      faketsuru.NewSimpleClientset(),
 		ExtensionsClient: fakeapiextensions.NewSimpleClientset(),
 	}
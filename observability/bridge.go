@@ -0,0 +1,329 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package observability
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	opentracingExt "github.com/opentracing/opentracing-go/ext"
+	opentracingLog "github.com/opentracing/opentracing-go/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	_ opentracing.Tracer                         = &bridgeTracer{}
+	_ opentracing.TracerContextWithSpanExtension = &bridgeTracer{}
+	_ opentracing.Span                           = &bridgeSpan{}
+	_ opentracing.SpanContext                    = bridgeSpanContext{}
+)
+
+// NewBridgeTracer returns an opentracing tracer recording its spans with the
+// OpenTelemetry tracer, span contexts are injected and extracted by the
+// propagator.
+func NewBridgeTracer(tracer trace.Tracer, propagator propagation.TextMapPropagator) opentracing.Tracer {
+	return &bridgeTracer{tracer: tracer, propagator: propagator}
+}
+
+type bridgeTracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+type bridgeSpanContext struct {
+	spanContext trace.SpanContext
+	baggage     map[string]string
+}
+
+func (c bridgeSpanContext) ForeachBaggageItem(handler func(k, v string) bool) {
+	for k, v := range c.baggage {
+		if !handler(k, v) {
+			return
+		}
+	}
+}
+
+type bridgeSpan struct {
+	span    trace.Span
+	tracer  *bridgeTracer
+	baggage map[string]string
+}
+
+func (t *bridgeTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var sso opentracing.StartSpanOptions
+	for _, opt := range opts {
+		opt.Apply(&sso)
+	}
+	ctx := context.Background()
+	var links []trace.Link
+	spanBaggage := map[string]string{}
+	for _, ref := range sso.References {
+		refContext, ok := ref.ReferencedContext.(bridgeSpanContext)
+		if !ok || !refContext.spanContext.IsValid() {
+			continue
+		}
+		for k, v := range refContext.baggage {
+			spanBaggage[k] = v
+		}
+		if ref.Type == opentracing.ChildOfRef && !trace.SpanContextFromContext(ctx).IsValid() {
+			ctx = trace.ContextWithSpanContext(ctx, refContext.spanContext)
+			continue
+		}
+		links = append(links, trace.Link{SpanContext: refContext.spanContext})
+	}
+	startOpts := []trace.SpanStartOption{
+		trace.WithLinks(links...),
+		trace.WithSpanKind(spanKind(sso.Tags[string(opentracingExt.SpanKind)])),
+	}
+	if !sso.StartTime.IsZero() {
+		startOpts = append(startOpts, trace.WithTimestamp(sso.StartTime))
+	}
+	_, span := t.tracer.Start(ctx, operationName, startOpts...)
+	s := &bridgeSpan{span: span, tracer: t, baggage: spanBaggage}
+	for k, v := range sso.Tags {
+		s.SetTag(k, v)
+	}
+	return s
+}
+
+// ContextWithSpanHook carries the OpenTelemetry span along with span, so
+// trace.SpanContextFromContext finds it in the contexts of opentracing.
+func (t *bridgeTracer) ContextWithSpanHook(ctx context.Context, span opentracing.Span) context.Context {
+	if s, ok := span.(*bridgeSpan); ok {
+		return trace.ContextWithSpan(ctx, s.span)
+	}
+	return ctx
+}
+
+func spanKind(kind interface{}) trace.SpanKind {
+	switch fmt.Sprint(kind) {
+	case string(opentracingExt.SpanKindRPCServerEnum):
+		return trace.SpanKindServer
+	case string(opentracingExt.SpanKindRPCClientEnum):
+		return trace.SpanKindClient
+	case string(opentracingExt.SpanKindProducerEnum):
+		return trace.SpanKindProducer
+	case string(opentracingExt.SpanKindConsumerEnum):
+		return trace.SpanKindConsumer
+	}
+	return trace.SpanKindInternal
+}
+
+func (t *bridgeTracer) Inject(sm opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	spanContext, ok := sm.(bridgeSpanContext)
+	if !ok {
+		return opentracing.ErrInvalidSpanContext
+	}
+	textMapCarrier, err := propagationCarrier(format, carrier)
+	if err != nil {
+		return err
+	}
+	ctx := trace.ContextWithSpanContext(context.Background(), spanContext.spanContext)
+	var members []baggage.Member
+	for k, v := range spanContext.baggage {
+		member, err := baggage.NewMember(k, v)
+		if err == nil {
+			members = append(members, member)
+		}
+	}
+	if bag, err := baggage.New(members...); err == nil {
+		ctx = baggage.ContextWithBaggage(ctx, bag)
+	}
+	t.propagator.Inject(ctx, textMapCarrier)
+	return nil
+}
+
+func (t *bridgeTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	textMapCarrier, err := propagationCarrier(format, carrier)
+	if err != nil {
+		return nil, err
+	}
+	ctx := t.propagator.Extract(context.Background(), textMapCarrier)
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+	extracted := bridgeSpanContext{spanContext: spanContext, baggage: map[string]string{}}
+	for _, member := range baggage.FromContext(ctx).Members() {
+		extracted.baggage[member.Key()] = member.Value()
+	}
+	return extracted, nil
+}
+
+// propagationCarrier adapts the opentracing HTTP headers and text map carriers
+// to the OpenTelemetry propagators.
+func propagationCarrier(format interface{}, carrier interface{}) (propagation.TextMapCarrier, error) {
+	switch format {
+	case opentracing.HTTPHeaders:
+		if headers, ok := carrier.(opentracing.HTTPHeadersCarrier); ok {
+			return propagation.HeaderCarrier(http.Header(headers)), nil
+		}
+	case opentracing.TextMap:
+		mapCarrier := propagation.MapCarrier{}
+		if reader, ok := carrier.(opentracing.TextMapReader); ok {
+			err := reader.ForeachKey(func(key, val string) error {
+				mapCarrier[key] = val
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		writer, _ := carrier.(opentracing.TextMapWriter)
+		return &textMapCarrier{MapCarrier: mapCarrier, writer: writer}, nil
+	default:
+		return nil, opentracing.ErrUnsupportedFormat
+	}
+	return nil, opentracing.ErrInvalidCarrier
+}
+
+type textMapCarrier struct {
+	propagation.MapCarrier
+	writer opentracing.TextMapWriter
+}
+
+func (c *textMapCarrier) Set(key, value string) {
+	c.MapCarrier.Set(key, value)
+	if c.writer != nil {
+		c.writer.Set(key, value)
+	}
+}
+
+func (s *bridgeSpan) Finish() {
+	s.span.End()
+}
+
+func (s *bridgeSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	for _, record := range opts.LogRecords {
+		s.addEvent(record.Timestamp, record.Fields)
+	}
+	if opts.FinishTime.IsZero() {
+		s.span.End()
+		return
+	}
+	s.span.End(trace.WithTimestamp(opts.FinishTime))
+}
+
+func (s *bridgeSpan) Context() opentracing.SpanContext {
+	return bridgeSpanContext{spanContext: s.span.SpanContext(), baggage: s.baggage}
+}
+
+func (s *bridgeSpan) SetOperationName(operationName string) opentracing.Span {
+	s.span.SetName(operationName)
+	return s
+}
+
+func (s *bridgeSpan) SetTag(key string, value interface{}) opentracing.Span {
+	switch key {
+	case string(opentracingExt.SpanKind):
+		// set when starting the span
+	case string(opentracingExt.Error):
+		if isError, ok := value.(bool); ok && isError {
+			s.span.SetStatus(codes.Error, "")
+		}
+	default:
+		s.span.SetAttributes(attributeFor(key, value))
+	}
+	return s
+}
+
+func attributeFor(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int32:
+		return attribute.Int64(key, int64(v))
+	case int64:
+		return attribute.Int64(key, v)
+	case uint16:
+		return attribute.Int64(key, int64(v))
+	case uint32:
+		return attribute.Int64(key, int64(v))
+	case float32:
+		return attribute.Float64(key, float64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
+
+func (s *bridgeSpan) LogFields(fields ...opentracingLog.Field) {
+	s.addEvent(time.Time{}, fields)
+}
+
+func (s *bridgeSpan) LogKV(alternatingKeyValues ...interface{}) {
+	fields, err := opentracingLog.InterleavedKVToFields(alternatingKeyValues...)
+	if err != nil {
+		s.span.AddEvent("log", trace.WithAttributes(attribute.String("error", err.Error())))
+		return
+	}
+	s.LogFields(fields...)
+}
+
+// addEvent adds the log fields as an event of the span, named by the event
+// field, as set by span.LogKV("event", "name").
+func (s *bridgeSpan) addEvent(timestamp time.Time, fields []opentracingLog.Field) {
+	name := "log"
+	attrs := make([]attribute.KeyValue, 0, len(fields))
+	for _, field := range fields {
+		if field.Key() == "event" {
+			name = fmt.Sprint(field.Value())
+			continue
+		}
+		if err, ok := field.Value().(error); ok {
+			attrs = append(attrs, attribute.String(field.Key(), err.Error()))
+			continue
+		}
+		attrs = append(attrs, attributeFor(field.Key(), field.Value()))
+	}
+	opts := []trace.EventOption{trace.WithAttributes(attrs...)}
+	if !timestamp.IsZero() {
+		opts = append(opts, trace.WithTimestamp(timestamp))
+	}
+	s.span.AddEvent(name, opts...)
+}
+
+func (s *bridgeSpan) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	newBaggage := make(map[string]string, len(s.baggage)+1)
+	for k, v := range s.baggage {
+		newBaggage[k] = v
+	}
+	newBaggage[restrictedKey] = value
+	s.baggage = newBaggage
+	return s
+}
+
+func (s *bridgeSpan) BaggageItem(restrictedKey string) string {
+	return s.baggage[restrictedKey]
+}
+
+func (s *bridgeSpan) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+func (s *bridgeSpan) LogEvent(event string) {
+	s.LogFields(opentracingLog.String("event", event))
+}
+
+func (s *bridgeSpan) LogEventWithPayload(event string, payload interface{}) {
+	s.LogFields(opentracingLog.String("event", event), opentracingLog.Object("payload", payload))
+}
+
+func (s *bridgeSpan) Log(data opentracing.LogData) {
+	s.addEvent(data.Timestamp, data.ToLogRecord().Fields)
+}
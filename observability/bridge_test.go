@@ -0,0 +1,108 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package observability
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	opentracingExt "github.com/opentracing/opentracing-go/ext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestBridgeTracer() (opentracing.Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	propagator := propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+		b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)),
+	)
+	return NewBridgeTracer(provider.Tracer("test"), propagator), recorder
+}
+
+func TestBridgeTracerSpans(t *testing.T) {
+	tracer, recorder := newTestBridgeTracer()
+
+	parent := tracer.StartSpan("ensureIngress", opentracingExt.SpanKindRPCServer, opentracing.Tag{Key: "app", Value: "myapp"})
+	child := tracer.StartSpan("getService", opentracing.ChildOf(parent.Context()))
+	child.SetTag("replicas", 3)
+	child.LogKV("event", "service found", "namespace", "default")
+	opentracingExt.Error.Set(child, true)
+	child.LogFields()
+	child.Finish()
+	parent.Finish()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	childSpan, parentSpan := spans[0], spans[1]
+	assert.Equal(t, "ensureIngress", parentSpan.Name())
+	assert.Equal(t, trace.SpanKindServer, parentSpan.SpanKind())
+	assert.Equal(t, []attribute.KeyValue{attribute.String("app", "myapp")}, parentSpan.Attributes())
+	assert.Equal(t, "getService", childSpan.Name())
+	assert.Equal(t, parentSpan.SpanContext().TraceID(), childSpan.SpanContext().TraceID())
+	assert.Equal(t, parentSpan.SpanContext().SpanID(), childSpan.Parent().SpanID())
+	assert.Equal(t, []attribute.KeyValue{attribute.Int("replicas", 3)}, childSpan.Attributes())
+	assert.Equal(t, codes.Error, childSpan.Status().Code)
+	require.Len(t, childSpan.Events(), 2)
+	assert.Equal(t, "service found", childSpan.Events()[0].Name)
+	assert.Equal(t, []attribute.KeyValue{attribute.String("namespace", "default")}, childSpan.Events()[0].Attributes)
+
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	assert.Equal(t, parentSpan.SpanContext(), trace.SpanContextFromContext(ctx))
+}
+
+func TestBridgeTracerInjectExtract(t *testing.T) {
+	tracer, recorder := newTestBridgeTracer()
+
+	span := tracer.StartSpan("request")
+	span.SetBaggageItem("request_id", "abc")
+	headers := http.Header{}
+	err := tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(headers))
+	require.NoError(t, err)
+	span.Finish()
+	assert.NotEmpty(t, headers.Get("traceparent"))
+	assert.NotEmpty(t, headers.Get("X-B3-TraceId"))
+	assert.Equal(t, "request_id=abc", headers.Get("baggage"))
+
+	b3Only := http.Header{}
+	b3Only.Set("X-B3-TraceId", headers.Get("X-B3-TraceId"))
+	b3Only.Set("X-B3-SpanId", headers.Get("X-B3-SpanId"))
+	b3Only.Set("X-B3-Sampled", "1")
+	for _, carrierHeaders := range []http.Header{headers, b3Only} {
+		extracted, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(carrierHeaders))
+		require.NoError(t, err)
+		child := tracer.StartSpan("handler", opentracing.ChildOf(extracted))
+		child.Finish()
+	}
+
+	textMap := opentracing.TextMapCarrier{}
+	err = tracer.Inject(span.Context(), opentracing.TextMap, textMap)
+	require.NoError(t, err)
+	assert.NotEmpty(t, textMap["traceparent"])
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	for _, child := range spans[1:] {
+		assert.Equal(t, spans[0].SpanContext().TraceID(), child.SpanContext().TraceID())
+		assert.Equal(t, spans[0].SpanContext().SpanID(), child.Parent().SpanID())
+	}
+
+	_, err = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(http.Header{}))
+	assert.True(t, errors.Is(err, opentracing.ErrSpanContextNotFound))
+	_, err = tracer.Extract(opentracing.Binary, nil)
+	assert.True(t, errors.Is(err, opentracing.ErrUnsupportedFormat))
+}
@@ -0,0 +1,107 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package observability
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"github.com/urfave/negroni"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type loggerKey struct{}
+
+// NewLogger returns a logger writing to w in the json or text format, lines
+// below level (debug, info, warn or error) are discarded.
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, use debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, use json or text", format)
+}
+
+// ContextWithLogger returns a copy of ctx carrying logger.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger returns the logger carried by ctx, tagged with the attributes of the
+// request being served, or the default logger.
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// TraceID returns the ID of the trace of the span carried by ctx, if any.
+func TraceID(ctx context.Context) string {
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		return spanContext.TraceID().String()
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if spanContext, ok := span.Context().(jaeger.SpanContext); ok {
+			return spanContext.TraceID().String()
+		}
+	}
+	return ""
+}
+
+// LoggingMiddleware tags the logger and span of each request with its
+// X-Request-ID header and the logger with its trace ID, and logs the served
+// requests. Requests without X-Request-ID are given a generated one, which is
+// returned in the X-Request-ID header of the response. It must follow
+// Middleware, which starts the span of the request.
+func LoggingMiddleware() negroni.Handler {
+	return &loggingMiddleware{}
+}
+
+type loggingMiddleware struct{}
+
+func (*loggingMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	ctx := r.Context()
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = uuid.NewString()
+		r.Header.Set("X-Request-ID", requestID)
+	}
+	rw.Header().Set("X-Request-ID", requestID)
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetTag("request_id", requestID)
+	}
+	logger := Logger(ctx).With("request_id", requestID)
+	if traceID := TraceID(ctx); traceID != "" {
+		logger = logger.With("trace_id", traceID)
+	}
+	start := time.Now()
+	next(rw, r.WithContext(ContextWithLogger(ctx, logger)))
+
+	status := rw.(negroni.ResponseWriter).Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	logger.Info("request served",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"duration", time.Since(start),
+	)
+}
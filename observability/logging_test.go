@@ -0,0 +1,106 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package observability
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/negroni"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "warn")
+	require.NoError(t, err)
+	logger.Info("discarded")
+	logger.Warn("kept", "app", "myapp")
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "kept", line["msg"])
+	assert.Equal(t, "WARN", line["level"])
+	assert.Equal(t, "myapp", line["app"])
+
+	buf.Reset()
+	logger, err = NewLogger(&buf, "text", "debug")
+	require.NoError(t, err)
+	logger.Debug("kept", "app", "myapp")
+	assert.Contains(t, buf.String(), "level=DEBUG msg=kept app=myapp")
+
+	_, err = NewLogger(&buf, "xml", "info")
+	assert.EqualError(t, err, `invalid log format "xml", use json or text`)
+	_, err = NewLogger(&buf, "json", "verbose")
+	assert.EqualError(t, err, `invalid log level "verbose", use debug, info, warn or error`)
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	tracer, _ := newTestBridgeTracer()
+	previous := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(previous)
+
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "info")
+	require.NoError(t, err)
+
+	var traceID string
+	n := negroni.New(Middleware(), LoggingMiddleware())
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = TraceID(r.Context())
+		Logger(r.Context()).Info("handling")
+		w.WriteHeader(http.StatusCreated)
+	})
+	req := httptest.NewRequest(http.MethodPut, "/api/backend/myapp", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	req = req.WithContext(ContextWithLogger(req.Context(), logger))
+	rec := httptest.NewRecorder()
+	n.ServeHTTP(rec, req)
+
+	assert.Equal(t, "req-1", rec.Header().Get("X-Request-ID"))
+	require.Len(t, traceID, 32)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var handled, served map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &handled))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &served))
+	assert.Equal(t, "handling", handled["msg"])
+	assert.Equal(t, "req-1", handled["request_id"])
+	assert.Equal(t, traceID, handled["trace_id"])
+	assert.Equal(t, "request served", served["msg"])
+	assert.Equal(t, "req-1", served["request_id"])
+	assert.Equal(t, traceID, served["trace_id"])
+	assert.Equal(t, "PUT", served["method"])
+	assert.Equal(t, "/api/backend/myapp", served["path"])
+	assert.Equal(t, float64(http.StatusCreated), served["status"])
+}
+
+func TestLoggingMiddlewareGeneratesRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "info")
+	require.NoError(t, err)
+
+	var requestID string
+	n := negroni.New(Middleware(), LoggingMiddleware())
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get("X-Request-ID")
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/backend/myapp", nil)
+	req = req.WithContext(ContextWithLogger(req.Context(), logger))
+	rec := httptest.NewRecorder()
+	n.ServeHTTP(rec, req)
+
+	require.NotEmpty(t, requestID)
+	assert.Equal(t, requestID, rec.Header().Get("X-Request-ID"))
+	var served map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &served))
+	assert.Equal(t, requestID, served["request_id"])
+}
@@ -5,14 +5,130 @@
 package observability
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	jaegerConfig "github.com/uber/jaeger-client-go/config"
 	"github.com/uber/jaeger-client-go/zipkin"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
-func init() {
+const (
+	serviceName = "kubernetes-router"
+
+	defaultPropagators = "tracecontext,baggage,b3multi"
+)
+
+// Init sets up the global tracer selected by the environment:
+//
+//   - OTEL_TRACES_EXPORTER=otlp, or an OTLP endpoint set by
+//     OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT,
+//     exports the spans with OpenTelemetry through OTLP, using gRPC or
+//     HTTP as set by OTEL_EXPORTER_OTLP_(TRACES_)PROTOCOL, and propagates
+//     them as set by OTEL_PROPAGATORS, tracecontext, baggage and B3 by
+//     default. The opentracing spans are bridged to OpenTelemetry;
+//   - OTEL_TRACES_EXPORTER=none disables tracing;
+//   - otherwise the Jaeger client is configured by the JAEGER_* variables
+//     with B3 propagation.
+//
+// The returned function flushes and stops the tracer.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); {
+	case exporter == "none":
+		return noopShutdown, nil
+	case exporter == "otlp",
+		exporter == "" && (os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""):
+		return initOpenTelemetry(ctx)
+	case exporter == "", exporter == "jaeger":
+		return initJaeger()
+	default:
+		return nil, fmt.Errorf("unsupported traces exporter %q, use otlp, jaeger or none", exporter)
+	}
+}
+
+func noopShutdown(context.Context) error {
+	return nil
+}
+
+func initOpenTelemetry(ctx context.Context) (func(context.Context) error, error) {
+	propagator, err := propagatorsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := newOTLPExporter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	opentracing.SetGlobalTracer(NewBridgeTracer(provider.Tracer("github.com/tsuru/kubernetes-router"), propagator))
+	return provider.Shutdown, nil
+}
+
+func newOTLPExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	switch protocol {
+	case "", "grpc":
+		return otlptracegrpc.New(ctx)
+	case "http/protobuf":
+		return otlptracehttp.New(ctx)
+	}
+	return nil, fmt.Errorf("unsupported OTLP protocol %q, use grpc or http/protobuf", protocol)
+}
+
+// propagatorsFromEnv returns the propagators listed by OTEL_PROPAGATORS,
+// b3 injects the single B3 header and b3multi the X-B3-* headers.
+func propagatorsFromEnv() (propagation.TextMapPropagator, error) {
+	names := os.Getenv("OTEL_PROPAGATORS")
+	if names == "" {
+		names = defaultPropagators
+	}
+	var propagators []propagation.TextMapPropagator
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "tracecontext":
+			propagators = append(propagators, propagation.TraceContext{})
+		case "baggage":
+			propagators = append(propagators, propagation.Baggage{})
+		case "b3":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case "b3multi":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		case "none":
+		default:
+			return nil, fmt.Errorf("unsupported propagator %q, use tracecontext, baggage, b3, b3multi or none", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...), nil
+}
+
+func initJaeger() (func(context.Context) error, error) {
 	// We decided to use B3 Format, in the future plan to move to W3C context propagation
 	// https://github.com/w3c/trace-context
 	zipkinPropagator := zipkin.NewZipkinB3HTTPHeaderPropagator()
@@ -20,21 +136,23 @@ func init() {
 	// setup opentracing
 	cfg, err := jaegerConfig.FromEnv()
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, err
 	}
-	cfg.ServiceName = "kubernetes-router"
+	cfg.ServiceName = serviceName
 
-	tracer, _, err := cfg.NewTracer(
+	tracer, closer, err := cfg.NewTracer(
 		jaegerConfig.Injector(opentracing.HTTPHeaders, zipkinPropagator),
 		jaegerConfig.Extractor(opentracing.HTTPHeaders, zipkinPropagator),
 		jaegerConfig.Injector(opentracing.TextMap, zipkinPropagator),
 		jaegerConfig.Extractor(opentracing.TextMap, zipkinPropagator),
 	)
-	if err == nil {
-		opentracing.SetGlobalTracer(tracer)
-	} else {
+	if err != nil {
 		// FIXME: we need to mark that traces are disabled
-		log.Printf("Could not initialize jaeger tracer: %s", err.Error())
+		slog.Warn("could not initialize jaeger tracer", "error", err)
+		return noopShutdown, nil
 	}
-
+	opentracing.SetGlobalTracer(tracer)
+	return func(context.Context) error {
+		return closer.Close()
+	}, nil
 }
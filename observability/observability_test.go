@@ -0,0 +1,45 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package observability
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPropagatorsFromEnv(t *testing.T) {
+	propagator, err := propagatorsFromEnv()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"traceparent", "tracestate", "baggage", "x-b3-traceid", "x-b3-spanid", "x-b3-sampled", "x-b3-flags"}, propagator.Fields())
+
+	t.Setenv("OTEL_PROPAGATORS", "b3")
+	propagator, err = propagatorsFromEnv()
+	require.NoError(t, err)
+	assert.Contains(t, propagator.Fields(), "b3")
+	assert.NotContains(t, propagator.Fields(), "traceparent")
+
+	t.Setenv("OTEL_PROPAGATORS", "tracecontext,xray")
+	_, err = propagatorsFromEnv()
+	assert.EqualError(t, err, `unsupported propagator "xray", use tracecontext, baggage, b3, b3multi or none`)
+}
+
+func TestInit(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	shutdown, err := Init(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "zipkin")
+	_, err = Init(context.Background())
+	assert.EqualError(t, err, `unsupported traces exporter "zipkin", use otlp, jaeger or none`)
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+	_, err = Init(context.Background())
+	assert.EqualError(t, err, `unsupported OTLP protocol "http/json", use grpc or http/protobuf`)
+}
@@ -7,17 +7,33 @@ package observability
 import (
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	opentracingHTTP "github.com/opentracing-contrib/go-stdlib/nethttp"
 	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+var kubernetesRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kubernetes_router_kubernetes_requests_total",
+	Help: "Total number of requests to the Kubernetes API by cluster, verb, resource and status code.",
+}, []string{"cluster", "verb", "resource", "code"})
+
 func WrapTransport(rt http.RoundTripper) http.RoundTripper {
-	return &AutoOpentracingTransport{RoundTripper: rt}
+	return WrapClusterTransport("", rt)
+}
+
+// WrapClusterTransport traces the requests to the Kubernetes API and counts
+// them by verb, resource and status code, cluster is empty for the local one.
+func WrapClusterTransport(cluster string, rt http.RoundTripper) http.RoundTripper {
+	return &AutoOpentracingTransport{RoundTripper: rt, Cluster: cluster}
 }
 
 type AutoOpentracingTransport struct {
 	http.RoundTripper
+	Cluster string
 }
 
 func (t *AutoOpentracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -32,10 +48,13 @@ func (t *AutoOpentracingTransport) RoundTrip(req *http.Request) (*http.Response,
 	transport := &opentracingHTTP.Transport{RoundTripper: rt}
 	response, err := transport.RoundTrip(req)
 
+	verb, resource := requestVerbResource(req)
 	if err != nil {
+		kubernetesRequests.WithLabelValues(t.Cluster, verb, resource, "error").Inc()
 		ht.Finish()
 		return nil, err
 	}
+	kubernetesRequests.WithLabelValues(t.Cluster, verb, resource, strconv.Itoa(response.StatusCode)).Inc()
 	response.Body = &autoCloseTracer{ht: ht, ReadCloser: response.Body}
 	return response, nil
 }
@@ -50,3 +69,56 @@ func (a *autoCloseTracer) Close() error {
 	a.ht.Finish()
 	return err
 }
+
+// requestVerbResource returns the Kubernetes API verb and resource of req,
+// resources of named API groups are suffixed by the group, as in
+// httproutes.gateway.networking.k8s.io.
+func requestVerbResource(req *http.Request) (string, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	var group string
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		group = parts[1]
+		parts = parts[3:]
+	default:
+		return strings.ToLower(req.Method), "other"
+	}
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+	if len(parts) == 0 {
+		return strings.ToLower(req.Method), "other"
+	}
+	resource := parts[0]
+	if len(parts) >= 3 {
+		resource += "/" + parts[2]
+	}
+	if group != "" {
+		resource += "." + group
+	}
+	hasName := len(parts) >= 2
+	switch req.Method {
+	case http.MethodGet:
+		if req.URL.Query().Get("watch") == "true" {
+			return "watch", resource
+		}
+		if hasName {
+			return "get", resource
+		}
+		return "list", resource
+	case http.MethodPost:
+		return "create", resource
+	case http.MethodPut:
+		return "update", resource
+	case http.MethodPatch:
+		return "patch", resource
+	case http.MethodDelete:
+		if hasName {
+			return "delete", resource
+		}
+		return "deletecollection", resource
+	}
+	return strings.ToLower(req.Method), resource
+}
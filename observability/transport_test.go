@@ -0,0 +1,64 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package observability
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestVerbResource(t *testing.T) {
+	tests := []struct {
+		method           string
+		url              string
+		expectedVerb     string
+		expectedResource string
+	}{
+		{http.MethodGet, "/api/v1/namespaces/default/services/my-svc", "get", "services"},
+		{http.MethodGet, "/api/v1/namespaces/default/services", "list", "services"},
+		{http.MethodGet, "/api/v1/services?watch=true", "watch", "services"},
+		{http.MethodGet, "/api/v1/namespaces/default", "get", "namespaces"},
+		{http.MethodPut, "/api/v1/namespaces/default/services/my-svc/status", "update", "services/status"},
+		{http.MethodPost, "/apis/networking.k8s.io/v1/namespaces/default/ingresses", "create", "ingresses.networking.k8s.io"},
+		{http.MethodPatch, "/apis/gateway.networking.k8s.io/v1/namespaces/default/httproutes/my-route", "patch", "httproutes.gateway.networking.k8s.io"},
+		{http.MethodDelete, "/apis/cert-manager.io/v1/namespaces/default/certificates/my-cert", "delete", "certificates.cert-manager.io"},
+		{http.MethodDelete, "/api/v1/namespaces/default/secrets", "deletecollection", "secrets"},
+		{http.MethodGet, "/version", "get", "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.url, func(t *testing.T) {
+			verb, resource := requestVerbResource(httptest.NewRequest(tt.method, tt.url, nil))
+			assert.Equal(t, tt.expectedVerb, verb)
+			assert.Equal(t, tt.expectedResource, resource)
+		})
+	}
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	var m dto.Metric
+	require.NoError(t, counter.Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestWrapClusterTransportCountsRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	counter := kubernetesRequests.WithLabelValues("my-cluster", "get", "services", "404")
+	before := counterValue(t, counter)
+	client := &http.Client{Transport: WrapClusterTransport("my-cluster", http.DefaultTransport)}
+	rsp, err := client.Get(server.URL + "/api/v1/namespaces/default/services/my-svc")
+	require.NoError(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, before+1, counterValue(t, counter))
+}
@@ -0,0 +1,202 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CertificateSource is how the certificate of a host is provisioned
+type CertificateSource string
+
+const (
+	CertificateSourceManual      = CertificateSource("manual")
+	CertificateSourceACME        = CertificateSource("acme")
+	CertificateSourceCertManager = CertificateSource("cert-manager")
+)
+
+// CertificateCondition is a condition of the cert-manager Certificate of a host
+type CertificateCondition struct {
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// CertificateInfo describes the certificate served for a TLS host of an app
+type CertificateInfo struct {
+	Host   string            `json:"host"`
+	Source CertificateSource `json:"source"`
+	// Issuer is the cert-manager issuer of the ACME and cert-manager certificates
+	Issuer     string `json:"issuer,omitempty"`
+	SecretName string `json:"secretName,omitempty"`
+	// Subject and CertificateIssuer are the distinguished names of the leaf certificate
+	Subject           string     `json:"subject,omitempty"`
+	CertificateIssuer string     `json:"certificateIssuer,omitempty"`
+	NotBefore         *time.Time `json:"notBefore,omitempty"`
+	NotAfter          *time.Time `json:"notAfter,omitempty"`
+	DNSNames          []string   `json:"dnsNames,omitempty"`
+	ValidChain        bool       `json:"validChain"`
+	// Error is the reason the certificate could not be inspected or its chain is invalid
+	Error string `json:"error,omitempty"`
+	// Ready is the Ready condition of the cert-manager Certificate of the host
+	Ready *CertificateCondition `json:"ready,omitempty"`
+}
+
+// RouterCertificates is implemented by routers able to describe the
+// certificates served for the TLS hosts of an app.
+type RouterCertificates interface {
+	Router
+	ListCertificates(ctx context.Context, id InstanceID) ([]CertificateInfo, error)
+}
+
+var errNoCertificate = errors.New("no certificate found")
+
+// InvalidCertificateError is returned when a certificate or its key are
+// rejected by CertData.Validate.
+type InvalidCertificateError struct {
+	Reason string
+}
+
+func (e *InvalidCertificateError) Error() string {
+	return "invalid certificate: " + e.Reason
+}
+
+// ErrorCode returns ErrorCodeInvalidCertificate.
+func (e *InvalidCertificateError) ErrorCode() ErrorCode {
+	return ErrorCodeInvalidCertificate
+}
+
+func invalidCertificate(format string, args ...interface{}) error {
+	return &InvalidCertificateError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// ParseCertificateChain decodes the PEM encoded certificates of a chain,
+// blocks other than certificates are ignored.
+func ParseCertificateChain(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate %d: %w", len(chain)+1, err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errNoCertificate
+	}
+	return chain, nil
+}
+
+// verifyChainOrder checks that each certificate of the chain is signed by the
+// next one, that is, the chain is ordered leaf first.
+func verifyChainOrder(chain []*x509.Certificate) error {
+	for i := 0; i < len(chain)-1; i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return fmt.Errorf("certificate %d is not signed by certificate %d, the chain must be ordered leaf first", i+1, i+2)
+		}
+	}
+	return nil
+}
+
+// Describe fills the details of the leaf certificate of the PEM encoded chain.
+func (info *CertificateInfo) Describe(chainPEM []byte) {
+	chain, err := ParseCertificateChain(chainPEM)
+	if err != nil {
+		info.Error = err.Error()
+		return
+	}
+	leaf := chain[0]
+	notBefore, notAfter := leaf.NotBefore, leaf.NotAfter
+	info.Subject = leaf.Subject.String()
+	info.CertificateIssuer = leaf.Issuer.String()
+	info.NotBefore = &notBefore
+	info.NotAfter = &notAfter
+	info.DNSNames = leaf.DNSNames
+	if err = verifyChainOrder(chain); err != nil {
+		info.Error = err.Error()
+		return
+	}
+	info.ValidChain = true
+}
+
+// Validate checks that the certificate chain and key of cert are well formed,
+// that the key matches the leaf certificate, that the leaf certificate covers
+// host and is valid at now and that the chain is ordered leaf first.
+func (cert CertData) Validate(host string, now time.Time) error {
+	chain, err := ParseCertificateChain([]byte(cert.Certificate))
+	if err != nil {
+		return invalidCertificate("%v", err)
+	}
+	key, err := parsePrivateKey([]byte(cert.Key))
+	if err != nil {
+		return invalidCertificate("%v", err)
+	}
+	leaf := chain[0]
+	publicKey, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(key.Public()) {
+		return invalidCertificate("private key does not match the certificate")
+	}
+	if err = leaf.VerifyHostname(host); err != nil {
+		if len(leaf.DNSNames) == 0 {
+			return invalidCertificate("certificate is not valid for %s, it has no DNS names", host)
+		}
+		return invalidCertificate("certificate is not valid for %s, it is valid for %s", host, strings.Join(leaf.DNSNames, ", "))
+	}
+	if now.After(leaf.NotAfter) {
+		return invalidCertificate("certificate expired at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if now.Before(leaf.NotBefore) {
+		return invalidCertificate("certificate is not valid before %s", leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if err = verifyChainOrder(chain); err != nil {
+		return invalidCertificate("%v", err)
+	}
+	return nil
+}
+
+// parsePrivateKey decodes the first private key of the PEM encoded data, in
+// the PKCS #1, PKCS #8 or SEC 1 forms.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no private key found")
+		}
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+		if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+		if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+func newTestCertificate(t *testing.T, commonName string, dnsNames []string, notAfter time.Time, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              dnsNames,
+		NotBefore:             notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCertificate{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+func TestCertificateInfoDescribe(t *testing.T) {
+	notAfter := time.Now().Add(5 * 24 * time.Hour).Truncate(time.Second).UTC()
+	ca := newTestCertificate(t, "my-ca", nil, notAfter.Add(time.Hour), nil)
+	leaf := newTestCertificate(t, "myapp.example.com", []string{"myapp.example.com", "*.myapp.example.com"}, notAfter, ca)
+
+	info := CertificateInfo{Host: "myapp.example.com"}
+	info.Describe([]byte(leaf.pem + ca.pem))
+	assert.Equal(t, "CN=myapp.example.com", info.Subject)
+	assert.Equal(t, "CN=my-ca", info.CertificateIssuer)
+	assert.Equal(t, notAfter, info.NotAfter.UTC())
+	assert.Equal(t, notAfter.Add(-90*24*time.Hour), info.NotBefore.UTC())
+	assert.Equal(t, []string{"myapp.example.com", "*.myapp.example.com"}, info.DNSNames)
+	assert.True(t, info.ValidChain)
+	assert.Empty(t, info.Error)
+
+	info = CertificateInfo{}
+	info.Describe([]byte(ca.pem + leaf.pem))
+	assert.Equal(t, "CN=my-ca", info.Subject)
+	assert.False(t, info.ValidChain)
+	assert.Equal(t, "certificate 1 is not signed by certificate 2, the chain must be ordered leaf first", info.Error)
+
+	info = CertificateInfo{}
+	info.Describe([]byte("invalid"))
+	assert.False(t, info.ValidChain)
+	assert.Equal(t, "no certificate found", info.Error)
+}
+
+func (c *testCertificate) keyPEM(t *testing.T) string {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+func TestCertDataValidate(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "my-ca", nil, now.Add(48*time.Hour), nil)
+	leaf := newTestCertificate(t, "myapp.example.com", []string{"myapp.example.com", "*.myapp.example.com"}, now.Add(24*time.Hour), ca)
+	other := newTestCertificate(t, "other.example.com", []string{"other.example.com"}, now.Add(24*time.Hour), ca)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	require.NoError(t, err)
+	leafPKCS8 := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+
+	tests := []struct {
+		name          string
+		cert          CertData
+		host          string
+		now           time.Time
+		expectedError string
+	}{
+		{
+			name: "valid chain",
+			cert: CertData{Certificate: leaf.pem + ca.pem, Key: leaf.keyPEM(t)},
+			host: "myapp.example.com",
+			now:  now,
+		},
+		{
+			name: "wildcard host with pkcs8 key",
+			cert: CertData{Certificate: leaf.pem, Key: leafPKCS8},
+			host: "www.myapp.example.com",
+			now:  now,
+		},
+		{
+			name:          "invalid certificate",
+			cert:          CertData{Certificate: "Certz", Key: leaf.keyPEM(t)},
+			host:          "myapp.example.com",
+			now:           now,
+			expectedError: "invalid certificate: no certificate found",
+		},
+		{
+			name:          "invalid key",
+			cert:          CertData{Certificate: leaf.pem, Key: "keyz"},
+			host:          "myapp.example.com",
+			now:           now,
+			expectedError: "invalid certificate: no private key found",
+		},
+		{
+			name:          "key mismatch",
+			cert:          CertData{Certificate: leaf.pem, Key: other.keyPEM(t)},
+			host:          "myapp.example.com",
+			now:           now,
+			expectedError: "invalid certificate: private key does not match the certificate",
+		},
+		{
+			name:          "host not covered",
+			cert:          CertData{Certificate: leaf.pem, Key: leaf.keyPEM(t)},
+			host:          "a.b.myapp.example.com",
+			now:           now,
+			expectedError: "invalid certificate: certificate is not valid for a.b.myapp.example.com, it is valid for myapp.example.com, *.myapp.example.com",
+		},
+		{
+			name:          "expired",
+			cert:          CertData{Certificate: leaf.pem, Key: leaf.keyPEM(t)},
+			host:          "myapp.example.com",
+			now:           now.Add(25 * time.Hour),
+			expectedError: "invalid certificate: certificate expired at " + leaf.cert.NotAfter.UTC().Format(time.RFC3339),
+		},
+		{
+			name:          "chain out of order",
+			cert:          CertData{Certificate: leaf.pem + other.pem, Key: leaf.keyPEM(t)},
+			host:          "myapp.example.com",
+			now:           now,
+			expectedError: "invalid certificate: certificate 1 is not signed by certificate 2, the chain must be ordered leaf first",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cert.Validate(tt.host, tt.now)
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.expectedError)
+			var certErr *InvalidCertificateError
+			assert.ErrorAs(t, err, &certErr)
+		})
+	}
+}
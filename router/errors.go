@@ -0,0 +1,123 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode identifies the kind of an error returned by a router, codes are
+// stable and returned to tsuru along the error message.
+type ErrorCode string
+
+const (
+	// ErrorCodeInvalidRequest is returned for malformed requests.
+	ErrorCodeInvalidRequest = ErrorCode("invalid_request")
+	// ErrorCodeInvalidOptions is returned when the router options of an app
+	// are malformed or contradictory.
+	ErrorCodeInvalidOptions = ErrorCode("invalid_options")
+	// ErrorCodeInvalidCertificate is returned when a certificate or its key
+	// are rejected.
+	ErrorCodeInvalidCertificate = ErrorCode("invalid_certificate")
+	// ErrorCodeInvalidIssuer is returned when a cert-manager issuer does not
+	// exist or is malformed.
+	ErrorCodeInvalidIssuer = ErrorCode("invalid_issuer")
+	// ErrorCodeInvalidResource is returned when Kubernetes rejects the
+	// resources generated for an app, usually due to invalid option values.
+	ErrorCodeInvalidResource = ErrorCode("invalid_resource")
+	// ErrorCodeUnsupported is returned when an option or operation is not
+	// supported by the router mode or by the current resources of the app.
+	ErrorCodeUnsupported = ErrorCode("unsupported")
+	// ErrorCodeNotFound is returned when the app, its services or the
+	// requested resources are not found.
+	ErrorCodeNotFound = ErrorCode("not_found")
+	// ErrorCodeAlreadyExists is returned when creating a resource that
+	// already exists.
+	ErrorCodeAlreadyExists = ErrorCode("already_exists")
+	// ErrorCodeConflict is returned when a resource was concurrently
+	// modified, the request may be retried.
+	ErrorCodeConflict = ErrorCode("conflict")
+	// ErrorCodeFrozen is returned when the resources of the app are frozen
+	// by the router.tsuru.io/freeze annotation.
+	ErrorCodeFrozen = ErrorCode("frozen")
+	// ErrorCodeForbidden is returned when the router is not allowed to
+	// manage the resources of the app.
+	ErrorCodeForbidden = ErrorCode("forbidden")
+	// ErrorCodeUnavailable is returned when a resource or the Kubernetes
+	// API are not ready or unavailable, the request may be retried.
+	ErrorCodeUnavailable = ErrorCode("unavailable")
+	// ErrorCodeNotImplemented is returned when the router does not
+	// implement the operation.
+	ErrorCodeNotImplemented = ErrorCode("not_implemented")
+	// ErrorCodeInternal is the code of errors without one.
+	ErrorCodeInternal = ErrorCode("internal")
+)
+
+// Error is an error with an ErrorCode and details about the resources
+// involved, as the app or host.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Details map[string]string
+	Err     error
+}
+
+// NewError returns an Error of code with the message formatted as
+// fmt.Errorf does, errors wrapped with %w are unwrapped by the Error.
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+	err := fmt.Errorf(format, args...)
+	return &Error{Code: code, Message: err.Error(), Err: errors.Unwrap(err)}
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCode returns the code of the error.
+func (e *Error) ErrorCode() ErrorCode {
+	return e.Code
+}
+
+// ErrorDetails returns the details of the error.
+func (e *Error) ErrorDetails() map[string]string {
+	return e.Details
+}
+
+// WithDetail sets a detail of the error and returns it.
+func (e *Error) WithDetail(key, value string) *Error {
+	if e.Details == nil {
+		e.Details = map[string]string{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+// CodeOf returns the code of the first error of the chain of err with one,
+// ErrorCodeInternal otherwise.
+func CodeOf(err error) ErrorCode {
+	var coded interface{ ErrorCode() ErrorCode }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	return ErrorCodeInternal
+}
+
+// DetailsOf returns the details of the first error of the chain of err with
+// them.
+func DetailsOf(err error) map[string]string {
+	var detailed interface{ ErrorDetails() map[string]string }
+	if errors.As(err, &detailed) {
+		return detailed.ErrorDetails()
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewError(t *testing.T) {
+	cause := errors.New("prefix \"v2\" not found")
+	err := NewError(ErrorCodeInvalidOptions, "route rule %d: %w", 0, cause).WithDetail("option", RouteRules)
+	assert.EqualError(t, err, `route rule 0: prefix "v2" not found`)
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, ErrorCodeInvalidOptions, err.ErrorCode())
+	assert.Equal(t, map[string]string{"option": RouteRules}, err.ErrorDetails())
+}
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		err             error
+		expectedCode    ErrorCode
+		expectedDetails map[string]string
+	}{
+		{err: errors.New("failed"), expectedCode: ErrorCodeInternal},
+		{err: ErrCertificateNotFound, expectedCode: ErrorCodeNotFound},
+		{err: fmt.Errorf("%w: weights of prefix %q sum more than 100", ErrInvalidWeights, "/"), expectedCode: ErrorCodeInvalidOptions},
+		{err: &InvalidCertificateError{Reason: "no certificate found"}, expectedCode: ErrorCodeInvalidCertificate},
+		{
+			err:             fmt.Errorf("could not ensure TLS: %w", NewError(ErrorCodeInvalidIssuer, "issuer %s not found", "letsencrypt").WithDetail("issuer", "letsencrypt")),
+			expectedCode:    ErrorCodeInvalidIssuer,
+			expectedDetails: map[string]string{"issuer": "letsencrypt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			assert.Equal(t, tt.expectedCode, CodeOf(tt.err))
+			assert.Equal(t, tt.expectedDetails, DetailsOf(tt.err))
+		})
+	}
+}
@@ -10,7 +10,12 @@ import (
 	"github.com/tsuru/kubernetes-router/router"
 )
 
-var _ router.Router = &RouterMock{}
+var (
+	_ router.Router             = &RouterMock{}
+	_ router.RouterSwap         = &RouterMock{}
+	_ router.RouterCertificates = &RouterMock{}
+	_ router.RouterPlan         = &RouterMock{}
+)
 
 // RouterMock is a router.Router mock implementation to be
 // used by tests
@@ -23,6 +28,9 @@ type RouterMock struct {
 	AddCertificateFn         func(router.InstanceID, string, router.CertData) error
 	RemoveCertificateFn      func(router.InstanceID, string) error
 	SupportedOptionsFn       func() map[string]string
+	SwapFn                   func(router.InstanceID, router.InstanceID, bool) error
+	ListCertificatesFn       func(router.InstanceID) ([]router.CertificateInfo, error)
+	PlanEnsureFn             func(router.InstanceID, router.EnsureBackendOpts) (*router.Plan, error)
 	RemoveInvoked            bool
 	EnsureInvoked            bool
 	GetAddressesInvoked      bool
@@ -31,6 +39,9 @@ type RouterMock struct {
 	RemoveCertificateInvoked bool
 	SupportedOptionsInvoked  bool
 	GetStatusInvoked         bool
+	SwapInvoked              bool
+	ListCertificatesInvoked  bool
+	PlanEnsureInvoked        bool
 }
 
 // Remove calls RemoveFn
@@ -79,3 +90,21 @@ func (s *RouterMock) SupportedOptions(ctx context.Context) map[string]string {
 	s.SupportedOptionsInvoked = true
 	return s.SupportedOptionsFn()
 }
+
+// Swap calls SwapFn
+func (s *RouterMock) Swap(ctx context.Context, srcApp, dstApp router.InstanceID, cnameOnly bool) error {
+	s.SwapInvoked = true
+	return s.SwapFn(srcApp, dstApp, cnameOnly)
+}
+
+// ListCertificates calls ListCertificatesFn
+func (s *RouterMock) ListCertificates(ctx context.Context, id router.InstanceID) ([]router.CertificateInfo, error) {
+	s.ListCertificatesInvoked = true
+	return s.ListCertificatesFn(id)
+}
+
+// PlanEnsure calls PlanEnsureFn
+func (s *RouterMock) PlanEnsure(ctx context.Context, id router.InstanceID, o router.EnsureBackendOpts) (*router.Plan, error) {
+	s.PlanEnsureInvoked = true
+	return s.PlanEnsureFn(id, o)
+}
@@ -0,0 +1,49 @@
+// Copyright 2026 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package router
+
+import "context"
+
+// PlanAction is the change Ensure would make to a resource
+type PlanAction string
+
+var (
+	PlanActionCreate = PlanAction("create")
+	PlanActionUpdate = PlanAction("update")
+	PlanActionDelete = PlanAction("delete")
+)
+
+// FieldChange is a field of a resource changed by Ensure, Old is omitted for
+// the fields added and New for the ones removed.
+type FieldChange struct {
+	// Path is the path of the field, as metadata.annotations["tsuru.io/app"]
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// ResourceChange is a resource Ensure would create, update or delete, with
+// the fields changed for the created and updated ones.
+type ResourceChange struct {
+	Action     PlanAction    `json:"action"`
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace,omitempty"`
+	Name       string        `json:"name"`
+	Diff       []FieldChange `json:"diff,omitempty"`
+}
+
+// Plan lists the changes Ensure would make to the resources of an app, in
+// the order they would be written.
+type Plan struct {
+	Changes []ResourceChange `json:"changes"`
+}
+
+// RouterPlan is implemented by routers able to run Ensure without writing
+// the resources, returning the changes it would make instead.
+type RouterPlan interface {
+	Router
+	PlanEnsure(ctx context.Context, id InstanceID, o EnsureBackendOpts) (*Plan, error)
+}
@@ -7,7 +7,9 @@ package router
 import (
 	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -37,19 +39,65 @@ const (
 	// AcmeCName is the acme option for cnames
 	AcmeCName = "tls-acme-cname"
 
+	// TLSIssuer is the option with the cert-manager issuer of the certificate
+	// of the app hostnames
+	TLSIssuer = "tls-issuer"
+
 	ExternalTrafficPolicy = "external-traffic-policy"
 
 	// optsAnnotation is the name of the annotation used to store opts.
 	optsAnnotation = "router.tsuru.io/opts"
 
 	AllPrefixes = "all-prefixes"
+
+	// RouteRules is the option with the JSON encoded list of route rules
+	RouteRules = "route-rules"
+
+	// RequestHeaders and ResponseHeaders are the options with the JSON
+	// encoded header modifiers of requests and responses
+	RequestHeaders  = "request-headers"
+	ResponseHeaders = "response-headers"
+
+	// HTTPSRedirect is the option redirecting HTTP requests to HTTPS, it
+	// accepts true or the redirect status code, 301 or 308
+	HTTPSRedirect = "https-redirect"
+
+	// URLRewrite is the option with the path replacing the route prefix
+	URLRewrite = "url-rewrite"
+
+	// Timeout is the option with the timeout of the requests, ie: 30s
+	Timeout = "timeout"
+
+	// Retries is the option with the number of retries of failed requests
+	Retries = "retries"
+
+	// RetryOn is the option with the comma separated conditions retrying
+	// requests, see RetryOnConditions
+	RetryOn = "retry-on"
+
+	// MirrorTo is the option with the service, in the app namespace, receiving
+	// a copy of the requests, ie: my-svc or my-svc:8080
+	MirrorTo = "mirror-to"
+
+	// MirrorPercent is the option with the percentage of mirrored requests
+	MirrorPercent = "mirror-percent"
 )
 
 // ErrIngressAlreadyExists is the error returned by the service when
 // trying to create a service that already exists
 var (
-	ErrIngressAlreadyExists = errors.New("ingress already exists")
-	ErrCertificateNotFound  = errors.New("certificate not found")
+	ErrIngressAlreadyExists = &Error{Code: ErrorCodeAlreadyExists, Message: "ingress already exists"}
+	ErrCertificateNotFound  = &Error{Code: ErrorCodeNotFound, Message: "certificate not found"}
+	ErrInvalidWeights       = &Error{Code: ErrorCodeInvalidOptions, Message: "invalid target weights"}
+)
+
+// RetryOnConditions are the conditions accepted by the retry-on option besides
+// the 4xx and 5xx status codes.
+var RetryOnConditions = []string{"5xx", "gateway-error", "retriable-4xx", "connect-failure", "reset"}
+
+var (
+	durationRegexp    = regexp.MustCompile(`^([0-9]{1,5}(h|m|s|ms)){1,4}$`)
+	serviceNameRegexp = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
 )
 
 type InstanceID struct {
@@ -87,6 +135,13 @@ type RouterTLS interface {
 	RemoveCertificate(ctx context.Context, id InstanceID, certName string) error
 }
 
+// RouterSwap is implemented by routers able to swap the backends of two apps.
+// When cnameOnly is set only the cnames are moved between the apps.
+type RouterSwap interface {
+	Router
+	Swap(ctx context.Context, srcApp, dstApp InstanceID, cnameOnly bool) error
+}
+
 // Opts used when creating/updating routers
 type Opts struct {
 	Pool                  string            `json:",omitempty"`
@@ -101,9 +156,83 @@ type Opts struct {
 	Acme                  bool              `json:",omitempty"`
 	HTTPOnly              bool              `json:",omitempty"`
 	AcmeCName             bool              `json:",omitempty"`
+	TLSIssuer             string            `json:",omitempty"`
 	ExposeAllServices     bool              `json:",omitempty"`
 	GatewayName           string            `json:",omitempty"`
 	GatewayNamespace      string            `json:",omitempty"`
+	RouteRules            []RouteRule       `json:",omitempty"`
+	RequestHeaders        *HeaderModifier   `json:",omitempty"`
+	ResponseHeaders       *HeaderModifier   `json:",omitempty"`
+	HTTPSRedirectCode     int               `json:",omitempty"`
+	URLRewrite            string            `json:",omitempty"`
+	Timeout               string            `json:",omitempty"`
+	Retries               *int              `json:",omitempty"`
+	RetryOn               []string          `json:",omitempty"`
+	MirrorTo              string            `json:",omitempty"`
+	MirrorPercent         *int32            `json:",omitempty"`
+}
+
+// HeaderModifier sets, adds and removes the headers of requests or responses.
+type HeaderModifier struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Add    map[string]string `json:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+var headerNameRegexp = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+\\-.^_`|~]+$")
+
+// Validate checks the header names and values, a header can only be changed
+// by one of the operations.
+func (h *HeaderModifier) Validate() error {
+	seen := map[string]bool{}
+	check := func(name string) error {
+		if !headerNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if seen[strings.ToLower(name)] {
+			return fmt.Errorf("header %q modified more than once", name)
+		}
+		seen[strings.ToLower(name)] = true
+		return nil
+	}
+	for _, headers := range []map[string]string{h.Set, h.Add} {
+		for name, value := range headers {
+			if err := check(name); err != nil {
+				return err
+			}
+			if strings.ContainsAny(value, "\r\n\x00") {
+				return fmt.Errorf("invalid value for header %q", name)
+			}
+		}
+	}
+	for _, name := range h.Remove {
+		if err := check(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RouteRule routes the requests matching its headers, cookies, query params
+// and method to the service of a prefix, e.g. a beta cookie or an API version
+// header.
+type RouteRule struct {
+	// Prefix is the backend prefix receiving the requests, the default one
+	// when empty.
+	Prefix      string           `json:"prefix,omitempty"`
+	Path        string           `json:"path,omitempty"`
+	Method      string           `json:"method,omitempty"`
+	Headers     []RouteRuleMatch `json:"headers,omitempty"`
+	Cookies     []RouteRuleMatch `json:"cookies,omitempty"`
+	QueryParams []RouteRuleMatch `json:"queryParams,omitempty"`
+}
+
+// RouteRuleMatch matches a header, cookie or query param by name. Type is
+// either Exact, the default, or RegularExpression.
+type RouteRuleMatch struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  string `json:"type,omitempty"`
 }
 
 // CertData user when adding certificates
@@ -115,6 +244,31 @@ type CertData struct {
 type BackendPrefix struct {
 	Prefix string        `json:"prefix"`
 	Target BackendTarget `json:"target"`
+	// WeightedTargets receive a percentage of the traffic of the prefix, as
+	// used by canary releases, Target receives the remaining traffic.
+	WeightedTargets []WeightedBackendTarget `json:"weightedTargets,omitempty"`
+}
+
+type WeightedBackendTarget struct {
+	BackendTarget
+	Weight int32 `json:"weight"`
+}
+
+// AllTargets returns every target of the prefix with its weight, Target is
+// always the first one and receives the weight not used by WeightedTargets.
+func (p BackendPrefix) AllTargets() ([]WeightedBackendTarget, error) {
+	remaining := int32(100)
+	for _, target := range p.WeightedTargets {
+		if target.Weight < 0 || target.Weight > 100 {
+			return nil, fmt.Errorf("%w: weight of %s/%s must be between 0 and 100", ErrInvalidWeights, target.Namespace, target.Service)
+		}
+		remaining -= target.Weight
+	}
+	if remaining < 0 {
+		return nil, fmt.Errorf("%w: weights of prefix %q sum more than 100", ErrInvalidWeights, p.Prefix)
+	}
+	targets := []WeightedBackendTarget{{BackendTarget: p.Target, Weight: remaining}}
+	return append(targets, p.WeightedTargets...), nil
 }
 
 type EnsureBackendOpts struct {
@@ -215,29 +369,174 @@ func (o *Opts) UnmarshalJSON(bs []byte) (err error) {
 			if err != nil {
 				o.AcmeCName = false
 			}
+		case TLSIssuer:
+			o.TLSIssuer = strV
 		case AllPrefixes:
 			o.ExposeAllServices, err = strconv.ParseBool(strV)
 			if err != nil {
 				o.ExposeAllServices = false
 			}
+		case RouteRules:
+			o.RouteRules = nil
+			if strV == "" {
+				continue
+			}
+			if err = json.Unmarshal([]byte(strV), &o.RouteRules); err != nil {
+				return fmt.Errorf("invalid %s option: %w", RouteRules, err)
+			}
+		case RequestHeaders:
+			o.RequestHeaders, err = parseHeaderModifier(k, strV)
+			if err != nil {
+				return err
+			}
+		case ResponseHeaders:
+			o.ResponseHeaders, err = parseHeaderModifier(k, strV)
+			if err != nil {
+				return err
+			}
+		case HTTPSRedirect:
+			o.HTTPSRedirectCode, err = parseHTTPSRedirect(strV)
+			if err != nil {
+				return err
+			}
+		case URLRewrite:
+			if strV != "" && !strings.HasPrefix(strV, "/") {
+				return fmt.Errorf("invalid %s option: path must start with /", URLRewrite)
+			}
+			o.URLRewrite = strV
+		case Timeout:
+			if strV != "" && !durationRegexp.MatchString(strV) {
+				return fmt.Errorf("invalid %s option: %q must be a duration as 30s or 1m30s", Timeout, strV)
+			}
+			o.Timeout = strV
+		case Retries:
+			o.Retries = nil
+			if strV == "" {
+				continue
+			}
+			retries, convErr := strconv.Atoi(strV)
+			if convErr != nil || retries < 0 {
+				return fmt.Errorf("invalid %s option: %q must be a non negative number", Retries, strV)
+			}
+			o.Retries = &retries
+		case RetryOn:
+			o.RetryOn, err = parseRetryOn(strV)
+			if err != nil {
+				return err
+			}
+		case MirrorTo:
+			if strV != "" {
+				if _, _, err = ParseMirrorTo(strV); err != nil {
+					return err
+				}
+			}
+			o.MirrorTo = strV
+		case MirrorPercent:
+			o.MirrorPercent = nil
+			if strV == "" {
+				continue
+			}
+			percent, convErr := strconv.Atoi(strV)
+			if convErr != nil || percent < 0 || percent > 100 {
+				return fmt.Errorf("invalid %s option: %q must be between 0 and 100", MirrorPercent, strV)
+			}
+			mirrorPercent := int32(percent)
+			o.MirrorPercent = &mirrorPercent
 		default:
 			o.AdditionalOpts[k] = strV
 		}
 	}
 
+	if o.MirrorPercent != nil && o.MirrorTo == "" {
+		return fmt.Errorf("invalid %s option: %s must be set", MirrorPercent, MirrorTo)
+	}
+
 	return err
 }
 
+func parseRetryOn(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var conditions []string
+	for _, condition := range strings.Split(value, ",") {
+		condition = strings.TrimSpace(condition)
+		if code, convErr := strconv.Atoi(condition); convErr == nil {
+			if code < 400 || code > 599 {
+				return nil, fmt.Errorf("invalid %s option: status code %d must be between 400 and 599", RetryOn, code)
+			}
+		} else if !slices.Contains(RetryOnConditions, condition) {
+			return nil, fmt.Errorf("invalid %s option: unknown condition %q", RetryOn, condition)
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+// ParseMirrorTo returns the service name and port of the mirror-to option, the
+// port is zero when not set.
+func ParseMirrorTo(value string) (string, int32, error) {
+	name, portStr, hasPort := strings.Cut(value, ":")
+	if !serviceNameRegexp.MatchString(name) {
+		return "", 0, fmt.Errorf("invalid %s option: invalid service name %q", MirrorTo, name)
+	}
+	if !hasPort {
+		return name, 0, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid %s option: invalid port %q", MirrorTo, portStr)
+	}
+	return name, int32(port), nil
+}
+
+func parseHeaderModifier(opt, value string) (*HeaderModifier, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var modifier HeaderModifier
+	if err := json.Unmarshal([]byte(value), &modifier); err != nil {
+		return nil, fmt.Errorf("invalid %s option: %w", opt, err)
+	}
+	if err := modifier.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s option: %w", opt, err)
+	}
+	return &modifier, nil
+}
+
+func parseHTTPSRedirect(value string) (int, error) {
+	switch value {
+	case "", "false":
+		return 0, nil
+	case "true", "301":
+		return 301, nil
+	case "308":
+		return 308, nil
+	}
+	return 0, fmt.Errorf("invalid %s option: %q must be true, false, 301 or 308", HTTPSRedirect, value)
+}
+
 // DescribedOptions returns a map containing all the available options
 // and their description as values of the map
 func DescribedOptions() map[string]string {
 	return map[string]string{
-		ExposedPort: "Port to be exposed by the Load Balancer. Defaults to 80.",
-		Domain:      "Domain used on Ingress.",
-		Route:       "Path used on Ingress rule.",
-		Acme:        "If set to true, adds ingress TLS options to Ingress. Defaults to false.",
-		AcmeCName:   "If set to true, adds ingress TLS options to CName Ingresses. Defaults to false.",
-		AllPrefixes: "If set to true, exposes all of the services of the app, allowing them to be accessible from the router.",
+		ExposedPort:     "Port to be exposed by the Load Balancer. Defaults to 80.",
+		Domain:          "Domain used on Ingress.",
+		Route:           "Path used on Ingress rule.",
+		Acme:            "If set to true, adds ingress TLS options to Ingress. Defaults to false.",
+		AcmeCName:       "If set to true, adds ingress TLS options to CName Ingresses. Defaults to false.",
+		TLSIssuer:       "cert-manager issuer of the certificates of the app hostnames, only used by the gateway-api mode.",
+		AllPrefixes:     "If set to true, exposes all of the services of the app, allowing them to be accessible from the router.",
+		RouteRules:      "JSON list of rules routing requests by header, cookie, query param or method to a prefix, only used by the gateway-api mode.",
+		RequestHeaders:  `JSON object with the "set", "add" and "remove" operations applied to the request headers, ie: {"set": {"X-Forwarded-Proto": "https"}, "remove": ["X-Internal"]}.`,
+		Timeout:         "Timeout of the requests sent to the app, ie: 30s or 1m30s.",
+		Retries:         "Number of times failed requests are retried.",
+		RetryOn:         "Comma separated conditions retrying requests: 5xx, gateway-error, retriable-4xx, connect-failure, reset or 4xx/5xx status codes.",
+		MirrorTo:        "Service, in the app namespace, receiving a copy of the requests, ie: my-svc or my-svc:8080.",
+		MirrorPercent:   "Percentage of the requests copied to the mirror-to service. Defaults to 100.",
+		HTTPSRedirect:   "If set to true, 301 or 308 redirects HTTP requests to HTTPS with the given status code, only used by the gateway-api mode. Defaults to false.",
+		URLRewrite:      "Path replacing the route prefix in the requests sent to the app, only used by the gateway-api mode.",
+		ResponseHeaders: `JSON object with the "set", "add" and "remove" operations applied to the response headers, ie: {"set": {"Strict-Transport-Security": "max-age=31536000"}}.`,
 	}
 }
 
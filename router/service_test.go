@@ -57,3 +57,153 @@ func TestUnmarshalOptsWithHeaderOpts(t *testing.T) {
 	}
 	assert.Equal(t, expected, routerOpts)
 }
+
+func TestBackendPrefixAllTargets(t *testing.T) {
+	prefix := BackendPrefix{
+		Target: BackendTarget{Namespace: "ns", Service: "app-web"},
+		WeightedTargets: []WeightedBackendTarget{
+			{BackendTarget: BackendTarget{Namespace: "ns", Service: "app-web-canary"}, Weight: 10},
+		},
+	}
+	targets, err := prefix.AllTargets()
+	assert.NoError(t, err)
+	assert.Equal(t, []WeightedBackendTarget{
+		{BackendTarget: BackendTarget{Namespace: "ns", Service: "app-web"}, Weight: 90},
+		{BackendTarget: BackendTarget{Namespace: "ns", Service: "app-web-canary"}, Weight: 10},
+	}, targets)
+
+	prefix.WeightedTargets = append(prefix.WeightedTargets, WeightedBackendTarget{
+		BackendTarget: BackendTarget{Namespace: "ns", Service: "app-web-other"}, Weight: 95,
+	})
+	_, err = prefix.AllTargets()
+	assert.ErrorIs(t, err, ErrInvalidWeights)
+}
+
+func TestUnmarshalWeightedTargets(t *testing.T) {
+	js := `{"prefix": "", "target": {"namespace": "ns", "service": "app-web"}, "weightedTargets": [{"namespace": "ns", "service": "app-web-canary", "weight": 20}]}`
+	var prefix BackendPrefix
+	err := json.Unmarshal([]byte(js), &prefix)
+	assert.NoError(t, err)
+	assert.Equal(t, []WeightedBackendTarget{
+		{BackendTarget: BackendTarget{Namespace: "ns", Service: "app-web-canary"}, Weight: 20},
+	}, prefix.WeightedTargets)
+}
+
+func TestUnmarshalOptsRouteRules(t *testing.T) {
+	js := `{"route-rules": "[{\"prefix\": \"beta\", \"cookies\": [{\"name\": \"beta\", \"value\": \"true\"}]}, {\"headers\": [{\"name\": \"X-Api-Version\", \"value\": \"v2\"}], \"method\": \"GET\"}]"}`
+	routerOpts := Opts{}
+	err := json.Unmarshal([]byte(js), &routerOpts)
+	assert.NoError(t, err)
+	assert.Equal(t, []RouteRule{
+		{Prefix: "beta", Cookies: []RouteRuleMatch{{Name: "beta", Value: "true"}}},
+		{Method: "GET", Headers: []RouteRuleMatch{{Name: "X-Api-Version", Value: "v2"}}},
+	}, routerOpts.RouteRules)
+	assert.Empty(t, routerOpts.AdditionalOpts)
+
+	err = json.Unmarshal([]byte(`{"route-rules": "[{"}`), &Opts{})
+	assert.ErrorContains(t, err, "invalid route-rules option")
+}
+
+func TestUnmarshalOptsHeaderModifiers(t *testing.T) {
+	js := `{"request-headers": "{\"set\": {\"X-Forwarded-Proto\": \"https\"}, \"remove\": [\"X-Internal\"]}", "response-headers": "{\"add\": {\"Strict-Transport-Security\": \"max-age=31536000\"}}"}`
+	routerOpts := Opts{}
+	err := json.Unmarshal([]byte(js), &routerOpts)
+	assert.NoError(t, err)
+	assert.Equal(t, &HeaderModifier{
+		Set:    map[string]string{"X-Forwarded-Proto": "https"},
+		Remove: []string{"X-Internal"},
+	}, routerOpts.RequestHeaders)
+	assert.Equal(t, &HeaderModifier{
+		Add: map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+	}, routerOpts.ResponseHeaders)
+	assert.Empty(t, routerOpts.AdditionalOpts)
+}
+
+func TestHeaderModifierValidate(t *testing.T) {
+	tests := []struct {
+		modifier HeaderModifier
+		expected string
+	}{
+		{modifier: HeaderModifier{Set: map[string]string{"X-Ok": "value"}, Remove: []string{"X-Other"}}},
+		{modifier: HeaderModifier{Set: map[string]string{"X Bad": "value"}}, expected: `invalid header name "X Bad"`},
+		{modifier: HeaderModifier{Add: map[string]string{"X-Bad": "a\r\nX-Injected: b"}}, expected: `invalid value for header "X-Bad"`},
+		{modifier: HeaderModifier{Set: map[string]string{"X-Dup": "a"}, Remove: []string{"x-dup"}}, expected: `header "x-dup" modified more than once`},
+	}
+	for _, tt := range tests {
+		err := tt.modifier.Validate()
+		if tt.expected == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.EqualError(t, err, tt.expected)
+		}
+	}
+
+	err := json.Unmarshal([]byte(`{"response-headers": "{\"remove\": [\"a:b\"]}"}`), &Opts{})
+	assert.EqualError(t, err, `invalid response-headers option: invalid header name "a:b"`)
+}
+
+func TestUnmarshalOptsHTTPSRedirectAndURLRewrite(t *testing.T) {
+	tests := []struct {
+		js       string
+		expected Opts
+		err      string
+	}{
+		{js: `{"https-redirect": "true", "url-rewrite": "/"}`, expected: Opts{HTTPSRedirectCode: 301, URLRewrite: "/"}},
+		{js: `{"https-redirect": "308"}`, expected: Opts{HTTPSRedirectCode: 308}},
+		{js: `{"https-redirect": "false"}`, expected: Opts{}},
+		{js: `{"https-redirect": "302"}`, err: `invalid https-redirect option: "302" must be true, false, 301 or 308`},
+		{js: `{"url-rewrite": "api"}`, err: "invalid url-rewrite option: path must start with /"},
+	}
+	for _, tt := range tests {
+		routerOpts := Opts{}
+		err := json.Unmarshal([]byte(tt.js), &routerOpts)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err)
+			continue
+		}
+		assert.NoError(t, err)
+		tt.expected.AdditionalOpts = map[string]string{}
+		assert.Equal(t, tt.expected, routerOpts)
+	}
+}
+
+func TestUnmarshalOptsTLSIssuer(t *testing.T) {
+	routerOpts := Opts{}
+	err := json.Unmarshal([]byte(`{"tls-acme": "true", "tls-issuer": "letsencrypt"}`), &routerOpts)
+	assert.NoError(t, err)
+	assert.Equal(t, Opts{Acme: true, TLSIssuer: "letsencrypt", AdditionalOpts: map[string]string{}}, routerOpts)
+}
+
+func TestUnmarshalOptsTrafficPolicies(t *testing.T) {
+	js := `{"timeout": "1m30s", "retries": "3", "retry-on": "5xx, connect-failure,429", "mirror-to": "shadow:8080", "mirror-percent": "10"}`
+	routerOpts := Opts{}
+	err := json.Unmarshal([]byte(js), &routerOpts)
+	assert.NoError(t, err)
+	retries, percent := 3, int32(10)
+	assert.Equal(t, Opts{
+		Timeout:        "1m30s",
+		Retries:        &retries,
+		RetryOn:        []string{"5xx", "connect-failure", "429"},
+		MirrorTo:       "shadow:8080",
+		MirrorPercent:  &percent,
+		AdditionalOpts: map[string]string{},
+	}, routerOpts)
+
+	tests := []struct {
+		js  string
+		err string
+	}{
+		{js: `{"timeout": "1.5s"}`, err: `invalid timeout option: "1.5s" must be a duration as 30s or 1m30s`},
+		{js: `{"retries": "-1"}`, err: `invalid retries option: "-1" must be a non negative number`},
+		{js: `{"retry-on": "5xx,sometimes"}`, err: `invalid retry-on option: unknown condition "sometimes"`},
+		{js: `{"retry-on": "302"}`, err: "invalid retry-on option: status code 302 must be between 400 and 599"},
+		{js: `{"mirror-to": "Shadow"}`, err: `invalid mirror-to option: invalid service name "Shadow"`},
+		{js: `{"mirror-to": "shadow:http"}`, err: `invalid mirror-to option: invalid port "http"`},
+		{js: `{"mirror-to": "shadow", "mirror-percent": "101"}`, err: `invalid mirror-percent option: "101" must be between 0 and 100`},
+		{js: `{"mirror-percent": "10"}`, err: "invalid mirror-percent option: mirror-to must be set"},
+	}
+	for _, tt := range tests {
+		err := json.Unmarshal([]byte(tt.js), &Opts{})
+		assert.EqualError(t, err, tt.err)
+	}
+}